@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// redactedValue replaces the values of the fields tagged with redact:"true", unless they are unset
+const redactedValue = "REDACTED"
+
+// redactConfig returns the fields of the config, by name, where secrets are redacted, such as to be marshalled as
+// JSON for confirming the effective config. The keys of maps tagged for redaction, such as header names, are kept.
+// Durations are given in the same form as their flags, such as 1m30s.
+func redactConfig(config any) map[string]any {
+	value := reflect.ValueOf(config)
+	if value.Kind() == reflect.Pointer {
+		value = value.Elem()
+	}
+
+	result := make(map[string]any, value.NumField())
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		fieldValue := value.Field(i)
+
+		switch {
+		case fieldValue.Kind() == reflect.Struct:
+			result[field.Name] = redactConfig(fieldValue.Interface())
+		case field.Type == reflect.TypeOf(time.Duration(0)):
+			result[field.Name] = fieldValue.Interface().(time.Duration).String()
+		case field.Tag.Get("redact") != "true" || fieldValue.IsZero():
+			result[field.Name] = fieldValue.Interface()
+		case fieldValue.Kind() == reflect.Map:
+			redacted := make(map[string]string, fieldValue.Len())
+			for _, key := range fieldValue.MapKeys() {
+				redacted[key.String()] = redactedValue
+			}
+			result[field.Name] = redacted
+		default:
+			result[field.Name] = redactedValue
+		}
+	}
+	return result
+}
+
+// marshalEffectiveConfig marshals the config as indented JSON with its secrets redacted
+func marshalEffectiveConfig(config *Config) ([]byte, error) {
+	return json.MarshalIndent(redactConfig(config), "", "  ")
+}
+
+// String represents the config with its secrets redacted, so that the config can't leak them when logged
+func (c Config) String() string {
+	return redactedString(c)
+}
+
+// String represents the config with its secrets redacted, so that the config can't leak them when logged
+func (c MetricsBackendConfig) String() string {
+	return redactedString(c)
+}
+
+func redactedString(config any) string {
+	content, err := json.Marshal(redactConfig(config))
+	if err != nil {
+		return fmt.Sprintf("unable to represent config: %s", err)
+	}
+	return string(content)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarshalEffectiveConfig(t *testing.T) {
+	config := &Config{
+		Port:           25565,
+		ApiAuthToken:   "api-secret",
+		NgrokToken:     "ngrok-secret",
+		DockerHeaders:  map[string]string{"Authorization": "Basic secret"},
+		StatusCacheTtl: 90 * time.Second,
+	}
+	config.MetricsBackendConfig.Influxdb.Username = "metrics"
+	config.MetricsBackendConfig.Influxdb.Password = "influx-secret"
+
+	content, err := marshalEffectiveConfig(config)
+	require.NoError(t, err)
+	assert.NotContains(t, string(content), "secret")
+
+	var exported map[string]any
+	require.NoError(t, json.Unmarshal(content, &exported))
+	assert.Equal(t, float64(25565), exported["Port"])
+	assert.Equal(t, redactedValue, exported["ApiAuthToken"])
+	assert.Equal(t, redactedValue, exported["NgrokToken"])
+	assert.Equal(t, "", exported["HandshakeToken"], "unset secrets are shown as unset")
+	assert.Equal(t, map[string]any{"Authorization": redactedValue}, exported["DockerHeaders"])
+	assert.Equal(t, "1m30s", exported["StatusCacheTtl"])
+
+	influxdb := exported["MetricsBackendConfig"].(map[string]any)["Influxdb"].(map[string]any)
+	assert.Equal(t, "metrics", influxdb["Username"])
+	assert.Equal(t, redactedValue, influxdb["Password"])
+}
+
+func TestConfig_String(t *testing.T) {
+	config := Config{NgrokToken: "ngrok-secret", HandshakeToken: "handshake-secret"}
+	config.MetricsBackendConfig.Influxdb.Password = "influx-secret"
+
+	logged, err := logrus.WithField("config", config).WithField("metrics", config.MetricsBackendConfig).String()
+	require.NoError(t, err)
+
+	for _, represented := range []string{
+		fmt.Sprint(config),
+		fmt.Sprintf("%v", &config),
+		fmt.Sprint(config.MetricsBackendConfig),
+		logged,
+	} {
+		assert.NotContains(t, represented, "secret")
+		assert.Contains(t, represented, redactedValue)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/itzg/mc-router/server"
+)
+
+// slidingWindow accumulates counts into one second buckets that cover the window
+type slidingWindow struct {
+	sync.Mutex
+	buckets []float64
+	current int
+}
+
+func newSlidingWindow(window time.Duration) *slidingWindow {
+	size := int(window / time.Second)
+	if size < 1 {
+		size = 1
+	}
+	return &slidingWindow{buckets: make([]float64, size)}
+}
+
+func (w *slidingWindow) add(delta float64) {
+	w.Lock()
+	defer w.Unlock()
+	w.buckets[w.current] += delta
+}
+
+// advance starts a new bucket, dropping the oldest
+func (w *slidingWindow) advance() {
+	w.Lock()
+	defer w.Unlock()
+	w.current = (w.current + 1) % len(w.buckets)
+	w.buckets[w.current] = 0
+}
+
+func (w *slidingWindow) sum() float64 {
+	w.Lock()
+	defer w.Unlock()
+	var total float64
+	for _, value := range w.buckets {
+		total += value
+	}
+	return total
+}
+
+func (w *slidingWindow) duration() time.Duration {
+	return time.Duration(len(w.buckets)) * time.Second
+}
+
+// windowedCounter passes through to the delegate counter while also accumulating into a sliding window,
+// except for counts labeled with one of the excluded error types
+type windowedCounter struct {
+	delegate metrics.Counter
+	// window is nil when the counts are excluded
+	window        *slidingWindow
+	excludedTypes map[string]struct{}
+}
+
+func (c *windowedCounter) With(labelValues ...string) metrics.Counter {
+	window := c.window
+	for i := 0; i+1 < len(labelValues); i += 2 {
+		if labelValues[i] == "type" {
+			if _, excluded := c.excludedTypes[labelValues[i+1]]; excluded {
+				window = nil
+			}
+		}
+	}
+	return &windowedCounter{delegate: c.delegate.With(labelValues...), window: window, excludedTypes: c.excludedTypes}
+}
+
+func (c *windowedCounter) Add(delta float64) {
+	c.delegate.Add(delta)
+	if c.window != nil {
+		c.window.add(delta)
+	}
+}
+
+// derivedErrorRatioExcludedTypes are the error types that don't count towards the error ratio since they're
+// part of normal client behavior, such as a launcher cancelling a connection attempt, or intended closes
+var derivedErrorRatioExcludedTypes = map[string]struct{}{
+	"client_abort": {},
+	"max_lifetime": {},
+}
+
+// derivedMetrics maintains pre-aggregated gauges of the connection rate and error ratio, which allows for
+// simple alerting without needing recording rules in the metrics system. The error ratio is the number of errors
+// per frontend connection and can exceed 1 since a single connection may incur more than one error.
+type derivedMetrics struct {
+	connections *slidingWindow
+	errors      *slidingWindow
+
+	connectionsPerSecond metrics.Gauge
+	errorRatio           metrics.Gauge
+}
+
+// wrapConnectorMetrics returns derived metrics that observe the frontend connections and errors
+// of the given connector metrics, which are updated in place.
+func wrapConnectorMetrics(connectorMetrics *server.ConnectorMetrics, window time.Duration,
+	connectionsPerSecond metrics.Gauge, errorRatio metrics.Gauge) *derivedMetrics {
+
+	d := &derivedMetrics{
+		connections:          newSlidingWindow(window),
+		errors:               newSlidingWindow(window),
+		connectionsPerSecond: connectionsPerSecond,
+		errorRatio:           errorRatio,
+	}
+	connectorMetrics.ConnectionsFrontend = &windowedCounter{delegate: connectorMetrics.ConnectionsFrontend, window: d.connections}
+	connectorMetrics.Errors = &windowedCounter{
+		delegate:      connectorMetrics.Errors,
+		window:        d.errors,
+		excludedTypes: derivedErrorRatioExcludedTypes,
+	}
+	return d
+}
+
+// Start updates the gauges and advances the windows every second until the ctx is done
+func (d *derivedMetrics) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				d.update()
+				d.connections.advance()
+				d.errors.advance()
+			}
+		}
+	}()
+}
+
+func (d *derivedMetrics) update() {
+	connections := d.connections.sum()
+	d.connectionsPerSecond.Set(connections / d.connections.duration().Seconds())
+
+	if connections > 0 {
+		d.errorRatio.Set(d.errors.sum() / connections)
+	} else {
+		d.errorRatio.Set(0)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/metrics/generic"
+	"github.com/itzg/mc-router/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDerivedMetrics(t *testing.T) {
+	connectorMetrics := &server.ConnectorMetrics{
+		ConnectionsFrontend: generic.NewCounter("connections"),
+		Errors:              generic.NewCounter("errors"),
+	}
+	connectionsPerSecond := generic.NewGauge("connections_per_second")
+	errorRatio := generic.NewGauge("error_ratio")
+
+	d := wrapConnectorMetrics(connectorMetrics, 2*time.Second, connectionsPerSecond, errorRatio)
+
+	connectorMetrics.ConnectionsFrontend.Add(3)
+	connectorMetrics.Errors.With("type", "read").Add(1)
+	// client cancellations are not failures
+	connectorMetrics.Errors.With("type", "client_abort").Add(1)
+	d.update()
+	d.connections.advance()
+	d.errors.advance()
+
+	connectorMetrics.ConnectionsFrontend.Add(1)
+	d.update()
+	assert.Equal(t, float64(2), connectionsPerSecond.Value())
+	assert.Equal(t, 0.25, errorRatio.Value())
+
+	// the first second falls out of the window
+	d.connections.advance()
+	d.errors.advance()
+	d.update()
+	assert.Equal(t, 0.5, connectionsPerSecond.Value())
+	assert.Equal(t, float64(0), errorRatio.Value())
+
+	// counts still reach the underlying counters
+	assert.Equal(t, float64(4), connectorMetrics.ConnectionsFrontend.(*windowedCounter).delegate.(*generic.Counter).Value())
+}
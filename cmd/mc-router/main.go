@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"net"
 	"os"
@@ -12,6 +13,7 @@ import (
 	"time"
 
 	"github.com/itzg/go-flagsfiller"
+	"github.com/itzg/mc-router/mcproto"
 	"github.com/itzg/mc-router/server"
 	"github.com/sirupsen/logrus"
 )
@@ -22,41 +24,169 @@ type MetricsBackendConfig struct {
 		Tags            map[string]string `usage:"any extra tags to be included with all reported metrics"`
 		Addr            string
 		Username        string
-		Password        string
+		Password        string `redact:"true"`
 		Database        string
 		RetentionPolicy string
 	}
+	Statsd struct {
+		Addr     string            `usage:"host:port of the StatsD agent, such as a Datadog agent, that the metrics are sent to over UDP"`
+		Interval time.Duration     `default:"10s" usage:"How often the metrics are sent"`
+		TagStyle string            `default:"dogstatsd" usage:"How the labels of the metrics are sent as tags: dogstatsd or influx"`
+		Tags     map[string]string `usage:"any extra tags to be included with all reported metrics"`
+	}
+}
+
+type ExecNotifierConfig struct {
+	Command       string        `usage:"If set, the command is executed on connection events with details passed as MC_ROUTER_* environment variables and JSON on stdin"`
+	Args          []string      `usage:"Arguments to pass to the command, where each may reference event fields such as {{.Event}}, {{.Client}}, {{.Server}}, or {{.BackendHostPort}}"`
+	Timeout       time.Duration `default:"10s" usage:"Maximum duration to allow the command to run"`
+	MaxConcurrent int           `default:"10" usage:"Maximum number of commands to run at once, where further events are dropped"`
+	RouteEvents   bool          `usage:"Also execute the command with route-added and route-removed events, such as when the Docker or Kubernetes watchers discover routes, where {{.Source}} identifies what registered the route"`
 }
 
 type Config struct {
 	Port                  int               `default:"25565" usage:"The [port] bound to listen for Minecraft client connections"`
+	Listeners             []string          `usage:"Additional host:port addresses to listen for Minecraft client connections, where the host is optional and each is optionally suffixed with ;receive-proxy-protocol=true|false to override -receive-proxy-protocol for that listener and ;default=host:port for the default Minecraft server of that listener"`
 	Default               string            `usage:"host:port of a default Minecraft server to use when mapping not found"`
-	Mapping               map[string]string `usage:"Comma or newline delimited or repeated mappings of externalHostname=host:port"`
+	DefaultByClient       map[string]string `usage:"Comma or newline delimited or repeated clientIPOrCIDR=host:port default Minecraft servers to use when mapping not found for clients in those IP ranges, where the most specific range is used before the -default"`
+	ProtocolMapping       map[string]string `usage:"Comma or newline delimited or repeated protocolVersions=host:port backends for clients whose server address isn't mapped, where protocolVersions is a version, such as 47, or an inclusive range that may be open-ended, such as 47-340 or 770-. These are used before any default and the ranges may not overlap."`
+	Mapping               map[string]string `usage:"Comma or newline delimited or repeated mappings of externalHostname=host:port, where more than one host:port separated by | are load balanced and a host:port followed by ;rewriteHost=name presents that server address to the backend or by ;wakeUrl=url posts to that URL to wake the backend"`
+	LoadBalance           string            `default:"round-robin" usage:"How one of a mapping's backends is selected for each connection: round-robin, least-conn, or random"`
 	ApiBinding            string            `usage:"The [host:port] bound for servicing API requests"`
+	ApiAuthToken          string            `redact:"true" usage:"If set, requests to the API server must declare this token in an Authorization header of Bearer followed by the token, and others are rejected as unauthorized. The web UI can't send the token, so isn't usable with it"`
+	ApiAuthExemptMetrics  bool              `usage:"With -api-auth-token, allow requests to /metrics without the token, such as from scrapers that can't send one"`
+	ApiAuthExemptVars     bool              `usage:"With -api-auth-token, allow requests to /vars without the token"`
+	ApiListeners          bool              `usage:"Allow listeners for Minecraft clients to be added and removed at runtime with the /listeners endpoints of the API"`
+	EnableWebUi           bool              `usage:"Serve a simple web UI at the root of the API server for viewing routes and active connections"`
 	Version               bool              `usage:"Output version and exit"`
+	PrintConfig           bool              `usage:"Output the effective configuration, resolved from the flags and environment variables, as JSON with secrets redacted and exit"`
 	CpuProfile            string            `usage:"Enables CPU profiling and writes to given path"`
 	Debug                 bool              `usage:"Enable debug logs"`
+	DebugProtocol         bool              `usage:"Enable debug logs along with the frequent logs of each frame and packet read from clients and backends"`
 	ConnectionRateLimit   int               `default:"1" usage:"Max number of connections to allow per second"`
 	InKubeCluster         bool              `usage:"Use in-cluster Kubernetes config"`
 	KubeConfig            string            `usage:"The path to a Kubernetes configuration file"`
-	AutoScaleUp           bool              `usage:"Increase Kubernetes StatefulSet Replicas (only) from 0 to 1 on respective backend servers when accessed"`
+	AutoScaleUp           bool              `usage:"Increase Kubernetes StatefulSet Replicas (only) on respective backend servers when accessed, from 0 to 1 unless annotated otherwise"`
+	AutoScaleUpRetries    int               `default:"5" usage:"Attempts, in total, to update the scale of a Kubernetes StatefulSet when the update conflicts with another change to it"`
+	AutoScaleUpBackoff    time.Duration     `default:"50ms" usage:"Wait before retrying a conflicting scale update, which doubles with each retry"`
 	InDocker              bool              `usage:"Use Docker service discovery"`
 	InDockerSwarm         bool              `usage:"Use Docker Swarm service discovery"`
 	DockerSocket          string            `default:"unix:///var/run/docker.sock" usage:"Path to Docker socket to use"`
 	DockerTimeout         int               `default:"0" usage:"Timeout configuration in seconds for the Docker integrations"`
 	DockerRefreshInterval int               `default:"15" usage:"Refresh interval in seconds for the Docker integrations"`
-	MetricsBackend        string            `default:"discard" usage:"Backend to use for metrics exposure/publishing: discard,expvar,influxdb,prometheus"`
+	DockerUserAgent       string            `usage:"User-Agent presented to the Docker API, which defaults to mc-router/ followed by the version"`
+	DockerRouteStopped    bool              `usage:"Retain the routes of stopped Docker containers even without the mc-router.motd label, so that the route is in place when the container starts. The route does not fall back to the default server."`
+	DockerHeaders         map[string]string `redact:"true" usage:"Comma or newline delimited or repeated name=value headers to include in requests to the Docker API, such as for a proxy in front of it"`
+	RouteConflictPolicy   string            `default:"last-wins" usage:"Which of the Docker containers, Swarm services, or Kubernetes services declaring the same host is routed: last-wins or first-wins by creation time, or error to route none of them. Each conflict is logged."`
+	MetricsBackend        string            `default:"discard" usage:"Backend to use for metrics exposure/publishing: discard,expvar,influxdb,prometheus,statsd"`
 	UseProxyProtocol      bool              `default:"false" usage:"Send PROXY protocol to backend servers"`
 	ReceiveProxyProtocol  bool              `default:"false" usage:"Receive PROXY protocol from backend servers, by default trusts every proxy header that it receives, combine with -trusted-proxies to specify a list of trusted proxies"`
 	TrustedProxies        []string          `usage:"Comma delimited list of CIDR notation IP blocks to trust when receiving PROXY protocol"`
+	ProxyProtocolTlvs     []string          `usage:"Comma delimited list of PROXY protocol v2 TLV types, such as 0xEA, to copy from the received to the sent PROXY header, when both -receive-proxy-protocol and -use-proxy-protocol are set"`
 	MetricsBackendConfig  MetricsBackendConfig
-	RoutesConfig          string `usage:"Name or full path to routes config file"`
-	NgrokToken            string `usage:"If set, an ngrok tunnel will be established. It is HIGHLY recommended to pass as an environment variable."`
+	MetricsFrameSizes     bool          `usage:"Report histograms of the frame lengths read during the handshake/login phase and the total bytes relayed per connection, which are also logged at debug level"`
+	MetricsDerivedWindow  time.Duration `usage:"If set, gauges of the connections per second and the errors per connection, excluding client aborts, over this sliding window are also reported, such as for alerting without recording rules"`
+	RoutesConfig          string        `usage:"Name or full path to routes config file"`
+	NgrokToken            string        `redact:"true" usage:"If set, an ngrok tunnel will be established. It is HIGHLY recommended to pass as an environment variable."`
+
+	DockerWatchEvents       bool     `usage:"Update the routes of Docker containers as their events, those of -docker-events, are received rather than listing all containers every -docker-refresh-interval"`
+	DockerReconcileInterval int      `default:"300" usage:"With -docker-watch-events, interval in seconds of listing all containers in case an event was missed"`
+	DockerEvents            []string `usage:"With -docker-watch-events, comma delimited container events that update the routes, which default to start,stop,die,destroy. Also supported are create, restart, kill, oom, pause, unpause, rename, update, and health_status"`
+
+	LbHealthCheckSources    []string      `usage:"Comma delimited IP addresses or CIDRs of load balancers whose connections are health checks, which are closed without being read, logged, or counted, such as the TCP health checks of cloud load balancers. These are matched against the load balancer's address rather than a received PROXY header"`
+	LbHealthCheckCloseDelay time.Duration `usage:"How long the health check connections of -lb-health-check-sources are held open before being closed"`
+	LbHealthCheckEmpty      bool          `usage:"Treat connections that close without sending any data as health checks rather than logging and counting them as client aborts"`
+
+	ProxyHeaderFallback bool `usage:"With -receive-proxy-protocol, handle connections whose PROXY header is malformed or partial as direct connections from the immediate peer, rather than closing them. Either way, these are logged and counted as proxy_header errors"`
+
+	ProxyProtocolForwardHeader bool `usage:"When both -receive-proxy-protocol and -use-proxy-protocol are set, send the received PROXY header, with all its TLVs such as those of TLS, to the backend rather than a header of the client's address and the -proxy-protocol-tlvs"`
+
+	ProxyProtocolVersion int `default:"2" usage:"Version of the PROXY headers sent to backends with -use-proxy-protocol: 2 for the binary form or 1 for the text form understood by older backends, which carries no TLVs"`
+
+	AllowExecWakers bool `usage:"Permit routes of the routes config file to declare a wakeCmd and sleepCmd, which are executed by mc-router, so only enable this when the file is trusted. Otherwise, such routes are ignored"`
 
 	ClientsToAllow []string `usage:"Zero or more client IP addresses or CIDRs to allow. Takes precedence over deny."`
 	ClientsToDeny  []string `usage:"Zero or more client IP addresses or CIDRs to deny. Ignored if any configured to allow"`
 
-	SimplifySRV bool `default:"false" usage:"Simplify fully qualified SRV records for mapping"`
+	ReconnectDelayThreshold int           `usage:"If set, clients connecting more than this many times within the -reconnect-delay-window are delayed by a random delay, up to -reconnect-delay-max, that grows as they keep reconnecting"`
+	ReconnectDelayWindow    time.Duration `default:"10s" usage:"Sliding window over which the connections of each client IP are counted for -reconnect-delay-threshold"`
+	ReconnectDelayMax       time.Duration `default:"2s" usage:"Maximum delay of clients reconnecting beyond -reconnect-delay-threshold"`
+
+	SimplifySRV       bool     `default:"false" usage:"Simplify fully qualified SRV records for mapping by stripping their leading underscore-prefixed labels, such as _minecraft._tcp"`
+	SimplifySrvLabels []string `usage:"If set, the only underscore-prefixed labels, such as _minecraft,_tcp, that are stripped by -simplify-srv"`
+
+	ExecNotifier ExecNotifierConfig
+
+	MaintenanceFile    string `usage:"If set, all client connections are served a maintenance status or disconnect while this file exists"`
+	MaintenanceMessage string `default:"Server is under maintenance, please try again later" usage:"Message served to clients while in maintenance mode"`
+
+	StatusCacheTtl      time.Duration `usage:"If set, the status of each route's backend is fetched periodically and, for up to this duration, served to status requests while the backend is asleep or can't be reached, in place of a MOTD"`
+	StatusCacheInterval time.Duration `default:"1m" usage:"How often the statuses are fetched with -status-cache-ttl"`
+	StatusCacheAll      bool          `usage:"With -status-cache-ttl, also answer the status requests of reachable backends from the cache rather than relaying each server list ping to the backend"`
+	StatusPlayersSource string        `usage:"Where the players of a status served in place of a backend come from, unless declared by the route: static, cached, or pool, which sums the cached players across a load balanced route's backends. By default, a cached status has the backend's players and a MOTD has none"`
+	StatusMaxPlayers    int           `usage:"If set, caps the maximum players of a status served in place of a backend, unless declared by the route"`
+	StatusTransparent   bool          `usage:"Answer status requests by fetching the status from the backend and relaying its status response unchanged, rather than relaying each server list ping, so that a backend that doesn't respond is presented with the route's status"`
+
+	StatusCacheFavicons  bool   `usage:"With -status-cache-ttl, statuses served in place of a backend, such as the MOTD of a sleeping backend, are presented with the favicon last fetched from that backend, even once older than the ttl, when the route has no favicon of its own"`
+	StatusDefaultFavicon string `usage:"Favicon of statuses served in place of a backend, when the route has none of its own or from -status-cache-favicons, which can be a data URL, base64 encoded PNG content, or the path to a PNG file"`
+
+	NetworkStatusMotd       string   `usage:"If set, status requests for server addresses that aren't mapped, such as the network's own address, are served this MOTD along with the players combined across the routes from the status cache of -status-cache-ttl"`
+	NetworkStatusFavicon    string   `usage:"Favicon of the network status, which can be a data URL, base64 encoded PNG content, or the path to a PNG file"`
+	NetworkStatusRoutes     []string `usage:"Comma delimited server addresses of the routes whose players are combined into the network status, where all routes are combined by default"`
+	NetworkStatusMaxPlayers int      `usage:"If set, the maximum players of the network status rather than the sum of those of the routes"`
+	NetworkStatusHideSample bool     `usage:"Omit the sample of online players from the network status"`
+
+	UnavailableStatusMessage string `usage:"If set, status requests are served a status with this message when their backend is missing or can't be reached and the route has no MOTD, so that the server still appears in the server list"`
+	NoBackendMessage         string `usage:"If set, login attempts are disconnected with this message when their backend is missing or can't be reached, rather than just closing the connection"`
+
+	UnknownVersionName string `default:"1.7+" usage:"Version name included in the statuses served by mc-router, such as during maintenance, to clients with an unknown protocol version. Known protocol versions are served their release versions"`
+
+	ServerAddressSummaryInterval time.Duration `usage:"If set, the most requested server addresses and their connection counts are logged at this interval"`
+	ServerAddressSummaryTop      int           `default:"10" usage:"Number of server addresses included in each summary enabled by -server-address-summary-interval"`
+
+	WakeWarmup        bool   `usage:"Wake backends, such as with -auto-scale-up, in the background and serve the -wake-warmup-message to clients while the backend can't be reached, rather than blocking them on the wake"`
+	WakeWarmupMessage string `default:"Server is starting, please try again shortly" usage:"Message served to clients while their backend is being woken with -wake-warmup"`
+
+	LoginStartTimeout time.Duration `default:"2s" usage:"Maximum duration to wait for the login start packet that identifies the player"`
+	RequirePlayerInfo bool          `usage:"Reject logins when the player info can't be read from the login start packet, rather than proceeding without it"`
+
+	ProbeResponse string `default:"none" usage:"How to handle connections that are clearly not Minecraft clients, such as HTTP requests and TLS handshakes: none logs the resulting read error, close closes them with only a debug log, banner also responds to HTTP requests with a 400 status and the -probe-banner"`
+	ProbeBanner   string `default:"This is a Minecraft server port" usage:"Message included in the response to HTTP requests with -probe-response=banner"`
+
+	AcceptedNextStates []string `usage:"Comma delimited next states of the handshake, by name or number, of clients that are routed, where others are disconnected. The names are status, login, and transfer, which are all accepted by default"`
+
+	IdleShutdown                time.Duration `usage:"If set, mc-router exits cleanly after there have been no client connections for this duration, such as to be started again on demand by an orchestrator"`
+	IdleShutdownRequireNoRoutes bool          `usage:"With -idle-shutdown, also require that no routes are mapped, such as none discovered by the Docker or Kubernetes watchers, for the duration"`
+
+	ShutdownTimeout time.Duration `usage:"If set, how long to wait for connections to complete when stopping, after which the remaining connections are closed. By default, stopping waits for all connections to complete"`
+
+	MaxConnectionLifetime time.Duration `usage:"If set, relayed connections are closed after this duration regardless of activity"`
+
+	RelayBufferSize int `usage:"If set, the size in bytes of the pooled buffers that relayed connections are copied through, which bounds how much is read from one side before being written to the other. Smaller buffers use less memory per connection while larger ones take fewer system calls for the same throughput. By default, relayed connections are spliced by the kernel on Linux without a buffer"`
+
+	BackendDialConcurrency int `usage:"Maximum number of concurrent dials to each backend, such as to smooth the load when many clients connect after the backend wakes up. Zero is unlimited"`
+
+	BackendDialTimeout      time.Duration `usage:"If set, how long dialing a backend may take, unless overridden by the route in the routes config file. By default, this is left to the operating system"`
+	BackendFirstByteTimeout time.Duration `usage:"If set, connections are closed when the backend doesn't respond within this duration after the handshake is relayed, unless overridden by the route in the routes config file"`
+
+	HandshakeToken string `redact:"true" usage:"If set, clients must prefix the server address with this token as a leading label, such as token.mc.example.com resolved by a wildcard DNS record, and others are disconnected. This is a lightweight gate rather than authentication since the token is sent in the clear"`
+
+	ForwardClientIp      string `usage:"If set, the client's IP address is appended to the server address of the handshake relayed to backends in this format: tcpshield or bungeecord, such as for backend plugins that don't support the PROXY protocol"`
+	ForwardClientIpToken string `redact:"true" usage:"With -forward-client-ip=bungeecord, the BungeeGuard token included in the forwarded properties"`
+
+	K8sUseEndpoints  bool   `flag:"k8s-use-endpoints" env:"K8S_USE_ENDPOINTS" usage:"Route Kubernetes services only while their EndpointSlices have a ready endpoint, and otherwise serve their MOTD as for an asleep backend. With -auto-scale-up, clients wait on the wake until an endpoint is ready"`
+	K8sLabelSelector string `flag:"k8s-label-selector" env:"K8S_LABEL_SELECTOR" usage:"If set, only the Kubernetes services matching this label selector, such as mc-router=true, are watched, which reduces the events and memory of watching services in a large cluster"`
+
+	K8sLeaderElection bool   `flag:"k8s-leader-election" env:"K8S_LEADER_ELECTION" usage:"With -auto-scale-up, only the replica of mc-router holding the -k8s-lease-name lease scales StatefulSets, so that replicas don't fight over their scale. All replicas route connections"`
+	K8sLeaseName      string `flag:"k8s-lease-name" env:"K8S_LEASE_NAME" default:"mc-router" usage:"Name of the Lease used by -k8s-leader-election"`
+	K8sLeaseNamespace string `flag:"k8s-lease-namespace" env:"K8S_LEASE_NAMESPACE" usage:"Namespace of the Lease used by -k8s-leader-election, which defaults to the namespace of the mc-router pod"`
+
+	HealthCheckInterval time.Duration `usage:"If set, the backend of each route is pinged this often and clients of a route whose backend didn't respond are served the route's status rather than dialing it. Routes of backends that are auto scaled are not checked"`
+
+	BackendFailureThreshold int           `usage:"If set, a backend is no longer dialed for the -backend-cooldown after this many consecutive dial failures, where clients are instead served as if the dial failed"`
+	BackendCooldown         time.Duration `default:"30s" usage:"How long a backend is not dialed after reaching the -backend-failure-threshold"`
+
+	BackendIpFamily string `default:"any" usage:"IP family to use when dialing backends that resolve to both IPv4 and IPv6 addresses: any, ipv4, ipv6, prefer-ipv4, prefer-ipv6"`
 }
 
 var (
@@ -65,10 +195,42 @@ var (
 	date    = "unknown"
 )
 
+// dockerHTTPHeaders combines the configured Docker API headers with the User-Agent, which identifies this build by default
+func dockerHTTPHeaders(config *Config) map[string]string {
+	headers := make(map[string]string, len(config.DockerHeaders)+1)
+	for name, value := range config.DockerHeaders {
+		headers[name] = value
+	}
+	userAgent := config.DockerUserAgent
+	if userAgent == "" {
+		userAgent = server.DockerDefaultUserAgent + "/" + version
+	}
+	headers["User-Agent"] = userAgent
+	return headers
+}
+
 func showVersion() {
 	fmt.Printf("%v, commit %v, built at %v", version, commit, date)
 }
 
+// registerStaticRoutes registers the routes declared by the routes config file and command-line
+func registerStaticRoutes(config *Config) {
+	server.Routes.AllowExecWakers(config.AllowExecWakers)
+	if config.RoutesConfig != "" {
+		err := server.RoutesConfig.ReadRoutesConfig(config.RoutesConfig)
+		if err != nil {
+			logrus.WithError(err).Error("Unable to load routes from config file")
+		}
+	}
+
+	server.Routes.RegisterAll(config.Mapping)
+	if config.Default != "" {
+		server.Routes.SetDefaultRoute(config.Default)
+	}
+	server.Routes.SimplifySRV(config.SimplifySRV)
+	server.Routes.UseSRVLabels(config.SimplifySrvLabels)
+}
+
 func main() {
 	var config Config
 	err := flagsfiller.Parse(&config, flagsfiller.WithEnv(""))
@@ -81,10 +243,27 @@ func main() {
 		os.Exit(0)
 	}
 
-	if config.Debug {
+	if config.PrintConfig {
+		content, err := marshalEffectiveConfig(&config)
+		if err != nil {
+			logrus.WithError(err).Fatal("Unable to marshal the effective config")
+		}
+		fmt.Println(string(content))
+		os.Exit(0)
+	}
+
+	if flag.Arg(0) == "test-route" {
+		if flag.NArg() != 2 {
+			logrus.Fatal("Usage: mc-router [flags] test-route <server address>")
+		}
+		os.Exit(testRoute(&config, flag.Arg(1)))
+	}
+
+	if config.Debug || config.DebugProtocol {
 		logrus.SetLevel(logrus.DebugLevel)
 		logrus.Debug("Debug logs enabled")
 	}
+	mcproto.EnableProtocolLogging(config.DebugProtocol)
 
 	if config.CpuProfile != "" {
 		cpuProfileFile, err := os.Create(config.CpuProfile)
@@ -110,17 +289,7 @@ func main() {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
 
-	if config.RoutesConfig != "" {
-		err := server.RoutesConfig.ReadRoutesConfig(config.RoutesConfig)
-		if err != nil {
-			logrus.WithError(err).Error("Unable to load routes from config file")
-		}
-	}
-
-	server.Routes.RegisterAll(config.Mapping)
-	if config.Default != "" {
-		server.Routes.SetDefaultRoute(config.Default)
-	}
+	registerStaticRoutes(&config)
 
 	if config.ConnectionRateLimit < 1 {
 		config.ConnectionRateLimit = 1
@@ -140,10 +309,177 @@ func main() {
 		logrus.WithError(err).Fatal("Unable to create client filter")
 	}
 
-	connector := server.NewConnector(metricsBuilder.BuildConnectorMetrics(), config.UseProxyProtocol, config.ReceiveProxyProtocol, trustedIpNets, clientFilter)
+	connectorMetrics := metricsBuilder.BuildConnectorMetrics()
+	if config.MetricsFrameSizes {
+		connectorMetrics.FrameLengths, connectorMetrics.ConnectionBytes = metricsBuilder.BuildFrameSizeHistograms()
+	}
+	if config.MetricsDerivedWindow > 0 {
+		connectionsPerSecond, errorRatio := metricsBuilder.BuildDerivedGauges()
+		wrapConnectorMetrics(connectorMetrics, config.MetricsDerivedWindow, connectionsPerSecond, errorRatio).
+			Start(ctx)
+	}
+
+	connector := server.NewConnector(connectorMetrics, config.UseProxyProtocol, config.ReceiveProxyProtocol, trustedIpNets, clientFilter)
+	if err := server.Routes.UseLoadBalancing(config.LoadBalance, connector.BackendConnections); err != nil {
+		logrus.WithError(err).Fatal("Invalid load balancing")
+	}
 	if config.NgrokToken != "" {
 		connector.UseNgrok(config.NgrokToken)
 	}
+	if config.ExecNotifier.Command != "" {
+		execNotifier, err := server.NewExecNotifier(config.ExecNotifier.Command, config.ExecNotifier.Args, config.ExecNotifier.Timeout)
+		if err != nil {
+			logrus.WithError(err).Fatal("Unable to setup exec notifier")
+		}
+		connector.UseConnectionNotifier(execNotifier, config.ExecNotifier.MaxConcurrent)
+		if config.ExecNotifier.RouteEvents {
+			connector.UseRouteEventNotifications()
+		}
+	}
+	server.Routes.ObserveRouteEvents(func(event *server.ConnectionEvent) {
+		connector.NotifyRouteEvent(ctx, event)
+	})
+	connector.UseLoginStartHandling(config.LoginStartTimeout, config.RequirePlayerInfo)
+	if err := connector.UseBackendIPFamily(config.BackendIpFamily); err != nil {
+		logrus.WithError(err).Fatal("Invalid backend IP family")
+	}
+	connector.UseBackendDialConcurrency(config.BackendDialConcurrency)
+	connector.UseBackendDialTimeout(config.BackendDialTimeout)
+	connector.UseBackendFirstByteTimeout(config.BackendFirstByteTimeout)
+	connector.UseBackendCircuitBreaker(config.BackendFailureThreshold, config.BackendCooldown)
+	connector.UseMaxConnectionLifetime(config.MaxConnectionLifetime)
+	if err := connector.UseRelayBufferSize(config.RelayBufferSize); err != nil {
+		logrus.WithError(err).Fatal("Invalid relay buffer size")
+	}
+	connector.UseUnknownVersionName(config.UnknownVersionName)
+	connector.UseNoBackendMessage(config.NoBackendMessage)
+	connector.UseUnavailableStatusMessage(config.UnavailableStatusMessage)
+	connector.UseHandshakeToken(config.HandshakeToken)
+	if len(config.LbHealthCheckSources) > 0 || config.LbHealthCheckEmpty {
+		if err := connector.UseLoadBalancerHealthChecks(config.LbHealthCheckSources, config.LbHealthCheckCloseDelay,
+			config.LbHealthCheckEmpty); err != nil {
+			logrus.WithError(err).Fatal("Invalid load balancer health checks")
+		}
+	}
+	if err := connector.UseStatusFavicons(config.StatusCacheFavicons, config.StatusDefaultFavicon); err != nil {
+		logrus.WithError(err).Fatal("Invalid status favicon")
+	}
+	if config.ForwardClientIp != "" {
+		if err := connector.UseClientIPForwarding(config.ForwardClientIp, config.ForwardClientIpToken); err != nil {
+			logrus.WithError(err).Fatal("Invalid client IP forwarding")
+		}
+	}
+	if err := connector.UseStatusPlayers(config.StatusPlayersSource, config.StatusMaxPlayers); err != nil {
+		logrus.WithError(err).Fatal("Invalid status players")
+	}
+	if config.StatusTransparent {
+		connector.UseTransparentStatus()
+	}
+	var statusCache *server.StatusCache
+	if config.StatusCacheTtl > 0 {
+		dial, err := server.NewBackendDialFunc(config.BackendIpFamily)
+		if err != nil {
+			logrus.WithError(err).Fatal("Invalid backend IP family")
+		}
+		statusCache = server.NewStatusCache(dial, config.StatusCacheTtl)
+		statusCache.StartUpdater(ctx, config.StatusCacheInterval, server.Routes)
+		connector.UseStatusCache(statusCache)
+		if config.StatusCacheAll {
+			connector.UseCachedStatusForReachableBackends()
+		}
+	}
+	if config.NetworkStatusMotd != "" {
+		if statusCache == nil {
+			logrus.Warn("The network status has no players without -status-cache-ttl")
+		}
+		err := connector.UseNetworkStatus(server.NetworkStatus{
+			MOTD:       config.NetworkStatusMotd,
+			Favicon:    config.NetworkStatusFavicon,
+			Routes:     config.NetworkStatusRoutes,
+			MaxPlayers: config.NetworkStatusMaxPlayers,
+			HideSample: config.NetworkStatusHideSample,
+		})
+		if err != nil {
+			logrus.WithError(err).Fatal("Invalid network status")
+		}
+	}
+	sizeGauges := []sizeGauge{
+		{
+			name: "relay_goroutines",
+			help: "The number of goroutines relaying between clients and backends, which is two per relayed connection",
+			read: func() float64 { return float64(connector.RelayGoroutines()) },
+		},
+		{
+			name: "registered_connections",
+			help: "The size of the registry of relayed connections",
+			read: func() float64 { return float64(connector.RegisteredConnections()) },
+		},
+	}
+	if statusCache != nil {
+		sizeGauges = append(sizeGauges, sizeGauge{
+			name: "status_cache_entries",
+			help: "The number of routes with a cached status",
+			read: func() float64 { return float64(statusCache.Size()) },
+		})
+	}
+	metricsBuilder.RegisterSizeGauges(sizeGauges)
+	if config.HealthCheckInterval > 0 {
+		dial, err := server.NewBackendDialFunc(config.BackendIpFamily)
+		if err != nil {
+			logrus.WithError(err).Fatal("Invalid backend IP family")
+		}
+		healthChecker := server.NewHealthChecker(dial, connectorMetrics.BackendHealthy)
+		healthChecker.Start(ctx, config.HealthCheckInterval, server.Routes)
+		connector.UseHealthChecker(healthChecker)
+	}
+	if len(config.DefaultByClient) > 0 {
+		clientDefaultRoutes, err := server.ParseClientDefaultRoutes(config.DefaultByClient)
+		if err != nil {
+			logrus.WithError(err).Fatal("Invalid default routes by client")
+		}
+		connector.UseClientDefaultRoutes(clientDefaultRoutes)
+	}
+	if len(config.ProtocolMapping) > 0 {
+		protocolRoutes, err := server.ParseProtocolRoutes(config.ProtocolMapping)
+		if err != nil {
+			logrus.WithError(err).Fatal("Invalid protocol mapping")
+		}
+		connector.UseProtocolRoutes(protocolRoutes)
+	}
+	if err := connector.UseProbeResponse(config.ProbeResponse, config.ProbeBanner); err != nil {
+		logrus.WithError(err).Fatal("Invalid probe response")
+	}
+	if len(config.AcceptedNextStates) > 0 {
+		states, err := server.ParseNextStates(config.AcceptedNextStates)
+		if err != nil {
+			logrus.WithError(err).Fatal("Invalid accepted next states")
+		}
+		connector.UseAcceptedNextStates(states)
+	}
+	if len(config.ProxyProtocolTlvs) > 0 {
+		tlvTypes, err := server.ParseTLVTypes(config.ProxyProtocolTlvs)
+		if err != nil {
+			logrus.WithError(err).Fatal("Invalid PROXY protocol TLV types")
+		}
+		connector.UseProxyProtoTLVForwarding(tlvTypes)
+	}
+	connector.UseProxyHeaderFallback(config.ProxyHeaderFallback)
+	connector.UseProxyHeaderForwarding(config.ProxyProtocolForwardHeader)
+	if err := connector.UseProxyProtocolVersion(config.ProxyProtocolVersion); err != nil {
+		logrus.WithError(err).Fatal("Invalid PROXY protocol version")
+	}
+	if config.ServerAddressSummaryInterval > 0 {
+		connector.UseServerAddressSummary(ctx, config.ServerAddressSummaryInterval, config.ServerAddressSummaryTop)
+	}
+	if config.WakeWarmup {
+		connector.UseWakeWarmup(config.WakeWarmupMessage)
+	}
+	if config.ReconnectDelayThreshold > 0 {
+		connector.UseReconnectDelay(config.ReconnectDelayThreshold, config.ReconnectDelayWindow, config.ReconnectDelayMax)
+	}
+	if config.MaintenanceFile != "" {
+		connector.WatchMaintenanceFile(ctx, config.MaintenanceFile, config.MaintenanceMessage)
+	}
 	err = connector.StartAcceptingConnections(ctx,
 		net.JoinHostPort("", strconv.Itoa(config.Port)),
 		config.ConnectionRateLimit,
@@ -151,11 +487,41 @@ func main() {
 	if err != nil {
 		logrus.Fatal(err)
 	}
+	for _, listener := range config.Listeners {
+		listenerConfig, err := server.ParseListenerConfig(listener)
+		if err != nil {
+			logrus.WithError(err).Fatal("Invalid listener")
+		}
+		err = connector.StartAcceptingConnectionsOn(ctx, listenerConfig, config.ConnectionRateLimit)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+	}
 
+	if config.ApiListeners {
+		connector.UseManagedListeners(ctx, config.ConnectionRateLimit)
+	}
 	if config.ApiBinding != "" {
-		server.StartApiServer(config.ApiBinding)
+		server.RegisterConfigApi(redactConfig(&config))
+		server.StartApiServer(config.ApiBinding, connector, config.EnableWebUi, server.ApiAuth{
+			Token:         config.ApiAuthToken,
+			ExemptMetrics: config.ApiAuthExemptMetrics,
+			ExemptVars:    config.ApiAuthExemptVars,
+		})
 	}
 
+	if err := server.ValidateRouteConflictPolicy(config.RouteConflictPolicy); err != nil {
+		logrus.WithError(err).Fatal("Invalid route conflict policy")
+	}
+	server.K8sWatcher.UseRouteConflictPolicy(config.RouteConflictPolicy)
+	server.K8sWatcher.UseScaleRetry(config.AutoScaleUpRetries, config.AutoScaleUpBackoff)
+	server.K8sWatcher.UseEndpoints(config.K8sUseEndpoints)
+	if err := server.K8sWatcher.UseLabelSelector(config.K8sLabelSelector); err != nil {
+		logrus.WithError(err).Fatal("Invalid Kubernetes label selector")
+	}
+	if config.K8sLeaderElection {
+		server.K8sWatcher.UseLeaderElection(config.K8sLeaseName, config.K8sLeaseNamespace)
+	}
 	if config.InKubeCluster {
 		err = server.K8sWatcher.StartInCluster(config.AutoScaleUp)
 		if err != nil {
@@ -172,8 +538,19 @@ func main() {
 		}
 	}
 
+	dockerWatcherConfig := server.DockerWatcherConfig{
+		Socket:                   config.DockerSocket,
+		TimeoutSeconds:           config.DockerTimeout,
+		RefreshIntervalSeconds:   config.DockerRefreshInterval,
+		HTTPHeaders:              dockerHTTPHeaders(&config),
+		RouteStoppedContainers:   config.DockerRouteStopped,
+		WatchEvents:              config.DockerWatchEvents,
+		ReconcileIntervalSeconds: config.DockerReconcileInterval,
+		EventActions:             config.DockerEvents,
+		RouteConflictPolicy:      config.RouteConflictPolicy,
+	}
 	if config.InDocker {
-		err = server.DockerWatcher.Start(config.DockerSocket, config.DockerTimeout, config.DockerRefreshInterval)
+		err = server.DockerWatcher.Start(dockerWatcherConfig)
 		if err != nil {
 			logrus.WithError(err).Fatal("Unable to start docker integration")
 		} else {
@@ -182,7 +559,7 @@ func main() {
 	}
 
 	if config.InDockerSwarm {
-		err = server.DockerSwarmWatcher.Start(config.DockerSocket, config.DockerTimeout, config.DockerRefreshInterval)
+		err = server.DockerSwarmWatcher.Start(dockerWatcherConfig)
 		if err != nil {
 			logrus.WithError(err).Fatal("Unable to start docker swarm integration")
 		} else {
@@ -190,17 +567,32 @@ func main() {
 		}
 	}
 
-	server.Routes.SimplifySRV(config.SimplifySRV)
-
 	err = metricsBuilder.Start(ctx)
 	if err != nil {
 		logrus.WithError(err).Fatal("Unable to start metrics reporter")
 	}
 
-	// wait for process-stop signal
-	<-c
+	var idleShutdown <-chan struct{}
+	if config.IdleShutdown > 0 {
+		idleShutdown = connector.WatchIdleShutdown(ctx, config.IdleShutdown, config.IdleShutdownRequireNoRoutes, server.Routes)
+	}
+
+	// wait for process-stop signal or being idle
+	select {
+	case <-c:
+	case <-idleShutdown:
+	}
 	logrus.Info("Stopping. Waiting for connections to complete...")
 	signal.Stop(c)
-	connector.WaitForConnections()
+	waitCtx := context.Background()
+	if config.ShutdownTimeout > 0 {
+		var waitCancel context.CancelFunc
+		waitCtx, waitCancel = context.WithTimeout(waitCtx, config.ShutdownTimeout)
+		defer waitCancel()
+	}
+	if closed := connector.WaitForConnections(waitCtx); closed > 0 {
+		logrus.WithField("timeout", config.ShutdownTimeout).
+			Warnf("Closed %d connection(s) that didn't complete within the shutdown timeout", closed)
+	}
 	logrus.Info("Stopped")
 }
@@ -3,14 +3,18 @@ package main
 import (
 	"context"
 	"errors"
+	"expvar"
 	"fmt"
 	"strings"
 	"time"
 
 	kitlogrus "github.com/go-kit/kit/log/logrus"
+	"github.com/go-kit/kit/metrics"
 	discardMetrics "github.com/go-kit/kit/metrics/discard"
+	"github.com/go-kit/kit/metrics/dogstatsd"
 	expvarMetrics "github.com/go-kit/kit/metrics/expvar"
 	kitinflux "github.com/go-kit/kit/metrics/influx"
+	"github.com/go-kit/kit/metrics/influxstatsd"
 	prometheusMetrics "github.com/go-kit/kit/metrics/prometheus"
 	influx "github.com/influxdata/influxdb1-client/v2"
 	"github.com/itzg/mc-router/server"
@@ -21,17 +25,41 @@ import (
 
 type MetricsBuilder interface {
 	BuildConnectorMetrics() *server.ConnectorMetrics
+	// BuildDerivedGauges builds the gauges of the connections per second and error ratio over a sliding window
+	BuildDerivedGauges() (connectionsPerSecond metrics.Gauge, errorRatio metrics.Gauge)
+	// BuildFrameSizeHistograms builds the histograms of handshake/login frame lengths and bytes per connection
+	BuildFrameSizeHistograms() (frameLengths metrics.Histogram, connectionBytes metrics.Histogram)
+	// RegisterSizeGauges reports the internal sizes, which are read when the metrics are collected
+	RegisterSizeGauges(gauges []sizeGauge)
 	Start(ctx context.Context) error
 }
 
+// sizeGauge is an unlabeled gauge of an internal size, such as of a registry or cache, for diagnosing leaks and sizing
+type sizeGauge struct {
+	name string
+	help string
+	read func() float64
+}
+
+// Metrics backends selected by -metrics-backend
+const (
+	MetricsBackendDiscard    = "discard"
+	MetricsBackendExpvar     = "expvar"
+	MetricsBackendInfluxdb   = "influxdb"
+	MetricsBackendPrometheus = "prometheus"
+	MetricsBackendStatsD     = "statsd"
+)
+
 func NewMetricsBuilder(backend string, config *MetricsBackendConfig) MetricsBuilder {
 	switch strings.ToLower(backend) {
-	case "expvar":
+	case MetricsBackendExpvar:
 		return &expvarMetricsBuilder{}
-	case "prometheus":
+	case MetricsBackendPrometheus:
 		return &prometheusMetricsBuilder{}
-	case "influxdb":
+	case MetricsBackendInfluxdb:
 		return &influxMetricsBuilder{config: config}
+	case MetricsBackendStatsD:
+		return &statsdMetricsBuilder{config: config}
 	default:
 		return &discardMetricsBuilder{}
 	}
@@ -48,14 +76,39 @@ func (b expvarMetricsBuilder) Start(ctx context.Context) error {
 func (b expvarMetricsBuilder) BuildConnectorMetrics() *server.ConnectorMetrics {
 	c := expvarMetrics.NewCounter("connections")
 	return &server.ConnectorMetrics{
-		Errors:              expvarMetrics.NewCounter("errors").With("subsystem", "connector"),
-		BytesTransmitted:    expvarMetrics.NewCounter("bytes"),
-		ConnectionsFrontend: c,
-		ConnectionsBackend:  c,
-		ActiveConnections:   expvarMetrics.NewGauge("active_connections"),
+		Errors:                expvarMetrics.NewCounter("errors").With("subsystem", "connector"),
+		BytesTransmitted:      expvarMetrics.NewCounter("bytes"),
+		ConnectionsFrontend:   c,
+		ConnectionsBackend:    c,
+		ActiveConnections:     expvarMetrics.NewGauge("active_connections"),
+		QueuedBackendDials:    expvarMetrics.NewGauge("queued_backend_dials"),
+		RateLimitAvailable:    expvarMetrics.NewGauge("rate_limit_available"),
+		LastConnection:        expvarMetrics.NewGauge("last_connection_timestamp_seconds"),
+		BackendCircuitOpen:    expvarMetrics.NewGauge("backend_circuit_open"),
+		BackendHealthy:        expvarMetrics.NewGauge("backend_healthy"),
+		RouteChanges:          expvarMetrics.NewCounter("route_changes"),
+		BackendConnectLatency: expvarMetrics.NewHistogram("backend_connect_seconds", 50),
+		Draining:              expvarMetrics.NewGauge("draining"),
+	}
+}
+
+func (b expvarMetricsBuilder) BuildDerivedGauges() (metrics.Gauge, metrics.Gauge) {
+	return expvarMetrics.NewGauge("connections_per_second"), expvarMetrics.NewGauge("error_ratio")
+}
+
+func (b expvarMetricsBuilder) RegisterSizeGauges(gauges []sizeGauge) {
+	for _, gauge := range gauges {
+		read := gauge.read
+		expvar.Publish(gauge.name, expvar.Func(func() any {
+			return read()
+		}))
 	}
 }
 
+func (b expvarMetricsBuilder) BuildFrameSizeHistograms() (metrics.Histogram, metrics.Histogram) {
+	return expvarMetrics.NewHistogram("frame_length_bytes", 50), expvarMetrics.NewHistogram("connection_bytes", 50)
+}
+
 type discardMetricsBuilder struct {
 }
 
@@ -66,17 +119,39 @@ func (b discardMetricsBuilder) Start(ctx context.Context) error {
 
 func (b discardMetricsBuilder) BuildConnectorMetrics() *server.ConnectorMetrics {
 	return &server.ConnectorMetrics{
-		Errors:              discardMetrics.NewCounter(),
-		BytesTransmitted:    discardMetrics.NewCounter(),
-		ConnectionsFrontend: discardMetrics.NewCounter(),
-		ConnectionsBackend:  discardMetrics.NewCounter(),
-		ActiveConnections:   discardMetrics.NewGauge(),
+		Errors:                discardMetrics.NewCounter(),
+		BytesTransmitted:      discardMetrics.NewCounter(),
+		ConnectionsFrontend:   discardMetrics.NewCounter(),
+		ConnectionsBackend:    discardMetrics.NewCounter(),
+		ActiveConnections:     discardMetrics.NewGauge(),
+		QueuedBackendDials:    discardMetrics.NewGauge(),
+		RateLimitAvailable:    discardMetrics.NewGauge(),
+		LastConnection:        discardMetrics.NewGauge(),
+		BackendCircuitOpen:    discardMetrics.NewGauge(),
+		BackendHealthy:        discardMetrics.NewGauge(),
+		RouteChanges:          discardMetrics.NewCounter(),
+		BackendConnectLatency: discardMetrics.NewHistogram(),
+		Draining:              discardMetrics.NewGauge(),
 	}
 }
 
+func (b discardMetricsBuilder) BuildDerivedGauges() (metrics.Gauge, metrics.Gauge) {
+	return discardMetrics.NewGauge(), discardMetrics.NewGauge()
+}
+
+func (b discardMetricsBuilder) RegisterSizeGauges([]sizeGauge) {
+	// nothing needed
+}
+
+func (b discardMetricsBuilder) BuildFrameSizeHistograms() (metrics.Histogram, metrics.Histogram) {
+	return discardMetrics.NewHistogram(), discardMetrics.NewHistogram()
+}
+
 type influxMetricsBuilder struct {
 	config  *MetricsBackendConfig
 	metrics *kitinflux.Influx
+	// sizeGauges are sampled before each write
+	sizeGauges []sizeGauge
 }
 
 func (b *influxMetricsBuilder) Start(ctx context.Context) error {
@@ -95,7 +170,11 @@ func (b *influxMetricsBuilder) Start(ctx context.Context) error {
 		return fmt.Errorf("failed to create influx http client: %w", err)
 	}
 
-	go b.metrics.WriteLoop(ctx, ticker.C, client)
+	writes := ticker.C
+	if len(b.sizeGauges) > 0 {
+		writes = b.sampleSizeGauges(ctx, ticker.C)
+	}
+	go b.metrics.WriteLoop(ctx, writes, client)
 
 	logrus.WithField("addr", influxConfig.Addr).
 		Debug("reporting metrics to influxdb")
@@ -115,12 +194,65 @@ func (b *influxMetricsBuilder) BuildConnectorMetrics() *server.ConnectorMetrics
 
 	c := metrics.NewCounter("mc_router_connections")
 	return &server.ConnectorMetrics{
-		Errors:              metrics.NewCounter("mc_router_errors"),
-		BytesTransmitted:    metrics.NewCounter("mc_router_transmitted_bytes"),
-		ConnectionsFrontend: c.With("side", "frontend"),
-		ConnectionsBackend:  c.With("side", "backend"),
-		ActiveConnections:   metrics.NewGauge("mc_router_connections_active"),
+		Errors:                metrics.NewCounter("mc_router_errors"),
+		BytesTransmitted:      metrics.NewCounter("mc_router_transmitted_bytes"),
+		ConnectionsFrontend:   c.With("side", "frontend"),
+		ConnectionsBackend:    c.With("side", "backend"),
+		ActiveConnections:     metrics.NewGauge("mc_router_connections_active"),
+		QueuedBackendDials:    metrics.NewGauge("mc_router_backend_dials_queued"),
+		RateLimitAvailable:    metrics.NewGauge("mc_router_rate_limit_available"),
+		LastConnection:        metrics.NewGauge("mc_router_last_connection_timestamp_seconds"),
+		BackendCircuitOpen:    metrics.NewGauge("mc_router_backend_circuit_open"),
+		BackendHealthy:        metrics.NewGauge("mc_router_backend_healthy"),
+		RouteChanges:          metrics.NewCounter("mc_router_route_changes"),
+		BackendConnectLatency: metrics.NewHistogram("mc_router_backend_connect_seconds"),
+		Draining:              metrics.NewGauge("mc_router_draining"),
+	}
+}
+
+func (b *influxMetricsBuilder) BuildDerivedGauges() (metrics.Gauge, metrics.Gauge) {
+	return b.metrics.NewGauge("mc_router_connections_per_second"), b.metrics.NewGauge("mc_router_error_ratio")
+}
+
+func (b *influxMetricsBuilder) RegisterSizeGauges(gauges []sizeGauge) {
+	b.sizeGauges = gauges
+}
+
+// sampleSizeGauges sets the size gauges upon each tick and then passes the tick along to trigger the write
+func (b *influxMetricsBuilder) sampleSizeGauges(ctx context.Context, ticks <-chan time.Time) <-chan time.Time {
+	gauges := make([]metrics.Gauge, len(b.sizeGauges))
+	for i, sizeGauge := range b.sizeGauges {
+		gauges[i] = b.metrics.NewGauge("mc_router_" + sizeGauge.name)
 	}
+	return sampleSizeGauges(ctx, ticks, b.sizeGauges, gauges)
+}
+
+// sampleSizeGauges sets the gauges, parallel to the sizeGauges, upon each tick and then passes the tick along to
+// trigger the write of a backend that pushes the metrics
+func sampleSizeGauges(ctx context.Context, ticks <-chan time.Time, sizeGauges []sizeGauge, gauges []metrics.Gauge) <-chan time.Time {
+	writes := make(chan time.Time)
+	go func() {
+		for {
+			select {
+			case tick := <-ticks:
+				for i, sizeGauge := range sizeGauges {
+					gauges[i].Set(sizeGauge.read())
+				}
+				select {
+				case writes <- tick:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return writes
+}
+
+func (b *influxMetricsBuilder) BuildFrameSizeHistograms() (metrics.Histogram, metrics.Histogram) {
+	return b.metrics.NewHistogram("mc_router_frame_length_bytes"), b.metrics.NewHistogram("mc_router_connection_bytes")
 }
 
 type prometheusMetricsBuilder struct {
@@ -139,21 +271,21 @@ func (b prometheusMetricsBuilder) BuildConnectorMetrics() *server.ConnectorMetri
 		Namespace: "mc_router",
 		Name:      "errors",
 		Help:      "The total number of errors",
-	}, []string{"type"}))
+	}, []string{"type", "listener"}))
 	return &server.ConnectorMetrics{
 		Errors: pcv,
 		BytesTransmitted: prometheusMetrics.NewCounter(promauto.NewCounterVec(prometheus.CounterOpts{
 			Namespace: "mc_router",
 			Name:      "bytes",
 			Help:      "The total number of bytes transmitted",
-		}, nil)),
+		}, []string{"listener"})),
 		ConnectionsFrontend: prometheusMetrics.NewCounter(promauto.NewCounterVec(prometheus.CounterOpts{
 			Namespace:   "mc_router",
 			Subsystem:   "frontend",
 			Name:        "connections",
 			Help:        "The total number of connections",
 			ConstLabels: prometheus.Labels{"side": "frontend"},
-		}, nil)),
+		}, []string{"listener"})),
 		ConnectionsBackend: prometheusMetrics.NewCounter(promauto.NewCounterVec(prometheus.CounterOpts{
 			Namespace:   "mc_router",
 			Subsystem:   "backend",
@@ -166,5 +298,217 @@ func (b prometheusMetricsBuilder) BuildConnectorMetrics() *server.ConnectorMetri
 			Name:      "active_connections",
 			Help:      "The number of active connections",
 		}, nil)),
+		QueuedBackendDials: prometheusMetrics.NewGauge(promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "mc_router",
+			Name:      "queued_backend_dials",
+			Help:      "The number of backend dials waiting due to the dial concurrency limit",
+		}, nil)),
+		RateLimitAvailable: prometheusMetrics.NewGauge(promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "mc_router",
+			Name:      "rate_limit_available",
+			Help:      "The number of connections that can be accepted before the connection rate limit applies",
+		}, nil)),
+		LastConnection: prometheusMetrics.NewGauge(promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "mc_router",
+			Name:      "last_connection_timestamp_seconds",
+			Help:      "The Unix time a client was last connected to the backend",
+		}, []string{"host"})),
+		BackendCircuitOpen: prometheusMetrics.NewGauge(promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "mc_router",
+			Name:      "backend_circuit_open",
+			Help:      "Whether dials to the backend are stopped, as 1, due to repeated dial failures",
+		}, []string{"host"})),
+		BackendHealthy: prometheusMetrics.NewGauge(promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "mc_router",
+			Name:      "backend_healthy",
+			Help:      "Whether the backend of the route responded, as 1, to its last health check",
+		}, []string{"server_address"})),
+		RouteChanges: prometheusMetrics.NewCounter(promauto.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "mc_router",
+			Name:      "route_changes",
+			Help:      "The total number of routes added and removed",
+		}, []string{"event", "source"})),
+		BackendConnectLatency: prometheusMetrics.NewHistogram(promauto.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "mc_router",
+			Name:      "backend_connect_seconds",
+			Help:      "The seconds taken to connect to the backend, including any wait due to the dial concurrency limit",
+			// 1 ms up to about 16 s, such as while a backend is woken
+			Buckets: prometheus.ExponentialBuckets(0.001, 2, 15),
+		}, []string{"host"})),
+		Draining: prometheusMetrics.NewGauge(promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "mc_router",
+			Name:      "draining",
+			Help:      "Whether new connections are being drained away, as 1, while still accepted",
+		}, nil)),
+	}
+}
+
+func (b prometheusMetricsBuilder) BuildDerivedGauges() (metrics.Gauge, metrics.Gauge) {
+	return prometheusMetrics.NewGauge(promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "mc_router",
+			Name:      "connections_per_second",
+			Help:      "The rate of frontend connections over the derived metrics window",
+		}, nil)),
+		prometheusMetrics.NewGauge(promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "mc_router",
+			Name:      "error_ratio",
+			Help:      "The ratio of errors, excluding client aborts, to frontend connections over the derived metrics window, which can exceed 1",
+		}, nil))
+}
+
+func (b prometheusMetricsBuilder) RegisterSizeGauges(gauges []sizeGauge) {
+	for _, gauge := range gauges {
+		promauto.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: "mc_router",
+			Name:      gauge.name,
+			Help:      gauge.help,
+		}, gauge.read)
 	}
 }
+
+func (b prometheusMetricsBuilder) BuildFrameSizeHistograms() (metrics.Histogram, metrics.Histogram) {
+	return prometheusMetrics.NewHistogram(promauto.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "mc_router",
+			Name:      "frame_length_bytes",
+			Help:      "The length of frames read during the handshake/login phase",
+			// 16 bytes up to 1 MiB
+			Buckets: prometheus.ExponentialBuckets(16, 4, 9),
+		}, nil)),
+		prometheusMetrics.NewHistogram(promauto.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "mc_router",
+			Name:      "connection_bytes",
+			Help:      "The total bytes relayed in both directions per connection, observed at close",
+			// 1 KiB up to 256 MiB
+			Buckets: prometheus.ExponentialBuckets(1024, 4, 10),
+		}, nil))
+}
+
+// StatsD tag styles, which are the extensions of the StatsD line format that carry the labels of the metrics
+const (
+	StatsdTagStyleDogstatsd = "dogstatsd"
+	StatsdTagStyleInflux    = "influx"
+)
+
+// statsdClient buffers the metrics of one of the StatsD tag styles and periodically sends them
+type statsdClient interface {
+	NewCounter(name string) metrics.Counter
+	NewGauge(name string) metrics.Gauge
+	NewHistogram(name string) metrics.Histogram
+	SendLoop(ctx context.Context, ticks <-chan time.Time, network, address string)
+}
+
+type dogstatsdClient struct {
+	*dogstatsd.Dogstatsd
+}
+
+func (c dogstatsdClient) NewCounter(name string) metrics.Counter {
+	return c.Dogstatsd.NewCounter(name, 1)
+}
+
+func (c dogstatsdClient) NewGauge(name string) metrics.Gauge {
+	return c.Dogstatsd.NewGauge(name)
+}
+
+func (c dogstatsdClient) NewHistogram(name string) metrics.Histogram {
+	return c.Dogstatsd.NewHistogram(name, 1)
+}
+
+type influxstatsdClient struct {
+	*influxstatsd.Influxstatsd
+}
+
+func (c influxstatsdClient) NewCounter(name string) metrics.Counter {
+	return c.Influxstatsd.NewCounter(name, 1)
+}
+
+func (c influxstatsdClient) NewGauge(name string) metrics.Gauge {
+	return c.Influxstatsd.NewGauge(name)
+}
+
+func (c influxstatsdClient) NewHistogram(name string) metrics.Histogram {
+	return c.Influxstatsd.NewHistogram(name, 1)
+}
+
+type statsdMetricsBuilder struct {
+	config  *MetricsBackendConfig
+	metrics statsdClient
+	// sizeGauges are sampled before each send
+	sizeGauges []sizeGauge
+}
+
+// newStatsdClient creates the client of the configured tag style, where the extra tags are included with every metric
+func newStatsdClient(config *MetricsBackendConfig) statsdClient {
+	logger := kitlogrus.NewLogger(logrus.StandardLogger())
+	tags := make([]string, 0, 2*len(config.Statsd.Tags))
+	for name, value := range config.Statsd.Tags {
+		tags = append(tags, name, value)
+	}
+
+	if strings.ToLower(config.Statsd.TagStyle) == StatsdTagStyleInflux {
+		return influxstatsdClient{influxstatsd.New("", logger, tags...)}
+	}
+	return dogstatsdClient{dogstatsd.New("", logger, tags...)}
+}
+
+func (b *statsdMetricsBuilder) Start(ctx context.Context) error {
+	statsdConfig := &b.config.Statsd
+	if statsdConfig.Addr == "" {
+		return errors.New("statsd addr is required")
+	}
+	switch strings.ToLower(statsdConfig.TagStyle) {
+	case StatsdTagStyleDogstatsd, StatsdTagStyleInflux:
+	default:
+		return fmt.Errorf("unknown statsd tag style %q, expected %s or %s",
+			statsdConfig.TagStyle, StatsdTagStyleDogstatsd, StatsdTagStyleInflux)
+	}
+
+	ticker := time.NewTicker(statsdConfig.Interval)
+	sends := ticker.C
+	if len(b.sizeGauges) > 0 {
+		gauges := make([]metrics.Gauge, len(b.sizeGauges))
+		for i, sizeGauge := range b.sizeGauges {
+			gauges[i] = b.metrics.NewGauge("mc_router_" + sizeGauge.name)
+		}
+		sends = sampleSizeGauges(ctx, ticker.C, b.sizeGauges, gauges)
+	}
+	go b.metrics.SendLoop(ctx, sends, "udp", statsdConfig.Addr)
+
+	logrus.WithField("addr", statsdConfig.Addr).
+		Debug("reporting metrics to statsd")
+
+	return nil
+}
+
+func (b *statsdMetricsBuilder) BuildConnectorMetrics() *server.ConnectorMetrics {
+	metrics := newStatsdClient(b.config)
+	b.metrics = metrics
+
+	c := metrics.NewCounter("mc_router_connections")
+	return &server.ConnectorMetrics{
+		Errors:                metrics.NewCounter("mc_router_errors"),
+		BytesTransmitted:      metrics.NewCounter("mc_router_transmitted_bytes"),
+		ConnectionsFrontend:   c.With("side", "frontend"),
+		ConnectionsBackend:    c.With("side", "backend"),
+		ActiveConnections:     metrics.NewGauge("mc_router_connections_active"),
+		QueuedBackendDials:    metrics.NewGauge("mc_router_backend_dials_queued"),
+		RateLimitAvailable:    metrics.NewGauge("mc_router_rate_limit_available"),
+		LastConnection:        metrics.NewGauge("mc_router_last_connection_timestamp_seconds"),
+		BackendCircuitOpen:    metrics.NewGauge("mc_router_backend_circuit_open"),
+		BackendHealthy:        metrics.NewGauge("mc_router_backend_healthy"),
+		RouteChanges:          metrics.NewCounter("mc_router_route_changes"),
+		BackendConnectLatency: metrics.NewHistogram("mc_router_backend_connect_seconds"),
+		Draining:              metrics.NewGauge("mc_router_draining"),
+	}
+}
+
+func (b *statsdMetricsBuilder) BuildDerivedGauges() (metrics.Gauge, metrics.Gauge) {
+	return b.metrics.NewGauge("mc_router_connections_per_second"), b.metrics.NewGauge("mc_router_error_ratio")
+}
+
+func (b *statsdMetricsBuilder) RegisterSizeGauges(gauges []sizeGauge) {
+	b.sizeGauges = gauges
+}
+
+func (b *statsdMetricsBuilder) BuildFrameSizeHistograms() (metrics.Histogram, metrics.Histogram) {
+	return b.metrics.NewHistogram("mc_router_frame_length_bytes"), b.metrics.NewHistogram("mc_router_connection_bytes")
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsdMetricsBuilder(t *testing.T) {
+	tests := []struct {
+		tagStyle string
+		expected []string
+	}{
+		{
+			tagStyle: StatsdTagStyleDogstatsd,
+			expected: []string{
+				"mc_router_errors:1.000000|c|#env:test,type:read",
+				"mc_router_connections_active:2.000000|g|#env:test",
+				"mc_router_open_connections:3.000000|g|#env:test",
+			},
+		},
+		{
+			tagStyle: StatsdTagStyleInflux,
+			expected: []string{
+				"mc_router_errors,env=test,type=read:1.000000|c",
+				"mc_router_connections_active,env=test:2.000000|g",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tagStyle, func(t *testing.T) {
+			agent, err := net.ListenPacket("udp", "127.0.0.1:0")
+			require.NoError(t, err)
+			//goland:noinspection GoUnhandledErrorResult
+			defer agent.Close()
+
+			config := &MetricsBackendConfig{}
+			config.Statsd.Addr = agent.LocalAddr().String()
+			config.Statsd.Interval = 10 * time.Millisecond
+			config.Statsd.TagStyle = tt.tagStyle
+			config.Statsd.Tags = map[string]string{"env": "test"}
+			builder := NewMetricsBuilder(MetricsBackendStatsD, config)
+
+			connectorMetrics := builder.BuildConnectorMetrics()
+			connectorMetrics.Errors.With("type", "read").Add(1)
+			connectorMetrics.ActiveConnections.Set(2)
+			builder.RegisterSizeGauges([]sizeGauge{
+				{name: "open_connections", read: func() float64 { return 3 }},
+			})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			require.NoError(t, builder.Start(ctx))
+
+			// each metric is sent as its own datagram
+			received := make(map[string]bool)
+			require.NoError(t, agent.SetReadDeadline(time.Now().Add(5*time.Second)))
+			buf := make([]byte, 65536)
+			for !containsAll(received, tt.expected) {
+				n, _, err := agent.ReadFrom(buf)
+				require.NoError(t, err)
+				received[strings.TrimSpace(string(buf[:n]))] = true
+			}
+		})
+	}
+}
+
+func containsAll(received map[string]bool, expected []string) bool {
+	for _, line := range expected {
+		if !received[line] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestStatsdMetricsBuilder_requiresAddr(t *testing.T) {
+	config := &MetricsBackendConfig{}
+	config.Statsd.TagStyle = StatsdTagStyleDogstatsd
+	builder := NewMetricsBuilder(MetricsBackendStatsD, config)
+	builder.BuildConnectorMetrics()
+
+	assert.Error(t, builder.Start(context.Background()))
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/itzg/mc-router/server"
+)
+
+// testRouteProtocolVersion is presented to the backend during the status ping, where any recent version is
+// acceptable since servers respond to status requests regardless of the client's version
+const testRouteProtocolVersion = 767
+
+// testRoute resolves the serverAddress using the statically configured routes and performs a status ping of
+// its backend, printing the outcome. Returns the process exit code.
+func testRoute(config *Config, serverAddress string) int {
+	registerStaticRoutes(config)
+
+	resolution := server.Routes.ResolveServerAddress(serverAddress)
+	fmt.Printf("Normalized address: %s\n", resolution.NormalizedAddress)
+	fmt.Printf("Match:              %s\n", resolution.Match)
+	if resolution.Backend == "" {
+		fmt.Println("No backend is routed for the server address")
+		return 1
+	}
+	fmt.Printf("Backend:            %s\n", resolution.Backend)
+
+	serverName := resolution.NormalizedAddress
+	if options, exists := server.Routes.GetRouteOptions(resolution.NormalizedAddress); exists && options.BackendServerName != "" {
+		serverName = options.BackendServerName
+	}
+
+	// dial in the same way as client connections are relayed
+	dial, err := server.NewBackendDialFunc(config.BackendIpFamily)
+	if err != nil {
+		fmt.Printf("Invalid backend IP family: %s\n", err)
+		return 1
+	}
+
+	// a load balanced route is represented by its first backend
+	backend := server.SplitBackends(resolution.Backend)[0]
+	backendStatus, err := server.FetchBackendStatus(context.Background(), dial, backend, serverName, testRouteProtocolVersion)
+	if err != nil {
+		fmt.Printf("Status ping failed: %s\n", err)
+		return 1
+	}
+
+	status := backendStatus.Status
+	fmt.Printf("Version:            %s (protocol %d)\n", status.Version.Name, status.Version.Protocol)
+	fmt.Printf("Players:            %d/%d\n", status.Players.Online, status.Players.Max)
+	fmt.Printf("Description:        %s\n", status.Description.Text)
+	fmt.Printf("Latency:            %s\n", backendStatus.Latency)
+	return 0
+}
@@ -0,0 +1,33 @@
+package mcproto
+
+import (
+	"io"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+)
+
+// protocolLogging enables the debug logs of each frame and packet read, which are too frequent on busy servers
+// to be included with the general debug logs
+var protocolLogging atomic.Bool
+
+var discardLogger = &logrus.Logger{
+	Out:       io.Discard,
+	Formatter: new(logrus.TextFormatter),
+	Hooks:     make(logrus.LevelHooks),
+	Level:     logrus.PanicLevel,
+}
+
+// EnableProtocolLogging includes the debug logs of each frame and packet read in the standard logger, which also
+// needs to be at the debug level
+func EnableProtocolLogging(enabled bool) {
+	protocolLogging.Store(enabled)
+}
+
+// protocolLog returns the logger for the protocol-level logs, which discards them unless enabled
+func protocolLog() *logrus.Logger {
+	if protocolLogging.Load() {
+		return logrus.StandardLogger()
+	}
+	return discardLogger
+}
@@ -0,0 +1,17 @@
+package mcproto
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnableProtocolLogging(t *testing.T) {
+	defer EnableProtocolLogging(false)
+
+	assert.False(t, protocolLog().IsLevelEnabled(logrus.DebugLevel))
+
+	EnableProtocolLogging(true)
+	assert.Same(t, logrus.StandardLogger(), protocolLog())
+}
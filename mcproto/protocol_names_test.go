@@ -0,0 +1,14 @@
+package mcproto
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProtocolName(t *testing.T) {
+	assert.Equal(t, "1.8-1.8.9", ProtocolName(47))
+	assert.Equal(t, "1.20.2", ProtocolName(ProtocolVersion1_20_2))
+	assert.Equal(t, "1.21-1.21.1", ProtocolName(767))
+	assert.Empty(t, ProtocolName(5))
+}
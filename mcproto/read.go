@@ -4,19 +4,18 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/binary"
+	"encoding/json"
 	"io"
 	"net"
-	"strings"
-	"time"
 
+	"github.com/google/uuid"
 	"github.com/pkg/errors"
-	"github.com/sirupsen/logrus"
 	"golang.org/x/text/encoding/unicode"
 	"golang.org/x/text/transform"
 )
 
 func ReadPacket(reader io.Reader, addr net.Addr, state State) (*Packet, error) {
-	logrus.
+	protocolLog().
 		WithField("client", addr).
 		Debug("Reading packet")
 
@@ -50,7 +49,7 @@ func ReadPacket(reader io.Reader, addr net.Addr, state State) (*Packet, error) {
 
 	packet.Data = remainder.Bytes()
 
-	logrus.
+	protocolLog().
 		WithField("client", addr).
 		WithField("packet", packet).
 		Debug("Read packet")
@@ -58,7 +57,7 @@ func ReadPacket(reader io.Reader, addr net.Addr, state State) (*Packet, error) {
 }
 
 func ReadLegacyServerListPing(reader *bufio.Reader, addr net.Addr) (*Packet, error) {
-	logrus.
+	protocolLog().
 		WithField("client", addr).
 		Debug("Reading legacy server list ping")
 
@@ -148,8 +147,13 @@ func ReadUTF16BEString(reader io.Reader, symbolLen uint16) (string, error) {
 	return string(result), nil
 }
 
+// MaxFrameLength is the largest frame length accepted, which is 2^21 - 1 as the most that fits in a 3 byte VarInt
+const MaxFrameLength = 2097151
+
+// ReadFrame reads a length-prefixed frame, blocking until all of it has been read. Reads can be aborted by
+// closing or setting a deadline on the underlying connection.
 func ReadFrame(reader io.Reader, addr net.Addr) (*Frame, error) {
-	logrus.
+	protocolLog().
 		WithField("client", addr).
 		Debug("Reading frame")
 
@@ -161,44 +165,32 @@ func ReadFrame(reader io.Reader, addr net.Addr) (*Frame, error) {
 		return nil, err
 	}
 
-	// Limit frame length to 2^21 - 1
-	if frame.Length > 2097151 {
+	if frame.Length > MaxFrameLength {
 		return nil, errors.Errorf("frame length %d too large", frame.Length)
 	}
 
-	logrus.
+	protocolLog().
 		WithField("client", addr).
 		WithField("length", frame.Length).
 		Debug("Read frame length")
 
 	frame.Payload = make([]byte, frame.Length)
-	total := 0
-	for total < frame.Length {
-		readIntoThis := frame.Payload[total:]
-		n, err := reader.Read(readIntoThis)
-		if err != nil {
-			if err != io.EOF {
-				return nil, err
-			}
-		}
-		total += n
-		logrus.
-			WithField("client", addr).
-			WithField("total", total).
-			WithField("length", frame.Length).
-			Debug("Reading frame content")
-
-		if n == 0 {
-			logrus.
-				WithField("client", addr).
-				WithField("frame", frame).
-				Debug("No progress on frame reading")
-
-			time.Sleep(100 * time.Millisecond)
+	// blocks until the whole frame has arrived, where a connection closed part way through the frame
+	// results in io.ErrUnexpectedEOF
+	n, err := io.ReadFull(reader, frame.Payload)
+	protocolLog().
+		WithField("client", addr).
+		WithField("total", n).
+		WithField("length", frame.Length).
+		Debug("Read frame content")
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.ErrUnexpectedEOF
 		}
+		return nil, err
 	}
 
-	logrus.
+	protocolLog().
 		WithField("client", addr).
 		WithField("frame", frame).
 		Debug("Read frame")
@@ -230,26 +222,22 @@ func ReadVarInt(reader io.Reader) (int, error) {
 	return 0, errors.New("VarInt is too big")
 }
 
+// ReadString reads a VarInt length-prefixed UTF-8 string, where the length is in bytes and is bounded by
+// MaxFrameLength since a string can't be longer than the frame containing it
 func ReadString(reader io.Reader) (string, error) {
 	length, err := ReadVarInt(reader)
 	if err != nil {
 		return "", err
 	}
-
-	b := make([]byte, 1)
-	var strBuilder strings.Builder
-	for i := 0; i < length; i++ {
-		n, err := reader.Read(b)
-		if err != nil {
-			return "", err
-		}
-		if n == 0 {
-			continue
-		}
-		strBuilder.WriteByte(b[0])
+	if length < 0 || length > MaxFrameLength {
+		return "", errors.Errorf("string length %d is invalid", length)
 	}
 
-	return strBuilder.String(), nil
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
 }
 
 func ReadByte(reader io.Reader) (byte, error) {
@@ -313,3 +301,167 @@ func ReadHandshake(data interface{}) (*Handshake, error) {
 	handshake.NextState = nextState
 	return handshake, nil
 }
+
+func ReadBoolean(reader io.Reader) (bool, error) {
+	b, err := ReadByte(reader)
+	if err != nil {
+		return false, err
+	}
+	return b == 0x01, nil
+}
+
+func ReadUUID(reader io.Reader) (uuid.UUID, error) {
+	var result uuid.UUID
+	_, err := io.ReadFull(reader, result[:])
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return result, nil
+}
+
+// ReadLoginStart parses the login start packet data using the layout of the given protocol version.
+// The PlayerUUID is left as uuid.Nil when the protocol version doesn't provide it.
+func ReadLoginStart(data interface{}, protocolVersion int) (*LoginStart, error) {
+	dataBytes, ok := data.([]byte)
+	if !ok {
+		return nil, errors.New("data is not expected byte slice")
+	}
+
+	return readLoginStart(bytes.NewBuffer(dataBytes), protocolVersion)
+}
+
+// loginStartLayoutVersions are the earliest protocol versions of each login start layout, from the newest
+var loginStartLayoutVersions = []int{
+	ProtocolVersion1_20_2,
+	ProtocolVersion1_19_3,
+	ProtocolVersion1_19_1,
+	ProtocolVersion1_19,
+	ProtocolVersion1_19 - 1,
+}
+
+// maxPlayerNameLength is the longest player name accepted by the login start packet
+const maxPlayerNameLength = 16
+
+// ReadLoginStartBestEffort reads the login start packet even when its layout doesn't match the protocolVersion,
+// such as from proxies and offline setups that present a different version in the handshake than their login
+// start packet follows. The layout of the protocolVersion is used when it exactly consumes the data, then any other
+// layout that does, then the layout of the protocolVersion regardless of trailing content, and finally just the
+// player name, which leaves the UUID unset.
+func ReadLoginStartBestEffort(data interface{}, protocolVersion int) (*LoginStart, error) {
+	dataBytes, ok := data.([]byte)
+	if !ok {
+		return nil, errors.New("data is not expected byte slice")
+	}
+
+	buffer := bytes.NewBuffer(dataBytes)
+	loginStart, err := readLoginStart(buffer, protocolVersion)
+	if err == nil && buffer.Len() == 0 {
+		return loginStart, nil
+	}
+
+	for _, layoutVersion := range loginStartLayoutVersions {
+		buffer := bytes.NewBuffer(dataBytes)
+		if variant, variantErr := readLoginStart(buffer, layoutVersion); variantErr == nil && buffer.Len() == 0 {
+			return variant, nil
+		}
+	}
+	if err == nil {
+		// with trailing content that no layout accounts for
+		return loginStart, nil
+	}
+
+	name, nameErr := ReadString(bytes.NewBuffer(dataBytes))
+	if nameErr != nil || name == "" || len(name) > maxPlayerNameLength {
+		// the error of the expected layout is the most relevant
+		return nil, err
+	}
+	return &LoginStart{Name: name}, nil
+}
+
+func readLoginStart(buffer *bytes.Buffer, protocolVersion int) (*LoginStart, error) {
+	loginStart := &LoginStart{}
+	var err error
+
+	loginStart.Name, err = ReadString(buffer)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read player name")
+	}
+
+	switch {
+	case protocolVersion >= ProtocolVersion1_20_2:
+		loginStart.PlayerUUID, err = ReadUUID(buffer)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read player UUID")
+		}
+	case protocolVersion >= ProtocolVersion1_19_3:
+		err = readOptionalUUID(buffer, loginStart)
+		if err != nil {
+			return nil, err
+		}
+	case protocolVersion >= ProtocolVersion1_19:
+		hasSigData, err := ReadBoolean(buffer)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read has signature data")
+		}
+		if hasSigData {
+			// timestamp
+			if _, err := io.ReadFull(buffer, make([]byte, 8)); err != nil {
+				return nil, errors.Wrap(err, "failed to read signature timestamp")
+			}
+			// public key, then signature
+			for i := 0; i < 2; i++ {
+				length, err := ReadVarInt(buffer)
+				if err != nil {
+					return nil, errors.Wrap(err, "failed to read signature data length")
+				}
+				if length < 0 || length > buffer.Len() {
+					return nil, errors.Errorf("invalid signature data length %d", length)
+				}
+				buffer.Next(length)
+			}
+		}
+		if protocolVersion >= ProtocolVersion1_19_1 {
+			err = readOptionalUUID(buffer, loginStart)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return loginStart, nil
+}
+
+func readOptionalUUID(reader io.Reader, loginStart *LoginStart) error {
+	hasUUID, err := ReadBoolean(reader)
+	if err != nil {
+		return errors.Wrap(err, "failed to read has player UUID")
+	}
+	if hasUUID {
+		loginStart.PlayerUUID, err = ReadUUID(reader)
+		if err != nil {
+			return errors.Wrap(err, "failed to read player UUID")
+		}
+	}
+	return nil
+}
+
+// ReadStatusResponse parses the JSON status contained in the status response packet data
+func ReadStatusResponse(data interface{}) (*StatusResponse, error) {
+	dataBytes, ok := data.([]byte)
+	if !ok {
+		return nil, errors.New("data is not expected byte slice")
+	}
+
+	statusJson, err := ReadString(bytes.NewBuffer(dataBytes))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read status JSON")
+	}
+
+	status := &StatusResponse{}
+	err = json.Unmarshal([]byte(statusJson), status)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse status JSON")
+	}
+	status.Raw = json.RawMessage(statusJson)
+	return status, nil
+}
@@ -2,8 +2,13 @@ package mcproto
 
 import (
 	"bytes"
+	"encoding/json"
+	"io"
+	"strconv"
 	"testing"
+	"testing/iotest"
 
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -35,3 +40,229 @@ func TestReadVarInt(t *testing.T) {
 		})
 	}
 }
+
+func TestReadLoginStart(t *testing.T) {
+	playerUUID := uuid.MustParse("5cddfd26-fc86-4981-b52e-c42bb10bfdef")
+
+	for _, protocolVersion := range []int{758, ProtocolVersion1_19, ProtocolVersion1_19_1, ProtocolVersion1_19_3, ProtocolVersion1_20_2} {
+		t.Run(strconv.Itoa(protocolVersion), func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			require.NoError(t, WriteLoginStart(buf, protocolVersion, &LoginStart{Name: "itzg", PlayerUUID: playerUUID}))
+
+			packet, err := ReadPacket(buf, nil, StateLogin)
+			require.NoError(t, err)
+
+			loginStart, err := ReadLoginStart(packet.Data, protocolVersion)
+			require.NoError(t, err)
+			assert.Equal(t, "itzg", loginStart.Name)
+			if protocolVersion >= ProtocolVersion1_19_1 {
+				assert.Equal(t, playerUUID, loginStart.PlayerUUID)
+			} else {
+				assert.Equal(t, uuid.Nil, loginStart.PlayerUUID)
+			}
+		})
+	}
+
+	t.Run("truncated", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		require.NoError(t, WriteLoginStart(buf, ProtocolVersion1_20_2, &LoginStart{Name: "itzg", PlayerUUID: playerUUID}))
+		packet, err := ReadPacket(buf, nil, StateLogin)
+		require.NoError(t, err)
+
+		data := packet.Data.([]byte)
+		_, err = ReadLoginStart(data[:len(data)-4], ProtocolVersion1_20_2)
+		assert.Error(t, err)
+	})
+	t.Run("oversized signature length", func(t *testing.T) {
+		data := new(bytes.Buffer)
+		require.NoError(t, WriteString(data, "itzg"))
+		require.NoError(t, WriteBoolean(data, true))
+		// timestamp
+		data.Write(make([]byte, 8))
+		// a public key length far exceeding the packet
+		data.Write([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0x7f})
+
+		_, err := ReadLoginStart(data.Bytes(), ProtocolVersion1_19)
+		assert.Error(t, err)
+	})
+
+	t.Run("negative signature length", func(t *testing.T) {
+		data := new(bytes.Buffer)
+		require.NoError(t, WriteString(data, "itzg"))
+		require.NoError(t, WriteBoolean(data, true))
+		data.Write(make([]byte, 8))
+		require.NoError(t, WriteVarInt(data, -1))
+
+		_, err := ReadLoginStart(data.Bytes(), ProtocolVersion1_19)
+		assert.Error(t, err)
+	})
+}
+
+func TestReadLoginStartBestEffort(t *testing.T) {
+	playerUUID := uuid.MustParse("5cddfd26-fc86-4981-b52e-c42bb10bfdef")
+
+	loginStartData := func(t *testing.T, layoutVersion int) []byte {
+		buf := new(bytes.Buffer)
+		require.NoError(t, WriteLoginStart(buf, layoutVersion, &LoginStart{Name: "itzg", PlayerUUID: playerUUID}))
+		packet, err := ReadPacket(buf, nil, StateLogin)
+		require.NoError(t, err)
+		return packet.Data.([]byte)
+	}
+
+	tests := []struct {
+		name            string
+		data            func(t *testing.T) []byte
+		protocolVersion int
+		expectUUID      uuid.UUID
+	}{
+		{
+			name:            "matching layout",
+			data:            func(t *testing.T) []byte { return loginStartData(t, ProtocolVersion1_20_2) },
+			protocolVersion: ProtocolVersion1_20_2,
+			expectUUID:      playerUUID,
+		},
+		{
+			// such as a proxy translating an older client
+			name:            "newer layout than handshake",
+			data:            func(t *testing.T) []byte { return loginStartData(t, ProtocolVersion1_20_2) },
+			protocolVersion: ProtocolVersion1_19,
+			expectUUID:      playerUUID,
+		},
+		{
+			name:            "name only layout",
+			data:            func(t *testing.T) []byte { return loginStartData(t, 758) },
+			protocolVersion: ProtocolVersion1_20_2,
+			expectUUID:      uuid.Nil,
+		},
+		{
+			name: "unknown trailing content",
+			data: func(t *testing.T) []byte {
+				data := bytes.NewBuffer(loginStartData(t, 758))
+				data.Write([]byte{0x07, 0x01, 0x02})
+				return data.Bytes()
+			},
+			protocolVersion: ProtocolVersion1_20_2,
+			expectUUID:      uuid.Nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loginStart, err := ReadLoginStartBestEffort(tt.data(t), tt.protocolVersion)
+			require.NoError(t, err)
+			assert.Equal(t, "itzg", loginStart.Name)
+			assert.Equal(t, tt.expectUUID, loginStart.PlayerUUID)
+		})
+	}
+
+	t.Run("unreadable name", func(t *testing.T) {
+		_, err := ReadLoginStartBestEffort([]byte{0x20, 'i'}, ProtocolVersion1_20_2)
+		assert.Error(t, err)
+	})
+}
+
+func TestReadStatusResponse(t *testing.T) {
+	tests := []struct {
+		name        string
+		statusJson  string
+		description string
+	}{
+		{
+			name:        "object description",
+			statusJson:  `{"version":{"name":"1.21","protocol":767},"players":{"max":20,"online":3},"description":{"text":"A Minecraft Server","extra":[]}}`,
+			description: "A Minecraft Server",
+		},
+		{
+			name:        "string description",
+			statusJson:  `{"version":{"name":"1.8.9","protocol":47},"players":{"max":20,"online":3},"description":"Legacy MOTD"}`,
+			description: "Legacy MOTD",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := new(bytes.Buffer)
+			require.NoError(t, WriteString(data, tt.statusJson))
+
+			status, err := ReadStatusResponse(data.Bytes())
+			require.NoError(t, err)
+			assert.Equal(t, tt.description, status.Description.Text)
+			assert.Equal(t, 3, status.Players.Online)
+		})
+	}
+}
+
+func TestStatusResponse_MarshalJSON(t *testing.T) {
+	data := new(bytes.Buffer)
+	require.NoError(t, WriteString(data, `{"version":{"name":"Paper 1.21","protocol":767},"players":{"max":20,"online":3},`+
+		`"description":{"text":"","extra":[{"text":"Lobby","color":"gold"}]},"forgeData":{"fmlNetworkVersion":3}}`))
+	status, err := ReadStatusResponse(data.Bytes())
+	require.NoError(t, err)
+
+	status.Version = StatusVersion{Name: "1.20.4", Protocol: 765}
+	status.Players = StatusPlayers{Max: 10}
+	marshaled, err := json.Marshal(status)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"version":{"name":"1.20.4","protocol":765},"players":{"max":10,"online":0},`+
+		`"description":{"text":"","extra":[{"text":"Lobby","color":"gold"}]},"forgeData":{"fmlNetworkVersion":3}}`,
+		string(marshaled))
+}
+
+func TestReadFrame(t *testing.T) {
+	payload := []byte("handshake payload")
+	content := new(bytes.Buffer)
+	require.NoError(t, WriteVarInt(content, len(payload)))
+	content.Write(payload)
+
+	t.Run("partial reads", func(t *testing.T) {
+		frame, err := ReadFrame(iotest.OneByteReader(bytes.NewReader(content.Bytes())), nil)
+		require.NoError(t, err)
+		assert.Equal(t, payload, frame.Payload)
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		_, err := ReadFrame(bytes.NewReader(content.Bytes()[:5]), nil)
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+
+	t.Run("closed after length", func(t *testing.T) {
+		_, err := ReadFrame(bytes.NewReader(content.Bytes()[:1]), nil)
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		tooLarge := new(bytes.Buffer)
+		require.NoError(t, WriteVarInt(tooLarge, MaxFrameLength+1))
+		_, err := ReadFrame(tooLarge, nil)
+		assert.Error(t, err)
+	})
+}
+
+func TestReadString(t *testing.T) {
+	content := new(bytes.Buffer)
+	require.NoError(t, WriteString(content, "mc.exämple.com"))
+	content.WriteByte(0x42)
+
+	// read in small pieces to ensure the string is assembled across reads
+	reader := iotest.HalfReader(content)
+	value, err := ReadString(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "mc.exämple.com", value)
+	// the content following the string is left unread
+	next, err := ReadByte(reader)
+	require.NoError(t, err)
+	assert.Equal(t, byte(0x42), next)
+
+	t.Run("truncated", func(t *testing.T) {
+		truncated := new(bytes.Buffer)
+		require.NoError(t, WriteString(truncated, "mc.example.com"))
+		_, err := ReadString(bytes.NewReader(truncated.Bytes()[:5]))
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+
+	t.Run("too long", func(t *testing.T) {
+		tooLong := new(bytes.Buffer)
+		require.NoError(t, WriteVarInt(tooLong, MaxFrameLength+1))
+		_, err := ReadString(tooLong)
+		assert.Error(t, err)
+	})
+}
@@ -1,6 +1,11 @@
 package mcproto
 
-import "fmt"
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/uuid"
+)
 
 type Frame struct {
 	Length  int
@@ -10,7 +15,11 @@ type Frame struct {
 type State int
 
 const (
-	StateHandshaking = iota
+	StateHandshaking State = iota
+	StateStatus
+	StateLogin
+	// StateTransfer is the next state of clients transferred by another server, since 1.20.5, which then log in
+	StateTransfer
 )
 
 var trimLimit = 64
@@ -46,6 +55,12 @@ func (p *Packet) String() string {
 
 const (
 	PacketIdHandshake            = 0x00
+	PacketIdStatusRequest        = 0x00
+	PacketIdStatusResponse       = 0x00
+	PacketIdPing                 = 0x01
+	PacketIdPong                 = 0x01
+	PacketIdLoginDisconnect      = 0x00
+	PacketIdLogin                = 0x00 // during StateLogin
 	PacketIdLegacyServerListPing = 0xFE
 )
 
@@ -56,12 +71,94 @@ type Handshake struct {
 	NextState       int
 }
 
+// Protocol versions where the layout of the login start packet changed
+const (
+	ProtocolVersion1_19   = 759
+	ProtocolVersion1_19_1 = 760
+	ProtocolVersion1_19_3 = 761
+	ProtocolVersion1_20_2 = 764
+)
+
+type LoginStart struct {
+	Name       string
+	PlayerUUID uuid.UUID
+}
+
 type LegacyServerListPing struct {
 	ProtocolVersion int
 	ServerAddress   string
 	ServerPort      uint16
 }
 
+// TextComponent is the simplest form of chat component, such as used for status descriptions
+// and disconnect reasons
+type TextComponent struct {
+	Text string `json:"text"`
+}
+
+// UnmarshalJSON also accepts the plain string form of a chat component, which some servers use for
+// status descriptions. Only the top-level text of an object component is retained.
+func (t *TextComponent) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &t.Text)
+	}
+
+	type plainTextComponent TextComponent
+	return json.Unmarshal(data, (*plainTextComponent)(t))
+}
+
+type StatusVersion struct {
+	Name     string `json:"name"`
+	Protocol int    `json:"protocol"`
+}
+
+type StatusPlayerSample struct {
+	Name string `json:"name"`
+	ID   string `json:"id"`
+}
+
+type StatusPlayers struct {
+	Max    int                  `json:"max"`
+	Online int                  `json:"online"`
+	Sample []StatusPlayerSample `json:"sample,omitempty"`
+}
+
+// StatusResponse is the JSON structure sent in response to a status request
+type StatusResponse struct {
+	Version     StatusVersion `json:"version"`
+	Players     StatusPlayers `json:"players"`
+	Description TextComponent `json:"description"`
+	Favicon     string        `json:"favicon,omitempty"`
+	// Raw is the status JSON as read, such as from a backend, which retains what the fields above don't, such as
+	// a formatted description and mod data
+	Raw json.RawMessage `json:"-"`
+}
+
+// MarshalJSON writes the Raw status, when read, with only its version and players replaced by those of the
+// fields, so that the rest of a backend's status is served as the backend presented it
+func (s StatusResponse) MarshalJSON() ([]byte, error) {
+	type plainStatusResponse StatusResponse
+	if len(s.Raw) == 0 {
+		return json.Marshal(plainStatusResponse(s))
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(s.Raw, &fields); err != nil {
+		return nil, fmt.Errorf("invalid raw status: %w", err)
+	}
+	version, err := json.Marshal(s.Version)
+	if err != nil {
+		return nil, err
+	}
+	players, err := json.Marshal(s.Players)
+	if err != nil {
+		return nil, err
+	}
+	fields["version"] = version
+	fields["players"] = players
+	return json.Marshal(fields)
+}
+
 type ByteReader interface {
 	ReadByte() (byte, error)
 }
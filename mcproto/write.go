@@ -0,0 +1,157 @@
+package mcproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+func WriteVarInt(writer io.Writer, value int) error {
+	buf := make([]byte, 0, 5)
+	unsigned := uint32(value)
+	for {
+		if unsigned&^0x7F == 0 {
+			buf = append(buf, byte(unsigned))
+			break
+		}
+		buf = append(buf, byte(unsigned&0x7F|0x80))
+		unsigned >>= 7
+	}
+
+	_, err := writer.Write(buf)
+	return err
+}
+
+func WriteString(writer io.Writer, value string) error {
+	err := WriteVarInt(writer, len(value))
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(writer, value)
+	return err
+}
+
+// WritePacket writes a length-prefixed frame containing the packet ID and data
+func WritePacket(writer io.Writer, packetID int, data []byte) error {
+	payload := new(bytes.Buffer)
+	err := WriteVarInt(payload, packetID)
+	if err != nil {
+		return err
+	}
+	payload.Write(data)
+
+	frame := new(bytes.Buffer)
+	err = WriteVarInt(frame, payload.Len())
+	if err != nil {
+		return err
+	}
+	frame.Write(payload.Bytes())
+
+	_, err = writer.Write(frame.Bytes())
+	return err
+}
+
+func WriteUnsignedShort(writer io.Writer, value uint16) error {
+	return binary.Write(writer, binary.BigEndian, value)
+}
+
+func WriteBoolean(writer io.Writer, value bool) error {
+	if value {
+		_, err := writer.Write([]byte{0x01})
+		return err
+	} else {
+		_, err := writer.Write([]byte{0x00})
+		return err
+	}
+}
+
+// WriteHandshake writes the handshake packet
+func WriteHandshake(writer io.Writer, handshake *Handshake) error {
+	data := new(bytes.Buffer)
+	if err := WriteVarInt(data, handshake.ProtocolVersion); err != nil {
+		return err
+	}
+	if err := WriteString(data, handshake.ServerAddress); err != nil {
+		return err
+	}
+	if err := WriteUnsignedShort(data, handshake.ServerPort); err != nil {
+		return err
+	}
+	if err := WriteVarInt(data, handshake.NextState); err != nil {
+		return err
+	}
+
+	return WritePacket(writer, PacketIdHandshake, data.Bytes())
+}
+
+// WriteLoginStart writes the login start packet using the layout expected for the given protocol version
+func WriteLoginStart(writer io.Writer, protocolVersion int, loginStart *LoginStart) error {
+	data := new(bytes.Buffer)
+	if err := WriteString(data, loginStart.Name); err != nil {
+		return err
+	}
+
+	switch {
+	case protocolVersion >= ProtocolVersion1_20_2:
+		data.Write(loginStart.PlayerUUID[:])
+	case protocolVersion >= ProtocolVersion1_19_3:
+		_ = WriteBoolean(data, true)
+		data.Write(loginStart.PlayerUUID[:])
+	case protocolVersion >= ProtocolVersion1_19:
+		// no signature data
+		_ = WriteBoolean(data, false)
+		if protocolVersion >= ProtocolVersion1_19_1 {
+			_ = WriteBoolean(data, true)
+			data.Write(loginStart.PlayerUUID[:])
+		}
+	}
+
+	return WritePacket(writer, PacketIdLogin, data.Bytes())
+}
+
+// WriteStatusRequest writes the status request packet that follows a handshake with the status next state
+func WriteStatusRequest(writer io.Writer) error {
+	return WritePacket(writer, PacketIdStatusRequest, nil)
+}
+
+// WritePing writes the ping packet of the status exchange with a payload that is echoed back by the pong
+func WritePing(writer io.Writer, payload int64) error {
+	data := new(bytes.Buffer)
+	err := binary.Write(data, binary.BigEndian, payload)
+	if err != nil {
+		return err
+	}
+
+	return WritePacket(writer, PacketIdPing, data.Bytes())
+}
+
+// WriteStatusResponse writes the status response packet containing the given status as JSON
+func WriteStatusResponse(writer io.Writer, status *StatusResponse) error {
+	statusJson, err := json.Marshal(status)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal status response")
+	}
+
+	data := new(bytes.Buffer)
+	err = WriteString(data, string(statusJson))
+	if err != nil {
+		return err
+	}
+
+	return WritePacket(writer, PacketIdStatusResponse, data.Bytes())
+}
+
+// WriteLoginDisconnect writes the disconnect packet for the login state where jsonMessage
+// is a chat component, such as {"text": "..."}
+func WriteLoginDisconnect(writer io.Writer, jsonMessage string) error {
+	data := new(bytes.Buffer)
+	err := WriteString(data, jsonMessage)
+	if err != nil {
+		return err
+	}
+
+	return WritePacket(writer, PacketIdLoginDisconnect, data.Bytes())
+}
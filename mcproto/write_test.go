@@ -0,0 +1,82 @@
+package mcproto
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteVarInt(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Value    int
+		Expected []byte
+	}{
+		{Name: "Zero", Value: 0, Expected: []byte{0x00}},
+		{Name: "Single byte", Value: 0x7A, Expected: []byte{0x7A}},
+		{Name: "Two byte", Value: 0x0201, Expected: []byte{0x81, 0x04}},
+		{Name: "Negative", Value: -1, Expected: []byte{0xFF, 0xFF, 0xFF, 0xFF, 0x0F}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			require.NoError(t, WriteVarInt(buf, tt.Value))
+			assert.Equal(t, tt.Expected, buf.Bytes())
+		})
+	}
+}
+
+func TestWriteHandshake(t *testing.T) {
+	expected := &Handshake{
+		ProtocolVersion: 767,
+		ServerAddress:   "mc.example.com",
+		ServerPort:      25565,
+		NextState:       int(StateLogin),
+	}
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, WriteHandshake(buf, expected))
+
+	packet, err := ReadPacket(buf, nil, StateHandshaking)
+	require.NoError(t, err)
+	assert.Equal(t, PacketIdHandshake, packet.PacketID)
+
+	handshake, err := ReadHandshake(packet.Data)
+	require.NoError(t, err)
+	assert.Equal(t, expected, handshake)
+}
+
+func TestWriteLoginStart(t *testing.T) {
+	playerUUID := uuid.MustParse("5cddfd26-fc86-4981-b52e-c42bb10bfdef")
+	tests := []struct {
+		name            string
+		protocolVersion int
+		expectedLength  int
+	}{
+		// packet ID + string length + name + fields after the name
+		{name: "1.18", protocolVersion: 758, expectedLength: 1 + 1 + 6},
+		{name: "1.19", protocolVersion: ProtocolVersion1_19, expectedLength: 1 + 1 + 6 + 1},
+		{name: "1.19.1", protocolVersion: ProtocolVersion1_19_1, expectedLength: 1 + 1 + 6 + 1 + 1 + 16},
+		{name: "1.19.3", protocolVersion: ProtocolVersion1_19_3, expectedLength: 1 + 1 + 6 + 1 + 16},
+		{name: "1.20.2", protocolVersion: ProtocolVersion1_20_2, expectedLength: 1 + 1 + 6 + 16},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			require.NoError(t, WriteLoginStart(buf, tt.protocolVersion, &LoginStart{Name: "itzg_1", PlayerUUID: playerUUID}))
+
+			frame, err := ReadFrame(buf, nil)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedLength, frame.Length)
+
+			name, err := ReadString(bytes.NewReader(frame.Payload[1:]))
+			require.NoError(t, err)
+			assert.Equal(t, "itzg_1", name)
+		})
+	}
+}
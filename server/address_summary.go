@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// addressSummaryMaxTracked bounds the distinct server addresses counted per interval, such as when clients
+// request random addresses. Connections to further addresses are counted together as untracked.
+const addressSummaryMaxTracked = 10000
+
+// serverAddressCounter counts the connections per requested server address
+type serverAddressCounter struct {
+	sync.Mutex
+	counts     map[string]int64
+	maxTracked int
+	untracked  int64
+}
+
+type serverAddressCount struct {
+	serverAddress string
+	count         int64
+}
+
+func newServerAddressCounter(maxTracked int) *serverAddressCounter {
+	return &serverAddressCounter{
+		counts:     make(map[string]int64),
+		maxTracked: maxTracked,
+	}
+}
+
+func (c *serverAddressCounter) record(serverAddress string) {
+	c.Lock()
+	defer c.Unlock()
+	if _, exists := c.counts[serverAddress]; exists || len(c.counts) < c.maxTracked {
+		c.counts[serverAddress]++
+	} else {
+		c.untracked++
+	}
+}
+
+// takeTop returns the topK most requested server addresses, most first, and the count of connections to
+// untracked addresses, and then resets the counts
+func (c *serverAddressCounter) takeTop(topK int) ([]serverAddressCount, int64) {
+	c.Lock()
+	counts, untracked := c.counts, c.untracked
+	c.counts = make(map[string]int64)
+	c.untracked = 0
+	c.Unlock()
+
+	result := make([]serverAddressCount, 0, len(counts))
+	for serverAddress, count := range counts {
+		result = append(result, serverAddressCount{serverAddress: serverAddress, count: count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].count != result[j].count {
+			return result[i].count > result[j].count
+		}
+		return result[i].serverAddress < result[j].serverAddress
+	})
+	if len(result) > topK {
+		result = result[:topK]
+	}
+	return result, untracked
+}
+
+// UseServerAddressSummary logs the topK most requested server addresses, with their connection counts,
+// every interval until the ctx is done
+func (c *Connector) UseServerAddressSummary(ctx context.Context, interval time.Duration, topK int) {
+	counter := newServerAddressCounter(addressSummaryMaxTracked)
+	c.serverAddressCounter = counter
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				top, untracked := counter.takeTop(topK)
+				if len(top) == 0 && untracked == 0 {
+					continue
+				}
+				entries := make([]string, 0, len(top))
+				for _, entry := range top {
+					entries = append(entries, fmt.Sprintf("%s=%d", entry.serverAddress, entry.count))
+				}
+				logrus.
+					WithField("interval", interval).
+					WithField("top", strings.Join(entries, ", ")).
+					WithField("untracked", untracked).
+					Info("Most requested server addresses")
+
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// recordServerAddress counts a connection requesting the serverAddress, if summarizing
+func (c *Connector) recordServerAddress(serverAddress string) {
+	if c.serverAddressCounter != nil {
+		c.serverAddressCounter.record(serverAddress)
+	}
+}
@@ -0,0 +1,30 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerAddressCounter(t *testing.T) {
+	counter := newServerAddressCounter(3)
+	for _, serverAddress := range []string{
+		"a.example.com", "b.example.com", "a.example.com", "c.example.com", "b.example.com", "a.example.com",
+		// beyond the tracked addresses
+		"d.example.com", "e.example.com",
+	} {
+		counter.record(serverAddress)
+	}
+
+	top, untracked := counter.takeTop(2)
+	assert.Equal(t, []serverAddressCount{
+		{serverAddress: "a.example.com", count: 3},
+		{serverAddress: "b.example.com", count: 2},
+	}, top)
+	assert.Equal(t, int64(2), untracked)
+
+	// reset for the next interval
+	top, untracked = counter.takeTop(2)
+	assert.Empty(t, top)
+	assert.Zero(t, untracked)
+}
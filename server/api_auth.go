@@ -0,0 +1,47 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// ApiAuth declares the bearer token required by requests to the API server
+type ApiAuth struct {
+	// Token, when set, is required in the Authorization header of each request as "Bearer" followed by the token
+	Token string
+	// ExemptMetrics and ExemptVars allow requests to /metrics and /vars without the token, such as from scrapers
+	// that can't send one
+	ExemptMetrics bool
+	ExemptVars    bool
+}
+
+const bearerPrefix = "Bearer "
+
+// wrap returns the handler guarded by the token, unless no token is declared
+func (a ApiAuth) wrap(handler http.Handler) http.Handler {
+	if a.Token == "" {
+		return handler
+	}
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if a.isExempt(request.URL.Path) || a.isAuthorized(request) {
+			handler.ServeHTTP(writer, request)
+			return
+		}
+		writer.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(writer, "missing or invalid bearer token", http.StatusUnauthorized)
+	})
+}
+
+func (a ApiAuth) isExempt(path string) bool {
+	return path == readyzPath || (a.ExemptMetrics && path == "/metrics") || (a.ExemptVars && path == "/vars")
+}
+
+func (a ApiAuth) isAuthorized(request *http.Request) bool {
+	header := request.Header.Get("Authorization")
+	if len(header) < len(bearerPrefix) || !strings.EqualFold(header[:len(bearerPrefix)], bearerPrefix) {
+		return false
+	}
+	// compared in constant time to avoid revealing how much of the token matched
+	return subtle.ConstantTimeCompare([]byte(header[len(bearerPrefix):]), []byte(a.Token)) == 1
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApiAuth_wrap(t *testing.T) {
+	okHandler := http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+		writer.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name          string
+		auth          ApiAuth
+		path          string
+		authorization string
+		expected      int
+	}{
+		{name: "no token declared", auth: ApiAuth{}, path: "/routes", expected: http.StatusOK},
+		{name: "valid token", auth: ApiAuth{Token: "secret"}, path: "/routes", authorization: "Bearer secret", expected: http.StatusOK},
+		{name: "scheme is case insensitive", auth: ApiAuth{Token: "secret"}, path: "/routes", authorization: "bearer secret", expected: http.StatusOK},
+		{name: "missing token", auth: ApiAuth{Token: "secret"}, path: "/routes", expected: http.StatusUnauthorized},
+		{name: "wrong token", auth: ApiAuth{Token: "secret"}, path: "/routes", authorization: "Bearer secret2", expected: http.StatusUnauthorized},
+		{name: "prefix of token", auth: ApiAuth{Token: "secret"}, path: "/routes", authorization: "Bearer sec", expected: http.StatusUnauthorized},
+		{name: "other scheme", auth: ApiAuth{Token: "secret"}, path: "/routes", authorization: "Basic secret", expected: http.StatusUnauthorized},
+		{name: "metrics guarded", auth: ApiAuth{Token: "secret", ExemptVars: true}, path: "/metrics", expected: http.StatusUnauthorized},
+		{name: "metrics exempt", auth: ApiAuth{Token: "secret", ExemptMetrics: true}, path: "/metrics", expected: http.StatusOK},
+		{name: "vars guarded", auth: ApiAuth{Token: "secret", ExemptMetrics: true}, path: "/vars", expected: http.StatusUnauthorized},
+		{name: "vars exempt", auth: ApiAuth{Token: "secret", ExemptVars: true}, path: "/vars", expected: http.StatusOK},
+		{name: "readyz exempt", auth: ApiAuth{Token: "secret"}, path: "/readyz", expected: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.authorization != "" {
+				request.Header.Set("Authorization", tt.authorization)
+			}
+			recorder := httptest.NewRecorder()
+			tt.auth.wrap(okHandler).ServeHTTP(recorder, request)
+
+			assert.Equal(t, tt.expected, recorder.Code)
+			if tt.expected == http.StatusUnauthorized {
+				assert.Equal(t, "Bearer", recorder.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
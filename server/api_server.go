@@ -1,6 +1,7 @@
 package server
 
 import (
+	"encoding/json"
 	"expvar"
 	"net/http"
 
@@ -11,15 +12,41 @@ import (
 
 var apiRoutes = mux.NewRouter()
 
-func StartApiServer(apiBinding string) {
-	logrus.WithField("binding", apiBinding).Info("Serving API requests")
+// StartApiServer serves the API requests on the apiBinding, where each request requires the bearer token of the auth,
+// if declared
+func StartApiServer(apiBinding string, connector *Connector, enableWebUi bool, auth ApiAuth) {
+	logrus.WithField("binding", apiBinding).WithField("auth", auth.Token != "").Info("Serving API requests")
 
+	connector.registerApiRoutes()
+	if enableWebUi {
+		registerWebUi()
+	}
+
+	connector.publishExpvars()
 	apiRoutes.Path("/vars").Handler(expvar.Handler())
 
 	apiRoutes.Path("/metrics").Handler(promhttp.Handler())
 
 	go func() {
 		logrus.WithError(
-			http.ListenAndServe(apiBinding, apiRoutes)).Error("API server failed")
+			http.ListenAndServe(apiBinding, auth.wrap(apiRoutes))).Error("API server failed")
 	}()
 }
+
+// RegisterConfigApi serves the effective config at /config, such as to confirm what the flags and environment
+// variables resolved to. Any secrets are expected to already be redacted.
+func RegisterConfigApi(effectiveConfig any) {
+	apiRoutes.Path("/config").Methods("GET").HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+		bytes, err := json.Marshal(effectiveConfig)
+		if err != nil {
+			logrus.WithError(err).Error("Failed to marshal config")
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		_, err = writer.Write(bytes)
+		if err != nil {
+			logrus.WithError(err).Error("Failed to write response")
+		}
+	})
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// errBackendCircuitOpen is given in place of a dial error while a backend's circuit is open
+var errBackendCircuitOpen = errors.New("backend circuit is open after repeated dial failures")
+
+// backendHealth is a circuit breaker that stops dialing a backend for a cooldown once its dials have failed
+// consecutively, so that clients are promptly served the route's status or disconnect message instead
+type backendHealth struct {
+	sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	backends  map[string]*backendFailures
+	// circuitOpen is set to 1 for each backend, by host, while its circuit is open and 0 once it is closed again
+	circuitOpen metrics.Gauge
+}
+
+type backendFailures struct {
+	consecutive int
+	// lastFailure is when the most recent dial failed, where a streak of failures only counts when each
+	// is within the cooldown of the previous
+	lastFailure time.Time
+	// openUntil is non-zero while the circuit is open
+	openUntil time.Time
+}
+
+func newBackendHealth(threshold int, cooldown time.Duration, circuitOpen metrics.Gauge) *backendHealth {
+	return &backendHealth{
+		threshold:   threshold,
+		cooldown:    cooldown,
+		backends:    make(map[string]*backendFailures),
+		circuitOpen: circuitOpen,
+	}
+}
+
+// allowDial reports if the backend may be dialed, which is false while its circuit is open. Once the cooldown
+// has passed, dials are allowed again and a single failure re-opens the circuit.
+func (h *backendHealth) allowDial(backend string, now time.Time) bool {
+	h.Lock()
+	defer h.Unlock()
+
+	failures, exists := h.backends[backend]
+	return !exists || !now.Before(failures.openUntil)
+}
+
+// recordFailure counts a failed dial of the backend and opens its circuit once the threshold is reached
+func (h *backendHealth) recordFailure(backend string, now time.Time) {
+	h.Lock()
+	defer h.Unlock()
+
+	failures, exists := h.backends[backend]
+	if !exists {
+		failures = &backendFailures{}
+		h.backends[backend] = failures
+	}
+	if now.Sub(failures.lastFailure) > h.cooldown {
+		failures.consecutive = 0
+	}
+	failures.consecutive++
+	failures.lastFailure = now
+
+	if failures.consecutive >= h.threshold {
+		if failures.openUntil.IsZero() {
+			logrus.
+				WithField("backend", backend).
+				WithField("failures", failures.consecutive).
+				WithField("cooldown", h.cooldown).
+				Warn("Opening circuit of backend after repeated dial failures")
+		}
+		failures.openUntil = now.Add(h.cooldown)
+		h.circuitOpen.With("host", backend).Set(1)
+	}
+}
+
+// recordSuccess closes the backend's circuit, if open, and resets its count of failures
+func (h *backendHealth) recordSuccess(backend string) {
+	h.Lock()
+	defer h.Unlock()
+
+	failures, exists := h.backends[backend]
+	if !exists {
+		return
+	}
+	delete(h.backends, backend)
+	if !failures.openUntil.IsZero() {
+		logrus.WithField("backend", backend).Info("Closing circuit of backend after successful dial")
+		h.circuitOpen.With("host", backend).Set(0)
+	}
+}
+
+// UseBackendCircuitBreaker stops dialing a backend for the cooldown once threshold consecutive dials to it have
+// failed, where each failure is within the cooldown of the previous. Clients of the backend are served as if the
+// dial failed, such as with the route's status. A threshold of zero disables the circuit breaker.
+func (c *Connector) UseBackendCircuitBreaker(threshold int, cooldown time.Duration) {
+	if threshold <= 0 || cooldown <= 0 {
+		c.backendHealth = nil
+		return
+	}
+	c.backendHealth = newBackendHealth(threshold, cooldown, c.metrics.BackendCircuitOpen)
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackendHealth(t *testing.T) {
+	circuitOpen := &observedGauge{observed: make(chan float64, 10)}
+	health := newBackendHealth(2, time.Minute, circuitOpen)
+	start := time.Now()
+
+	// failures further apart than the cooldown aren't consecutive
+	health.recordFailure("mc:25565", start)
+	health.recordFailure("mc:25565", start.Add(2*time.Minute))
+	assert.True(t, health.allowDial("mc:25565", start.Add(2*time.Minute)))
+
+	health.recordFailure("mc:25565", start.Add(2*time.Minute+time.Second))
+	assert.Equal(t, float64(1), <-circuitOpen.observed)
+	assert.False(t, health.allowDial("mc:25565", start.Add(2*time.Minute+2*time.Second)))
+	assert.True(t, health.allowDial("other:25565", start.Add(2*time.Minute+2*time.Second)))
+
+	// dials are allowed again after the cooldown, where another failure re-opens the circuit
+	assert.True(t, health.allowDial("mc:25565", start.Add(4*time.Minute)))
+	health.recordFailure("mc:25565", start.Add(3*time.Minute))
+	assert.Equal(t, float64(1), <-circuitOpen.observed)
+	assert.False(t, health.allowDial("mc:25565", start.Add(3*time.Minute+time.Second)))
+
+	health.recordSuccess("mc:25565")
+	assert.Equal(t, float64(0), <-circuitOpen.observed)
+	assert.True(t, health.allowDial("mc:25565", start.Add(3*time.Minute+time.Second)))
+}
+
+func TestConnector_dialBackendCircuitOpen(t *testing.T) {
+	c := newTestConnector(t)
+	c.UseBackendCircuitBreaker(1, time.Minute)
+
+	// nothing is listening on the reserved port
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	backend := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	_, err = c.dialBackend(context.Background(), backend, RouteOptions{})
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, errBackendCircuitOpen)
+
+	_, err = c.dialBackend(context.Background(), backend, RouteOptions{})
+	assert.ErrorIs(t, err, errBackendCircuitOpen)
+}
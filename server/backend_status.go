@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/itzg/mc-router/mcproto"
+	"github.com/pkg/errors"
+)
+
+const defaultBackendStatusTimeout = 5 * time.Second
+
+// BackendStatus is the outcome of a status ping of a backend
+type BackendStatus struct {
+	Status  *mcproto.StatusResponse
+	Latency time.Duration
+	// Data is the status response packet data as the backend sent it
+	Data []byte
+}
+
+// FetchBackendStatus performs the status exchange, as a client's server list ping would, with the backend
+// connected using the given dial function, such as one from NewBackendDialFunc.
+// The serverAddress is presented in the handshake along with the given protocolVersion.
+// Unless the ctx has an earlier deadline, the exchange is limited to 5 seconds.
+func FetchBackendStatus(ctx context.Context, dial func(ctx context.Context, address string) (net.Conn, error),
+	backendHostPort string, serverAddress string, protocolVersion int) (*BackendStatus, error) {
+	_, portStr, err := net.SplitHostPort(backendHostPort)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid backend address")
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid backend port")
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultBackendStatusTimeout)
+	}
+
+	dialCtx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+	conn, err := dial(dialCtx, backendHostPort)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to backend")
+	}
+	//goland:noinspection GoUnhandledErrorResult
+	defer conn.Close()
+
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil, errors.Wrap(err, "failed to set deadline")
+	}
+
+	return exchangeStatus(conn, &mcproto.Handshake{
+		ProtocolVersion: protocolVersion,
+		ServerAddress:   serverAddress,
+		ServerPort:      uint16(port),
+		NextState:       int(mcproto.StateStatus),
+	})
+}
+
+// exchangeStatus performs the status exchange, starting with the given handshake, on a connection to a backend
+func exchangeStatus(conn net.Conn, handshake *mcproto.Handshake) (*BackendStatus, error) {
+	err := mcproto.WriteHandshake(conn, handshake)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to write handshake")
+	}
+	if err := mcproto.WriteStatusRequest(conn); err != nil {
+		return nil, errors.Wrap(err, "failed to write status request")
+	}
+
+	packet, err := mcproto.ReadPacket(conn, conn.RemoteAddr(), mcproto.StateStatus)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read status response")
+	}
+	if packet.PacketID != mcproto.PacketIdStatusResponse {
+		return nil, errors.Errorf("expected status response, got packetID %d", packet.PacketID)
+	}
+	status, err := mcproto.ReadStatusResponse(packet.Data)
+	if err != nil {
+		return nil, err
+	}
+	data, _ := packet.Data.([]byte)
+
+	pingStart := time.Now()
+	if err := mcproto.WritePing(conn, pingStart.UnixMilli()); err != nil {
+		return nil, errors.Wrap(err, "failed to write ping")
+	}
+	packet, err = mcproto.ReadPacket(conn, conn.RemoteAddr(), mcproto.StateStatus)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read pong")
+	}
+	if packet.PacketID != mcproto.PacketIdPong {
+		return nil, errors.Errorf("expected pong, got packetID %d", packet.PacketID)
+	}
+
+	return &BackendStatus{
+		Status:  status,
+		Latency: time.Since(pingStart),
+		Data:    data,
+	}, nil
+}
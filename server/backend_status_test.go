@@ -0,0 +1,87 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/itzg/mc-router/mcproto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveTestStatus accepts one connection and completes the status exchange with the given status,
+// returning the handshake that was received
+func serveTestStatus(t *testing.T, listener net.Listener, status *mcproto.StatusResponse) <-chan *mcproto.Handshake {
+	handshakes := make(chan *mcproto.Handshake, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		//goland:noinspection GoUnhandledErrorResult
+		defer conn.Close()
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		// shared so that packets buffered while reading the handshake aren't lost
+		reader := bufio.NewReader(conn)
+
+		packet, err := mcproto.ReadPacket(reader, conn.RemoteAddr(), mcproto.StateHandshaking)
+		if !assert.NoError(t, err) {
+			return
+		}
+		handshake, err := mcproto.ReadHandshake(packet.Data)
+		if !assert.NoError(t, err) {
+			return
+		}
+		handshakes <- handshake
+
+		_, err = mcproto.ReadPacket(reader, conn.RemoteAddr(), mcproto.StateStatus)
+		if !assert.NoError(t, err) {
+			return
+		}
+		if !assert.NoError(t, mcproto.WriteStatusResponse(conn, status)) {
+			return
+		}
+
+		packet, err = mcproto.ReadPacket(reader, conn.RemoteAddr(), mcproto.StateStatus)
+		if !assert.NoError(t, err) {
+			return
+		}
+		_ = mcproto.WritePacket(conn, mcproto.PacketIdPong, packet.Data.([]byte))
+	}()
+	return handshakes
+}
+
+func TestFetchBackendStatus(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	//goland:noinspection GoUnhandledErrorResult
+	defer listener.Close()
+
+	handshakes := serveTestStatus(t, listener, &mcproto.StatusResponse{
+		Version:     mcproto.StatusVersion{Name: "1.21", Protocol: 767},
+		Players:     mcproto.StatusPlayers{Max: 20, Online: 2},
+		Description: mcproto.TextComponent{Text: "Hello"},
+	})
+
+	backendStatus, err := FetchBackendStatus(context.Background(), dialTest, listener.Addr().String(), "mc.example.com", 767)
+	require.NoError(t, err)
+	assert.Equal(t, "Hello", backendStatus.Status.Description.Text)
+	assert.Equal(t, 2, backendStatus.Status.Players.Online)
+
+	handshake := <-handshakes
+	assert.Equal(t, "mc.example.com", handshake.ServerAddress)
+	assert.Equal(t, int(mcproto.StateStatus), handshake.NextState)
+}
+
+func TestFetchBackendStatus_Unreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	_, err = FetchBackendStatus(context.Background(), dialTest, address, "mc.example.com", 767)
+	assert.Error(t, err)
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// errBackendFirstByteTimeout is returned by the reads of a backend that didn't respond within its first byte timeout
+var errBackendFirstByteTimeout = errors.New("backend did not respond within the first byte timeout")
+
+// UseBackendDialTimeout limits how long dialing a backend may take, where zero leaves it to the operating system.
+// Routes may override the timeout with RouteOptions.DialTimeout.
+func (c *Connector) UseBackendDialTimeout(timeout time.Duration) {
+	c.backendDialTimeout = timeout
+}
+
+// UseBackendFirstByteTimeout closes connections whose backend doesn't respond within the timeout after the handshake
+// was relayed, such as a backend that accepts connections while still starting, where zero is unlimited.
+// Routes may override the timeout with RouteOptions.FirstByteTimeout.
+func (c *Connector) UseBackendFirstByteTimeout(timeout time.Duration) {
+	c.backendFirstByteTimeout = timeout
+}
+
+// dialTimeout returns the timeout of dialing the backend of the route, if limited
+func (c *Connector) dialTimeout(routeOptions RouteOptions) time.Duration {
+	if routeOptions.DialTimeout > 0 {
+		return routeOptions.DialTimeout
+	}
+	return c.backendDialTimeout
+}
+
+// firstByteTimeout returns the timeout of the backend's first response to the route's clients, if limited
+func (c *Connector) firstByteTimeout(routeOptions RouteOptions) time.Duration {
+	if routeOptions.FirstByteTimeout > 0 {
+		return routeOptions.FirstByteTimeout
+	}
+	return c.backendFirstByteTimeout
+}
+
+// firstByteConn is a backend connection with a read deadline that is cleared once the backend responds. Since it
+// wraps the connection, the relay from the backend is copied through user space rather than spliced.
+type firstByteConn struct {
+	net.Conn
+	responded atomic.Bool
+}
+
+// newFirstByteConn requires the backend to respond within the timeout
+func newFirstByteConn(backendConn net.Conn, timeout time.Duration) (*firstByteConn, error) {
+	if err := backendConn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, errors.Wrap(err, "failed to set first byte deadline")
+	}
+	return &firstByteConn{Conn: backendConn}, nil
+}
+
+func (c *firstByteConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if c.responded.Load() {
+		return n, err
+	}
+	if n > 0 {
+		c.responded.Store(true)
+		if deadlineErr := c.Conn.SetReadDeadline(noDeadline); deadlineErr != nil && err == nil {
+			err = errors.Wrap(deadlineErr, "failed to clear first byte deadline")
+		}
+		return n, err
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return n, errBackendFirstByteTimeout
+	}
+	return n, err
+}
+
+// isFirstByteTimeout determines if the relay error is due to the backend not responding, such as after being wrapped
+// by the frontend connection
+func isFirstByteTimeout(err error) bool {
+	return errors.Is(err, errBackendFirstByteTimeout)
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/itzg/mc-router/mcproto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnector_backendTimeouts(t *testing.T) {
+	c := newTestConnector(t)
+	assert.Equal(t, time.Duration(0), c.dialTimeout(RouteOptions{}))
+	assert.Equal(t, time.Duration(0), c.firstByteTimeout(RouteOptions{}))
+
+	c.UseBackendDialTimeout(5 * time.Second)
+	c.UseBackendFirstByteTimeout(10 * time.Second)
+	assert.Equal(t, 5*time.Second, c.dialTimeout(RouteOptions{}))
+	assert.Equal(t, 10*time.Second, c.firstByteTimeout(RouteOptions{}))
+
+	slowRoute := RouteOptions{DialTimeout: time.Minute, FirstByteTimeout: 2 * time.Minute}
+	assert.Equal(t, time.Minute, c.dialTimeout(slowRoute))
+	assert.Equal(t, 2*time.Minute, c.firstByteTimeout(slowRoute))
+}
+
+func TestFirstByteConn(t *testing.T) {
+	t.Run("backend responds", func(t *testing.T) {
+		routerConn, backendConn := net.Pipe()
+		//goland:noinspection GoUnhandledErrorResult
+		defer backendConn.Close()
+		conn, err := newFirstByteConn(routerConn, 50*time.Millisecond)
+		require.NoError(t, err)
+
+		go func() {
+			_, _ = backendConn.Write([]byte("a"))
+			// after the first byte deadline would have passed
+			time.Sleep(100 * time.Millisecond)
+			_, _ = backendConn.Write([]byte("b"))
+		}()
+
+		buf := make([]byte, 1)
+		for _, expected := range []string{"a", "b"} {
+			_, err = conn.Read(buf)
+			require.NoError(t, err)
+			assert.Equal(t, expected, string(buf))
+		}
+	})
+
+	t.Run("backend is silent", func(t *testing.T) {
+		routerConn, backendConn := net.Pipe()
+		//goland:noinspection GoUnhandledErrorResult
+		defer backendConn.Close()
+		conn, err := newFirstByteConn(routerConn, 50*time.Millisecond)
+		require.NoError(t, err)
+
+		_, err = conn.Read(make([]byte, 1))
+		assert.True(t, isFirstByteTimeout(err))
+	})
+}
+
+func TestConnector_RouteFirstByteTimeout(t *testing.T) {
+	backendListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	//goland:noinspection GoUnhandledErrorResult
+	defer backendListener.Close()
+
+	Routes.Reset()
+	Routes.CreateMapping("mc.example.com", backendListener.Addr().String(), nil,
+		RouteOptions{FirstByteTimeout: 50 * time.Millisecond})
+	t.Cleanup(Routes.Reset)
+
+	errorCounter := newErrorTypeCounter()
+	connectorMetrics := newTestConnectorMetrics()
+	connectorMetrics.Errors = errorCounter
+	c := NewConnector(connectorMetrics, false, false, nil, nil)
+	// the route's timeout overrides the connector's
+	c.UseBackendFirstByteTimeout(time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clientConn, routerConn := net.Pipe()
+	//goland:noinspection GoUnhandledErrorResult
+	defer clientConn.Close()
+	handled := make(chan struct{})
+	go func() {
+		c.HandleConnection(ctx, routerConn)
+		close(handled)
+	}()
+	go func() {
+		_ = mcproto.WriteHandshake(clientConn, &mcproto.Handshake{
+			ProtocolVersion: 767,
+			ServerAddress:   "mc.example.com",
+			ServerPort:      25565,
+			NextState:       int(mcproto.StateStatus),
+		})
+	}()
+
+	require.NoError(t, backendListener.(*net.TCPListener).SetDeadline(time.Now().Add(5*time.Second)))
+	backendConn, err := backendListener.Accept()
+	require.NoError(t, err)
+	//goland:noinspection GoUnhandledErrorResult
+	defer backendConn.Close()
+
+	// the backend never responds
+	select {
+	case <-handled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection was not closed")
+	}
+	assert.Equal(t, float64(1), errorCounter.count("backend_first_byte_timeout"))
+}
@@ -0,0 +1,20 @@
+package server
+
+import (
+	"io"
+	"net"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// isClientAbort determines if the error resulted from the client closing or resetting its connection,
+// such as when a launcher cancels a connection attempt part way through the handshake.
+func isClientAbort(err error) bool {
+	return errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.ErrClosedPipe) ||
+		errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE)
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/itzg/mc-router/mcproto"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Formats of forwarding the client's IP address in the server address of the handshake relayed to the backend
+const (
+	// ClientIPForwardingTCPShield appends ///ip:port///timestamp as done by TCPShield, without its signature
+	ClientIPForwardingTCPShield = "tcpshield"
+	// ClientIPForwardingBungeeCord appends the null-delimited IP address, player UUID, and properties as done by the
+	// legacy IP forwarding of BungeeCord, which BungeeGuard extends with a token property
+	ClientIPForwardingBungeeCord = "bungeecord"
+)
+
+// bungeeGuardTokenProperty is the name of the property that carries the BungeeGuard token
+const bungeeGuardTokenProperty = "bungeeguard-token"
+
+// ValidateClientIPForwarding returns an error if the format is not one of the client IP forwarding formats, or if the
+// token is given for a format that doesn't support it
+func ValidateClientIPForwarding(format string, token string) error {
+	switch format {
+	case ClientIPForwardingTCPShield:
+		if token != "" {
+			return errors.Errorf("a token is not supported by client IP forwarding format %s", format)
+		}
+		return nil
+	case ClientIPForwardingBungeeCord:
+		return nil
+	default:
+		return errors.Errorf("unknown client IP forwarding format %q, expected %s or %s",
+			format, ClientIPForwardingTCPShield, ClientIPForwardingBungeeCord)
+	}
+}
+
+// UseClientIPForwarding appends the client's IP address to the server address of the handshake relayed to the
+// backend in the given format, such as for backend plugins that read it from there rather than from a PROXY header.
+// With ClientIPForwardingBungeeCord, a token, when given, is included as the BungeeGuard token property.
+func (c *Connector) UseClientIPForwarding(format string, token string) error {
+	if err := ValidateClientIPForwarding(format, token); err != nil {
+		return err
+	}
+	c.clientIPForwarding = format
+	c.clientIPForwardingToken = token
+	return nil
+}
+
+// relayHandshake relays the content read from the client so far, where the handshake presents the backendServerName,
+// if given, and the client's IP address, when forwarded
+func (c *Connector) relayHandshake(backendConn io.Writer, preReadContent io.Reader, handshake *mcproto.Handshake,
+	backendServerName string, clientAddr net.Addr, playerInfo *PlayerInfo) (int64, error) {
+
+	if c.clientIPForwarding == "" || handshake == nil {
+		return relayPreReadContent(backendConn, preReadContent, handshake, backendServerName)
+	}
+
+	serverAddress := handshake.ServerAddress
+	if backendServerName != "" {
+		serverAddress = rewriteServerAddress(serverAddress, backendServerName)
+	}
+	forwarded, ok := c.forwardClientIP(serverAddress, clientAddr, handshake, playerInfo, time.Now())
+	if !ok {
+		return relayPreReadContent(backendConn, preReadContent, handshake, backendServerName)
+	}
+	return relayHandshakeWithServerAddress(backendConn, preReadContent, handshake, forwarded)
+}
+
+// forwardClientIP returns the server address with the client's IP address appended in the forwarding format.
+// Returns false when it can't be forwarded, such as the BungeeCord format for clients that aren't logging in.
+func (c *Connector) forwardClientIP(serverAddress string, clientAddr net.Addr, handshake *mcproto.Handshake,
+	playerInfo *PlayerInfo, now time.Time) (string, bool) {
+
+	host, port, err := net.SplitHostPort(clientAddr.String())
+	if err != nil {
+		logrus.WithError(err).WithField("client", clientAddr).Warn("Unable to forward the IP address of the client")
+		return "", false
+	}
+	// any forwarding by an upstream proxy is replaced along with the parts of a Forge client
+	hostname, _, _ := strings.Cut(serverAddress, "\x00")
+	hostname = tcpShieldPattern.ReplaceAllString(hostname, "")
+
+	switch c.clientIPForwarding {
+	case ClientIPForwardingTCPShield:
+		forwarded := hostname + "///" + net.JoinHostPort(host, port) + "///" + strconv.FormatInt(now.Unix(), 10)
+		// retains the parts of a Forge client
+		if i := strings.IndexByte(serverAddress, 0); i >= 0 {
+			forwarded += serverAddress[i:]
+		}
+		return forwarded, true
+
+	case ClientIPForwardingBungeeCord:
+		// BungeeCord backends only expect the forwarding when logging in, which is also when the player is known
+		if !isLoginIntent(handshake.NextState) {
+			return "", false
+		}
+		if playerInfo == nil {
+			logrus.WithField("client", clientAddr).
+				Warn("Unable to forward the IP address of the client without its player info")
+			return "", false
+		}
+		playerUUID := playerInfo.UUID
+		if playerUUID == uuid.Nil {
+			// older clients don't send their UUID, which is then the one of an offline player as with BungeeCord
+			playerUUID = offlinePlayerUUID(playerInfo.Name)
+		}
+		forwarded := hostname + "\x00" + host + "\x00" + strings.ReplaceAll(playerUUID.String(), "-", "")
+		if c.clientIPForwardingToken != "" {
+			properties, err := json.Marshal([]struct {
+				Name  string `json:"name"`
+				Value string `json:"value"`
+			}{{Name: bungeeGuardTokenProperty, Value: c.clientIPForwardingToken}})
+			if err != nil {
+				logrus.WithError(err).Error("Failed to marshal the forwarded properties")
+				return "", false
+			}
+			forwarded += "\x00" + string(properties)
+		}
+		return forwarded, true
+	}
+	return "", false
+}
+
+// offlinePlayerUUID returns the UUID given to the player by servers in offline mode
+func offlinePlayerUUID(name string) uuid.UUID {
+	sum := md5.Sum([]byte("OfflinePlayer:" + name))
+	// version 3 and the RFC 4122 variant
+	sum[6] = sum[6]&0x0f | 0x30
+	sum[8] = sum[8]&0x3f | 0x80
+	return sum
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/itzg/mc-router/mcproto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnector_forwardClientIP(t *testing.T) {
+	clientAddr := &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 51234}
+	now := time.Unix(1700000000, 0)
+	player := &PlayerInfo{Name: "Notch", UUID: uuid.MustParse("069a79f4-44e9-4726-a5be-fca90e38aaf5")}
+
+	tests := []struct {
+		name          string
+		format        string
+		token         string
+		serverAddress string
+		nextState     mcproto.State
+		playerInfo    *PlayerInfo
+		expected      string
+		forwarded     bool
+	}{
+		{
+			name:          "tcpshield",
+			format:        ClientIPForwardingTCPShield,
+			serverAddress: "mc.example.com",
+			nextState:     mcproto.StateStatus,
+			expected:      "mc.example.com///203.0.113.7:51234///1700000000",
+			forwarded:     true,
+		},
+		{
+			name:          "tcpshield replaces upstream forwarding and retains forge marker",
+			format:        ClientIPForwardingTCPShield,
+			serverAddress: "mc.example.com///198.51.100.1:1234///1600000000\x00FML3\x00",
+			nextState:     mcproto.StateLogin,
+			playerInfo:    player,
+			expected:      "mc.example.com///203.0.113.7:51234///1700000000\x00FML3\x00",
+			forwarded:     true,
+		},
+		{
+			name:          "bungeecord",
+			format:        ClientIPForwardingBungeeCord,
+			serverAddress: "mc.example.com",
+			nextState:     mcproto.StateLogin,
+			playerInfo:    player,
+			expected:      "mc.example.com\x00203.0.113.7\x00069a79f444e94726a5befca90e38aaf5",
+			forwarded:     true,
+		},
+		{
+			name:          "bungeecord offline player",
+			format:        ClientIPForwardingBungeeCord,
+			serverAddress: "mc.example.com",
+			nextState:     mcproto.StateLogin,
+			playerInfo:    &PlayerInfo{Name: "Notch"},
+			expected:      "mc.example.com\x00203.0.113.7\x00b50ad385829d3141a2167e7d7539ba7f",
+			forwarded:     true,
+		},
+		{
+			name:          "bungeeguard token",
+			format:        ClientIPForwardingBungeeCord,
+			token:         "s3cret",
+			serverAddress: "mc.example.com",
+			nextState:     mcproto.StateLogin,
+			playerInfo:    player,
+			expected: "mc.example.com\x00203.0.113.7\x00069a79f444e94726a5befca90e38aaf5" +
+				"\x00[{\"name\":\"bungeeguard-token\",\"value\":\"s3cret\"}]",
+			forwarded: true,
+		},
+		{
+			name:          "bungeecord status",
+			format:        ClientIPForwardingBungeeCord,
+			serverAddress: "mc.example.com",
+			nextState:     mcproto.StateStatus,
+		},
+		{
+			name:          "bungeecord without player info",
+			format:        ClientIPForwardingBungeeCord,
+			serverAddress: "mc.example.com",
+			nextState:     mcproto.StateLogin,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestConnector(t)
+			require.NoError(t, c.UseClientIPForwarding(tt.format, tt.token))
+
+			handshake := &mcproto.Handshake{
+				ProtocolVersion: 767,
+				ServerAddress:   tt.serverAddress,
+				ServerPort:      25565,
+				NextState:       int(tt.nextState),
+			}
+			forwarded, ok := c.forwardClientIP(tt.serverAddress, clientAddr, handshake, tt.playerInfo, now)
+			assert.Equal(t, tt.forwarded, ok)
+			assert.Equal(t, tt.expected, forwarded)
+		})
+	}
+}
+
+func TestConnector_UseClientIPForwarding(t *testing.T) {
+	c := newTestConnector(t)
+	assert.Error(t, c.UseClientIPForwarding("velocity", ""))
+	assert.Error(t, c.UseClientIPForwarding(ClientIPForwardingTCPShield, "s3cret"))
+	assert.NoError(t, c.UseClientIPForwarding(ClientIPForwardingBungeeCord, "s3cret"))
+}
+
+func TestConnector_relayHandshake(t *testing.T) {
+	c := newTestConnector(t)
+	require.NoError(t, c.UseClientIPForwarding(ClientIPForwardingBungeeCord, ""))
+	clientAddr := &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 51234}
+	player := &PlayerInfo{Name: "Notch"}
+
+	handshake := &mcproto.Handshake{
+		ProtocolVersion: 767,
+		ServerAddress:   "mc.example.com",
+		ServerPort:      25565,
+		NextState:       int(mcproto.StateLogin),
+	}
+	trailing := []byte{0x05, 0x00, 0x03, 'a', 'b', 'c'}
+
+	preRead := new(bytes.Buffer)
+	require.NoError(t, mcproto.WriteHandshake(preRead, handshake))
+	preRead.Write(trailing)
+
+	expected := new(bytes.Buffer)
+	expectedHandshake := *handshake
+	expectedHandshake.ServerAddress = "internal.svc\x00203.0.113.7\x00b50ad385829d3141a2167e7d7539ba7f"
+	require.NoError(t, mcproto.WriteHandshake(expected, &expectedHandshake))
+	expected.Write(trailing)
+
+	relayed := new(bytes.Buffer)
+	amount, err := c.relayHandshake(relayed, preRead, handshake, "internal.svc", clientAddr, player)
+	require.NoError(t, err)
+	assert.Equal(t, int64(expected.Len()), amount)
+	assert.Equal(t, expected.Bytes(), relayed.Bytes())
+}
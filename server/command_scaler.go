@@ -0,0 +1,144 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// how long, by default, a backend is idle before a CommandScaler puts it to sleep
+var commandScalerDefaultSleepAfter = 10 * time.Minute
+
+// CommandScaler wakes and puts to sleep a backend by running commands, such as docker compose up -d and stop.
+// The commands are executed directly, without a shell, and are only run by routes when AllowExecWakers is set.
+type CommandScaler struct {
+	Backend string
+	// WakeCommand and SleepCommand are the program and its arguments
+	WakeCommand  []string
+	SleepCommand []string
+	// Timeout is how long each command, and when waking then the backend becoming reachable, may take in total,
+	// which defaults to two minutes
+	Timeout time.Duration
+}
+
+// Wake runs the wake command unless the backend is already reachable and then waits until the backend is reachable
+func (s *CommandScaler) Wake(ctx context.Context) error {
+	if isReachable(ctx, s.Backend, httpWakerPollInterval) {
+		return nil
+	}
+
+	timeout := s.timeout()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if err := s.run(ctx, "wake", s.WakeCommand); err != nil {
+		return err
+	}
+	return waitUntilReachable(ctx, s.Backend, timeout, httpWakerPollInterval)
+}
+
+// Sleep runs the sleep command
+func (s *CommandScaler) Sleep(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout())
+	defer cancel()
+	return s.run(ctx, "sleep", s.SleepCommand)
+}
+
+func (s *CommandScaler) timeout() time.Duration {
+	if s.Timeout <= 0 {
+		return httpWakerDefaultTimeout
+	}
+	return s.Timeout
+}
+
+// run executes the command, where its stderr is included in the error or otherwise logged
+func (s *CommandScaler) run(ctx context.Context, purpose string, command []string) error {
+	if len(command) == 0 {
+		return errors.Errorf("no %s command declared for backend %s", purpose, s.Backend)
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	trimmedStderr := strings.TrimSpace(stderr.String())
+	if err != nil {
+		return errors.Wrapf(err, "%s command of backend %s failed with stderr: %s", purpose, s.Backend, trimmedStderr)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"backend": s.Backend,
+		"command": command[0],
+		"stderr":  trimmedStderr,
+	}).Infof("Ran %s command of backend", purpose)
+	return nil
+}
+
+// commandScaler returns the scaler of the route's commands, which are split on whitespace. For load balanced
+// backends, the first one is woken.
+func commandScaler(backend string, options RouteOptions) *CommandScaler {
+	return &CommandScaler{
+		Backend:      strings.TrimSpace(strings.Split(backend, BackendsDelimiter)[0]),
+		WakeCommand:  strings.Fields(options.WakeCommand),
+		SleepCommand: strings.Fields(options.SleepCommand),
+		Timeout:      options.WakeTimeout,
+	}
+}
+
+// backendSleeps are the pending sleeps of backends that no longer have connections
+type backendSleeps struct {
+	sync.Mutex
+	timers map[string]*time.Timer
+}
+
+// routeSleeper returns the function that puts the backend of the route of the resolvedHost to sleep, which runs the
+// route's sleep command or is the route's sleeper, such as one scaling down a Docker Swarm service, if any
+func routeSleeper(resolvedHost string, backend string, options RouteOptions) func(ctx context.Context) error {
+	if options.SleepCommand != "" {
+		return commandScaler(backend, options).Sleep
+	}
+	return Routes.GetSleeper(resolvedHost)
+}
+
+// scheduleSleep puts the backend to sleep with the sleeper of the route of the resolvedHost, if any, once it has had
+// no connections for the route's SleepAfter
+func (c *Connector) scheduleSleep(ctx context.Context, resolvedHost string, backend string, options RouteOptions) {
+	sleeper := routeSleeper(resolvedHost, backend, options)
+	if sleeper == nil || c.connections.countForBackend(backend) > 0 {
+		return
+	}
+	sleepAfter := options.SleepAfter
+	if sleepAfter <= 0 {
+		sleepAfter = commandScalerDefaultSleepAfter
+	}
+
+	c.sleeps.Lock()
+	defer c.sleeps.Unlock()
+	if timer, exists := c.sleeps.timers[backend]; exists {
+		timer.Reset(sleepAfter)
+		return
+	}
+	if c.sleeps.timers == nil {
+		c.sleeps.timers = make(map[string]*time.Timer)
+	}
+	c.sleeps.timers[backend] = time.AfterFunc(sleepAfter, func() {
+		c.sleeps.Lock()
+		delete(c.sleeps.timers, backend)
+		c.sleeps.Unlock()
+		if c.connections.countForBackend(backend) > 0 || ctx.Err() != nil {
+			return
+		}
+
+		logrus.
+			WithField("backend", backend).
+			WithField("sleepAfter", sleepAfter).
+			Info("Putting idle backend to sleep")
+		if err := sleeper(ctx); err != nil {
+			logrus.WithError(err).WithField("backend", backend).Error("Failed to put backend to sleep")
+		}
+	})
+}
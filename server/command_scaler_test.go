@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommandScaler_Wake(t *testing.T) {
+	t.Run("reachable", func(t *testing.T) {
+		backendListener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		//goland:noinspection GoUnhandledErrorResult
+		defer backendListener.Close()
+
+		scaler := &CommandScaler{Backend: backendListener.Addr().String(), WakeCommand: []string{"false"}}
+		assert.NoError(t, scaler.Wake(context.Background()), "the command isn't run")
+	})
+
+	t.Run("failed command", func(t *testing.T) {
+		scaler := &CommandScaler{
+			Backend:     unreachableAddress(t),
+			WakeCommand: []string{"ls", filepath.Join(t.TempDir(), "missing")},
+		}
+		err := scaler.Wake(context.Background())
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "missing", "the stderr is included")
+		}
+	})
+}
+
+func TestCommandScaler_Sleep(t *testing.T) {
+	slept := filepath.Join(t.TempDir(), "slept")
+	scaler := commandScaler("127.0.0.1:25565", RouteOptions{SleepCommand: "touch " + slept})
+	require.NoError(t, scaler.Sleep(context.Background()))
+	assert.FileExists(t, slept)
+}
+
+func TestConnector_scheduleSleep(t *testing.T) {
+	slept := filepath.Join(t.TempDir(), "slept")
+	c := newTestConnector(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	options := RouteOptions{SleepCommand: "touch " + slept, SleepAfter: 50 * time.Millisecond}
+	c.scheduleSleep(ctx, "mc.example.com", "127.0.0.1:25565", options)
+	// another disconnect postpones the sleep
+	c.scheduleSleep(ctx, "mc.example.com", "127.0.0.1:25565", options)
+
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(slept)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestConnector_scheduleSleep_routeSleeper(t *testing.T) {
+	Routes.Reset()
+	Routes.CreateMapping("mc.example.com", "127.0.0.1:25565", nil, RouteOptions{SleepAfter: 10 * time.Millisecond})
+	t.Cleanup(Routes.Reset)
+	slept := make(chan struct{})
+	Routes.SetSleeper("MC.example.com", func(ctx context.Context) error {
+		close(slept)
+		return nil
+	})
+
+	c := newTestConnector(t)
+	options, _ := Routes.GetRouteOptions("mc.example.com")
+	c.scheduleSleep(context.Background(), "mc.example.com", "127.0.0.1:25565", options)
+
+	select {
+	case <-slept:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the route's sleeper was not called")
+	}
+
+	// the sleeper is cleared when the route is created again
+	Routes.CreateMapping("mc.example.com", "127.0.0.1:25565", nil, RouteOptions{})
+	assert.Nil(t, Routes.GetSleeper("mc.example.com"))
+}
+
+func TestRoutes_CreateMapping_execWakers(t *testing.T) {
+	routes := NewRoutes()
+	options := RouteOptions{WakeCommand: "docker compose up -d mc"}
+
+	routes.CreateMapping("mc.example.com", "mc:25565", routeWaker("mc:25565", options), options)
+	assert.Empty(t, routes.GetMappings(), "commands require AllowExecWakers")
+
+	routes.AllowExecWakers(true)
+	routes.CreateMapping("mc.example.com", "mc:25565", routeWaker("mc:25565", options), options)
+	assert.Equal(t, map[string]string{"mc.example.com": "mc:25565"}, routes.GetMappings())
+}
+
+// unreachableAddress reserves an address that nothing listens on
+func unreachableAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	require.NoError(t, listener.Close())
+	return address
+}
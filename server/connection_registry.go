@@ -0,0 +1,104 @@
+package server
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// ActiveConnection describes a client connection currently relayed to a backend
+type ActiveConnection struct {
+	Client        string      `json:"client"`
+	ServerAddress string      `json:"serverAddress"`
+	Backend       string      `json:"backend"`
+	Player        *PlayerInfo `json:"player,omitempty"`
+	Since         time.Time   `json:"since"`
+}
+
+// connectionRegistry tracks the live, relayed client connections
+type connectionRegistry struct {
+	sync.RWMutex
+	connections map[net.Conn]*ActiveConnection
+	// countChanged is given the count after each change while the lock is held, so that anything
+	// derived from the count is updated in step with the registry
+	countChanged func(count int)
+}
+
+func newConnectionRegistry(countChanged func(count int)) *connectionRegistry {
+	return &connectionRegistry{
+		connections:  make(map[net.Conn]*ActiveConnection),
+		countChanged: countChanged,
+	}
+}
+
+func (r *connectionRegistry) register(frontendConn net.Conn, connection *ActiveConnection) {
+	r.Lock()
+	defer r.Unlock()
+	r.connections[frontendConn] = connection
+	r.countChanged(len(r.connections))
+}
+
+func (r *connectionRegistry) unregister(frontendConn net.Conn) {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.connections, frontendConn)
+	r.countChanged(len(r.connections))
+}
+
+// withCount invokes fn with the current count while excluding any changes to the registry
+func (r *connectionRegistry) withCount(fn func(count int)) {
+	r.Lock()
+	defer r.Unlock()
+	fn(len(r.connections))
+}
+
+// closeAll closes the frontend of the registered connections, which ends their relay, and returns how many were closed
+func (r *connectionRegistry) closeAll() int {
+	r.RLock()
+	defer r.RUnlock()
+
+	for frontendConn := range r.connections {
+		_ = frontendConn.Close()
+	}
+	return len(r.connections)
+}
+
+// snapshot returns a copy of the currently registered connections
+func (r *connectionRegistry) snapshot() []ActiveConnection {
+	r.RLock()
+	defer r.RUnlock()
+
+	result := make([]ActiveConnection, 0, len(r.connections))
+	for _, connection := range r.connections {
+		result = append(result, *connection)
+	}
+	return result
+}
+
+// countForServerAddress returns the number of registered connections of clients routed by the serverAddress
+func (r *connectionRegistry) countForServerAddress(serverAddress string) int {
+	r.RLock()
+	defer r.RUnlock()
+
+	count := 0
+	for _, connection := range r.connections {
+		if connection.ServerAddress == serverAddress {
+			count++
+		}
+	}
+	return count
+}
+
+// countForBackend returns the number of registered connections relayed to the backend
+func (r *connectionRegistry) countForBackend(backend string) int {
+	r.RLock()
+	defer r.RUnlock()
+
+	count := 0
+	for _, connection := range r.connections {
+		if connection.Backend == backend {
+			count++
+		}
+	}
+	return count
+}
@@ -1,10 +1,12 @@
 package server
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"io"
 	"net"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -16,6 +18,7 @@ import (
 	"github.com/itzg/mc-router/mcproto"
 	"github.com/juju/ratelimit"
 	"github.com/pires/go-proxyproto"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
@@ -31,18 +34,44 @@ type ConnectorMetrics struct {
 	ConnectionsFrontend metrics.Counter
 	ConnectionsBackend  metrics.Counter
 	ActiveConnections   metrics.Gauge
+	QueuedBackendDials  metrics.Gauge
+	// RateLimitAvailable is set to the tokens remaining in the connection rate limiter after each accepted connection
+	RateLimitAvailable metrics.Gauge
+	// LastConnection is set to the Unix time, in seconds, a client was last connected to each backend by host
+	LastConnection metrics.Gauge
+	// FrameLengths, when set, observes the length of each frame read during the handshake/login phase
+	FrameLengths metrics.Histogram
+	// ConnectionBytes, when set, observes the total bytes relayed in both directions once a connection closes
+	ConnectionBytes metrics.Histogram
+	// BackendCircuitOpen is set to 1 for each backend, by host, while its circuit is open due to repeated dial failures
+	BackendCircuitOpen metrics.Gauge
+	// BackendHealthy is set to 1 or 0 for each route, by server_address, after each health check of its backend
+	BackendHealthy metrics.Gauge
+	// RouteChanges counts the routes added and removed by event and source, such as by the Docker or Kubernetes watchers
+	RouteChanges metrics.Counter
+	// BackendConnectLatency observes the seconds taken to connect to the backend, by host, including any wait due to
+	// the dial concurrency limit
+	BackendConnectLatency metrics.Histogram
+	// Draining is set to 1 while soft draining, where new connections are still accepted, and otherwise 0
+	Draining metrics.Gauge
 }
 
 func NewConnector(metrics *ConnectorMetrics, sendProxyProto bool, receiveProxyProto bool, trustedProxyNets []*net.IPNet,
 	clientFilter *ClientFilter) *Connector {
-	return &Connector{
-		metrics:           metrics,
-		sendProxyProto:    sendProxyProto,
-		connectionsCond:   sync.NewCond(&sync.Mutex{}),
-		receiveProxyProto: receiveProxyProto,
-		trustedProxyNets:  trustedProxyNets,
-		clientFilter:      clientFilter,
+	c := &Connector{
+		metrics:            metrics,
+		sendProxyProto:     sendProxyProto,
+		connectionsCond:    sync.NewCond(&sync.Mutex{}),
+		receiveProxyProto:  receiveProxyProto,
+		trustedProxyNets:   trustedProxyNets,
+		clientFilter:       clientFilter,
+		backendDialer:      &backendDialer{ipFamily: IPFamilyAny},
+		dialLimiter:        newDialLimiter(0, metrics.QueuedBackendDials),
+		unknownVersionName: defaultUnknownVersionName,
 	}
+	c.connections = newConnectionRegistry(c.setActiveConnections)
+	c.shutdown, c.abortHandshakes = context.WithCancel(context.Background())
+	return c
 }
 
 type Connector struct {
@@ -53,49 +82,185 @@ type Connector struct {
 	trustedProxyNets  []*net.IPNet
 
 	activeConnections int32
+	// lastActivityNanos is the Unix time, in nanoseconds, the active connections last changed
+	lastActivityNanos atomic.Int64
+	connections       *connectionRegistry
 	connectionsCond   *sync.Cond
 	ngrokToken        string
 	clientFilter      *ClientFilter
+
+	// relayGoroutines counts the goroutines relaying in either direction between clients and backends
+	relayGoroutines atomic.Int32
+
+	connectionNotifier ConnectionNotifier
+	// notifySlots bounds the number of notifications in progress
+	notifySlots chan struct{}
+
+	maintenance        atomic.Bool
+	maintenanceMessage string
+
+	loginStartTimeout time.Duration
+	requirePlayerInfo bool
+	// acceptedNextStates, when set, are the next states of the handshakes that are routed
+	acceptedNextStates []mcproto.State
+
+	backendDialer *backendDialer
+	dialLimiter   *dialLimiter
+	// backendDialTimeout and backendFirstByteTimeout, when positive, are the defaults of the routes' timeouts
+	backendDialTimeout      time.Duration
+	backendFirstByteTimeout time.Duration
+	// backendHealth, when set, stops dialing backends that repeatedly fail
+	backendHealth *backendHealth
+	// healthChecker, when set, identifies routes whose backend failed its last health check
+	healthChecker *HealthChecker
+
+	// wakeWarmup is set when backends are woken in the background
+	wakeWarmup *wakeWarmup
+
+	// reconnects, when set, delays clients that reconnect too often
+	reconnects *reconnectTracker
+
+	// forwardTLVTypes are the TLVs copied from the received to the sent PROXY header
+	forwardTLVTypes []proxyproto.PP2Type
+	// forwardProxyHeader sends the received PROXY header to the backend rather than one of the client's address
+	forwardProxyHeader bool
+	// proxyProtoVersion is the version of the PROXY headers sent to backends, where 0 is the default version
+	proxyProtoVersion byte
+	// relayBuffers, when set, are the buffers that relayed connections are copied through rather than spliced
+	relayBuffers *relayBuffers
+	// proxyHeaderFallback handles connections whose PROXY header can't be read as direct connections
+	proxyHeaderFallback bool
+	// lbHealthChecks, when set, identifies the health checks of load balancers in front of the listeners
+	lbHealthChecks *lbHealthChecks
+	// clientIPForwarding, when set, is the format of the client's IP address appended to the relayed server address
+	clientIPForwarding      string
+	clientIPForwardingToken string
+
+	// serverAddressCounter is set when periodically summarizing the requested server addresses
+	serverAddressCounter *serverAddressCounter
+
+	// maxConnectionLifetime, when positive, is when a relayed connection is closed regardless of activity
+	maxConnectionLifetime time.Duration
+
+	// notifyRouteEvents delivers route events to the connectionNotifier along with connection events
+	notifyRouteEvents bool
+
+	// statusCache, when set, provides the last known status of backends that are missing or can't be reached
+	statusCache *StatusCache
+	// faviconsFromBackends presents statuses served in place of a backend with the favicon it last reported
+	faviconsFromBackends bool
+	// defaultStatusFavicon, when set, presents statuses served in place of a backend without a favicon of their own
+	defaultStatusFavicon string
+	// cachedStatusForReachable serves status requests from the statusCache even when the backend is reachable
+	cachedStatusForReachable bool
+
+	// clientDefaultRoutes are the default backends by client IP range, from the most specific range
+	clientDefaultRoutes []ClientDefaultRoute
+	// protocolRoutes are the backends by range of client protocol versions for server addresses that aren't mapped
+	protocolRoutes []ProtocolRoute
+
+	// noBackendMessage, when set, disconnects login attempts when their backend is missing or can't be reached
+	noBackendMessage string
+	// unavailableStatusMessage, when set, is served to status requests in place of a missing route MOTD
+	unavailableStatusMessage string
+
+	// playersSource and maxPlayers are the defaults of the routes' PlayersSource and MaxPlayers
+	playersSource string
+	maxPlayers    int
+
+	// unknownVersionName is served in statuses to clients with a protocol version that isn't known
+	unknownVersionName string
+
+	// handshakeToken, when set, is required as the leading label of the server address of each client
+	handshakeToken string
+
+	// probeResponse, when set, is how connections that are clearly not Minecraft clients are handled
+	probeResponse string
+	probeBanner   string
+
+	// managedListeners, when set, are the listeners added and removed at runtime
+	managedListeners *managedListeners
+
+	// transparentStatus serves the status fetched from the backend rather than relaying status requests
+	transparentStatus bool
+
+	// networkStatus, when set, is served to the status requests of server addresses that aren't mapped
+	networkStatus *NetworkStatus
+
+	// sleeps are the pending sleeps of the backends of routes with a sleep command
+	sleeps backendSleeps
+
+	// draining reports not ready, such as to a load balancer, while still accepting connections
+	draining atomic.Bool
+
+	// shutdown is done once WaitForConnections is called, which aborts the handshakes in progress
+	shutdown        context.Context
+	abortHandshakes context.CancelFunc
 }
 
 func (c *Connector) StartAcceptingConnections(ctx context.Context, listenAddress string, connRateLimit int) error {
-	ln, err := c.createListener(ctx, listenAddress)
+	var ln net.Listener
+	var err error
+	name := DefaultListenerName
+	if c.ngrokToken != "" {
+		ln, err = c.createNgrokListener(ctx)
+		name = NgrokListenerName
+	} else {
+		ln, err = c.createListener(listenAddress, c.receiveProxyProto)
+	}
 	if err != nil {
 		return err
 	}
 
-	go c.acceptConnections(ctx, ln, connRateLimit)
+	go c.acceptConnections(withListenerName(ctx, name), ln, connRateLimit, "")
 
 	return nil
 }
 
-func (c *Connector) createListener(ctx context.Context, listenAddress string) (net.Listener, error) {
-	if c.ngrokToken != "" {
-		ngrokTun, err := ngrok.Listen(ctx,
-			config.TCPEndpoint(),
-			ngrok.WithAuthtoken(c.ngrokToken),
-		)
-		if err != nil {
-			logrus.WithError(err).Fatal("Unable to start ngrok tunnel")
-			return nil, err
-		}
-		logrus.WithField("ngrokUrl", ngrokTun.URL()).Info("Listening for Minecraft client connections via ngrok tunnel")
-		return ngrokTun, nil
+// StartAcceptingConnectionsOn accepts client connections on an additional listener, which may override
+// the receipt of PROXY protocol, such as enabling it only on the port behind a load balancer, and the default route.
+func (c *Connector) StartAcceptingConnectionsOn(ctx context.Context, listenerConfig ListenerConfig, connRateLimit int) error {
+	ln, err := c.createListener(listenerConfig.Address, c.receivesProxyProto(listenerConfig))
+	if err != nil {
+		return err
 	}
 
-	listener, err := net.Listen("tcp", listenAddress)
+	go c.acceptConnections(withListenerName(ctx, listenerConfig.Address), ln, connRateLimit,
+		listenerConfig.DefaultBackend)
+
+	return nil
+}
+
+func (c *Connector) createNgrokListener(ctx context.Context) (net.Listener, error) {
+	ngrokTun, err := ngrok.Listen(ctx,
+		config.TCPEndpoint(),
+		ngrok.WithAuthtoken(c.ngrokToken),
+	)
 	if err != nil {
-		logrus.WithError(err).Fatal("Unable to start listening")
+		logrus.WithError(err).Fatal("Unable to start ngrok tunnel")
 		return nil, err
 	}
+	logrus.WithField("ngrokUrl", ngrokTun.URL()).Info("Listening for Minecraft client connections via ngrok tunnel")
+	return ngrokTun, nil
+}
+
+func (c *Connector) createListener(listenAddress string, receiveProxyProto bool) (net.Listener, error) {
+	listener, err := net.Listen("tcp", listenAddress)
+	if err != nil {
+		logrus.WithError(err).WithField("listenAddress", listenAddress).Error("Unable to start listening")
+		return nil, errors.Wrapf(err, "unable to listen on %s", listenAddress)
+	}
 	logrus.WithField("listenAddress", listenAddress).Info("Listening for Minecraft client connections")
 
-	if c.receiveProxyProto {
+	if receiveProxyProto {
+		if c.proxyHeaderFallback {
+			listener = &recordingListener{Listener: listener}
+		}
 		proxyListener := &proxyproto.Listener{
 			Listener: listener,
 			Policy:   c.createProxyProtoPolicy(),
 		}
-		logrus.Info("Using PROXY protocol listener")
+		logrus.WithField("listenAddress", listenAddress).Info("Using PROXY protocol listener")
 		return proxyListener, nil
 	}
 
@@ -124,22 +289,49 @@ func (c *Connector) createProxyProtoPolicy() func(upstream net.Addr) (proxyproto
 	}
 }
 
-func (c *Connector) WaitForConnections() {
+// setActiveConnections is given the count of the connection registry as it changes
+func (c *Connector) setActiveConnections(count int) {
+	atomic.StoreInt32(&c.activeConnections, int32(count))
+	c.lastActivityNanos.Store(time.Now().UnixNano())
+	c.metrics.ActiveConnections.Set(float64(count))
+}
+
+// WaitForConnections aborts the handshakes in progress and waits for the active connections to complete. Once the
+// ctx is done, such as after a shutdown timeout, the remaining connections are closed instead and their count is
+// returned.
+func (c *Connector) WaitForConnections(ctx context.Context) int {
+	c.abortHandshakes()
+	// wakes the wait below once the ctx is done
+	stop := context.AfterFunc(ctx, c.signalConnectionsChanged)
+	defer stop()
+
 	c.connectionsCond.L.Lock()
 	defer c.connectionsCond.L.Unlock()
 
 	for {
 		count := atomic.LoadInt32(&c.activeConnections)
-		if count > 0 {
-			logrus.Infof("Waiting on %d connection(s)", count)
-			c.connectionsCond.Wait()
-		} else {
-			break
+		if count == 0 {
+			return 0
+		}
+		if ctx.Err() != nil {
+			return c.connections.closeAll()
 		}
+		logrus.Infof("Waiting on %d connection(s)", count)
+		c.connectionsCond.Wait()
 	}
 }
 
-func (c *Connector) acceptConnections(ctx context.Context, ln net.Listener, connRateLimit int) {
+// signalConnectionsChanged wakes WaitForConnections, where holding the lock ensures the wake isn't missed between
+// its check of the count and its wait
+func (c *Connector) signalConnectionsChanged() {
+	c.connectionsCond.L.Lock()
+	defer c.connectionsCond.L.Unlock()
+	c.connectionsCond.Broadcast()
+}
+
+// acceptConnections handles the connections accepted by the listener, where listenerDefault, when set, is the
+// listener's default backend
+func (c *Connector) acceptConnections(ctx context.Context, ln net.Listener, connRateLimit int, listenerDefault string) {
 	//noinspection GoUnhandledErrorResult
 	defer ln.Close()
 
@@ -151,21 +343,38 @@ func (c *Connector) acceptConnections(ctx context.Context, ln net.Listener, conn
 			return
 
 		case <-time.After(bucket.Take(1)):
+			c.metrics.RateLimitAvailable.Set(float64(bucket.Available()))
 			conn, err := ln.Accept()
-			if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				// such as a listener that was removed
+				return
+			} else if err != nil {
 				logrus.WithError(err).Error("Failed to accept connection")
 			} else {
-				go c.HandleConnection(ctx, conn)
+				go c.handleConnection(ctx, conn, listenerDefault)
 			}
 		}
 	}
 }
 
 func (c *Connector) HandleConnection(ctx context.Context, frontendConn net.Conn) {
-	c.metrics.ConnectionsFrontend.Add(1)
+	c.handleConnection(ctx, frontendConn, "")
+}
+
+func (c *Connector) handleConnection(ctx context.Context, frontendConn net.Conn, listenerDefault string) {
+	if c.lbHealthChecks.fromSource(frontendConn) {
+		c.lbHealthChecks.serve(ctx, frontendConn)
+		return
+	}
+
+	c.metrics.ConnectionsFrontend.With("listener", listenerName(ctx)).Add(1)
 	//noinspection GoUnhandledErrorResult
 	defer frontendConn.Close()
 
+	frontendConn, ok := c.checkProxyHeader(ctx, frontendConn)
+	if !ok {
+		return
+	}
 	clientAddr := frontendConn.RemoteAddr()
 
 	if tcpAddr, ok := clientAddr.(*net.TCPAddr); ok {
@@ -178,6 +387,10 @@ func (c *Connector) HandleConnection(ctx context.Context, frontendConn net.Conn)
 		logrus.WithField("client", clientAddr).Warn("Remote address is not a TCP address, skipping filtering")
 	}
 
+	if !c.delayReconnect(ctx, clientAddr) {
+		return
+	}
+
 	logrus.
 		WithField("client", clientAddr).
 		Info("Got connection")
@@ -185,22 +398,53 @@ func (c *Connector) HandleConnection(ctx context.Context, frontendConn net.Conn)
 
 	inspectionBuffer := new(bytes.Buffer)
 
-	inspectionReader := io.TeeReader(frontendConn, inspectionBuffer)
+	// buffered so that subsequent packets, such as a status request, can be read after the handshake
+	inspectionReader := bufio.NewReader(io.TeeReader(frontendConn, inspectionBuffer))
 
 	if err := frontendConn.SetReadDeadline(time.Now().Add(handshakeTimeout)); err != nil {
 		logrus.
 			WithError(err).
 			WithField("client", clientAddr).
 			Error("Failed to set read deadline")
-		c.metrics.Errors.With("type", "read_deadline").Add(1)
+		c.connectionErrors(ctx, "read_deadline").Add(1)
+		return
+	}
+	// a server shutdown, by cancelling the ctx or waiting for connections, aborts the reads of a client that stalls
+	// during the handshake, which stops applying once the client is being routed
+	abortHandshake := func() {
+		_ = frontendConn.SetReadDeadline(time.Now())
+	}
+	stopCtxAbort := context.AfterFunc(ctx, abortHandshake)
+	stopShutdownAbort := context.AfterFunc(c.shutdown, abortHandshake)
+	stopHandshakeAbort := func() {
+		stopCtxAbort()
+		stopShutdownAbort()
+	}
+	defer stopHandshakeAbort()
+
+	if c.handleProbe(ctx, frontendConn, clientAddr, inspectionReader) {
 		return
 	}
 	packet, err := mcproto.ReadPacket(inspectionReader, clientAddr, c.state)
 	if err != nil {
+		if ctx.Err() != nil {
+			logrus.WithError(err).WithField("client", clientAddr).Debug("Handshake aborted by shutdown")
+			return
+		}
+		if c.lbHealthChecks.isEmpty(err, inspectionBuffer) {
+			logrus.WithField("client", clientAddr).Debug("Closed health check that sent no data")
+			return
+		}
+		if isClientAbort(err) {
+			logrus.WithError(err).WithField("client", clientAddr).Debug("Client disconnected before handshake")
+			c.connectionErrors(ctx, "client_abort").Add(1)
+			return
+		}
 		logrus.WithError(err).WithField("clientAddr", clientAddr).Error("Failed to read packet")
-		c.metrics.Errors.With("type", "read").Add(1)
+		c.connectionErrors(ctx, "read").Add(1)
 		return
 	}
+	c.observeFrameLength(packet)
 
 	logrus.
 		WithField("client", clientAddr).
@@ -213,18 +457,58 @@ func (c *Connector) HandleConnection(ctx context.Context, frontendConn net.Conn)
 		if err != nil {
 			logrus.WithError(err).WithField("clientAddr", clientAddr).
 				Error("Failed to read handshake")
-			c.metrics.Errors.With("type", "read").Add(1)
+			c.connectionErrors(ctx, "read").Add(1)
 			return
 		}
 
+		loggedHandshake := *handshake
+		loggedHandshake.ServerAddress = c.redactHandshakeToken(handshake.ServerAddress)
 		logrus.
 			WithField("client", clientAddr).
-			WithField("handshake", handshake).
+			WithField("handshake", &loggedHandshake).
 			Debug("Got handshake")
 
+		if !c.acceptsNextState(handshake.NextState) {
+			logrus.
+				WithField("client", clientAddr).
+				WithField("nextState", handshake.NextState).
+				Warn("Disconnecting client whose handshake has a next state that is not accepted")
+			c.connectionErrors(ctx, "next_state").Add(1)
+			return
+		}
+
 		serverAddress := handshake.ServerAddress
 
-		c.findAndConnectBackend(ctx, frontendConn, clientAddr, inspectionBuffer, serverAddress)
+		var playerInfo *PlayerInfo
+		if isLoginIntent(handshake.NextState) {
+			playerInfo, err = c.readPlayerInfo(frontendConn, clientAddr, inspectionReader, handshake.ProtocolVersion)
+			if err != nil {
+				if isClientAbort(err) {
+					logrus.
+						WithError(err).
+						WithField("client", clientAddr).
+						Debug("Client disconnected during handshake")
+					c.connectionErrors(ctx, "client_abort").Add(1)
+					return
+				}
+				if c.requirePlayerInfo {
+					logrus.
+						WithError(err).
+						WithField("client", clientAddr).
+						Warn("Rejecting client since player info could not be read")
+					c.connectionErrors(ctx, "player_info").Add(1)
+					return
+				}
+				logrus.
+					WithError(err).
+					WithField("client", clientAddr).
+					Warn("Proceeding without player info")
+			}
+		}
+
+		stopHandshakeAbort()
+		c.findAndConnectBackend(ctx, frontendConn, clientAddr, inspectionReader, inspectionBuffer, serverAddress, handshake, playerInfo,
+			listenerDefault)
 	} else if packet.PacketID == mcproto.PacketIdLegacyServerListPing {
 		handshake, ok := packet.Data.(*mcproto.LegacyServerListPing)
 		if !ok {
@@ -232,7 +516,7 @@ func (c *Connector) HandleConnection(ctx context.Context, frontendConn net.Conn)
 				WithField("client", clientAddr).
 				WithField("packet", packet).
 				Warn("Unexpected data type for PacketIdLegacyServerListPing")
-			c.metrics.Errors.With("type", "unexpected_content").Add(1)
+			c.connectionErrors(ctx, "unexpected_content").Add(1)
 			return
 		}
 
@@ -243,25 +527,119 @@ func (c *Connector) HandleConnection(ctx context.Context, frontendConn net.Conn)
 
 		serverAddress := handshake.ServerAddress
 
-		c.findAndConnectBackend(ctx, frontendConn, clientAddr, inspectionBuffer, serverAddress)
+		stopHandshakeAbort()
+		c.findAndConnectBackend(ctx, frontendConn, clientAddr, inspectionReader, inspectionBuffer, serverAddress, nil, nil,
+			listenerDefault)
 	} else {
 		logrus.
 			WithField("client", clientAddr).
 			WithField("packetID", packet.PacketID).
 			Error("Unexpected packetID, expected handshake")
-		c.metrics.Errors.With("type", "unexpected_content").Add(1)
+		c.connectionErrors(ctx, "unexpected_content").Add(1)
 		return
 	}
 }
 
+// dialBackend connects to the backend, waiting for a slot when the concurrent dials to the backend are limited
+func (c *Connector) dialBackend(ctx context.Context, backendHostPort string, routeOptions RouteOptions) (net.Conn, error) {
+	if c.backendHealth != nil && !c.backendHealth.allowDial(backendHostPort, time.Now()) {
+		return nil, errBackendCircuitOpen
+	}
+
+	release, err := c.dialLimiter.acquire(ctx, backendHostPort, routeOptions.DialConcurrency)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	dialCtx := ctx
+	if timeout := c.dialTimeout(routeOptions); timeout > 0 {
+		var cancel context.CancelFunc
+		dialCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	backendConn, err := c.backendDialer.DialContext(dialCtx, backendHostPort)
+	if c.backendHealth != nil {
+		if err == nil {
+			c.backendHealth.recordSuccess(backendHostPort)
+		} else if ctx.Err() == nil {
+			// otherwise, the client went away rather than the backend failing
+			c.backendHealth.recordFailure(backendHostPort, time.Now())
+		}
+	}
+	return backendConn, err
+}
+
+// findAndConnectBackend locates and connects the client to the backend for the serverAddress.
+// The frontendReader is used for any further reads from the client prior to relaying, such as when
+// serving a status in place of a backend, and preReadContent is what needs to be relayed to the backend.
+// The handshake is nil for legacy server list pings and playerInfo is nil when not logging in or when
+// the player info could not be read. The listenerDefault, when set, is the default backend of the listener
+// that accepted the client.
 func (c *Connector) findAndConnectBackend(ctx context.Context, frontendConn net.Conn,
-	clientAddr net.Addr, preReadContent io.Reader, serverAddress string) {
+	clientAddr net.Addr, frontendReader io.Reader, preReadContent io.Reader, serverAddress string,
+	handshake *mcproto.Handshake, playerInfo *PlayerInfo, listenerDefault string) {
+
+	if c.maintenance.Load() {
+		logrus.
+			WithField("client", clientAddr).
+			WithField("serverAddress", c.redactHandshakeToken(serverAddress)).
+			Debug("Serving maintenance mode")
+		c.serveUnavailable(ctx, frontendConn, clientAddr, frontendReader, handshake, c.maintenanceMessage,
+			c.defaultStatusFavicon)
+		return
+	}
+
+	// the server address presented to the backend, when it differs from the client's
+	backendServerName := ""
+	if c.handshakeToken != "" {
+		stripped, ok := c.stripHandshakeToken(serverAddress)
+		if !ok {
+			logrus.
+				WithField("client", clientAddr).
+				Debug("Rejecting client without the handshake token")
+			c.connectionErrors(ctx, "handshake_token").Add(1)
+			return
+		}
+		serverAddress = stripped
+		// without any null-delimited parts, such as from Forge, which are retained when relayed
+		backendServerName, _, _ = strings.Cut(stripped, "\x00")
+	}
 
 	backendHostPort, resolvedHost, waker := Routes.FindBackendForServerAddress(ctx, serverAddress)
-	if waker != nil {
+	c.recordServerAddress(resolvedHost)
+	routeOptions, mapped := Routes.GetRouteOptions(resolvedHost)
+	if !mapped {
+		if protocolBackend := c.selectProtocolBackend(handshake); protocolBackend != "" {
+			backendHostPort = protocolBackend
+		} else if defaultBackend := c.selectDefaultBackend(listenerDefault, clientAddr); defaultBackend != "" {
+			backendHostPort = defaultBackend
+		}
+	}
+	// for connection events, the server address of the matched route, if any
+	route := resolvedHost
+	if !mapped {
+		route = ""
+		if backendHostPort != "" {
+			route = EventRouteDefault
+		}
+	}
+	if !c.acceptsRouteProxyProto(ctx, frontendConn, resolvedHost, routeOptions) {
+		return
+	}
+	if !mapped && c.networkStatus != nil && handshake != nil && mcproto.State(handshake.NextState) == mcproto.StateStatus {
+		logrus.
+			WithField("client", clientAddr).
+			WithField("serverAddress", serverAddress).
+			Debug("Serving network status")
+		c.serveStatusResponse(ctx, frontendConn, clientAddr, frontendReader, handshake, c.buildNetworkStatus())
+		return
+	}
+	// with the wake warmup, the backend is only woken when it can't be reached
+	if waker != nil && c.wakeWarmup == nil {
 		if err := waker(ctx); err != nil {
 			logrus.WithFields(logrus.Fields{"serverAddress": serverAddress}).WithError(err).Error("failed to wake up backend")
-			c.metrics.Errors.With("type", "wakeup_failed").Add(1)
+			c.connectionErrors(ctx, "wakeup_failed").Add(1)
 			return
 		}
 	}
@@ -271,96 +649,192 @@ func (c *Connector) findAndConnectBackend(ctx context.Context, frontendConn net.
 			WithField("serverAddress", serverAddress).
 			WithField("resolvedHost", resolvedHost).
 			Warn("Unable to find registered backend")
-		c.metrics.Errors.With("type", "missing_backend").Add(1)
+		c.connectionErrors(ctx, "missing_backend").Add(1)
+		c.notifyConnectionEvent(ctx,
+			newConnectionEvent(ConnectionEventMissingBackend, clientAddr, serverAddress, route, playerInfo, "", nil))
+		c.serveRouteStatus(ctx, frontendConn, clientAddr, frontendReader, handshake, resolvedHost, routeOptions)
 		return
 	}
+	if c.cachedStatusForReachable && handshake != nil && mcproto.State(handshake.NextState) == mcproto.StateStatus {
+		if status, ok := c.cachedStatus(resolvedHost); ok {
+			logrus.
+				WithField("client", clientAddr).
+				WithField("serverAddress", resolvedHost).
+				Debug("Serving cached status rather than relaying to backend")
+			c.serveStatusResponse(ctx, frontendConn, clientAddr, frontendReader, handshake,
+				c.withRoutePlayers(status, resolvedHost, routeOptions))
+			return
+		}
+	}
+
+	if mapped && c.healthChecker != nil && !c.healthChecker.IsHealthy(resolvedHost) {
+		logrus.
+			WithField("client", clientAddr).
+			WithField("serverAddress", resolvedHost).
+			Debug("Not connecting to unhealthy backend")
+		c.connectionErrors(ctx, "backend_unhealthy").Add(1)
+		c.serveRouteStatus(ctx, frontendConn, clientAddr, frontendReader, handshake, resolvedHost, routeOptions)
+		return
+	}
+
 	logrus.
 		WithField("client", clientAddr).
 		WithField("server", serverAddress).
+		WithField("player", playerInfo).
 		WithField("backendHostPort", backendHostPort).
 		Info("Connecting to backend")
-	backendConn, err := net.Dial("tcp", backendHostPort)
+	dialStart := time.Now()
+	backendConn, err := c.dialBackend(ctx, backendHostPort, routeOptions)
 	if err != nil {
+		if waker != nil && c.wakeWarmup != nil {
+			logrus.
+				WithError(err).
+				WithField("client", clientAddr).
+				WithField("backend", backendHostPort).
+				Debug("Unable to connect to backend, so warming it up")
+			c.warmUpBackend(ctx, clientAddr, resolvedHost, waker)
+			c.serveUnavailable(ctx, frontendConn, clientAddr, frontendReader, handshake, c.wakeWarmup.message,
+				c.routeFavicon(resolvedHost, routeOptions))
+			return
+		}
 		logrus.
 			WithError(err).
 			WithField("client", clientAddr).
 			WithField("serverAddress", serverAddress).
 			WithField("backend", backendHostPort).
 			Warn("Unable to connect to backend")
-		c.metrics.Errors.With("type", "backend_failed").Add(1)
+		if errors.Is(err, errBackendCircuitOpen) {
+			c.connectionErrors(ctx, "backend_circuit_open").Add(1)
+		} else {
+			c.connectionErrors(ctx, "backend_failed").Add(1)
+		}
+		c.notifyConnectionEvent(ctx,
+			newConnectionEvent(ConnectionEventFailedBackendConnection, clientAddr, serverAddress, route, playerInfo, backendHostPort, err))
+		c.serveRouteStatus(ctx, frontendConn, clientAddr, frontendReader, handshake, resolvedHost, routeOptions)
 		return
 	}
 
 	c.metrics.ConnectionsBackend.With("host", resolvedHost).Add(1)
-
-	c.metrics.ActiveConnections.Set(float64(
-		atomic.AddInt32(&c.activeConnections, 1)))
+	connectedAt := time.Now()
+	c.metrics.BackendConnectLatency.With("host", resolvedHost).Observe(connectedAt.Sub(dialStart).Seconds())
+	c.metrics.LastConnection.With("host", resolvedHost).Set(float64(connectedAt.Unix()))
+	Routes.RecordConnection(resolvedHost, connectedAt)
+
+	c.connections.register(frontendConn, &ActiveConnection{
+		Client:        clientAddr.String(),
+		ServerAddress: resolvedHost,
+		Backend:       backendHostPort,
+		Player:        playerInfo,
+		Since:         connectedAt,
+	})
 	defer func() {
-		c.metrics.ActiveConnections.Set(float64(
-			atomic.AddInt32(&c.activeConnections, -1)))
-		c.connectionsCond.Signal()
+		c.connections.unregister(frontendConn)
+		c.signalConnectionsChanged()
+		c.scheduleSleep(ctx, resolvedHost, backendHostPort, routeOptions)
 	}()
 
-	// PROXY protocol implementation
-	if c.sendProxyProto {
+	if shouldShadow(routeOptions) {
+		logrus.
+			WithField("client", clientAddr).
+			WithField("shadowBackend", routeOptions.ShadowBackend).
+			Debug("Mirroring connection to shadow backend")
+		backendConn = newShadowedConn(ctx, backendConn, routeOptions.ShadowBackend, clientAddr, c.backendDialer.DialContext)
+	}
 
-		// Determine transport protocol for the PROXY header by "analyzing" the frontend connection's address
-		transportProtocol := proxyproto.TCPv4
-		ourHostIpPart, _, err := net.SplitHostPort(frontendConn.LocalAddr().String())
-		if err != nil {
-			logrus.
-				WithError(err).
-				WithField("localAddr", frontendConn.LocalAddr()).
-				Error("Failed to extract host part of our address")
-			_ = backendConn.Close()
-			return
-		}
-		ourFrontendIp := net.ParseIP(ourHostIpPart)
-		if ourFrontendIp.To4() == nil {
-			transportProtocol = proxyproto.TCPv6
-		}
+	// PROXY protocol implementation
+	if c.shouldSendProxyProto(routeOptions) {
+		header := c.receivedProxyHeader(frontendConn)
+		if header == nil {
+			// Determine transport protocol for the PROXY header by "analyzing" the frontend connection's address
+			transportProtocol := proxyproto.TCPv4
+			ourHostIpPart, _, err := net.SplitHostPort(frontendConn.LocalAddr().String())
+			if err != nil {
+				logrus.
+					WithError(err).
+					WithField("localAddr", frontendConn.LocalAddr()).
+					Error("Failed to extract host part of our address")
+				_ = backendConn.Close()
+				return
+			}
+			ourFrontendIp := net.ParseIP(ourHostIpPart)
+			if ourFrontendIp.To4() == nil {
+				transportProtocol = proxyproto.TCPv6
+			}
 
-		header := &proxyproto.Header{
-			Version:           2,
-			Command:           proxyproto.PROXY,
-			TransportProtocol: transportProtocol,
-			SourceAddr:        clientAddr,
-			DestinationAddr:   frontendConn.LocalAddr(), // our end of the client's connection
+			header = &proxyproto.Header{
+				Version:           c.sentProxyProtoVersion(),
+				Command:           proxyproto.PROXY,
+				TransportProtocol: transportProtocol,
+				SourceAddr:        clientAddr,
+				DestinationAddr:   frontendConn.LocalAddr(), // our end of the client's connection
+			}
+			if header.Version == 2 {
+				c.addForwardedTLVs(frontendConn, header)
+			}
 		}
 
-		_, err = header.WriteTo(backendConn)
+		_, err := header.WriteTo(backendConn)
 		if err != nil {
 			logrus.
 				WithError(err).
 				WithField("clientAddr", header.SourceAddr).
 				WithField("destAddr", header.DestinationAddr).
 				Error("Failed to write PROXY header")
-			c.metrics.Errors.With("type", "proxy_write").Add(1)
+			c.connectionErrors(ctx, "proxy_write").Add(1)
 			_ = backendConn.Close()
 			return
 		}
 	}
 
-	amount, err := io.Copy(backendConn, preReadContent)
+	if routeOptions.BackendServerName != "" {
+		backendServerName = routeOptions.BackendServerName
+	}
+	if c.transparentStatus && handshake != nil && mcproto.State(handshake.NextState) == mcproto.StateStatus {
+		c.serveTransparentStatus(ctx, frontendConn, clientAddr, frontendReader, backendConn, handshake,
+			backendServerName, resolvedHost, routeOptions)
+		_ = backendConn.Close()
+		return
+	}
+	amount, err := c.relayHandshake(backendConn, preReadContent, handshake, backendServerName, clientAddr, playerInfo)
 	if err != nil {
 		logrus.WithError(err).Error("Failed to write handshake to backend connection")
-		c.metrics.Errors.With("type", "backend_failed").Add(1)
+		c.connectionErrors(ctx, "backend_failed").Add(1)
 		return
 	}
 
 	logrus.WithField("amount", amount).Debug("Relayed handshake to backend")
+	if timeout := c.firstByteTimeout(routeOptions); timeout > 0 {
+		firstByteBackendConn, err := newFirstByteConn(backendConn, timeout)
+		if err != nil {
+			logrus.WithError(err).WithField("client", clientAddr).Error("Unable to limit the wait for the backend")
+			c.connectionErrors(ctx, "read_deadline").Add(1)
+			_ = backendConn.Close()
+			return
+		}
+		backendConn = firstByteBackendConn
+	}
 	if err = frontendConn.SetReadDeadline(noDeadline); err != nil {
 		logrus.
 			WithError(err).
 			WithField("client", clientAddr).
 			Error("Failed to clear read deadline")
-		c.metrics.Errors.With("type", "read_deadline").Add(1)
+		c.connectionErrors(ctx, "read_deadline").Add(1)
 		return
 	}
 
+	c.notifyConnectionEvent(ctx,
+		newConnectionEvent(ConnectionEventConnect, clientAddr, serverAddress, route, playerInfo, backendHostPort, nil))
+
 	c.pumpConnections(ctx, frontendConn, backendConn)
+
+	c.notifyConnectionEvent(ctx,
+		newConnectionEvent(ConnectionEventDisconnect, clientAddr, serverAddress, route, playerInfo, backendHostPort, nil))
 }
 
+// pumpConnections relays between the client and backend until either side closes or the ctx is done.
+// The connections are used directly, rather than through the readers used for the handshake, since any content
+// buffered by those was already relayed. That way io.Copy between two *net.TCPConn, including one accepted with the
+// PROXY protocol, is spliced by the runtime on Linux rather than copied through user space.
 func (c *Connector) pumpConnections(ctx context.Context, frontendConn, backendConn net.Conn) {
 	//noinspection GoUnhandledErrorResult
 	defer backendConn.Close()
@@ -369,32 +843,62 @@ func (c *Connector) pumpConnections(ctx context.Context, frontendConn, backendCo
 	defer logrus.WithField("client", clientAddr).Debug("Closing backend connection")
 
 	errors := make(chan error, 2)
+	amounts := make(chan int64, 2)
+	// both relays finish once the connections are closed on return, which is when the total is known
+	defer func() {
+		go c.observeConnectionBytes(amounts, clientAddr)
+	}()
+
+	go c.pumpFrames(ctx, backendConn, frontendConn, errors, amounts, "backend", "frontend", clientAddr)
+	go c.pumpFrames(ctx, frontendConn, backendConn, errors, amounts, "frontend", "backend", clientAddr)
 
-	go c.pumpFrames(backendConn, frontendConn, errors, "backend", "frontend", clientAddr)
-	go c.pumpFrames(frontendConn, backendConn, errors, "frontend", "backend", clientAddr)
+	// never fires unless the lifetime is limited
+	var lifetimeExpired <-chan time.Time
+	if c.maxConnectionLifetime > 0 {
+		lifetimeTimer := time.NewTimer(c.maxConnectionLifetime)
+		defer lifetimeTimer.Stop()
+		lifetimeExpired = lifetimeTimer.C
+	}
 
 	select {
 	case err := <-errors:
-		if err != io.EOF {
+		if isFirstByteTimeout(err) {
+			logrus.WithError(err).
+				WithField("client", clientAddr).
+				Warn("Closing connection since the backend did not respond")
+			c.connectionErrors(ctx, "backend_first_byte_timeout").Add(1)
+		} else if err != io.EOF {
 			logrus.WithError(err).
 				WithField("client", clientAddr).
 				Error("Error observed on connection relay")
-			c.metrics.Errors.With("type", "relay").Add(1)
+			c.connectionErrors(ctx, "relay").Add(1)
 		}
 
+	case <-lifetimeExpired:
+		logrus.
+			WithField("client", clientAddr).
+			WithField("maxLifetime", c.maxConnectionLifetime).
+			Info("Closing connection that reached its maximum lifetime")
+		c.connectionErrors(ctx, "max_lifetime").Add(1)
+
 	case <-ctx.Done():
 		logrus.Debug("Observed context cancellation")
 	}
 }
 
-func (c *Connector) pumpFrames(incoming io.Reader, outgoing io.Writer, errors chan<- error, from, to string, clientAddr net.Addr) {
-	amount, err := io.Copy(outgoing, incoming)
+func (c *Connector) pumpFrames(ctx context.Context, incoming io.Reader, outgoing io.Writer, errors chan<- error,
+	amounts chan<- int64, from, to string, clientAddr net.Addr) {
+	c.relayGoroutines.Add(1)
+	defer c.relayGoroutines.Add(-1)
+
+	amount, err := c.copyRelay(outgoing, incoming)
 	logrus.
 		WithField("client", clientAddr).
 		WithField("amount", amount).
 		Infof("Finished relay %s->%s", from, to)
 
-	c.metrics.BytesTransmitted.Add(float64(amount))
+	c.metrics.BytesTransmitted.With("listener", listenerName(ctx)).Add(float64(amount))
+	amounts <- amount
 
 	if err != nil {
 		errors <- err
@@ -404,6 +908,109 @@ func (c *Connector) pumpFrames(incoming io.Reader, outgoing io.Writer, errors ch
 	}
 }
 
+// observeFrameLength records the length of a frame read during the handshake/login phase, if enabled.
+// Relayed frames are not parsed and so are not observed.
+func (c *Connector) observeFrameLength(packet *mcproto.Packet) {
+	if c.metrics.FrameLengths != nil {
+		c.metrics.FrameLengths.Observe(float64(packet.Length))
+	}
+}
+
+// observeConnectionBytes waits for the amount relayed in each direction and records the total, if enabled
+func (c *Connector) observeConnectionBytes(amounts <-chan int64, clientAddr net.Addr) {
+	if c.metrics.ConnectionBytes == nil {
+		return
+	}
+	total := <-amounts + <-amounts
+	logrus.
+		WithField("client", clientAddr).
+		WithField("total", total).
+		Debug("Relayed bytes for connection")
+	c.metrics.ConnectionBytes.Observe(float64(total))
+}
+
 func (c *Connector) UseNgrok(token string) {
 	c.ngrokToken = token
 }
+
+// UseBackendIPFamily restricts or prefers the IP family used when dialing backends, where ipFamily is one of
+// IPFamilyAny, IPFamilyIPv4, IPFamilyIPv6, IPFamilyPreferIPv4, or IPFamilyPreferIPv6
+func (c *Connector) UseBackendIPFamily(ipFamily string) error {
+	dialer, err := newBackendDialer(ipFamily)
+	if err != nil {
+		return err
+	}
+	c.backendDialer = dialer
+	return nil
+}
+
+// UseMaxConnectionLifetime closes relayed connections once they have lasted the given duration, regardless of
+// activity, such as to force players to reconnect. Zero leaves connections open until either side closes.
+func (c *Connector) UseMaxConnectionLifetime(maxLifetime time.Duration) {
+	c.maxConnectionLifetime = maxLifetime
+}
+
+// UseBackendDialConcurrency limits the number of concurrent dials to each backend, where zero is unlimited.
+// Routes may override the limit with RouteOptions.DialConcurrency.
+func (c *Connector) UseBackendDialConcurrency(limit int) {
+	c.dialLimiter = newDialLimiter(limit, c.metrics.QueuedBackendDials)
+}
+
+// UseConnectionNotifier delivers connection events to the notifier, where at most maxConcurrent notifications
+// are in progress at once. Events beyond that are dropped, such as during a flood of connections.
+func (c *Connector) UseConnectionNotifier(notifier ConnectionNotifier, maxConcurrent int) {
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
+	}
+	c.connectionNotifier = notifier
+	c.notifySlots = make(chan struct{}, maxConcurrent)
+}
+
+// UseRouteEventNotifications also delivers route events given to NotifyRouteEvent to the connection notifier
+func (c *Connector) UseRouteEventNotifications() {
+	c.notifyRouteEvents = true
+}
+
+// NotifyRouteEvent counts a route being added or removed, drops the cached status of a removed route, and, when
+// enabled by UseRouteEventNotifications, delivers the event to the connection notifier
+func (c *Connector) NotifyRouteEvent(ctx context.Context, event *ConnectionEvent) {
+	c.metrics.RouteChanges.With("event", event.Event, "source", event.Source).Add(1)
+	if event.Event == RouteEventRemoved && c.statusCache != nil {
+		c.statusCache.Delete(event.Server)
+	}
+	if c.notifyRouteEvents {
+		c.notifyConnectionEvent(ctx, event)
+	}
+}
+
+// notifyConnectionEvent delivers the event to the connection notifier, if configured, without
+// blocking the connection handling
+func (c *Connector) notifyConnectionEvent(ctx context.Context, event *ConnectionEvent) {
+	if c.connectionNotifier == nil {
+		return
+	}
+
+	select {
+	case c.notifySlots <- struct{}{}:
+	default:
+		logrus.
+			WithField("event", event.Event).
+			WithField("client", event.Client).
+			Warn("Dropping connection event since too many notifications are in progress")
+		c.connectionErrors(ctx, "notify_dropped").Add(1)
+		return
+	}
+
+	// notifications, such as disconnects, should still be delivered while shutting down
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		defer func() { <-c.notifySlots }()
+		if err := c.connectionNotifier.Notify(ctx, event); err != nil {
+			logrus.
+				WithError(err).
+				WithField("event", event.Event).
+				WithField("client", event.Client).
+				Warn("Failed to notify connection event")
+		}
+	}()
+}
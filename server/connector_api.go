@@ -0,0 +1,164 @@
+package server
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"sort"
+	"sync/atomic"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+func (c *Connector) registerApiRoutes() {
+	apiRoutes.Path("/metrics/reset-active").Methods("POST").HandlerFunc(c.resetActiveHandler)
+	apiRoutes.Path("/connections").Methods("GET").HandlerFunc(c.connectionsListHandler)
+	apiRoutes.Path("/routes/{serverAddress}").Methods("GET").HandlerFunc(c.routeDetailHandler)
+	c.registerDrainApiRoutes()
+	if c.managedListeners != nil {
+		c.registerListenerApiRoutes()
+	}
+}
+
+// Names of the structured expvars, which are distinct from those of the expvar metrics backend
+const (
+	expvarRoutes      = "routes"
+	expvarConnections = "relayed_connections"
+)
+
+// publishExpvars publishes snapshots of the routes and relayed connections at /vars, regardless of the
+// metrics backend in use
+func (c *Connector) publishExpvars() {
+	// expvar panics when a name is published again
+	if expvar.Get(expvarRoutes) == nil {
+		expvar.Publish(expvarRoutes, expvar.Func(func() any {
+			return Routes.GetMappings()
+		}))
+	}
+	if expvar.Get(expvarConnections) == nil {
+		expvar.Publish(expvarConnections, expvar.Func(func() any {
+			return c.ActiveConnections()
+		}))
+	}
+}
+
+// ActiveConnections returns the client connections currently relayed to backends, oldest first
+func (c *Connector) ActiveConnections() []ActiveConnection {
+	connections := c.connections.snapshot()
+	sort.Slice(connections, func(i, j int) bool {
+		return connections[i].Since.Before(connections[j].Since)
+	})
+	return connections
+}
+
+// RouteDetail describes the route registered for the serverAddress, if any, along with the client connections
+// currently relayed by it and its status, when cached
+func (c *Connector) RouteDetail(serverAddress string) (RouteDetail, bool) {
+	detail, exists := Routes.GetRouteDetail(serverAddress)
+	if !exists {
+		return RouteDetail{}, false
+	}
+	detail.ActiveConnections = c.connections.countForServerAddress(detail.ServerAddress)
+	if status, ok := c.cachedStatus(detail.ServerAddress); ok {
+		detail.Status = status
+	}
+	return detail, true
+}
+
+// BackendConnections returns the number of client connections currently relayed to the backend, such as for
+// least-connections load balancing
+func (c *Connector) BackendConnections(backend string) int {
+	return c.connections.countForBackend(backend)
+}
+
+// RelayGoroutines returns the number of goroutines currently relaying between clients and backends, which is two
+// per relayed connection
+func (c *Connector) RelayGoroutines() int {
+	return int(c.relayGoroutines.Load())
+}
+
+// RegisteredConnections returns the size of the registry of relayed connections
+func (c *Connector) RegisteredConnections() int {
+	var registered int
+	c.connections.withCount(func(count int) {
+		registered = count
+	})
+	return registered
+}
+
+func (c *Connector) connectionsListHandler(writer http.ResponseWriter, _ *http.Request) {
+	bytes, err := json.Marshal(c.ActiveConnections())
+	if err != nil {
+		logrus.WithError(err).Error("Failed to marshal connections")
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	_, err = writer.Write(bytes)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to write response")
+	}
+}
+
+func (c *Connector) routeDetailHandler(writer http.ResponseWriter, request *http.Request) {
+	detail, exists := c.RouteDetail(mux.Vars(request)["serverAddress"])
+	if !exists {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+	bytes, err := json.Marshal(detail)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to marshal route detail")
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	_, err = writer.Write(bytes)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to write response")
+	}
+}
+
+// ResyncActiveConnections recomputes the active connection count from the live connections, which corrects any drift
+// in the count and its gauge, such as when cleanup was skipped.
+// Returns the previous and re-synced counts.
+func (c *Connector) ResyncActiveConnections() (int32, int32) {
+	var previous, actual int32
+	c.connections.withCount(func(count int) {
+		actual = int32(count)
+		previous = atomic.SwapInt32(&c.activeConnections, actual)
+		c.metrics.ActiveConnections.Set(float64(actual))
+	})
+	c.signalConnectionsChanged()
+
+	if previous != actual {
+		logrus.
+			WithField("previous", previous).
+			WithField("actual", actual).
+			Warn("Corrected active connection count")
+	}
+	return previous, actual
+}
+
+func (c *Connector) resetActiveHandler(writer http.ResponseWriter, _ *http.Request) {
+	previous, actual := c.ResyncActiveConnections()
+
+	bytes, err := json.Marshal(struct {
+		Previous int32 `json:"previous"`
+		Active   int32 `json:"active"`
+	}{
+		Previous: previous,
+		Active:   actual,
+	})
+	if err != nil {
+		logrus.WithError(err).Error("Failed to marshal response")
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	_, err = writer.Write(bytes)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to write response")
+	}
+}
@@ -1,13 +1,88 @@
 package server
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/itzg/mc-router/mcproto"
 	"github.com/pires/go-proxyproto"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+func newTestConnectorMetrics() *ConnectorMetrics {
+	return &ConnectorMetrics{
+		Errors:                discard.NewCounter(),
+		BytesTransmitted:      discard.NewCounter(),
+		ConnectionsFrontend:   discard.NewCounter(),
+		ConnectionsBackend:    discard.NewCounter(),
+		ActiveConnections:     discard.NewGauge(),
+		QueuedBackendDials:    discard.NewGauge(),
+		RateLimitAvailable:    discard.NewGauge(),
+		LastConnection:        discard.NewGauge(),
+		BackendCircuitOpen:    discard.NewGauge(),
+		BackendHealthy:        discard.NewGauge(),
+		RouteChanges:          discard.NewCounter(),
+		BackendConnectLatency: discard.NewHistogram(),
+		Draining:              discard.NewGauge(),
+	}
+}
+
+// errorTypeCounter records the counts of errors by their type label
+type errorTypeCounter struct {
+	mu        *sync.Mutex
+	counts    map[string]float64
+	errorType string
+}
+
+func newErrorTypeCounter() *errorTypeCounter {
+	return &errorTypeCounter{mu: &sync.Mutex{}, counts: make(map[string]float64)}
+}
+
+func (c *errorTypeCounter) With(labelValues ...string) metrics.Counter {
+	errorType := c.errorType
+	for i := 0; i+1 < len(labelValues); i += 2 {
+		if labelValues[i] == "type" {
+			errorType = labelValues[i+1]
+		}
+	}
+	return &errorTypeCounter{mu: c.mu, counts: c.counts, errorType: errorType}
+}
+
+func (c *errorTypeCounter) Add(delta float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[c.errorType] += delta
+}
+
+func (c *errorTypeCounter) count(errorType string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts[errorType]
+}
+
+func newTestConnector(t testing.TB) *Connector {
+	clientFilter, err := NewClientFilter(nil, nil)
+	require.NoError(t, err)
+	return NewConnector(newTestConnectorMetrics(), false, false, nil, clientFilter)
+}
+
 func TestTrustedProxyNetworkPolicy(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -75,3 +150,740 @@ func parseTrustedProxyNets(nets []string) []*net.IPNet {
 	}
 	return parsedNets
 }
+
+func TestConnector_RoutesAcrossProtocolVersions(t *testing.T) {
+	backendListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	//goland:noinspection GoUnhandledErrorResult
+	defer backendListener.Close()
+
+	Routes.Reset()
+	Routes.CreateMapping("mc.example.com", backendListener.Addr().String(),
+		func(ctx context.Context) error { return nil }, RouteOptions{})
+
+	c := newTestConnector(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// 1.8, 1.12.2, 1.16.5, 1.19, 1.19.3, 1.20.2, 1.21
+	for _, protocolVersion := range []int{47, 340, 754, 759, 761, 764, 767} {
+		t.Run(strconv.Itoa(protocolVersion), func(t *testing.T) {
+			expected := new(bytes.Buffer)
+			require.NoError(t, mcproto.WriteHandshake(expected, &mcproto.Handshake{
+				ProtocolVersion: protocolVersion,
+				ServerAddress:   "MC.example.com",
+				ServerPort:      25565,
+				NextState:       int(mcproto.StateLogin),
+			}))
+			require.NoError(t, mcproto.WriteLoginStart(expected, protocolVersion, &mcproto.LoginStart{
+				Name:       "itzg",
+				PlayerUUID: uuid.New(),
+			}))
+
+			clientConn, routerConn := net.Pipe()
+			//goland:noinspection GoUnhandledErrorResult
+			defer clientConn.Close()
+			go c.HandleConnection(ctx, routerConn)
+
+			go func() {
+				_, _ = clientConn.Write(expected.Bytes())
+			}()
+
+			require.NoError(t, backendListener.(*net.TCPListener).SetDeadline(time.Now().Add(5*time.Second)))
+			backendConn, err := backendListener.Accept()
+			require.NoError(t, err)
+			//goland:noinspection GoUnhandledErrorResult
+			defer backendConn.Close()
+
+			require.NoError(t, backendConn.SetReadDeadline(time.Now().Add(5*time.Second)))
+			received := make([]byte, expected.Len())
+			_, err = io.ReadFull(backendConn, received)
+			require.NoError(t, err)
+			assert.Equal(t, expected.Bytes(), received)
+		})
+	}
+}
+
+func TestConnector_TruncatedLoginStart(t *testing.T) {
+	tests := []struct {
+		name              string
+		requirePlayerInfo bool
+		expectRelayed     bool
+	}{
+		{name: "proceed", requirePlayerInfo: false, expectRelayed: true},
+		{name: "reject", requirePlayerInfo: true, expectRelayed: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backendListener, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			//goland:noinspection GoUnhandledErrorResult
+			defer backendListener.Close()
+
+			Routes.Reset()
+			Routes.CreateMapping("mc.example.com", backendListener.Addr().String(),
+				func(ctx context.Context) error { return nil }, RouteOptions{})
+
+			c := newTestConnector(t)
+			c.UseLoginStartHandling(50*time.Millisecond, tt.requirePlayerInfo)
+
+			content := new(bytes.Buffer)
+			require.NoError(t, mcproto.WriteHandshake(content, &mcproto.Handshake{
+				ProtocolVersion: 767,
+				ServerAddress:   "mc.example.com",
+				ServerPort:      25565,
+				NextState:       int(mcproto.StateLogin),
+			}))
+			loginStart := new(bytes.Buffer)
+			require.NoError(t, mcproto.WriteLoginStart(loginStart, 767, &mcproto.LoginStart{Name: "itzg", PlayerUUID: uuid.New()}))
+			// only the first half of the login start arrives
+			content.Write(loginStart.Bytes()[:loginStart.Len()/2])
+
+			clientConn, routerConn := net.Pipe()
+			//goland:noinspection GoUnhandledErrorResult
+			defer clientConn.Close()
+			handled := make(chan struct{})
+			go func() {
+				c.HandleConnection(context.Background(), routerConn)
+				close(handled)
+			}()
+			_, err = clientConn.Write(content.Bytes())
+			require.NoError(t, err)
+
+			require.NoError(t, backendListener.(*net.TCPListener).SetDeadline(time.Now().Add(time.Second)))
+			backendConn, err := backendListener.Accept()
+			if !tt.expectRelayed {
+				assert.Error(t, err, "expected backend to not be connected")
+				<-handled
+				return
+			}
+			require.NoError(t, err)
+			//goland:noinspection GoUnhandledErrorResult
+			defer backendConn.Close()
+
+			require.NoError(t, backendConn.SetReadDeadline(time.Now().Add(5*time.Second)))
+			received := make([]byte, content.Len())
+			_, err = io.ReadFull(backendConn, received)
+			require.NoError(t, err)
+			assert.Equal(t, content.Bytes(), received)
+		})
+	}
+}
+
+func TestConnector_NoBackendMessage(t *testing.T) {
+	Routes.Reset()
+	c := newTestConnector(t)
+	c.UseNoBackendMessage("No server here")
+
+	content := new(bytes.Buffer)
+	require.NoError(t, mcproto.WriteHandshake(content, &mcproto.Handshake{
+		ProtocolVersion: 767,
+		ServerAddress:   "unknown.example.com",
+		ServerPort:      25565,
+		NextState:       int(mcproto.StateLogin),
+	}))
+	require.NoError(t, mcproto.WriteLoginStart(content, 767, &mcproto.LoginStart{Name: "itzg", PlayerUUID: uuid.New()}))
+
+	clientConn, routerConn := net.Pipe()
+	//goland:noinspection GoUnhandledErrorResult
+	defer clientConn.Close()
+	go c.HandleConnection(context.Background(), routerConn)
+	_, err := clientConn.Write(content.Bytes())
+	require.NoError(t, err)
+
+	require.NoError(t, clientConn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	packet, err := mcproto.ReadPacket(clientConn, clientConn.RemoteAddr(), mcproto.StateLogin)
+	require.NoError(t, err)
+	assert.Equal(t, mcproto.PacketIdLoginDisconnect, packet.PacketID)
+	message, err := mcproto.ReadString(bytes.NewReader(packet.Data.([]byte)))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"text":"No server here"}`, message)
+}
+
+func TestConnector_UnavailableStatusMessage(t *testing.T) {
+	Routes.Reset()
+	c := newTestConnector(t)
+	c.UseUnavailableStatusMessage("Server is offline")
+
+	content := new(bytes.Buffer)
+	require.NoError(t, mcproto.WriteHandshake(content, &mcproto.Handshake{
+		ProtocolVersion: 767,
+		ServerAddress:   "unknown.example.com",
+		ServerPort:      25565,
+		NextState:       int(mcproto.StateStatus),
+	}))
+	require.NoError(t, mcproto.WriteStatusRequest(content))
+
+	clientConn, routerConn := net.Pipe()
+	//goland:noinspection GoUnhandledErrorResult
+	defer clientConn.Close()
+	go c.HandleConnection(context.Background(), routerConn)
+	_, err := clientConn.Write(content.Bytes())
+	require.NoError(t, err)
+
+	require.NoError(t, clientConn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	packet, err := mcproto.ReadPacket(clientConn, clientConn.RemoteAddr(), mcproto.StateStatus)
+	require.NoError(t, err)
+	status, err := mcproto.ReadStatusResponse(packet.Data)
+	require.NoError(t, err)
+	assert.Equal(t, "Server is offline", status.Description.Text)
+	assert.Equal(t, 767, status.Version.Protocol)
+}
+
+func TestConnector_ClientAbortDuringHandshake(t *testing.T) {
+	errorCounter := newErrorTypeCounter()
+	clientFilter, err := NewClientFilter(nil, nil)
+	require.NoError(t, err)
+	connectorMetrics := newTestConnectorMetrics()
+	connectorMetrics.Errors = errorCounter
+	c := NewConnector(connectorMetrics, false, false, nil, clientFilter)
+
+	clientConn, routerConn := net.Pipe()
+	handled := make(chan struct{})
+	go func() {
+		c.HandleConnection(context.Background(), routerConn)
+		close(handled)
+	}()
+
+	require.NoError(t, mcproto.WriteHandshake(clientConn, &mcproto.Handshake{
+		ProtocolVersion: 767,
+		ServerAddress:   "mc.example.com",
+		ServerPort:      25565,
+		NextState:       int(mcproto.StateLogin),
+	}))
+	// the client cancels before sending login start
+	require.NoError(t, clientConn.Close())
+
+	select {
+	case <-handled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection was not handled")
+	}
+	assert.Equal(t, float64(1), errorCounter.count("client_abort"))
+	assert.Equal(t, float64(0), errorCounter.count("read"))
+	assert.Equal(t, float64(0), errorCounter.count("player_info"))
+}
+
+func TestConnector_ResyncActiveConnections(t *testing.T) {
+	c := newTestConnector(t)
+	frontendConn, _ := net.Pipe()
+	c.connections.register(frontendConn, &ActiveConnection{Client: "pipe"})
+	// simulate drift from a skipped cleanup
+	c.activeConnections = 3
+
+	previous, actual := c.ResyncActiveConnections()
+	assert.Equal(t, int32(3), previous)
+	assert.Equal(t, int32(1), actual)
+	assert.Equal(t, int32(1), c.activeConnections)
+}
+
+func TestConnector_ResyncActiveConnectionsConcurrently(t *testing.T) {
+	c := newTestConnector(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				frontendConn, _ := net.Pipe()
+				c.connections.register(frontendConn, &ActiveConnection{Client: "pipe"})
+				c.connections.unregister(frontendConn)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				c.ResyncActiveConnections()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(0), atomic.LoadInt32(&c.activeConnections))
+	previous, actual := c.ResyncActiveConnections()
+	assert.Equal(t, int32(0), previous, "resyncing concurrently with changes should not cause drift")
+	assert.Equal(t, int32(0), actual)
+}
+
+func TestConnector_ActiveConnections(t *testing.T) {
+	c := newTestConnector(t)
+	now := time.Now()
+	first, _ := net.Pipe()
+	second, _ := net.Pipe()
+	c.connections.register(second, &ActiveConnection{Client: "second", Since: now})
+	c.connections.register(first, &ActiveConnection{Client: "first", Since: now.Add(-time.Minute)})
+
+	connections := c.ActiveConnections()
+	if assert.Len(t, connections, 2) {
+		assert.Equal(t, "first", connections[0].Client)
+		assert.Equal(t, "second", connections[1].Client)
+	}
+}
+
+func TestConnector_WaitForConnections(t *testing.T) {
+	t.Run("completed", func(t *testing.T) {
+		c := newTestConnector(t)
+		frontendConn, _ := net.Pipe()
+		c.connections.register(frontendConn, &ActiveConnection{Client: "pipe"})
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			c.connections.unregister(frontendConn)
+			c.signalConnectionsChanged()
+		}()
+
+		assert.Equal(t, 0, c.WaitForConnections(context.Background()))
+	})
+
+	t.Run("closes remaining after deadline", func(t *testing.T) {
+		c := newTestConnector(t)
+		frontendConn, clientConn := net.Pipe()
+		c.connections.register(frontendConn, &ActiveConnection{Client: "pipe"})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		assert.Equal(t, 1, c.WaitForConnections(ctx))
+
+		_, err := clientConn.Read(make([]byte, 1))
+		assert.ErrorIs(t, err, io.EOF, "the client should observe the connection closing")
+	})
+}
+
+// blockingNotifier holds each notification until released
+type blockingNotifier struct {
+	release  chan struct{}
+	notified chan *ConnectionEvent
+}
+
+func (n *blockingNotifier) Notify(_ context.Context, event *ConnectionEvent) error {
+	n.notified <- event
+	<-n.release
+	return nil
+}
+
+func TestConnector_NotifyConnectionEventDropsExcess(t *testing.T) {
+	errorCounter := newErrorTypeCounter()
+	connectorMetrics := newTestConnectorMetrics()
+	connectorMetrics.Errors = errorCounter
+	c := NewConnector(connectorMetrics, false, false, nil, nil)
+
+	notifier := &blockingNotifier{release: make(chan struct{}), notified: make(chan *ConnectionEvent, 2)}
+	c.UseConnectionNotifier(notifier, 1)
+
+	clientAddr := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 51000}
+	c.notifyConnectionEvent(context.Background(),
+		newConnectionEvent(ConnectionEventConnect, clientAddr, "mc.example.com", "mc.example.com", nil, "backend:25565", nil))
+	<-notifier.notified
+
+	c.notifyConnectionEvent(context.Background(),
+		newConnectionEvent(ConnectionEventDisconnect, clientAddr, "mc.example.com", "mc.example.com", nil, "backend:25565", nil))
+	assert.Equal(t, float64(1), errorCounter.count("notify_dropped"))
+
+	close(notifier.release)
+	// the slot is freed once the first notification completes
+	assert.Eventually(t, func() bool { return len(c.notifySlots) == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestConnector_NotifyRouteEvent(t *testing.T) {
+	c := newTestConnector(t)
+	notifier := &blockingNotifier{release: make(chan struct{}), notified: make(chan *ConnectionEvent, 1)}
+	close(notifier.release)
+	c.UseConnectionNotifier(notifier, 1)
+
+	// only counted until enabled
+	c.NotifyRouteEvent(context.Background(), newRouteEvent(RouteEventAdded, "mc.example.com", "backend:25565", RouteSourceK8s))
+	assert.Empty(t, notifier.notified)
+
+	c.UseRouteEventNotifications()
+	c.NotifyRouteEvent(context.Background(), newRouteEvent(RouteEventRemoved, "mc.example.com", "backend:25565", RouteSourceK8s))
+	select {
+	case event := <-notifier.notified:
+		assert.Equal(t, RouteEventRemoved, event.Event)
+		assert.Equal(t, RouteSourceK8s, event.Source)
+	case <-time.After(time.Second):
+		t.Fatal("route event was not delivered")
+	}
+}
+
+func TestConnector_getVersionInfo(t *testing.T) {
+	c := newTestConnector(t)
+	assert.Equal(t, mcproto.StatusVersion{Name: "1.20.3-1.20.4", Protocol: 765}, c.getVersionInfo(765))
+	assert.Equal(t, mcproto.StatusVersion{Name: "1.7+", Protocol: 9999}, c.getVersionInfo(9999))
+
+	c.UseUnknownVersionName("Snapshot")
+	assert.Equal(t, mcproto.StatusVersion{Name: "Snapshot", Protocol: 9999}, c.getVersionInfo(9999))
+}
+
+// observedGauge passes along each value that is set
+type observedGauge struct {
+	observed chan float64
+}
+
+func (g *observedGauge) With(...string) metrics.Gauge {
+	return g
+}
+
+func (g *observedGauge) Set(value float64) {
+	g.observed <- value
+}
+
+func (g *observedGauge) Add(float64) {}
+
+func TestConnector_acceptConnectionsReportsRateLimit(t *testing.T) {
+	rateLimitAvailable := &observedGauge{observed: make(chan float64, 10)}
+	connectorMetrics := newTestConnectorMetrics()
+	connectorMetrics.RateLimitAvailable = rateLimitAvailable
+	c := NewConnector(connectorMetrics, false, false, nil, nil)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	//goland:noinspection GoUnhandledErrorResult
+	defer listener.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.acceptConnections(ctx, listener, 5, "")
+
+	// the bucket starts with twice the rate, where one token was taken
+	select {
+	case available := <-rateLimitAvailable.observed:
+		assert.Equal(t, float64(9), available)
+	case <-time.After(5 * time.Second):
+		t.Fatal("rate limit availability was not reported")
+	}
+}
+
+// observedHistogram passes along each observed value
+type observedHistogram struct {
+	observed chan float64
+}
+
+func (h *observedHistogram) With(...string) metrics.Histogram {
+	return h
+}
+
+func (h *observedHistogram) Observe(value float64) {
+	h.observed <- value
+}
+
+func TestConnector_ObservesConnectionBytes(t *testing.T) {
+	connectionBytes := &observedHistogram{observed: make(chan float64, 1)}
+	connectorMetrics := newTestConnectorMetrics()
+	connectorMetrics.ConnectionBytes = connectionBytes
+	c := NewConnector(connectorMetrics, false, false, nil, nil)
+
+	clientConn, frontendConn := net.Pipe()
+	backendConn, serverConn := net.Pipe()
+
+	go func() {
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(serverConn, buf); err == nil {
+			_, _ = serverConn.Write([]byte("hi!"))
+		}
+	}()
+
+	go func() {
+		_, _ = clientConn.Write([]byte("hello"))
+		_, _ = io.ReadFull(clientConn, make([]byte, 3))
+		_ = clientConn.Close()
+	}()
+
+	c.pumpConnections(context.Background(), frontendConn, backendConn)
+	// as done by HandleConnection
+	_ = frontendConn.Close()
+
+	select {
+	case total := <-connectionBytes.observed:
+		assert.Equal(t, float64(8), total)
+	case <-time.After(time.Second):
+		t.Fatal("connection bytes were not observed")
+	}
+}
+
+func TestConnector_ObservesBackendConnectLatency(t *testing.T) {
+	backendListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	//goland:noinspection GoUnhandledErrorResult
+	defer backendListener.Close()
+
+	Routes.Reset()
+	Routes.CreateMapping("mc.example.com", backendListener.Addr().String(), nil, RouteOptions{})
+	t.Cleanup(Routes.Reset)
+
+	backendConnectLatency := &observedHistogram{observed: make(chan float64, 1)}
+	connectorMetrics := newTestConnectorMetrics()
+	connectorMetrics.BackendConnectLatency = backendConnectLatency
+	c := NewConnector(connectorMetrics, false, false, nil, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clientConn, routerConn := net.Pipe()
+	handled := make(chan struct{})
+	go func() {
+		c.HandleConnection(ctx, routerConn)
+		close(handled)
+	}()
+	go func() {
+		_ = mcproto.WriteHandshake(clientConn, &mcproto.Handshake{
+			ProtocolVersion: 767,
+			ServerAddress:   "mc.example.com",
+			ServerPort:      25565,
+			NextState:       int(mcproto.StateStatus),
+		})
+	}()
+
+	require.NoError(t, backendListener.(*net.TCPListener).SetDeadline(time.Now().Add(5*time.Second)))
+	backendConn, err := backendListener.Accept()
+	require.NoError(t, err)
+
+	select {
+	case seconds := <-backendConnectLatency.observed:
+		assert.Greater(t, seconds, float64(0))
+		assert.Less(t, seconds, float64(5))
+	case <-time.After(5 * time.Second):
+		t.Fatal("backend connect latency was not observed")
+	}
+
+	// the connection is done with the routes before they are reset
+	require.NoError(t, clientConn.Close())
+	require.NoError(t, backendConn.Close())
+	select {
+	case <-handled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection was not handled")
+	}
+}
+
+func TestConnector_MaxConnectionLifetime(t *testing.T) {
+	errorCounter := newErrorTypeCounter()
+	connectorMetrics := newTestConnectorMetrics()
+	connectorMetrics.Errors = errorCounter
+	c := NewConnector(connectorMetrics, false, false, nil, nil)
+	c.UseMaxConnectionLifetime(50 * time.Millisecond)
+
+	// both sides stay idle and open
+	_, frontendConn := net.Pipe()
+	backendConn, _ := net.Pipe()
+	defer frontendConn.Close()
+
+	pumped := make(chan struct{})
+	go func() {
+		c.pumpConnections(context.Background(), frontendConn, backendConn)
+		close(pumped)
+	}()
+
+	select {
+	case <-pumped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection was not closed after its maximum lifetime")
+	}
+	assert.Equal(t, float64(1), errorCounter.count("max_lifetime"))
+}
+
+func TestConnector_RelayGoroutines(t *testing.T) {
+	c := newTestConnector(t)
+
+	_, frontendConn := net.Pipe()
+	backendConn, _ := net.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	pumped := make(chan struct{})
+	go func() {
+		c.pumpConnections(ctx, frontendConn, backendConn)
+		close(pumped)
+	}()
+
+	assert.Eventually(t, func() bool {
+		return c.RelayGoroutines() == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	<-pumped
+	// as done by handleConnection
+	require.NoError(t, frontendConn.Close())
+	assert.Eventually(t, func() bool {
+		return c.RelayGoroutines() == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// tcpPair connects a client to a loopback listener and returns both ends
+func tcpPair(tb testing.TB) (clientConn net.Conn, serverConn net.Conn) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(tb, err)
+	defer listener.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := listener.Accept()
+		accepted <- conn
+	}()
+
+	clientConn, err = net.Dial("tcp", listener.Addr().String())
+	require.NoError(tb, err)
+	serverConn = <-accepted
+	require.NotNil(tb, serverConn)
+	tb.Cleanup(func() {
+		_ = clientConn.Close()
+		_ = serverConn.Close()
+	})
+	return clientConn, serverConn
+}
+
+// opaqueConn hides the io.ReaderFrom and io.WriterTo of the wrapped connection, which prevents zero-copy relaying
+type opaqueConn struct {
+	net.Conn
+}
+
+func benchmarkPumpConnections(b *testing.B, wrap func(conn net.Conn) net.Conn, relayBufferSize int) {
+	clientConn, frontendConn := tcpPair(b)
+	backendConn, serverConn := tcpPair(b)
+	c := newTestConnector(b)
+	require.NoError(b, c.UseRelayBufferSize(relayBufferSize))
+
+	relayed := make(chan struct{})
+	go func() {
+		c.pumpConnections(context.Background(), wrap(frontendConn), wrap(backendConn))
+		close(relayed)
+	}()
+
+	chunk := make([]byte, 64*1024)
+	received := make(chan error, 1)
+	go func() {
+		_, err := io.CopyN(io.Discard, serverConn, int64(b.N)*int64(len(chunk)))
+		received <- err
+	}()
+
+	b.SetBytes(int64(len(chunk)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := clientConn.Write(chunk); err != nil {
+			b.Fatal(err)
+		}
+	}
+	require.NoError(b, <-received)
+	b.StopTimer()
+
+	_ = clientConn.Close()
+	_ = frontendConn.Close()
+	<-relayed
+}
+
+// BenchmarkConnector_pumpConnections compares relaying between the accepted and dialed TCP connections, which
+// the runtime splices on Linux, with relaying through wrappers that force copying through user space and through
+// relay buffers of several sizes
+func BenchmarkConnector_pumpConnections(b *testing.B) {
+	b.Run("tcp", func(b *testing.B) {
+		benchmarkPumpConnections(b, func(conn net.Conn) net.Conn { return conn }, 0)
+	})
+	b.Run("wrapped", func(b *testing.B) {
+		benchmarkPumpConnections(b, func(conn net.Conn) net.Conn { return opaqueConn{conn} }, 0)
+	})
+	for _, size := range []int{4 * 1024, 32 * 1024, 256 * 1024} {
+		b.Run(fmt.Sprintf("buffer %dKiB", size/1024), func(b *testing.B) {
+			benchmarkPumpConnections(b, func(conn net.Conn) net.Conn { return conn }, size)
+		})
+	}
+}
+
+func TestConnector_publishExpvars(t *testing.T) {
+	Routes.Reset()
+	Routes.CreateMapping("mc.example.com", "backend:25565", nil, RouteOptions{})
+	t.Cleanup(Routes.Reset)
+
+	c := newTestConnector(t)
+	conn, _ := net.Pipe()
+	c.connections.register(conn, &ActiveConnection{Client: "client", ServerAddress: "mc.example.com", Backend: "backend:25565"})
+	c.publishExpvars()
+
+	assert.JSONEq(t, `{"mc.example.com": "backend:25565"}`, expvar.Get(expvarRoutes).String())
+	var connections []ActiveConnection
+	require.NoError(t, json.Unmarshal([]byte(expvar.Get(expvarConnections).String()), &connections))
+	if assert.Len(t, connections, 1) {
+		assert.Equal(t, "client", connections[0].Client)
+	}
+}
+
+func TestConnector_RouteDetail(t *testing.T) {
+	Routes.Reset()
+	Routes.CreateMapping("mc.example.com", "backend:25565", nil, RouteOptions{})
+	t.Cleanup(Routes.Reset)
+
+	c := newTestConnector(t)
+	cache := NewStatusCache(dialTest, time.Minute)
+	cache.put("mc.example.com", &mcproto.StatusResponse{Description: mcproto.TextComponent{Text: "cached"}}, time.Now())
+	c.UseStatusCache(cache)
+	for _, serverAddress := range []string{"mc.example.com", "mc.example.com", "other.example.com"} {
+		conn, _ := net.Pipe()
+		c.connections.register(conn, &ActiveConnection{ServerAddress: serverAddress, Backend: "backend:25565"})
+	}
+
+	router := mux.NewRouter()
+	router.Path("/routes/{serverAddress}").Methods("GET").HandlerFunc(c.routeDetailHandler)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/routes/MC.example.com", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	var detail RouteDetail
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &detail))
+	assert.Equal(t, "backend:25565", detail.Backend)
+	assert.False(t, detail.Wakeable)
+	assert.Equal(t, 2, detail.ActiveConnections)
+	if assert.NotNil(t, detail.Status) {
+		assert.Equal(t, "cached", detail.Status.Description.Text)
+	}
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/routes/other.example.com", nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
+
+func TestConnector_ShutdownAbortsStalledHandshake(t *testing.T) {
+	c := newTestConnector(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	clientConn, routerConn := net.Pipe()
+	//goland:noinspection GoUnhandledErrorResult
+	defer clientConn.Close()
+	handled := make(chan struct{})
+	go func() {
+		c.HandleConnection(ctx, routerConn)
+		close(handled)
+	}()
+
+	// the client stalls part way through the handshake frame
+	_, err := clientConn.Write([]byte{0x10, 0x00})
+	require.NoError(t, err)
+	cancel()
+
+	select {
+	case <-handled:
+	case <-time.After(handshakeTimeout / 2):
+		t.Fatal("stalled handshake was not aborted")
+	}
+}
+
+func TestConnector_WaitForConnectionsAbortsStalledHandshake(t *testing.T) {
+	c := newTestConnector(t)
+
+	// as in main, the connection's ctx is not cancelled before waiting for the connections
+	clientConn, routerConn := net.Pipe()
+	//goland:noinspection GoUnhandledErrorResult
+	defer clientConn.Close()
+	handled := make(chan struct{})
+	go func() {
+		c.HandleConnection(context.Background(), routerConn)
+		close(handled)
+	}()
+
+	// the client stalls part way through the handshake frame
+	_, err := clientConn.Write([]byte{0x10, 0x00})
+	require.NoError(t, err)
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), time.Second)
+	defer waitCancel()
+	assert.Zero(t, c.WaitForConnections(waitCtx))
+
+	select {
+	case <-handled:
+	case <-time.After(handshakeTimeout / 2):
+		t.Fatal("stalled handshake was not aborted")
+	}
+}
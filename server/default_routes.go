@@ -0,0 +1,86 @@
+package server
+
+import (
+	"net"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// ClientDefaultRoute is the default backend for clients in an IP range whose server address isn't mapped
+type ClientDefaultRoute struct {
+	Network *net.IPNet
+	Backend string
+}
+
+// ParseClientDefaultRoutes parses the default backends keyed by IP address or CIDR, such as
+// "10.0.0.0/8" = "lobby:25565". The routes are ordered from the most to the least specific range.
+func ParseClientDefaultRoutes(defaults map[string]string) ([]ClientDefaultRoute, error) {
+	routes := make([]ClientDefaultRoute, 0, len(defaults))
+	for clients, backend := range defaults {
+		network, err := parseIPOrCIDR(clients)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid clients of default route %s", clients)
+		}
+		if backend == "" {
+			return nil, errors.Errorf("missing backend of default route for %s", clients)
+		}
+		if err := ValidateBackend(backend); err != nil {
+			return nil, errors.Wrapf(err, "invalid backend of default route for %s", clients)
+		}
+		routes = append(routes, ClientDefaultRoute{Network: network, Backend: backend})
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		onesI, _ := routes[i].Network.Mask.Size()
+		onesJ, _ := routes[j].Network.Mask.Size()
+		if onesI != onesJ {
+			return onesI > onesJ
+		}
+		return routes[i].Network.String() < routes[j].Network.String()
+	})
+	return routes, nil
+}
+
+// parseIPOrCIDR parses either CIDR notation or a single IP address as a network of just that address
+func parseIPOrCIDR(value string) (*net.IPNet, error) {
+	if _, network, err := net.ParseCIDR(value); err == nil {
+		return network, nil
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, errors.Errorf("%q is not an IP address or CIDR", value)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// UseClientDefaultRoutes selects the default backend by client IP range for server addresses that aren't mapped,
+// where the most specific range containing the client is used. A listener's default takes precedence and
+// the global default route of Routes is the fallback.
+func (c *Connector) UseClientDefaultRoutes(routes []ClientDefaultRoute) {
+	c.clientDefaultRoutes = routes
+}
+
+// selectDefaultBackend returns the listener's default, or else the default for the client's IP range, if any.
+// An empty result leaves the global default route in place.
+func (c *Connector) selectDefaultBackend(listenerDefault string, clientAddr net.Addr) string {
+	if listenerDefault != "" {
+		return listenerDefault
+	}
+	if len(c.clientDefaultRoutes) == 0 {
+		return ""
+	}
+	tcpAddr, ok := clientAddr.(*net.TCPAddr)
+	if !ok {
+		return ""
+	}
+	for _, route := range c.clientDefaultRoutes {
+		if route.Network.Contains(tcpAddr.IP) {
+			return route.Backend
+		}
+	}
+	return ""
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseClientDefaultRoutes(t *testing.T) {
+	routes, err := ParseClientDefaultRoutes(map[string]string{
+		"10.0.0.0/8":  "wide:25565",
+		"10.1.2.3":    "single:25565",
+		"10.1.0.0/16": "narrow:25565",
+	})
+	require.NoError(t, err)
+
+	backends := make([]string, 0, len(routes))
+	for _, route := range routes {
+		backends = append(backends, route.Backend)
+	}
+	assert.Equal(t, []string{"single:25565", "narrow:25565", "wide:25565"}, backends)
+
+	for _, invalid := range []map[string]string{
+		{"10.0.0.0/99": "backend:25565"},
+		{"lobby": "backend:25565"},
+		{"10.0.0.0/8": ""},
+		{"10.0.0.0/8": "backend"},
+	} {
+		_, err := ParseClientDefaultRoutes(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestConnector_selectDefaultBackend(t *testing.T) {
+	c := newTestConnector(t)
+	routes, err := ParseClientDefaultRoutes(map[string]string{
+		"10.0.0.0/8":  "wide:25565",
+		"10.1.0.0/16": "narrow:25565",
+	})
+	require.NoError(t, err)
+	c.UseClientDefaultRoutes(routes)
+
+	clientAt := func(ip string) net.Addr {
+		return &net.TCPAddr{IP: net.ParseIP(ip), Port: 51000}
+	}
+
+	assert.Equal(t, "listener:25565", c.selectDefaultBackend("listener:25565", clientAt("10.1.0.5")))
+	assert.Equal(t, "narrow:25565", c.selectDefaultBackend("", clientAt("10.1.0.5")))
+	assert.Equal(t, "wide:25565", c.selectDefaultBackend("", clientAt("10.2.0.5")))
+	assert.Empty(t, c.selectDefaultBackend("", clientAt("192.168.1.5")))
+}
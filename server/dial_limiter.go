@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-kit/kit/metrics"
+)
+
+// dialLimiter limits the number of concurrent dials to each backend, such as when many queued clients
+// connect at once after a backend wakes up
+type dialLimiter struct {
+	sync.Mutex
+	defaultLimit int
+	// semaphores are keyed by backend and limit so that routes to the same backend with differing limits
+	// each enforce their own limit
+	semaphores map[dialLimiterKey]*dialSemaphore
+
+	queued      int32
+	queuedGauge metrics.Gauge
+}
+
+type dialLimiterKey struct {
+	backend string
+	limit   int
+}
+
+type dialSemaphore struct {
+	slots chan struct{}
+	// users counts the dials holding or waiting for a slot, where the semaphore is discarded when there are none
+	users int
+}
+
+func newDialLimiter(defaultLimit int, queuedGauge metrics.Gauge) *dialLimiter {
+	return &dialLimiter{
+		defaultLimit: defaultLimit,
+		semaphores:   make(map[dialLimiterKey]*dialSemaphore),
+		queuedGauge:  queuedGauge,
+	}
+}
+
+// acquire waits, if needed, for a dial slot to the backend. The routeLimit, when greater than zero, overrides
+// the default limit. Returns a function that must be called to release the slot once dialing is complete.
+func (l *dialLimiter) acquire(ctx context.Context, backend string, routeLimit int) (func(), error) {
+	limit := l.defaultLimit
+	if routeLimit > 0 {
+		limit = routeLimit
+	}
+	if limit <= 0 {
+		return func() {}, nil
+	}
+
+	key := dialLimiterKey{backend: backend, limit: limit}
+	semaphore := l.join(key)
+
+	select {
+	case semaphore.slots <- struct{}{}:
+	default:
+		l.queuedGauge.Set(float64(atomic.AddInt32(&l.queued, 1)))
+		defer func() {
+			l.queuedGauge.Set(float64(atomic.AddInt32(&l.queued, -1)))
+		}()
+
+		select {
+		case semaphore.slots <- struct{}{}:
+		case <-ctx.Done():
+			l.leave(key, semaphore)
+			return nil, ctx.Err()
+		}
+	}
+
+	return func() {
+		<-semaphore.slots
+		l.leave(key, semaphore)
+	}, nil
+}
+
+func (l *dialLimiter) join(key dialLimiterKey) *dialSemaphore {
+	l.Lock()
+	defer l.Unlock()
+
+	semaphore, exists := l.semaphores[key]
+	if !exists {
+		semaphore = &dialSemaphore{slots: make(chan struct{}, key.limit)}
+		l.semaphores[key] = semaphore
+	}
+	semaphore.users++
+	return semaphore
+}
+
+func (l *dialLimiter) leave(key dialLimiterKey, semaphore *dialSemaphore) {
+	l.Lock()
+	defer l.Unlock()
+
+	semaphore.users--
+	if semaphore.users == 0 {
+		delete(l.semaphores, key)
+	}
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/metrics/generic"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialLimiter_Unlimited(t *testing.T) {
+	l := newDialLimiter(0, generic.NewGauge("queued"))
+
+	for i := 0; i < 5; i++ {
+		_, err := l.acquire(context.Background(), "backend:25565", 0)
+		require.NoError(t, err)
+	}
+}
+
+func TestDialLimiter_QueuesBeyondLimit(t *testing.T) {
+	queued := generic.NewGauge("queued")
+	l := newDialLimiter(1, queued)
+
+	release, err := l.acquire(context.Background(), "backend:25565", 0)
+	require.NoError(t, err)
+
+	// other backends are limited independently
+	releaseOther, err := l.acquire(context.Background(), "other:25565", 0)
+	require.NoError(t, err)
+	releaseOther()
+
+	acquired := make(chan func())
+	go func() {
+		secondRelease, err := l.acquire(context.Background(), "backend:25565", 0)
+		if assert.NoError(t, err) {
+			acquired <- secondRelease
+		}
+	}()
+
+	assert.Eventually(t, func() bool { return queued.Value() == 1 }, time.Second, 10*time.Millisecond)
+	select {
+	case <-acquired:
+		t.Fatal("second dial should wait for the first to release")
+	default:
+	}
+
+	release()
+	select {
+	case secondRelease := <-acquired:
+		secondRelease()
+	case <-time.After(time.Second):
+		t.Fatal("second dial was not released")
+	}
+	assert.Equal(t, float64(0), queued.Value())
+}
+
+func TestDialLimiter_RouteOverrideAndCancel(t *testing.T) {
+	l := newDialLimiter(5, generic.NewGauge("queued"))
+
+	_, err := l.acquire(context.Background(), "backend:25565", 1)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = l.acquire(ctx, "backend:25565", 1)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestDialLimiter_DifferingLimitsForSameBackend(t *testing.T) {
+	l := newDialLimiter(2, generic.NewGauge("queued"))
+
+	// a route overriding the limit to 1 on the same backend as the default limit
+	releaseOverride, err := l.acquire(context.Background(), "backend:25565", 1)
+	require.NoError(t, err)
+	releaseDefault, err := l.acquire(context.Background(), "backend:25565", 0)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = l.acquire(ctx, "backend:25565", 1)
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "override limit should still be enforced")
+
+	releaseOverride()
+	releaseDefault()
+	assert.Empty(t, l.semaphores, "idle semaphores should be discarded")
+}
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"context"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Values accepted for the IP family used when dialing backends
+const (
+	IPFamilyAny        = "any"
+	IPFamilyIPv4       = "ipv4"
+	IPFamilyIPv6       = "ipv6"
+	IPFamilyPreferIPv4 = "prefer-ipv4"
+	IPFamilyPreferIPv6 = "prefer-ipv6"
+)
+
+// backendDialer dials backends while restricting or preferring an IP family for hostnames that
+// resolve to both IPv4 and IPv6 addresses
+type backendDialer struct {
+	ipFamily string
+	dialer   net.Dialer
+	// dialFunc, when set, replaces the dialer for the individual addresses of a preferred family
+	dialFunc func(ctx context.Context, network string, address string) (net.Conn, error)
+}
+
+// preferredFamilyFallbackDelay is how long to wait for the preferred family to connect before also
+// attempting the other family, which matches the default of net.Dialer
+const preferredFamilyFallbackDelay = 300 * time.Millisecond
+
+func newBackendDialer(ipFamily string) (*backendDialer, error) {
+	switch ipFamily {
+	case "":
+		ipFamily = IPFamilyAny
+	case IPFamilyAny, IPFamilyIPv4, IPFamilyIPv6, IPFamilyPreferIPv4, IPFamilyPreferIPv6:
+	default:
+		return nil, errors.Errorf("unsupported IP family: %s", ipFamily)
+	}
+	return &backendDialer{ipFamily: ipFamily}, nil
+}
+
+// NewBackendDialFunc returns a function that dials backends in the same way as relayed client connections,
+// where ipFamily is one of the values accepted by Connector.UseBackendIPFamily
+func NewBackendDialFunc(ipFamily string) (func(ctx context.Context, address string) (net.Conn, error), error) {
+	dialer, err := newBackendDialer(ipFamily)
+	if err != nil {
+		return nil, err
+	}
+	return dialer.DialContext, nil
+}
+
+func (d *backendDialer) DialContext(ctx context.Context, address string) (net.Conn, error) {
+	switch d.ipFamily {
+	case IPFamilyIPv4:
+		return d.dialer.DialContext(ctx, "tcp4", address)
+	case IPFamilyIPv6:
+		return d.dialer.DialContext(ctx, "tcp6", address)
+	case IPFamilyPreferIPv4, IPFamilyPreferIPv6:
+		return d.dialPreferred(ctx, address, d.ipFamily == IPFamilyPreferIPv4)
+	default:
+		return d.dialer.DialContext(ctx, "tcp", address)
+	}
+}
+
+// dialPreferred resolves the host and attempts the addresses of the preferred family before the others
+func (d *backendDialer) dialPreferred(ctx context.Context, address string, preferIPv4 bool) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) != nil {
+		return d.dialer.DialContext(ctx, "tcp", address)
+	}
+
+	ipAddrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ipAddrs) == 0 {
+		return nil, errors.Errorf("no addresses found for %s", host)
+	}
+	orderByIPFamily(ipAddrs, preferIPv4)
+
+	return d.dialOrdered(ctx, ipAddrs, port, preferIPv4)
+}
+
+type dialResult struct {
+	conn      net.Conn
+	err       error
+	preferred bool
+}
+
+// dialOrdered dials the addresses of the preferred family and, if those haven't connected after
+// preferredFamilyFallbackDelay or they have failed, races them with the addresses of the other family.
+// That way an unreachable, such as blackholed, preferred family doesn't delay every connection by the
+// operating system's connect timeout. The ipAddrs must already be ordered by orderByIPFamily.
+func (d *backendDialer) dialOrdered(ctx context.Context, ipAddrs []net.IPAddr, port string, preferIPv4 bool) (net.Conn, error) {
+	split := len(ipAddrs)
+	for i, ipAddr := range ipAddrs {
+		if (ipAddr.IP.To4() != nil) != preferIPv4 {
+			split = i
+			break
+		}
+	}
+	preferred, fallback := ipAddrs[:split], ipAddrs[split:]
+	if len(preferred) == 0 || len(fallback) == 0 {
+		return d.dialSerial(ctx, ipAddrs, port)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan dialResult, 2)
+	startDialing := func(addrs []net.IPAddr, isPreferred bool) {
+		go func() {
+			conn, err := d.dialSerial(ctx, addrs, port)
+			results <- dialResult{conn: conn, err: err, preferred: isPreferred}
+		}()
+	}
+
+	startDialing(preferred, true)
+	pending := 1
+	fallbackTimer := time.NewTimer(preferredFamilyFallbackDelay)
+	defer fallbackTimer.Stop()
+	fallbackStarted := false
+
+	var preferredErr error
+	for {
+		select {
+		case <-fallbackTimer.C:
+			if !fallbackStarted {
+				fallbackStarted = true
+				startDialing(fallback, false)
+				pending++
+			}
+
+		case result := <-results:
+			pending--
+			if result.err == nil {
+				if pending > 0 {
+					// the other attempt is cancelled, but may have connected in the meantime
+					go func() {
+						if late := <-results; late.conn != nil {
+							_ = late.conn.Close()
+						}
+					}()
+				}
+				return result.conn, nil
+			}
+
+			if result.preferred {
+				preferredErr = result.err
+				if !fallbackStarted {
+					fallbackStarted = true
+					startDialing(fallback, false)
+					pending++
+				}
+			}
+			if pending == 0 {
+				if preferredErr != nil {
+					return nil, preferredErr
+				}
+				return nil, result.err
+			}
+		}
+	}
+}
+
+// dialSerial attempts each of the addresses in turn until one connects
+func (d *backendDialer) dialSerial(ctx context.Context, ipAddrs []net.IPAddr, port string) (net.Conn, error) {
+	var lastErr error
+	for _, ipAddr := range ipAddrs {
+		conn, err := d.dial(ctx, "tcp", net.JoinHostPort(ipAddr.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func (d *backendDialer) dial(ctx context.Context, network string, address string) (net.Conn, error) {
+	if d.dialFunc != nil {
+		return d.dialFunc(ctx, network, address)
+	}
+	return d.dialer.DialContext(ctx, network, address)
+}
+
+// orderByIPFamily stable sorts the addresses so that those of the preferred family come first
+func orderByIPFamily(ipAddrs []net.IPAddr, preferIPv4 bool) {
+	sort.SliceStable(ipAddrs, func(i, j int) bool {
+		iPreferred := (ipAddrs[i].IP.To4() != nil) == preferIPv4
+		jPreferred := (ipAddrs[j].IP.To4() != nil) == preferIPv4
+		return iPreferred && !jPreferred
+	})
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrderByIPFamily(t *testing.T) {
+	parse := func(ips ...string) []net.IPAddr {
+		result := make([]net.IPAddr, 0, len(ips))
+		for _, ip := range ips {
+			result = append(result, net.IPAddr{IP: net.ParseIP(ip)})
+		}
+		return result
+	}
+
+	ipAddrs := parse("2001:db8::1", "10.0.0.1", "2001:db8::2", "10.0.0.2")
+	orderByIPFamily(ipAddrs, true)
+	assert.Equal(t, parse("10.0.0.1", "10.0.0.2", "2001:db8::1", "2001:db8::2"), ipAddrs)
+
+	orderByIPFamily(ipAddrs, false)
+	assert.Equal(t, parse("2001:db8::1", "2001:db8::2", "10.0.0.1", "10.0.0.2"), ipAddrs)
+}
+
+func TestNewBackendDialer(t *testing.T) {
+	dialer, err := newBackendDialer("")
+	require.NoError(t, err)
+	assert.Equal(t, IPFamilyAny, dialer.ipFamily)
+
+	_, err = newBackendDialer("ipv5")
+	assert.Error(t, err)
+}
+
+func TestBackendDialer_FallsBackFromUnreachableFamily(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	//goland:noinspection GoUnhandledErrorResult
+	defer listener.Close()
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	require.NoError(t, err)
+
+	d := &backendDialer{ipFamily: IPFamilyPreferIPv6}
+	var dialer net.Dialer
+	d.dialFunc = func(ctx context.Context, network string, address string) (net.Conn, error) {
+		host, _, _ := net.SplitHostPort(address)
+		if net.ParseIP(host).To4() == nil {
+			// simulates a blackholed IPv6 route that never completes connecting
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return dialer.DialContext(ctx, network, address)
+	}
+
+	ipAddrs := []net.IPAddr{{IP: net.ParseIP("127.0.0.1")}, {IP: net.ParseIP("2001:db8::1")}}
+	orderByIPFamily(ipAddrs, false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	conn, err := d.dialOrdered(ctx, ipAddrs, port, false)
+	require.NoError(t, err)
+	//goland:noinspection GoUnhandledErrorResult
+	defer conn.Close()
+
+	assert.Less(t, time.Since(start), 2*time.Second)
+	assert.Equal(t, listener.Addr().String(), conn.RemoteAddr().String())
+}
+
+func TestBackendDialer_FallsBackAfterPreferredFailure(t *testing.T) {
+	d := &backendDialer{ipFamily: IPFamilyPreferIPv4}
+	var attempted []string
+	d.dialFunc = func(ctx context.Context, network string, address string) (net.Conn, error) {
+		attempted = append(attempted, address)
+		return nil, errors.New("refused")
+	}
+
+	ipAddrs := []net.IPAddr{{IP: net.ParseIP("10.0.0.1")}, {IP: net.ParseIP("2001:db8::1")}}
+	_, err := d.dialOrdered(context.Background(), ipAddrs, "25565", true)
+	assert.Error(t, err)
+	assert.Equal(t, []string{"10.0.0.1:25565", "[2001:db8::1]:25565"}, attempted)
+}
@@ -3,6 +3,7 @@ package server
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"strconv"
 	"strings"
 	"sync"
@@ -10,63 +11,138 @@ import (
 
 	dockertypes "github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/client"
 	"github.com/sirupsen/logrus"
 )
 
 type IDockerWatcher interface {
-	Start(socket string, timeoutSeconds int, refreshIntervalSeconds int) error
+	Start(config DockerWatcherConfig) error
 	Stop()
 }
 
+// DockerWatcherConfig declares how the Docker API is accessed and its containers, or swarm services, are routed
+type DockerWatcherConfig struct {
+	Socket                 string
+	TimeoutSeconds         int
+	RefreshIntervalSeconds int
+	// HTTPHeaders are included in requests to the Docker API, where DockerDefaultUserAgent is used if no User-Agent is given
+	HTTPHeaders map[string]string
+	// RouteStoppedContainers retains the routes of stopped containers even without a MOTD label, so that the route
+	// exists when the container starts. It does not apply to swarm services.
+	RouteStoppedContainers bool
+	// WatchEvents updates the routes of containers as their events are received, rather than listing all containers
+	// every refresh interval. All containers are still listed every ReconcileIntervalSeconds in case an event is missed.
+	// It does not apply to swarm services.
+	WatchEvents              bool
+	ReconcileIntervalSeconds int
+	// EventActions are the container events, such as start or health_status, that update the routes while
+	// watching events, where DockerDefaultEventActions are used if empty
+	EventActions []string
+	// RouteConflictPolicy decides which of the running containers, or services, declaring the same host is routed,
+	// such as RouteConflictFirstWins, where RouteConflictLastWins is used if empty
+	RouteConflictPolicy string
+}
+
 const (
 	DockerAPIVersion         = "1.24"
 	DockerRouterLabelHost    = "mc-router.host"
 	DockerRouterLabelPort    = "mc-router.port"
 	DockerRouterLabelDefault = "mc-router.default"
 	DockerRouterLabelNetwork = "mc-router.network"
+	DockerRouterLabelMOTD    = "mc-router.motd"
+	DockerRouterLabelFavicon = "mc-router.favicon"
+
+	DockerRouterLabelBackendServerName = "mc-router.backend-server-name"
+	DockerRouterLabelDialConcurrency   = "mc-router.dial-concurrency"
+	DockerRouterLabelShadowBackend     = "mc-router.shadow-backend"
+	DockerRouterLabelShadowSampleRate  = "mc-router.shadow-sample-rate"
+	DockerRouterLabelSendProxyProtocol = "mc-router.send-proxy-protocol"
+	DockerRouterLabelRequireProxyProto = "mc-router.require-proxy-protocol"
+	DockerRouterLabelTrustedProxies    = "mc-router.trusted-proxies"
+	DockerRouterLabelPlayersSource     = "mc-router.players-source"
+	DockerRouterLabelMaxPlayers        = "mc-router.max-players"
+	// DockerRouterLabelAutoScaleUp declares that a Docker Swarm service is scaled up from zero replicas when woken
+	DockerRouterLabelAutoScaleUp = "mc-router.auto-scale-up"
+	// DockerRouterLabelAutoScaleDown declares that a Docker Swarm service is scaled down to zero replicas once idle
+	DockerRouterLabelAutoScaleDown = "mc-router.auto-scale-down"
+	// DockerRouterLabelAutoScaleDownAfter is how long a Docker Swarm service is idle before it is scaled down
+	DockerRouterLabelAutoScaleDownAfter = "mc-router.auto-scale-down-after"
 )
 
+// DockerDefaultUserAgent is presented to the Docker API when the given HTTP headers do not include a User-Agent
+const DockerDefaultUserAgent = "mc-router"
+
 var DockerWatcher IDockerWatcher = &dockerWatcherImpl{}
 
 type dockerWatcherImpl struct {
 	sync.RWMutex
 	client        *client.Client
 	contextCancel context.CancelFunc
+	favicons      faviconCache
+	routeStopped  bool
+	// eventActions are the container events that update the routes while watching events
+	eventActions map[events.Action]bool
+	// conflictPolicy resolves running containers that declare the same host
+	conflictPolicy string
 }
 
-func (w *dockerWatcherImpl) makeWakerFunc(_ *routableContainer) func(ctx context.Context) error {
-	return func(ctx context.Context) error {
-		return nil
+// dockerHTTPHeaders returns the headers to include in requests to the Docker API, which are the given headers
+// with a default User-Agent, if needed
+func dockerHTTPHeaders(headers map[string]string) map[string]string {
+	result := make(map[string]string, len(headers)+1)
+	for name, value := range headers {
+		result[http.CanonicalHeaderKey(name)] = value
+	}
+	if result["User-Agent"] == "" {
+		result["User-Agent"] = DockerDefaultUserAgent
 	}
+	return result
 }
 
-func (w *dockerWatcherImpl) Start(socket string, timeoutSeconds int, refreshIntervalSeconds int) error {
+func (w *dockerWatcherImpl) Start(config DockerWatcherConfig) error {
 	var err error
 
-	timeout := time.Duration(timeoutSeconds) * time.Second
-	refreshInterval := time.Duration(refreshIntervalSeconds) * time.Second
+	timeout := time.Duration(config.TimeoutSeconds) * time.Second
+	refreshInterval := time.Duration(config.RefreshIntervalSeconds) * time.Second
+	w.routeStopped = config.RouteStoppedContainers
+	w.conflictPolicy = config.RouteConflictPolicy
 
 	opts := []client.Opt{
-		client.WithHost(socket),
-		client.WithTimeout(timeout),
-		client.WithHTTPHeaders(map[string]string{
-			"User-Agent": "mc-router ",
-		}),
+		client.WithHost(config.Socket),
+		client.WithHTTPHeaders(dockerHTTPHeaders(config.HTTPHeaders)),
 		client.WithVersion(DockerAPIVersion),
 	}
 
-	w.client, err = client.NewClientWithOpts(opts...)
+	w.client, err = client.NewClientWithOpts(append(opts, client.WithTimeout(timeout))...)
 	if err != nil {
 		return err
 	}
 
-	ticker := time.NewTicker(refreshInterval)
 	containerMap := map[string]*routableContainer{}
 
 	var ctx context.Context
 	ctx, w.contextCancel = context.WithCancel(context.Background())
 
+	if config.WatchEvents {
+		// the events stream lasts until stopped, so is not limited by the timeout
+		eventsClient, err := client.NewClientWithOpts(opts...)
+		if err != nil {
+			return err
+		}
+		w.eventActions, err = parseDockerEventActions(config.EventActions)
+		if err != nil {
+			return err
+		}
+		reconcileInterval := time.Duration(config.ReconcileIntervalSeconds) * time.Second
+		if err := w.startWatchingEvents(ctx, eventsClient, containerMap, reconcileInterval, refreshInterval); err != nil {
+			return err
+		}
+		logrus.Info("Monitoring Docker events for Minecraft containers")
+		return nil
+	}
+
+	ticker := time.NewTicker(refreshInterval)
 	initialContainers, err := w.listContainers(ctx)
 	if err != nil {
 		return err
@@ -75,7 +151,7 @@ func (w *dockerWatcherImpl) Start(socket string, timeoutSeconds int, refreshInte
 	for _, c := range initialContainers {
 		containerMap[c.externalContainerName] = c
 		if c.externalContainerName != "" {
-			Routes.CreateMapping(c.externalContainerName, c.containerEndpoint, w.makeWakerFunc(c))
+			Routes.CreateMapping(c.externalContainerName, c.containerEndpoint, nil, c.routeOptions.withSource(RouteSourceDocker))
 		} else {
 			Routes.SetDefaultRoute(c.containerEndpoint)
 		}
@@ -91,39 +167,7 @@ func (w *dockerWatcherImpl) Start(socket string, timeoutSeconds int, refreshInte
 					return
 				}
 
-				visited := map[string]struct{}{}
-				for _, rs := range containers {
-					if oldRs, ok := containerMap[rs.externalContainerName]; !ok {
-						containerMap[rs.externalContainerName] = rs
-						logrus.WithField("routableContainer", rs).Debug("ADD")
-						if rs.externalContainerName != "" {
-							Routes.CreateMapping(rs.externalContainerName, rs.containerEndpoint, w.makeWakerFunc(rs))
-						} else {
-							Routes.SetDefaultRoute(rs.containerEndpoint)
-						}
-					} else if oldRs.containerEndpoint != rs.containerEndpoint {
-						containerMap[rs.externalContainerName] = rs
-						if rs.externalContainerName != "" {
-							Routes.DeleteMapping(rs.externalContainerName)
-							Routes.CreateMapping(rs.externalContainerName, rs.containerEndpoint, w.makeWakerFunc(rs))
-						} else {
-							Routes.SetDefaultRoute(rs.containerEndpoint)
-						}
-						logrus.WithFields(logrus.Fields{"old": oldRs, "new": rs}).Debug("UPDATE")
-					}
-					visited[rs.externalContainerName] = struct{}{}
-				}
-				for _, rs := range containerMap {
-					if _, ok := visited[rs.externalContainerName]; !ok {
-						delete(containerMap, rs.externalContainerName)
-						if rs.externalContainerName != "" {
-							Routes.DeleteMapping(rs.externalContainerName)
-						} else {
-							Routes.SetDefaultRoute("")
-						}
-						logrus.WithField("routableContainer", rs).Debug("DELETE")
-					}
-				}
+				w.applyContainers(containerMap, containers)
 
 			case <-ctx.Done():
 				ticker.Stop()
@@ -136,42 +180,132 @@ func (w *dockerWatcherImpl) Start(socket string, timeoutSeconds int, refreshInte
 	return nil
 }
 
+// applyContainers updates the routes of the containerMap, keyed by host, to those of the listed containers
+func (w *dockerWatcherImpl) applyContainers(containerMap map[string]*routableContainer, containers []*routableContainer) {
+	visited := map[string]struct{}{}
+	for _, rs := range containers {
+		if oldRs, ok := containerMap[rs.externalContainerName]; !ok {
+			containerMap[rs.externalContainerName] = rs
+			logrus.WithField("routableContainer", rs).Debug("ADD")
+			if rs.externalContainerName != "" {
+				Routes.CreateMapping(rs.externalContainerName, rs.containerEndpoint, nil, rs.routeOptions.withSource(RouteSourceDocker))
+			} else {
+				Routes.SetDefaultRoute(rs.containerEndpoint)
+			}
+		} else if oldRs.containerEndpoint != rs.containerEndpoint || oldRs.routeOptions != rs.routeOptions {
+			containerMap[rs.externalContainerName] = rs
+			if rs.externalContainerName != "" {
+				Routes.DeleteMapping(rs.externalContainerName)
+				Routes.CreateMapping(rs.externalContainerName, rs.containerEndpoint, nil, rs.routeOptions.withSource(RouteSourceDocker))
+			} else {
+				Routes.SetDefaultRoute(rs.containerEndpoint)
+			}
+			logrus.WithFields(logrus.Fields{"old": oldRs, "new": rs}).Debug("UPDATE")
+		}
+		visited[rs.externalContainerName] = struct{}{}
+	}
+	for _, rs := range containerMap {
+		if _, ok := visited[rs.externalContainerName]; !ok {
+			delete(containerMap, rs.externalContainerName)
+			if rs.externalContainerName != "" {
+				Routes.DeleteMapping(rs.externalContainerName)
+			} else {
+				Routes.SetDefaultRoute("")
+			}
+			logrus.WithField("routableContainer", rs).Debug("DELETE")
+		}
+	}
+}
+
 func (w *dockerWatcherImpl) listContainers(ctx context.Context) ([]*routableContainer, error) {
-	containers, err := w.client.ContainerList(ctx, container.ListOptions{})
+	// stopped containers are included so that their status labels are still served
+	containers, err := w.client.ContainerList(ctx, container.ListOptions{All: true})
 	if err != nil {
 		return nil, err
 	}
 
-	var result []*routableContainer
+	return w.toRoutableContainers(containers), nil
+}
+
+func (w *dockerWatcherImpl) toRoutableContainers(containers []dockertypes.Container) []*routableContainer {
+	var running []*routableContainer
+	var claims []routeClaim
+	var stopped []*routableContainer
 	for _, container := range containers {
 		data, ok := w.parseContainerData(&container)
 		if !ok {
 			continue
 		}
 
+		if data.stopped {
+			// the route has no backend, which serves the MOTD, if any, to status requests
+			for _, host := range data.hosts {
+				stopped = append(stopped, &routableContainer{
+					externalContainerName: host,
+					routeOptions:          data.routeOptions,
+				})
+			}
+			continue
+		}
+
+		claim := routeClaim{owner: dockerContainerName(&container), created: time.Unix(container.Created, 0)}
 		for _, host := range data.hosts {
-			result = append(result, &routableContainer{
+			running = append(running, &routableContainer{
 				containerEndpoint:     fmt.Sprintf("%s:%d", data.ip, data.port),
 				externalContainerName: host,
+				routeOptions:          data.routeOptions,
 			})
+			claim.host = host
+			claims = append(claims, claim)
 		}
 		if data.def != nil && *data.def {
-			result = append(result, &routableContainer{
+			running = append(running, &routableContainer{
 				containerEndpoint:     fmt.Sprintf("%s:%d", data.ip, data.port),
 				externalContainerName: "",
 			})
+			claim.host = ""
+			claims = append(claims, claim)
 		}
 	}
 
-	return result, nil
+	var result []*routableContainer
+	runningHosts := make(map[string]struct{})
+	for i, routed := range resolveRouteConflicts(w.conflictPolicy, RouteSourceDocker, claims) {
+		// a host in conflict is not served by stopped containers either
+		runningHosts[running[i].externalContainerName] = struct{}{}
+		if routed {
+			result = append(result, running[i])
+		}
+	}
+
+	// a running container takes precedence over stopped ones, such as those left behind when recreated
+	for _, rs := range stopped {
+		if _, running := runningHosts[rs.externalContainerName]; !running {
+			runningHosts[rs.externalContainerName] = struct{}{}
+			result = append(result, rs)
+		}
+	}
+
+	return result
+}
+
+// dockerContainerName identifies the container by its name, or else its ID, such as when logging route conflicts
+func dockerContainerName(container *dockertypes.Container) string {
+	if len(container.Names) > 0 {
+		return strings.TrimPrefix(container.Names[0], "/")
+	}
+	return container.ID
 }
 
 type parsedDockerContainerData struct {
-	hosts   []string
-	port    uint64
-	def     *bool
-	network *string
-	ip      string
+	// stopped indicates the container is not running, so has no ip
+	stopped      bool
+	hosts        []string
+	port         uint64
+	def          *bool
+	network      *string
+	ip           string
+	routeOptions RouteOptions
 }
 
 func (w *dockerWatcherImpl) parseContainerData(container *dockertypes.Container) (data parsedDockerContainerData, ok bool) {
@@ -220,6 +354,87 @@ func (w *dockerWatcherImpl) parseContainerData(container *dockertypes.Container)
 			data.network = new(string)
 			*data.network = value
 		}
+		if key == DockerRouterLabelMOTD {
+			data.routeOptions.MOTD = value
+		}
+		if key == DockerRouterLabelPlayersSource {
+			if err := ValidatePlayersSource(value); err != nil {
+				logrus.WithFields(logrus.Fields{"containerId": container.ID, "containerNames": container.Names}).
+					WithError(err).
+					Warnf("ignoring invalid %s label", DockerRouterLabelPlayersSource)
+			} else {
+				data.routeOptions.PlayersSource = value
+			}
+		}
+		if key == DockerRouterLabelMaxPlayers {
+			maxPlayers, err := strconv.Atoi(value)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{"containerId": container.ID, "containerNames": container.Names}).
+					WithError(err).
+					Warnf("ignoring invalid %s label", DockerRouterLabelMaxPlayers)
+			} else {
+				data.routeOptions.MaxPlayers = maxPlayers
+			}
+		}
+		if key == DockerRouterLabelBackendServerName {
+			data.routeOptions.BackendServerName = value
+		}
+		if key == DockerRouterLabelDialConcurrency {
+			dialConcurrency, err := strconv.Atoi(value)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{"containerId": container.ID, "containerNames": container.Names}).
+					WithError(err).
+					Warnf("ignoring invalid %s label", DockerRouterLabelDialConcurrency)
+			} else {
+				data.routeOptions.DialConcurrency = dialConcurrency
+			}
+		}
+		if key == DockerRouterLabelShadowBackend {
+			data.routeOptions.ShadowBackend = value
+		}
+		if key == DockerRouterLabelShadowSampleRate {
+			sampleRate, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{"containerId": container.ID, "containerNames": container.Names}).
+					WithError(err).
+					Warnf("ignoring invalid %s label", DockerRouterLabelShadowSampleRate)
+			} else {
+				data.routeOptions.ShadowSampleRate = sampleRate
+			}
+		}
+		if key == DockerRouterLabelSendProxyProtocol {
+			sendProxyProto, err := ParseOptionalBool(value)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{"containerId": container.ID, "containerNames": container.Names}).
+					WithError(err).
+					Warnf("ignoring invalid %s label", DockerRouterLabelSendProxyProtocol)
+			} else {
+				data.routeOptions.SendProxyProto = sendProxyProto
+			}
+		}
+		if key == DockerRouterLabelRequireProxyProto {
+			requireProxyProto, err := strconv.ParseBool(value)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{"containerId": container.ID, "containerNames": container.Names}).
+					WithError(err).
+					Warnf("ignoring invalid %s label", DockerRouterLabelRequireProxyProto)
+			} else {
+				data.routeOptions.RequireProxyProto = requireProxyProto
+			}
+		}
+		if key == DockerRouterLabelTrustedProxies {
+			if _, err := ParseTrustedProxies(value); err != nil {
+				// the route is still restricted rather than opened up by an invalid value
+				logrus.WithFields(logrus.Fields{"containerId": container.ID, "containerNames": container.Names}).
+					WithError(err).
+					Warnf("invalid %s label will reject all clients", DockerRouterLabelTrustedProxies)
+			}
+			data.routeOptions.TrustedProxies = value
+		}
+		if key == DockerRouterLabelFavicon {
+			data.routeOptions.Favicon = w.favicons.get(value,
+				logrus.WithFields(logrus.Fields{"containerId": container.ID, "containerNames": container.Names, "label": DockerRouterLabelFavicon}))
+		}
 	}
 
 	// probably not minecraft related
@@ -227,6 +442,16 @@ func (w *dockerWatcherImpl) parseContainerData(container *dockertypes.Container)
 		return
 	}
 
+	if container.State != "running" {
+		// A stopped container has no address, but its route is retained to serve its status, such as a sleeping MOTD,
+		// or when configured to, so that the route is in place when it starts
+		if data.routeOptions.MOTD != "" || w.routeStopped {
+			data.stopped = true
+			ok = true
+		}
+		return
+	}
+
 	if len(container.NetworkSettings.Networks) == 0 {
 		logrus.WithFields(logrus.Fields{"containerId": container.ID, "containerNames": container.Names}).
 			Warnf("ignoring container, no networks found")
@@ -293,4 +518,5 @@ func (w *dockerWatcherImpl) Stop() {
 type routableContainer struct {
 	externalContainerName string
 	containerEndpoint     string
+	routeOptions          RouteOptions
 }
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// DockerDefaultEventActions are the container events that update the routes unless others are configured
+var DockerDefaultEventActions = []string{
+	string(events.ActionStart), string(events.ActionStop), string(events.ActionDie), string(events.ActionDestroy),
+}
+
+// dockerSupportedEventActions are the container events that may change whether, and how, a container is routed
+var dockerSupportedEventActions = []events.Action{
+	events.ActionCreate, events.ActionStart, events.ActionRestart, events.ActionStop, events.ActionKill,
+	events.ActionDie, events.ActionOOM, events.ActionDestroy, events.ActionPause, events.ActionUnPause,
+	events.ActionRename, events.ActionUpdate, events.ActionHealthStatus,
+}
+
+// parseDockerEventActions validates the container events that update the routes, where the default ones are
+// used if none are given
+func parseDockerEventActions(actions []string) (map[events.Action]bool, error) {
+	if len(actions) == 0 {
+		actions = DockerDefaultEventActions
+	}
+	result := make(map[events.Action]bool, len(actions))
+	for _, action := range actions {
+		action = strings.TrimSpace(action)
+		if !slices.Contains(dockerSupportedEventActions, events.Action(action)) {
+			return nil, errors.Errorf("unsupported Docker event %q, expected one of %s",
+				action, strings.Join(supportedDockerEventActionNames(), ", "))
+		}
+		result[events.Action(action)] = true
+	}
+	return result, nil
+}
+
+func supportedDockerEventActionNames() []string {
+	names := make([]string, 0, len(dockerSupportedEventActions))
+	for _, action := range dockerSupportedEventActions {
+		names = append(names, string(action))
+	}
+	return names
+}
+
+// dockerEventsWatch is the state of watching Docker events, which are only consumed by its goroutine
+type dockerEventsWatch struct {
+	watcher *dockerWatcherImpl
+	// containers are the last known containers by ID, which are routed together to resolve their conflicts
+	containers map[string]dockertypes.Container
+	// containerMap are the routed containers by host
+	containerMap map[string]*routableContainer
+}
+
+// startWatchingEvents routes the current containers and then updates their routes as container events are received.
+// All containers are listed again every reconcileInterval and, after the events stream fails, once resubscribed
+// following the retryInterval.
+func (w *dockerWatcherImpl) startWatchingEvents(ctx context.Context, eventsClient *client.Client,
+	containerMap map[string]*routableContainer, reconcileInterval time.Duration, retryInterval time.Duration) error {
+
+	watch := &dockerEventsWatch{
+		watcher:      w,
+		containers:   make(map[string]dockertypes.Container),
+		containerMap: containerMap,
+	}
+
+	// subscribing before listing ensures that changes in between are not missed
+	messages, errs := subscribeDockerEvents(ctx, eventsClient, w.eventActions)
+	if err := watch.reconcile(ctx); err != nil {
+		return err
+	}
+
+	go func() {
+		reconcileTicker := time.NewTicker(reconcileInterval)
+		defer reconcileTicker.Stop()
+		// never fires unless resubscribing after the events stream failed
+		var retry <-chan time.Time
+
+		for {
+			select {
+			case message := <-messages:
+				watch.handleEvent(ctx, message)
+
+			case err := <-errs:
+				if ctx.Err() != nil {
+					return
+				}
+				logrus.WithError(err).Error("Docker events stream failed, resubscribing")
+				messages, errs = nil, nil
+				retry = time.After(retryInterval)
+
+			case <-retry:
+				retry = nil
+				messages, errs = subscribeDockerEvents(ctx, eventsClient, w.eventActions)
+				if err := watch.reconcile(ctx); err != nil {
+					logrus.WithError(err).Error("Docker failed to list containers")
+				}
+
+			case <-reconcileTicker.C:
+				if err := watch.reconcile(ctx); err != nil {
+					logrus.WithError(err).Error("Docker failed to list containers")
+				}
+
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+func subscribeDockerEvents(ctx context.Context, eventsClient *client.Client,
+	actions map[events.Action]bool) (<-chan events.Message, <-chan error) {
+
+	args := filters.NewArgs(filters.Arg("type", string(events.ContainerEventType)))
+	for action := range actions {
+		args.Add("event", string(action))
+	}
+	return eventsClient.Events(ctx, events.ListOptions{Filters: args})
+}
+
+// reconcile replaces the known containers with all the current ones
+func (d *dockerEventsWatch) reconcile(ctx context.Context) error {
+	// stopped containers are included so that their status labels are still served
+	containers, err := d.watcher.client.ContainerList(ctx, container.ListOptions{All: true})
+	if err != nil {
+		return err
+	}
+
+	d.containers = make(map[string]dockertypes.Container, len(containers))
+	for _, c := range containers {
+		d.containers[c.ID] = c
+	}
+	d.apply()
+	return nil
+}
+
+// handleEvent updates the routes after an event of the container by listing only that container
+func (d *dockerEventsWatch) handleEvent(ctx context.Context, message events.Message) {
+	id := message.Actor.ID
+	logger := logrus.WithFields(logrus.Fields{"containerId": id, "action": message.Action})
+	if !d.handles(message.Action) {
+		logger.Debug("Ignoring Docker container event")
+		return
+	}
+	logger.Debug("Received Docker container event")
+
+	if message.Action == events.ActionDestroy {
+		delete(d.containers, id)
+		d.apply()
+		return
+	}
+
+	// the listed summary, unlike the result of inspecting the container, is what parseContainerData expects
+	containers, err := d.watcher.client.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("id", id)),
+	})
+	if err != nil {
+		logger.WithError(err).Error("Docker failed to list container of event")
+		return
+	}
+
+	delete(d.containers, id)
+	for _, c := range containers {
+		// the filter also matches IDs with the given prefix
+		if c.ID == id {
+			d.containers[id] = c
+		}
+	}
+	d.apply()
+}
+
+// handles reports whether the event is one of those that update the routes, since the events stream may also
+// deliver others
+func (d *dockerEventsWatch) handles(action events.Action) bool {
+	// health status events are received with the status, such as "health_status: healthy"
+	name, _, _ := strings.Cut(string(action), ":")
+	return d.watcher.eventActions[events.Action(name)]
+}
+
+// apply routes the known containers
+func (d *dockerEventsWatch) apply() {
+	containers := make([]dockertypes.Container, 0, len(d.containers))
+	for _, c := range d.containers {
+		containers = append(containers, c)
+	}
+	// the most recently created first, as listed by Docker
+	sort.Slice(containers, func(i, j int) bool {
+		if containers[i].Created != containers[j].Created {
+			return containers[i].Created > containers[j].Created
+		}
+		return containers[i].ID < containers[j].ID
+	})
+
+	d.watcher.applyContainers(d.containerMap, d.watcher.toRoutableContainers(containers))
+}
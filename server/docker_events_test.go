@@ -0,0 +1,155 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDockerAPI serves the containers and streams the events used by the Docker watcher
+type fakeDockerAPI struct {
+	sync.Mutex
+	containers map[string]dockertypes.Container
+	listed     []string
+	events     chan events.Message
+}
+
+func (f *fakeDockerAPI) setContainer(c dockertypes.Container) {
+	f.Lock()
+	defer f.Unlock()
+	f.containers[c.ID] = c
+}
+
+func (f *fakeDockerAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/containers/json"):
+		args, err := filters.FromJSON(r.URL.Query().Get("filters"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.Lock()
+		var result []dockertypes.Container
+		for id, c := range f.containers {
+			if args.Len() == 0 || args.ExactMatch("id", id) {
+				result = append(result, c)
+			}
+		}
+		f.listed = append(f.listed, strings.Join(args.Get("id"), ","))
+		f.Unlock()
+		_ = json.NewEncoder(w).Encode(result)
+
+	case strings.HasSuffix(r.URL.Path, "/events"):
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case message := <-f.events:
+				_ = json.NewEncoder(w).Encode(message)
+				w.(http.Flusher).Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func runningDockerContainer(id string, host string, ip string) dockertypes.Container {
+	return dockertypes.Container{
+		ID:     id,
+		State:  "running",
+		Labels: map[string]string{DockerRouterLabelHost: host},
+		NetworkSettings: &dockertypes.SummaryNetworkSettings{
+			Networks: map[string]*network.EndpointSettings{
+				"default": {IPAddress: ip},
+			},
+		},
+	}
+}
+
+func TestDockerWatcher_watchEvents(t *testing.T) {
+	Routes.Reset()
+	t.Cleanup(Routes.Reset)
+
+	api := &fakeDockerAPI{
+		containers: map[string]dockertypes.Container{
+			"first": runningDockerContainer("first", "first.example.com", "172.18.0.2"),
+		},
+		events: make(chan events.Message),
+	}
+	apiServer := httptest.NewServer(api)
+	t.Cleanup(apiServer.Close)
+
+	w := &dockerWatcherImpl{}
+	require.NoError(t, w.Start(DockerWatcherConfig{
+		Socket:                   "tcp://" + apiServer.Listener.Addr().String(),
+		RefreshIntervalSeconds:   1,
+		WatchEvents:              true,
+		ReconcileIntervalSeconds: 3600,
+	}))
+	t.Cleanup(w.Stop)
+
+	backend := func(serverAddress string) func() bool {
+		return func() bool {
+			return Routes.GetMappings()[serverAddress] != ""
+		}
+	}
+	assert.True(t, backend("first.example.com")())
+
+	api.setContainer(runningDockerContainer("second", "second.example.com", "172.18.0.3"))
+	api.events <- events.Message{Type: events.ContainerEventType, Action: events.ActionStart, Actor: events.Actor{ID: "second"}}
+	assert.Eventually(t, backend("second.example.com"), 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "172.18.0.3:25565", Routes.GetMappings()["second.example.com"])
+
+	stopped := runningDockerContainer("second", "second.example.com", "")
+	stopped.State = "exited"
+	api.setContainer(stopped)
+	api.events <- events.Message{Type: events.ContainerEventType, Action: events.ActionDie, Actor: events.Actor{ID: "second"}}
+	assert.Eventually(t, func() bool { return !backend("second.example.com")() }, 5*time.Second, 10*time.Millisecond)
+	assert.True(t, backend("first.example.com")(), "other routes are retained")
+
+	api.Lock()
+	defer api.Unlock()
+	// the initial list of all containers followed by only the container of each event
+	assert.Equal(t, []string{"", "second", "second"}, api.listed)
+}
+
+func TestParseDockerEventActions(t *testing.T) {
+	actions, err := parseDockerEventActions(nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[events.Action]bool{
+		events.ActionStart: true, events.ActionStop: true, events.ActionDie: true, events.ActionDestroy: true,
+	}, actions)
+
+	actions, err = parseDockerEventActions([]string{"start", " health_status"})
+	require.NoError(t, err)
+	assert.Equal(t, map[events.Action]bool{events.ActionStart: true, events.ActionHealthStatus: true}, actions)
+
+	_, err = parseDockerEventActions([]string{"start", "exec_start"})
+	assert.ErrorContains(t, err, "exec_start")
+}
+
+func TestDockerEventsWatch_handles(t *testing.T) {
+	actions, err := parseDockerEventActions([]string{"start", "health_status"})
+	require.NoError(t, err)
+	watch := &dockerEventsWatch{watcher: &dockerWatcherImpl{eventActions: actions}}
+
+	assert.True(t, watch.handles(events.ActionStart))
+	assert.True(t, watch.handles(events.ActionHealthStatusHealthy))
+	assert.False(t, watch.handles(events.ActionDie))
+	assert.False(t, watch.handles(events.ActionExecStart))
+}
@@ -16,35 +16,37 @@ import (
 	swarmtypes "github.com/docker/docker/api/types/swarm"
 	"github.com/docker/docker/api/types/versions"
 	"github.com/docker/docker/client"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
 var DockerSwarmWatcher IDockerWatcher = &dockerSwarmWatcherImpl{}
 
+// swarmWakeTimeout and swarmWakePollInterval bound the wait for a woken service to be reachable
+var (
+	swarmWakeTimeout      = 2 * time.Minute
+	swarmWakePollInterval = 500 * time.Millisecond
+)
+
 type dockerSwarmWatcherImpl struct {
 	sync.RWMutex
 	client        *client.Client
 	contextCancel context.CancelFunc
+	// conflictPolicy resolves services that declare the same host
+	conflictPolicy string
 }
 
-func (w *dockerSwarmWatcherImpl) makeWakerFunc(_ *routableService) func(ctx context.Context) error {
-	return func(ctx context.Context) error {
-		return nil
-	}
-}
-
-func (w *dockerSwarmWatcherImpl) Start(socket string, timeoutSeconds int, refreshIntervalSeconds int) error {
+func (w *dockerSwarmWatcherImpl) Start(config DockerWatcherConfig) error {
 	var err error
 
-	timeout := time.Duration(timeoutSeconds) * time.Second
-	refreshInterval := time.Duration(refreshIntervalSeconds) * time.Second
+	timeout := time.Duration(config.TimeoutSeconds) * time.Second
+	refreshInterval := time.Duration(config.RefreshIntervalSeconds) * time.Second
+	w.conflictPolicy = config.RouteConflictPolicy
 
 	opts := []client.Opt{
-		client.WithHost(socket),
+		client.WithHost(config.Socket),
 		client.WithTimeout(timeout),
-		client.WithHTTPHeaders(map[string]string{
-			"User-Agent": "mc-router ",
-		}),
+		client.WithHTTPHeaders(dockerHTTPHeaders(config.HTTPHeaders)),
 		client.WithVersion(DockerAPIVersion),
 	}
 
@@ -67,7 +69,7 @@ func (w *dockerSwarmWatcherImpl) Start(socket string, timeoutSeconds int, refres
 	for _, s := range initialServices {
 		serviceMap[s.externalServiceName] = s
 		if s.externalServiceName != "" {
-			Routes.CreateMapping(s.externalServiceName, s.containerEndpoint, w.makeWakerFunc(s))
+			s.createSwarmMapping()
 		} else {
 			Routes.SetDefaultRoute(s.containerEndpoint)
 		}
@@ -89,15 +91,16 @@ func (w *dockerSwarmWatcherImpl) Start(socket string, timeoutSeconds int, refres
 						serviceMap[rs.externalServiceName] = rs
 						logrus.WithField("routableService", rs).Debug("ADD")
 						if rs.externalServiceName != "" {
-							Routes.CreateMapping(rs.externalServiceName, rs.containerEndpoint, w.makeWakerFunc(rs))
+							rs.createSwarmMapping()
 						} else {
 							Routes.SetDefaultRoute(rs.containerEndpoint)
 						}
-					} else if oldRs.containerEndpoint != rs.containerEndpoint {
+					} else if oldRs.containerEndpoint != rs.containerEndpoint || oldRs.swarmRouteOptions() != rs.swarmRouteOptions() ||
+						(oldRs.autoScaleDown == nil) != (rs.autoScaleDown == nil) {
 						serviceMap[rs.externalServiceName] = rs
 						if rs.externalServiceName != "" {
 							Routes.DeleteMapping(rs.externalServiceName)
-							Routes.CreateMapping(rs.externalServiceName, rs.containerEndpoint, w.makeWakerFunc(rs))
+							rs.createSwarmMapping()
 						} else {
 							Routes.SetDefaultRoute(rs.containerEndpoint)
 						}
@@ -158,7 +161,8 @@ func (w *dockerSwarmWatcherImpl) listServices(ctx context.Context) ([]*routableS
 		networkMap[network.ID] = &networkToAdd
 	}
 
-	var result []*routableService
+	var candidates []*routableService
+	var claims []routeClaim
 	for _, service := range services {
 		if service.Spec.EndpointSpec == nil || service.Spec.EndpointSpec.Mode != swarmtypes.ResolutionModeVIP {
 			continue
@@ -172,23 +176,60 @@ func (w *dockerSwarmWatcherImpl) listServices(ctx context.Context) ([]*routableS
 			continue
 		}
 
+		endpoint := net.JoinHostPort(data.ip, strconv.FormatUint(data.port, 10))
+		var autoScaleUp func(ctx context.Context) error
+		if data.autoScaleUp {
+			autoScaleUp = w.buildScaleUpFunction(service.ID, service.Spec.Name, endpoint)
+		}
+		var autoScaleDown func(ctx context.Context) error
+		if data.autoScaleDown {
+			autoScaleDown = w.buildScaleDownFunction(service.ID, service.Spec.Name)
+		}
+
+		claim := routeClaim{owner: service.Spec.Name, created: service.CreatedAt}
 		for _, host := range data.hosts {
-			result = append(result, &routableService{
-				containerEndpoint:   fmt.Sprintf("%s:%d", data.ip, data.port),
+			candidates = append(candidates, &routableService{
+				containerEndpoint:   endpoint,
 				externalServiceName: host,
+				autoScaleUp:         autoScaleUp,
+				autoScaleDown:       autoScaleDown,
+				autoScaleDownAfter:  data.autoScaleDownAfter,
 			})
+			claim.host = host
+			claims = append(claims, claim)
 		}
 		if data.def != nil && *data.def {
-			result = append(result, &routableService{
-				containerEndpoint:   fmt.Sprintf("%s:%d", data.ip, data.port),
+			candidates = append(candidates, &routableService{
+				containerEndpoint:   endpoint,
 				externalServiceName: "",
 			})
+			claim.host = ""
+			claims = append(claims, claim)
 		}
 	}
 
+	var result []*routableService
+	for i, routed := range resolveRouteConflicts(w.conflictPolicy, RouteSourceDockerSwarm, claims) {
+		if routed {
+			result = append(result, candidates[i])
+		}
+	}
 	return result, nil
 }
 
+// swarmRouteOptions are the options of the routes of the Docker Swarm service
+func (rs *routableService) swarmRouteOptions() RouteOptions {
+	return RouteOptions{Source: RouteSourceDockerSwarm, Scalable: rs.autoScaleUp != nil, SleepAfter: rs.autoScaleDownAfter}
+}
+
+// createSwarmMapping registers the route of the Docker Swarm service along with its sleeper, if any
+func (rs *routableService) createSwarmMapping() {
+	Routes.CreateMapping(rs.externalServiceName, rs.containerEndpoint, rs.autoScaleUp, rs.swarmRouteOptions())
+	if rs.autoScaleDown != nil {
+		Routes.SetSleeper(rs.externalServiceName, rs.autoScaleDown)
+	}
+}
+
 func dockerCheckNetworkName(id string, name string, networkMap map[string]*network.Inspect, networkAliases map[string][]string) (bool, error) {
 	// we allow to specify the id instead
 	if id == name {
@@ -216,6 +257,12 @@ type parsedDockerServiceData struct {
 	def     *bool
 	network *string
 	ip      string
+	// autoScaleUp is set when the service is scaled up from zero replicas by its routes' wakers
+	autoScaleUp bool
+	// autoScaleDown is set when the service is scaled down to zero replicas once its routes are idle for
+	// autoScaleDownAfter, or the default when zero
+	autoScaleDown      bool
+	autoScaleDownAfter time.Duration
 }
 
 func (w *dockerSwarmWatcherImpl) parseServiceData(service *swarm.Service, networkMap map[string]*network.Inspect) (data parsedDockerServiceData, ok bool) {
@@ -268,6 +315,36 @@ func (w *dockerSwarmWatcherImpl) parseServiceData(service *swarm.Service, networ
 			data.network = new(string)
 			*data.network = value
 		}
+		if key == DockerRouterLabelAutoScaleUp {
+			autoScaleUp, err := strconv.ParseBool(value)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{"serviceId": service.ID, "serviceName": service.Spec.Name}).
+					WithError(err).
+					Warnf("ignoring invalid %s", DockerRouterLabelAutoScaleUp)
+			} else {
+				data.autoScaleUp = autoScaleUp
+			}
+		}
+		if key == DockerRouterLabelAutoScaleDown {
+			autoScaleDown, err := strconv.ParseBool(value)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{"serviceId": service.ID, "serviceName": service.Spec.Name}).
+					WithError(err).
+					Warnf("ignoring invalid %s", DockerRouterLabelAutoScaleDown)
+			} else {
+				data.autoScaleDown = autoScaleDown
+			}
+		}
+		if key == DockerRouterLabelAutoScaleDownAfter {
+			autoScaleDownAfter, err := time.ParseDuration(value)
+			if err != nil || autoScaleDownAfter < 0 {
+				logrus.WithFields(logrus.Fields{"serviceId": service.ID, "serviceName": service.Spec.Name}).
+					WithError(err).
+					Warnf("ignoring invalid %s", DockerRouterLabelAutoScaleDownAfter)
+			} else {
+				data.autoScaleDownAfter = autoScaleDownAfter
+			}
+		}
 	}
 
 	// probably not minecraft related
@@ -314,6 +391,112 @@ func (w *dockerSwarmWatcherImpl) parseServiceData(service *swarm.Service, networ
 	return
 }
 
+// buildScaleUpFunction returns the waker of the service, which scales it up from zero replicas and then waits until
+// its endpoint is reachable
+func (w *dockerSwarmWatcherImpl) buildScaleUpFunction(serviceID string, serviceName string, endpoint string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		if err := w.scaleUpService(ctx, serviceID, serviceName); err != nil {
+			return err
+		}
+		return waitUntilReachable(ctx, endpoint, swarmWakeTimeout, swarmWakePollInterval)
+	}
+}
+
+// scaleUpService scales the replicated service to one replica when it has none
+func (w *dockerSwarmWatcherImpl) scaleUpService(ctx context.Context, serviceID string, serviceName string) error {
+	service, _, err := w.client.ServiceInspectWithRaw(ctx, serviceID, dockertypes.ServiceInspectOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to inspect service %s", serviceName)
+	}
+	replicated := service.Spec.Mode.Replicated
+	if replicated == nil {
+		return errors.Errorf("service %s can't be scaled since it is not replicated", serviceName)
+	}
+	// unset replicas default to one
+	if replicated.Replicas == nil || *replicated.Replicas > 0 {
+		return nil
+	}
+
+	wake := uint64(1)
+	spec := service.Spec
+	spec.Mode.Replicated = &swarm.ReplicatedService{Replicas: &wake}
+	response, err := w.client.ServiceUpdate(ctx, service.ID, service.Version, spec, dockertypes.ServiceUpdateOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to scale up service %s", serviceName)
+	}
+	for _, warning := range response.Warnings {
+		logrus.WithField("service", serviceName).Warn(warning)
+	}
+
+	logrus.WithField("service", serviceName).Infof("Service Replicas Autoscaled from 0 to %d (wake up)", wake)
+	return nil
+}
+
+// buildScaleDownFunction returns the sleeper of the service, which scales it down to zero replicas
+func (w *dockerSwarmWatcherImpl) buildScaleDownFunction(serviceID string, serviceName string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		return w.scaleDownService(ctx, serviceID, serviceName)
+	}
+}
+
+// scaleDownService scales the replicated service to zero replicas unless it has none
+func (w *dockerSwarmWatcherImpl) scaleDownService(ctx context.Context, serviceID string, serviceName string) error {
+	service, _, err := w.client.ServiceInspectWithRaw(ctx, serviceID, dockertypes.ServiceInspectOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to inspect service %s", serviceName)
+	}
+	replicated := service.Spec.Mode.Replicated
+	if replicated == nil {
+		return errors.Errorf("service %s can't be scaled since it is not replicated", serviceName)
+	}
+	// unset replicas default to one
+	previous := uint64(1)
+	if replicated.Replicas != nil {
+		previous = *replicated.Replicas
+	}
+	if previous == 0 {
+		return nil
+	}
+
+	sleep := uint64(0)
+	spec := service.Spec
+	spec.Mode.Replicated = &swarm.ReplicatedService{Replicas: &sleep}
+	response, err := w.client.ServiceUpdate(ctx, service.ID, service.Version, spec, dockertypes.ServiceUpdateOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to scale down service %s", serviceName)
+	}
+	for _, warning := range response.Warnings {
+		logrus.WithField("service", serviceName).Warn(warning)
+	}
+
+	logrus.WithField("service", serviceName).Infof("Service Replicas Autoscaled from %d to %d (sleep)", previous, sleep)
+	return nil
+}
+
+// waitUntilReachable polls the endpoint until it accepts a connection, the timeout passes, or the ctx is done
+func waitUntilReachable(ctx context.Context, endpoint string, timeout time.Duration, pollInterval time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	dialer := &net.Dialer{Timeout: pollInterval}
+	for {
+		conn, err := dialer.DialContext(ctx, "tcp", endpoint)
+		if err == nil {
+			_ = conn.Close()
+			return nil
+		}
+		logrus.WithError(err).WithField("endpoint", endpoint).Debug("Waiting for woken backend to be reachable")
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return errors.Wrapf(err, "backend %s was not reachable after waking", endpoint)
+		}
+	}
+}
+
 func (w *dockerSwarmWatcherImpl) Stop() {
 	if w.contextCancel != nil {
 		w.contextCancel()
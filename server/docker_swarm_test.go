@@ -0,0 +1,247 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDockerSwarmWatcher_parseServiceData_autoScaleUp(t *testing.T) {
+	for _, tt := range []struct {
+		label    string
+		expected bool
+	}{
+		{label: "true", expected: true},
+		{label: "false", expected: false},
+		{label: "invalid", expected: false},
+	} {
+		t.Run(tt.label, func(t *testing.T) {
+			service := &swarm.Service{
+				ID: "abc",
+				Spec: swarm.ServiceSpec{
+					Annotations: swarm.Annotations{
+						Name: "mc",
+						Labels: map[string]string{
+							DockerRouterLabelHost:        "mc.example.com",
+							DockerRouterLabelAutoScaleUp: tt.label,
+						},
+					},
+				},
+				Endpoint: swarm.Endpoint{
+					VirtualIPs: []swarm.EndpointVirtualIP{{NetworkID: "overlay", Addr: "10.0.1.5/24"}},
+				},
+			}
+
+			w := &dockerSwarmWatcherImpl{}
+			data, ok := w.parseServiceData(service, map[string]*network.Inspect{})
+			assert.True(t, ok)
+			assert.Equal(t, "10.0.1.5", data.ip)
+			assert.Equal(t, tt.expected, data.autoScaleUp)
+		})
+	}
+}
+
+func TestDockerSwarmWatcher_parseServiceData_autoScaleDown(t *testing.T) {
+	service := &swarm.Service{
+		ID: "abc",
+		Spec: swarm.ServiceSpec{
+			Annotations: swarm.Annotations{
+				Name: "mc",
+				Labels: map[string]string{
+					DockerRouterLabelHost:               "mc.example.com",
+					DockerRouterLabelAutoScaleDown:      "true",
+					DockerRouterLabelAutoScaleDownAfter: "30m",
+				},
+			},
+		},
+		Endpoint: swarm.Endpoint{
+			VirtualIPs: []swarm.EndpointVirtualIP{{NetworkID: "overlay", Addr: "10.0.1.5/24"}},
+		},
+	}
+
+	w := &dockerSwarmWatcherImpl{}
+	data, ok := w.parseServiceData(service, map[string]*network.Inspect{})
+	require.True(t, ok)
+	assert.True(t, data.autoScaleDown)
+	assert.Equal(t, 30*time.Minute, data.autoScaleDownAfter)
+
+	service.Spec.Labels[DockerRouterLabelAutoScaleDownAfter] = "invalid"
+	data, ok = w.parseServiceData(service, map[string]*network.Inspect{})
+	require.True(t, ok)
+	assert.Zero(t, data.autoScaleDownAfter, "the default is used")
+}
+
+// fakeSwarmAPI serves the inspection and updates of a single service
+type fakeSwarmAPI struct {
+	sync.Mutex
+	service swarm.Service
+	updates []swarm.ServiceSpec
+}
+
+func (f *fakeSwarmAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	switch {
+	case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/services/"+f.service.ID):
+		_ = json.NewEncoder(w).Encode(f.service)
+
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/services/"+f.service.ID+"/update"):
+		if r.URL.Query().Get("version") != f.service.Version.String() {
+			http.Error(w, "version out of sequence", http.StatusConflict)
+			return
+		}
+		var spec swarm.ServiceSpec
+		if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.updates = append(f.updates, spec)
+		f.service.Spec = spec
+		f.service.Version.Index++
+		_ = json.NewEncoder(w).Encode(swarm.ServiceUpdateResponse{})
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestDockerSwarmWatcher_scaleUp(t *testing.T) {
+	replicas := func(n uint64) *uint64 { return &n }
+
+	tests := []struct {
+		name            string
+		replicas        *uint64
+		expectedUpdates int
+	}{
+		{name: "asleep", replicas: replicas(0), expectedUpdates: 1},
+		{name: "awake", replicas: replicas(2), expectedUpdates: 0},
+		{name: "unset replicas", replicas: nil, expectedUpdates: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &fakeSwarmAPI{service: swarm.Service{
+				ID:   "abc",
+				Meta: swarm.Meta{Version: swarm.Version{Index: 7}},
+				Spec: swarm.ServiceSpec{
+					Annotations: swarm.Annotations{Name: "mc"},
+					Mode:        swarm.ServiceMode{Replicated: &swarm.ReplicatedService{Replicas: tt.replicas}},
+				},
+			}}
+			apiServer := httptest.NewServer(api)
+			t.Cleanup(apiServer.Close)
+
+			// the woken backend
+			backendListener, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			//goland:noinspection GoUnhandledErrorResult
+			defer backendListener.Close()
+
+			w := &dockerSwarmWatcherImpl{}
+			w.client, err = client.NewClientWithOpts(
+				client.WithHost("tcp://"+apiServer.Listener.Addr().String()),
+				client.WithVersion(DockerAPIVersion),
+			)
+			require.NoError(t, err)
+
+			waker := w.buildScaleUpFunction("abc", "mc", backendListener.Addr().String())
+			require.NoError(t, waker(context.Background()))
+
+			api.Lock()
+			defer api.Unlock()
+			if assert.Len(t, api.updates, tt.expectedUpdates) && tt.expectedUpdates > 0 {
+				assert.Equal(t, uint64(1), *api.updates[0].Mode.Replicated.Replicas)
+				assert.Equal(t, "mc", api.updates[0].Name, "the rest of the spec is retained")
+			}
+		})
+	}
+}
+
+func TestDockerSwarmWatcher_scaleDown(t *testing.T) {
+	replicas := func(n uint64) *uint64 { return &n }
+
+	tests := []struct {
+		name            string
+		replicas        *uint64
+		expectedUpdates int
+	}{
+		{name: "awake", replicas: replicas(2), expectedUpdates: 1},
+		{name: "unset replicas", replicas: nil, expectedUpdates: 1},
+		{name: "asleep", replicas: replicas(0), expectedUpdates: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &fakeSwarmAPI{service: swarm.Service{
+				ID:   "abc",
+				Meta: swarm.Meta{Version: swarm.Version{Index: 7}},
+				Spec: swarm.ServiceSpec{
+					Annotations: swarm.Annotations{Name: "mc"},
+					Mode:        swarm.ServiceMode{Replicated: &swarm.ReplicatedService{Replicas: tt.replicas}},
+				},
+			}}
+			apiServer := httptest.NewServer(api)
+			t.Cleanup(apiServer.Close)
+
+			w := &dockerSwarmWatcherImpl{}
+			var err error
+			w.client, err = client.NewClientWithOpts(
+				client.WithHost("tcp://"+apiServer.Listener.Addr().String()),
+				client.WithVersion(DockerAPIVersion),
+			)
+			require.NoError(t, err)
+
+			sleeper := w.buildScaleDownFunction("abc", "mc")
+			require.NoError(t, sleeper(context.Background()))
+
+			api.Lock()
+			defer api.Unlock()
+			if assert.Len(t, api.updates, tt.expectedUpdates) && tt.expectedUpdates > 0 {
+				assert.Equal(t, uint64(0), *api.updates[0].Mode.Replicated.Replicas)
+				assert.Equal(t, "mc", api.updates[0].Name, "the rest of the spec is retained")
+			}
+		})
+	}
+}
+
+func TestWaitUntilReachable(t *testing.T) {
+	// reserves an address that is not listening until later
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	endpoint := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	t.Run("not reachable", func(t *testing.T) {
+		err := waitUntilReachable(context.Background(), endpoint, 50*time.Millisecond, 10*time.Millisecond)
+		assert.Error(t, err)
+	})
+
+	t.Run("becomes reachable", func(t *testing.T) {
+		listening := make(chan net.Listener, 1)
+		go func() {
+			time.Sleep(30 * time.Millisecond)
+			listener, err := net.Listen("tcp", endpoint)
+			if err != nil {
+				close(listening)
+				return
+			}
+			listening <- listener
+		}()
+		assert.NoError(t, waitUntilReachable(context.Background(), endpoint, 5*time.Second, 10*time.Millisecond))
+		if listener, ok := <-listening; ok {
+			_ = listener.Close()
+		}
+	})
+}
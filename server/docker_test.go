@@ -0,0 +1,160 @@
+package server
+
+import (
+	"encoding/base64"
+	"testing"
+
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDockerWatcher_parseContainerData_statusLabels(t *testing.T) {
+	png := append(append([]byte{}, pngSignature...), 0x00, 0x01)
+	encodedPng := base64.StdEncoding.EncodeToString(png)
+
+	container := &dockertypes.Container{
+		ID:    "abc",
+		State: "running",
+		Labels: map[string]string{
+			DockerRouterLabelHost:    "mc.example.com",
+			DockerRouterLabelMOTD:    "Sleeping...",
+			DockerRouterLabelFavicon: encodedPng,
+		},
+		NetworkSettings: &dockertypes.SummaryNetworkSettings{
+			Networks: map[string]*network.EndpointSettings{
+				"default": {IPAddress: "172.18.0.2"},
+			},
+		},
+	}
+
+	w := &dockerWatcherImpl{}
+	data, ok := w.parseContainerData(container)
+	assert.True(t, ok)
+	assert.Equal(t, "Sleeping...", data.routeOptions.MOTD)
+	assert.Equal(t, faviconDataUrlPrefix+encodedPng, data.routeOptions.Favicon)
+}
+
+func TestDockerWatcher_toRoutableContainers_stopped(t *testing.T) {
+	networks := &dockertypes.SummaryNetworkSettings{
+		Networks: map[string]*network.EndpointSettings{
+			"default": {IPAddress: "172.18.0.2"},
+		},
+	}
+	containers := []dockertypes.Container{
+		{
+			ID:     "sleeping",
+			State:  "exited",
+			Labels: map[string]string{DockerRouterLabelHost: "sleeping.example.com", DockerRouterLabelMOTD: "Sleeping..."},
+		},
+		{
+			// without a MOTD there is nothing to serve for a stopped container
+			ID:     "no-motd",
+			State:  "exited",
+			Labels: map[string]string{DockerRouterLabelHost: "stopped.example.com"},
+		},
+		{
+			ID:     "old",
+			State:  "exited",
+			Labels: map[string]string{DockerRouterLabelHost: "recreated.example.com", DockerRouterLabelMOTD: "Old"},
+		},
+		{
+			ID:              "new",
+			State:           "running",
+			Labels:          map[string]string{DockerRouterLabelHost: "recreated.example.com", DockerRouterLabelMOTD: "New"},
+			NetworkSettings: networks,
+		},
+	}
+
+	w := &dockerWatcherImpl{}
+	routable := w.toRoutableContainers(containers)
+
+	assert.ElementsMatch(t, []*routableContainer{
+		{
+			externalContainerName: "recreated.example.com",
+			containerEndpoint:     "172.18.0.2:25565",
+			routeOptions:          RouteOptions{MOTD: "New"},
+		},
+		{
+			externalContainerName: "sleeping.example.com",
+			routeOptions:          RouteOptions{MOTD: "Sleeping..."},
+		},
+	}, routable)
+}
+
+func TestDockerWatcher_toRoutableContainers_routeStopped(t *testing.T) {
+	containers := []dockertypes.Container{
+		{
+			ID:     "no-motd",
+			State:  "exited",
+			Labels: map[string]string{DockerRouterLabelHost: "stopped.example.com"},
+		},
+	}
+
+	w := &dockerWatcherImpl{routeStopped: true}
+	routable := w.toRoutableContainers(containers)
+
+	assert.Equal(t, []*routableContainer{
+		{externalContainerName: "stopped.example.com"},
+	}, routable)
+}
+
+func TestDockerWatcher_toRoutableContainers_conflicts(t *testing.T) {
+	containerOn := func(name string, created int64, ip string) dockertypes.Container {
+		return dockertypes.Container{
+			ID:      name,
+			Names:   []string{"/" + name},
+			Created: created,
+			State:   "running",
+			Labels:  map[string]string{DockerRouterLabelHost: "shared.example.com,own-" + name + ".example.com"},
+			NetworkSettings: &dockertypes.SummaryNetworkSettings{
+				Networks: map[string]*network.EndpointSettings{"default": {IPAddress: ip}},
+			},
+		}
+	}
+	// listed newest first, as by the Docker API
+	containers := []dockertypes.Container{
+		containerOn("newer", 200, "172.18.0.3"),
+		containerOn("older", 100, "172.18.0.2"),
+		{
+			ID:     "stopped",
+			State:  "exited",
+			Labels: map[string]string{DockerRouterLabelHost: "shared.example.com", DockerRouterLabelMOTD: "Stopped"},
+		},
+	}
+	ownRoutes := []*routableContainer{
+		{externalContainerName: "own-newer.example.com", containerEndpoint: "172.18.0.3:25565"},
+		{externalContainerName: "own-older.example.com", containerEndpoint: "172.18.0.2:25565"},
+	}
+
+	tests := []struct {
+		policy string
+		shared []*routableContainer
+	}{
+		{policy: "", shared: []*routableContainer{{externalContainerName: "shared.example.com", containerEndpoint: "172.18.0.3:25565"}}},
+		{policy: RouteConflictLastWins, shared: []*routableContainer{{externalContainerName: "shared.example.com", containerEndpoint: "172.18.0.3:25565"}}},
+		{policy: RouteConflictFirstWins, shared: []*routableContainer{{externalContainerName: "shared.example.com", containerEndpoint: "172.18.0.2:25565"}}},
+		// nor is the stopped container's MOTD served in place of the conflicting ones
+		{policy: RouteConflictError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			w := &dockerWatcherImpl{conflictPolicy: tt.policy}
+			routable := w.toRoutableContainers(containers)
+
+			assert.ElementsMatch(t, append(tt.shared, ownRoutes...), routable)
+		})
+	}
+}
+
+func TestDockerHTTPHeaders(t *testing.T) {
+	assert.Equal(t, map[string]string{"User-Agent": DockerDefaultUserAgent}, dockerHTTPHeaders(nil))
+
+	assert.Equal(t, map[string]string{
+		"User-Agent":    "mc-router/1.2.3",
+		"X-Proxy-Token": "secret",
+	}, dockerHTTPHeaders(map[string]string{
+		"user-agent":    "mc-router/1.2.3",
+		"x-proxy-token": "secret",
+	}))
+}
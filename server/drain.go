@@ -0,0 +1,88 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DrainingHeader is set to true in the /readyz responses while draining
+const DrainingHeader = "X-Mc-Router-Draining"
+
+// readyzPath is not guarded by the API token, since load balancers probing it usually can't send one
+const readyzPath = "/readyz"
+
+// DrainStatus describes whether new connections are being drained away and the connections still relayed
+type DrainStatus struct {
+	Draining          bool `json:"draining"`
+	ActiveConnections int  `json:"activeConnections"`
+}
+
+func (c *Connector) registerDrainApiRoutes() {
+	c.metrics.Draining.Set(0)
+	apiRoutes.Path("/drain").Methods("GET").HandlerFunc(c.drainStatusHandler)
+	apiRoutes.Path("/drain").Methods("POST").HandlerFunc(c.drainHandler(true))
+	apiRoutes.Path("/drain").Methods("DELETE").HandlerFunc(c.drainHandler(false))
+	apiRoutes.Path(readyzPath).Methods("GET").HandlerFunc(c.readyzHandler)
+}
+
+// SetDraining soft drains, where new connections are still accepted while /readyz reports not ready, so that a load
+// balancer stops sending new connections while those in progress complete, such as during a rolling update
+func (c *Connector) SetDraining(draining bool) {
+	if c.draining.Swap(draining) == draining {
+		return
+	}
+	if draining {
+		c.metrics.Draining.Set(1)
+		logrus.WithField("activeConnections", atomic.LoadInt32(&c.activeConnections)).Info("Draining enabled")
+	} else {
+		c.metrics.Draining.Set(0)
+		logrus.Info("Draining disabled")
+	}
+}
+
+// DrainStatus describes the drain state
+func (c *Connector) DrainStatus() DrainStatus {
+	return DrainStatus{
+		Draining:          c.draining.Load(),
+		ActiveConnections: int(atomic.LoadInt32(&c.activeConnections)),
+	}
+}
+
+func (c *Connector) drainStatusHandler(writer http.ResponseWriter, _ *http.Request) {
+	c.writeDrainStatus(writer)
+}
+
+func (c *Connector) drainHandler(draining bool) http.HandlerFunc {
+	return func(writer http.ResponseWriter, _ *http.Request) {
+		c.SetDraining(draining)
+		c.writeDrainStatus(writer)
+	}
+}
+
+func (c *Connector) writeDrainStatus(writer http.ResponseWriter) {
+	bytes, err := json.Marshal(c.DrainStatus())
+	if err != nil {
+		logrus.WithError(err).Error("Failed to marshal drain status")
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	_, err = writer.Write(bytes)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to write response")
+	}
+}
+
+func (c *Connector) readyzHandler(writer http.ResponseWriter, _ *http.Request) {
+	writer.Header().Set("Content-Type", "text/plain")
+	if c.draining.Load() {
+		writer.Header().Set(DrainingHeader, "true")
+		writer.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = writer.Write([]byte("draining\n"))
+		return
+	}
+	_, _ = writer.Write([]byte("ready\n"))
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnector_drainApi(t *testing.T) {
+	draining := &observedGauge{observed: make(chan float64, 10)}
+	c := newTestConnector(t)
+	c.metrics.Draining = draining
+	conn, _ := net.Pipe()
+	c.connections.register(conn, &ActiveConnection{ServerAddress: "mc.example.com", Backend: "backend:25565"})
+
+	router := mux.NewRouter()
+	router.Path("/drain").Methods("GET").HandlerFunc(c.drainStatusHandler)
+	router.Path("/drain").Methods("POST").HandlerFunc(c.drainHandler(true))
+	router.Path("/drain").Methods("DELETE").HandlerFunc(c.drainHandler(false))
+	router.Path(readyzPath).Methods("GET").HandlerFunc(c.readyzHandler)
+
+	request := func(method string, path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(method, path, nil))
+		return recorder
+	}
+	drainStatus := func(recorder *httptest.ResponseRecorder) DrainStatus {
+		require.Equal(t, http.StatusOK, recorder.Code)
+		var status DrainStatus
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &status))
+		return status
+	}
+
+	readyz := request(http.MethodGet, readyzPath)
+	assert.Equal(t, http.StatusOK, readyz.Code)
+	assert.Empty(t, readyz.Header().Get(DrainingHeader))
+	assert.Equal(t, DrainStatus{Draining: false, ActiveConnections: 1}, drainStatus(request(http.MethodGet, "/drain")))
+
+	assert.Equal(t, DrainStatus{Draining: true, ActiveConnections: 1}, drainStatus(request(http.MethodPost, "/drain")))
+	assert.Equal(t, float64(1), <-draining.observed)
+	readyz = request(http.MethodGet, readyzPath)
+	assert.Equal(t, http.StatusServiceUnavailable, readyz.Code)
+	assert.Equal(t, "true", readyz.Header().Get(DrainingHeader))
+
+	assert.Equal(t, DrainStatus{Draining: false, ActiveConnections: 1}, drainStatus(request(http.MethodDelete, "/drain")))
+	assert.Equal(t, float64(0), <-draining.observed)
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, readyzPath).Code)
+}
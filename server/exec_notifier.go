@@ -0,0 +1,112 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// ExecNotifier is a ConnectionNotifier that runs an external command for each connection event.
+// The command is executed directly, without a shell, so event values can never be interpreted as
+// shell syntax. Event details are passed as MC_ROUTER_* environment variables and as JSON on stdin.
+type ExecNotifier struct {
+	command string
+	args    []*template.Template
+	timeout time.Duration
+}
+
+// NewExecNotifier creates an ExecNotifier where each of the given args may contain
+// Go template references to ConnectionEvent fields, such as {{.Event}} or {{.Server}}.
+func NewExecNotifier(command string, args []string, timeout time.Duration) (*ExecNotifier, error) {
+	if command == "" {
+		return nil, errors.New("command is required")
+	}
+
+	argTemplates := make([]*template.Template, 0, len(args))
+	for i, arg := range args {
+		tmpl, err := template.New("arg").Option("missingkey=error").Parse(arg)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid template in argument %d", i)
+		}
+		argTemplates = append(argTemplates, tmpl)
+	}
+
+	return &ExecNotifier{
+		command: command,
+		args:    argTemplates,
+		timeout: timeout,
+	}, nil
+}
+
+func (n *ExecNotifier) Notify(ctx context.Context, event *ConnectionEvent) error {
+	if n.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, n.timeout)
+		defer cancel()
+	}
+
+	cmd, err := n.buildCommand(ctx, event)
+	if err != nil {
+		return err
+	}
+
+	output, err := cmd.CombinedOutput()
+	trimmedOutput := strings.TrimSpace(string(output))
+	if err != nil {
+		return errors.Wrapf(err, "notifier command failed with output: %s", trimmedOutput)
+	}
+	logrus.
+		WithField("command", n.command).
+		WithField("event", event.Event).
+		WithField("output", trimmedOutput).
+		Debug("Notifier command completed")
+
+	return nil
+}
+
+func (n *ExecNotifier) buildCommand(ctx context.Context, event *ConnectionEvent) (*exec.Cmd, error) {
+	args := make([]string, 0, len(n.args))
+	for _, tmpl := range n.args {
+		var buf strings.Builder
+		if err := tmpl.Execute(&buf, event); err != nil {
+			return nil, errors.Wrap(err, "failed to render notifier command argument")
+		}
+		args = append(args, buf.String())
+	}
+
+	eventJson, err := json.Marshal(event)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal event")
+	}
+
+	var playerName, playerUUID string
+	if event.Player != nil {
+		playerName = event.Player.Name
+		playerUUID = event.Player.UUID.String()
+	}
+
+	cmd := exec.CommandContext(ctx, n.command, args...)
+	cmd.Stdin = bytes.NewReader(eventJson)
+	cmd.Env = append(os.Environ(),
+		"MC_ROUTER_EVENT="+event.Event,
+		"MC_ROUTER_TIMESTAMP="+event.Timestamp.Format(time.RFC3339),
+		"MC_ROUTER_CLIENT="+event.Client,
+		"MC_ROUTER_SERVER="+event.Server,
+		"MC_ROUTER_ROUTE="+event.Route,
+		"MC_ROUTER_PLAYER_NAME="+playerName,
+		"MC_ROUTER_PLAYER_UUID="+playerUUID,
+		"MC_ROUTER_BACKEND="+event.BackendHostPort,
+		"MC_ROUTER_ERROR="+event.Error,
+		"MC_ROUTER_SOURCE="+event.Source,
+	)
+
+	return cmd, nil
+}
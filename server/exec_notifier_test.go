@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecNotifier_buildCommand(t *testing.T) {
+	notifier, err := NewExecNotifier("notify-send", []string{"{{.Event}}", "player from {{.Client}}; rm -rf /"}, 0)
+	require.NoError(t, err)
+
+	event := newConnectionEvent(ConnectionEventConnect,
+		&net.TCPAddr{IP: net.ParseIP("192.168.1.5"), Port: 51000},
+		"MC.Example.com.", "mc.example.com", nil, "backend:25565", nil)
+
+	cmd, err := notifier.buildCommand(context.Background(), event)
+	require.NoError(t, err)
+
+	// each argument is passed as-is without shell interpretation
+	assert.Equal(t, []string{"notify-send", "connect", "player from 192.168.1.5:51000; rm -rf /"}, cmd.Args)
+	assert.Contains(t, cmd.Env, "MC_ROUTER_EVENT=connect")
+	assert.Contains(t, cmd.Env, "MC_ROUTER_SERVER=MC.Example.com.")
+	assert.Contains(t, cmd.Env, "MC_ROUTER_ROUTE=mc.example.com")
+	assert.Contains(t, cmd.Env, "MC_ROUTER_BACKEND=backend:25565")
+
+	stdin, err := io.ReadAll(cmd.Stdin)
+	require.NoError(t, err)
+	var decoded ConnectionEvent
+	require.NoError(t, json.Unmarshal(stdin, &decoded))
+	assert.Equal(t, "connect", decoded.Event)
+	assert.Equal(t, "192.168.1.5:51000", decoded.Client)
+	assert.Equal(t, "mc.example.com", decoded.Route)
+}
+
+func TestExecNotifier_InvalidTemplate(t *testing.T) {
+	_, err := NewExecNotifier("echo", []string{"{{.Event"}, 0)
+	assert.Error(t, err)
+}
+
+func TestExecNotifier_Notify(t *testing.T) {
+	notifier, err := NewExecNotifier("sh", []string{"-c", `test "$MC_ROUTER_EVENT" = "{{.Event}}"`}, 0)
+	require.NoError(t, err)
+
+	event := newConnectionEvent(ConnectionEventDisconnect,
+		&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 51000},
+		"mc.example.com", "mc.example.com", nil, "backend:25565", nil)
+	assert.NoError(t, notifier.Notify(context.Background(), event))
+
+	failing, err := NewExecNotifier("sh", []string{"-c", "echo oops; exit 3"}, 0)
+	require.NoError(t, err)
+	err = failing.Notify(context.Background(), event)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "oops")
+	}
+}
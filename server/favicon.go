@@ -0,0 +1,98 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const faviconDataUrlPrefix = "data:image/png;base64,"
+
+var pngSignature = []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1A, '\n'}
+
+// loadFavicon resolves the given value into the data URL form used by status responses.
+// The value can already be a data URL, base64 encoded PNG content, or the path to a PNG file.
+func loadFavicon(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", nil
+	}
+
+	if strings.HasPrefix(value, "data:") {
+		return value, nil
+	}
+
+	if decoded, err := base64.StdEncoding.DecodeString(value); err == nil && bytes.HasPrefix(decoded, pngSignature) {
+		return faviconDataUrlPrefix + value, nil
+	}
+
+	content, err := os.ReadFile(value)
+	if err != nil {
+		return "", errors.Wrap(err, "favicon is neither base64 encoded PNG nor a readable file")
+	}
+	if !bytes.HasPrefix(content, pngSignature) {
+		return "", errors.Errorf("favicon file %s is not a PNG image", value)
+	}
+
+	return faviconDataUrlPrefix + base64.StdEncoding.EncodeToString(content), nil
+}
+
+// faviconCache remembers the outcome of loading each favicon value, such as from labels that are
+// re-evaluated on every refresh, so that files are read and problems are reported only once
+type faviconCache struct {
+	sync.Mutex
+	entries map[string]string
+}
+
+// get returns the favicon loaded from the value, or an empty string when it could not be loaded, in which
+// case the problem is logged with the given logger the first time
+func (c *faviconCache) get(value string, logger *logrus.Entry) string {
+	c.Lock()
+	defer c.Unlock()
+
+	if favicon, exists := c.entries[value]; exists {
+		return favicon
+	}
+	if c.entries == nil {
+		c.entries = make(map[string]string)
+	}
+
+	favicon, err := loadFavicon(value)
+	if err != nil {
+		logger.WithError(err).Warn("ignoring invalid favicon")
+	}
+	c.entries[value] = favicon
+	return favicon
+}
+
+// UseStatusFavicons presents the statuses served in place of a route's backend, such as its MOTD while asleep, with a
+// favicon when the route has none of its own. With fromBackends, it is the favicon last fetched from the route's
+// backend by the status cache. Otherwise, or when none was fetched, it is the defaultFavicon, if given, which can be a
+// data URL, base64 encoded PNG content, or the path to a PNG file.
+func (c *Connector) UseStatusFavicons(fromBackends bool, defaultFavicon string) error {
+	favicon, err := loadFavicon(defaultFavicon)
+	if err != nil {
+		return errors.Wrap(err, "invalid default status favicon")
+	}
+	c.faviconsFromBackends = fromBackends
+	c.defaultStatusFavicon = favicon
+	return nil
+}
+
+// routeFavicon returns the favicon of a status served in place of the backend of the route of the resolvedHost
+func (c *Connector) routeFavicon(resolvedHost string, options RouteOptions) string {
+	if options.Favicon != "" {
+		return options.Favicon
+	}
+	if c.faviconsFromBackends && c.statusCache != nil {
+		if favicon, ok := c.statusCache.GetFavicon(resolvedHost); ok {
+			return favicon
+		}
+	}
+	return c.defaultStatusFavicon
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/itzg/mc-router/mcproto"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadFavicon(t *testing.T) {
+	png := append(append([]byte{}, pngSignature...), 0x00, 0x01)
+	encodedPng := base64.StdEncoding.EncodeToString(png)
+
+	pngFile := filepath.Join(t.TempDir(), "icon.png")
+	require.NoError(t, os.WriteFile(pngFile, png, 0644))
+	textFile := filepath.Join(t.TempDir(), "icon.txt")
+	require.NoError(t, os.WriteFile(textFile, []byte("not a png"), 0644))
+
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{name: "empty", value: "", want: ""},
+		{name: "data url", value: faviconDataUrlPrefix + "abcd", want: faviconDataUrlPrefix + "abcd"},
+		{name: "base64", value: encodedPng, want: faviconDataUrlPrefix + encodedPng},
+		{name: "file", value: pngFile, want: faviconDataUrlPrefix + encodedPng},
+		{name: "not png file", value: textFile, wantErr: true},
+		{name: "missing file", value: filepath.Join(t.TempDir(), "missing.png"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadFavicon(tt.value)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestFaviconCache(t *testing.T) {
+	faviconFile := filepath.Join(t.TempDir(), "favicon.png")
+	png := append(append([]byte{}, pngSignature...), 0x00, 0x01)
+	require.NoError(t, os.WriteFile(faviconFile, png, 0644))
+
+	var cache faviconCache
+	logger := logrus.NewEntry(logrus.StandardLogger())
+	favicon := cache.get(faviconFile, logger)
+	assert.Equal(t, faviconDataUrlPrefix+base64.StdEncoding.EncodeToString(png), favicon)
+
+	// subsequent lookups don't read the file again
+	require.NoError(t, os.Remove(faviconFile))
+	assert.Equal(t, favicon, cache.get(faviconFile, logger))
+
+	assert.Empty(t, cache.get(filepath.Join(t.TempDir(), "missing.png"), logger))
+}
+
+func TestConnector_routeFavicon(t *testing.T) {
+	defaultPng := base64.StdEncoding.EncodeToString(append(append([]byte{}, pngSignature...), 0x00, 0x01))
+	const backendFavicon = faviconDataUrlPrefix + "YmFja2VuZA=="
+	const routeFavicon = faviconDataUrlPrefix + "cm91dGU="
+
+	cache := NewStatusCache(dialTest, time.Minute)
+	// the favicon is still used once the status is older than the ttl
+	cache.put("asleep.example.com", &mcproto.StatusResponse{Favicon: backendFavicon}, time.Now().Add(-time.Hour))
+	cache.put("no-favicon.example.com", &mcproto.StatusResponse{}, time.Now())
+
+	c := newTestConnector(t)
+	c.UseStatusCache(cache)
+	assert.Empty(t, c.routeFavicon("asleep.example.com", RouteOptions{}), "nothing by default")
+
+	require.NoError(t, c.UseStatusFavicons(true, defaultPng))
+	assert.Equal(t, routeFavicon, c.routeFavicon("asleep.example.com", RouteOptions{Favicon: routeFavicon}))
+	assert.Equal(t, backendFavicon, c.routeFavicon("asleep.example.com", RouteOptions{}))
+	assert.Equal(t, faviconDataUrlPrefix+defaultPng, c.routeFavicon("no-favicon.example.com", RouteOptions{}))
+	assert.Equal(t, faviconDataUrlPrefix+defaultPng, c.routeFavicon("unknown.example.com", RouteOptions{}))
+
+	require.NoError(t, c.UseStatusFavicons(false, defaultPng))
+	assert.Equal(t, faviconDataUrlPrefix+defaultPng, c.routeFavicon("asleep.example.com", RouteOptions{}))
+
+	assert.Error(t, c.UseStatusFavicons(false, filepath.Join(t.TempDir(), "missing.png")))
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	"github.com/itzg/mc-router/mcproto"
+	"github.com/pkg/errors"
+)
+
+// relayPreReadContent writes the content read from the client so far to the backend. When a backendServerName
+// is given, the handshake is re-encoded to present that server address to the backend.
+func relayPreReadContent(backendConn io.Writer, preReadContent io.Reader, handshake *mcproto.Handshake,
+	backendServerName string) (int64, error) {
+
+	if handshake == nil || backendServerName == "" {
+		return io.Copy(backendConn, preReadContent)
+	}
+
+	// consume the original handshake frame, leaving anything the client sent after it
+	_, err := mcproto.ReadFrame(preReadContent, nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to read original handshake")
+	}
+
+	return writeRewrittenHandshake(backendConn, preReadContent, handshake,
+		rewriteServerAddress(handshake.ServerAddress, backendServerName))
+}
+
+// relayHandshakeWithServerAddress writes the content read from the client so far to the backend, where the handshake
+// is re-encoded to present the serverAddress in its entirety
+func relayHandshakeWithServerAddress(backendConn io.Writer, preReadContent io.Reader, handshake *mcproto.Handshake,
+	serverAddress string) (int64, error) {
+
+	// consume the original handshake frame, leaving anything the client sent after it
+	_, err := mcproto.ReadFrame(preReadContent, nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to read original handshake")
+	}
+	return writeRewrittenHandshake(backendConn, preReadContent, handshake, serverAddress)
+}
+
+// writeRewrittenHandshake writes the handshake presenting the serverAddress followed by the rest of the content that
+// was read from the client
+func writeRewrittenHandshake(backendConn io.Writer, remainingContent io.Reader, handshake *mcproto.Handshake,
+	serverAddress string) (int64, error) {
+
+	rewritten := *handshake
+	rewritten.ServerAddress = serverAddress
+
+	rewrittenContent := new(bytes.Buffer)
+	if err := mcproto.WriteHandshake(rewrittenContent, &rewritten); err != nil {
+		return 0, errors.Wrap(err, "failed to encode rewritten handshake")
+	}
+
+	return io.Copy(backendConn, io.MultiReader(rewrittenContent, remainingContent))
+}
+
+// rewriteServerAddress replaces the hostname part of the handshake's server address while retaining
+// null-delimited parts, such as \x00FML3\x00 that Forge clients append.
+func rewriteServerAddress(serverAddress string, backendServerName string) string {
+	if i := strings.IndexByte(serverAddress, 0); i >= 0 {
+		return backendServerName + serverAddress[i:]
+	}
+	return backendServerName
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/itzg/mc-router/mcproto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRelayPreReadContent(t *testing.T) {
+	tests := []struct {
+		name              string
+		serverAddress     string
+		backendServerName string
+		expectedAddress   string
+	}{
+		{
+			name:            "no rewrite",
+			serverAddress:   "mc.example.com",
+			expectedAddress: "mc.example.com",
+		},
+		{
+			name:              "rewrite",
+			serverAddress:     "mc.example.com",
+			backendServerName: "internal.svc",
+			expectedAddress:   "internal.svc",
+		},
+		{
+			name:              "rewrite retains forge marker",
+			serverAddress:     "mc.example.com\x00FML3\x00",
+			backendServerName: "internal.svc",
+			expectedAddress:   "internal.svc\x00FML3\x00",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handshake := &mcproto.Handshake{
+				ProtocolVersion: 767,
+				ServerAddress:   tt.serverAddress,
+				ServerPort:      25565,
+				NextState:       int(mcproto.StateLogin),
+			}
+			trailing := []byte{0x05, 0x00, 0x03, 'a', 'b', 'c'}
+
+			preRead := new(bytes.Buffer)
+			require.NoError(t, mcproto.WriteHandshake(preRead, handshake))
+			preRead.Write(trailing)
+
+			expected := new(bytes.Buffer)
+			expectedHandshake := *handshake
+			expectedHandshake.ServerAddress = tt.expectedAddress
+			require.NoError(t, mcproto.WriteHandshake(expected, &expectedHandshake))
+			expected.Write(trailing)
+
+			forwarded := new(bytes.Buffer)
+			amount, err := relayPreReadContent(forwarded, preRead, handshake, tt.backendServerName)
+			require.NoError(t, err)
+			assert.Equal(t, int64(expected.Len()), amount)
+			assert.Equal(t, expected.Bytes(), forwarded.Bytes())
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"crypto/subtle"
+	"strings"
+)
+
+// UseHandshakeToken requires clients to prefix the server address with the token as a leading label, such as
+// "secret.mc.example.com", where a wildcard DNS record lets the client resolve that address. Clients without
+// the token are disconnected before any backend is dialed. The token is stripped before routing and from the
+// handshake relayed to the backend. This only gates casual access since the token is sent in the clear and
+// is visible to anyone the address is shared with.
+func (c *Connector) UseHandshakeToken(token string) {
+	c.handshakeToken = token
+}
+
+// stripHandshakeToken removes the required token label from the serverAddress. Returns false when
+// the serverAddress doesn't start with the token.
+func (c *Connector) stripHandshakeToken(serverAddress string) (string, bool) {
+	label, rest, found := strings.Cut(serverAddress, ".")
+	if !found || rest == "" {
+		return "", false
+	}
+	if subtle.ConstantTimeCompare([]byte(label), []byte(c.handshakeToken)) != 1 {
+		return "", false
+	}
+	return rest, true
+}
+
+// redactedHandshakeToken replaces the handshake token in logged server addresses
+const redactedHandshakeToken = "REDACTED"
+
+// redactHandshakeToken masks the required token label of the serverAddress, such as to be logged
+func (c *Connector) redactHandshakeToken(serverAddress string) string {
+	if c.handshakeToken == "" {
+		return serverAddress
+	}
+	if stripped, ok := c.stripHandshakeToken(serverAddress); ok {
+		return redactedHandshakeToken + "." + stripped
+	}
+	return serverAddress
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/itzg/mc-router/mcproto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnector_stripHandshakeToken(t *testing.T) {
+	c := newTestConnector(t)
+	c.UseHandshakeToken("s3cret")
+
+	stripped, ok := c.stripHandshakeToken("s3cret.mc.example.com\x00FML3\x00")
+	assert.True(t, ok)
+	assert.Equal(t, "mc.example.com\x00FML3\x00", stripped)
+
+	for _, serverAddress := range []string{"mc.example.com", "wrong.mc.example.com", "s3cret", "s3cret.", "s3cretx.mc.example.com"} {
+		_, ok := c.stripHandshakeToken(serverAddress)
+		assert.False(t, ok, serverAddress)
+	}
+}
+
+func TestConnector_redactHandshakeToken(t *testing.T) {
+	c := newTestConnector(t)
+	assert.Equal(t, "s3cret.mc.example.com", c.redactHandshakeToken("s3cret.mc.example.com"), "no token is required")
+
+	c.UseHandshakeToken("s3cret")
+	assert.Equal(t, "REDACTED.mc.example.com", c.redactHandshakeToken("s3cret.mc.example.com"))
+	assert.Equal(t, "wrong.mc.example.com", c.redactHandshakeToken("wrong.mc.example.com"))
+}
+
+func TestConnector_HandshakeToken(t *testing.T) {
+	Routes.Reset()
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	//goland:noinspection GoUnhandledErrorResult
+	defer backend.Close()
+	Routes.CreateMapping("mc.example.com", backend.Addr().String(), nil, RouteOptions{})
+	defer Routes.DeleteMapping("mc.example.com")
+
+	errorCounter := newErrorTypeCounter()
+	connectorMetrics := newTestConnectorMetrics()
+	connectorMetrics.Errors = errorCounter
+	c := NewConnector(connectorMetrics, false, false, nil, nil)
+	c.UseHandshakeToken("s3cret")
+
+	connect := func(serverAddress string) {
+		content := new(bytes.Buffer)
+		require.NoError(t, mcproto.WriteHandshake(content, &mcproto.Handshake{
+			ProtocolVersion: 767,
+			ServerAddress:   serverAddress,
+			ServerPort:      25565,
+			NextState:       int(mcproto.StateStatus),
+		}))
+		clientConn, routerConn := net.Pipe()
+		//goland:noinspection GoUnhandledErrorResult
+		defer clientConn.Close()
+		go c.HandleConnection(context.Background(), routerConn)
+		_, err := clientConn.Write(content.Bytes())
+		require.NoError(t, err)
+	}
+
+	// a client without the token is never relayed
+	connect("mc.example.com")
+	assert.Eventually(t, func() bool {
+		return errorCounter.count("handshake_token") == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	connect("s3cret.mc.example.com")
+	require.NoError(t, backend.(*net.TCPListener).SetDeadline(time.Now().Add(5*time.Second)))
+	backendConn, err := backend.Accept()
+	require.NoError(t, err)
+	//goland:noinspection GoUnhandledErrorResult
+	defer backendConn.Close()
+	require.NoError(t, backendConn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	packet, err := mcproto.ReadPacket(backendConn, backendConn.RemoteAddr(), mcproto.StateHandshaking)
+	require.NoError(t, err)
+	handshake, err := mcproto.ReadHandshake(packet.Data)
+	require.NoError(t, err)
+	assert.Equal(t, "mc.example.com", handshake.ServerAddress)
+}
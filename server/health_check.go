@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+// healthCheckProtocolVersion is presented to backends during the health check pings, where any recent version is
+// acceptable since servers respond to status requests regardless of the client's version
+const healthCheckProtocolVersion = 767
+
+// HealthChecker periodically pings the backend of each route, so that clients of a route whose backend
+// doesn't respond are served the route's status rather than waiting on a dial that is likely to fail
+type HealthChecker struct {
+	sync.RWMutex
+	// unhealthy are the server addresses of routes whose backend didn't respond to the last check
+	unhealthy map[string]struct{}
+	dial      func(ctx context.Context, address string) (net.Conn, error)
+	// healthy is set to 1 or 0 by server_address after each check
+	healthy metrics.Gauge
+}
+
+// NewHealthChecker creates a HealthChecker that pings backends using the given dial function, such as one from
+// NewBackendDialFunc, and reports the health of each route with the healthy gauge
+func NewHealthChecker(dial func(ctx context.Context, address string) (net.Conn, error), healthy metrics.Gauge) *HealthChecker {
+	return &HealthChecker{
+		unhealthy: make(map[string]struct{}),
+		dial:      dial,
+		healthy:   healthy,
+	}
+}
+
+// IsHealthy reports if the backend of the route of the serverAddress responded to the last check, which is
+// the case for routes that haven't been checked
+func (h *HealthChecker) IsHealthy(serverAddress string) bool {
+	h.RLock()
+	defer h.RUnlock()
+	_, unhealthy := h.unhealthy[serverAddress]
+	return !unhealthy
+}
+
+// Start checks the backend of every route now and then every interval until the ctx is done
+func (h *HealthChecker) Start(ctx context.Context, interval time.Duration, routes IRoutes) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			h.check(ctx, routes)
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// check concurrently pings the backend of every route, other than those without a backend or that may be
+// intentionally scaled to zero
+func (h *HealthChecker) check(ctx context.Context, routes IRoutes) {
+	mappings := routes.GetMappings()
+
+	var wg sync.WaitGroup
+	for serverAddress, backend := range mappings {
+		options, exists := routes.GetRouteOptions(serverAddress)
+		if backend == "" || !exists || options.Scalable {
+			h.forget(serverAddress)
+			continue
+		}
+
+		serverName := serverAddress
+		if options.BackendServerName != "" {
+			serverName = options.BackendServerName
+		}
+
+		wg.Add(1)
+		go func(serverAddress string, backends []string, serverName string) {
+			defer wg.Done()
+			h.record(serverAddress, h.anyResponds(ctx, serverAddress, backends, serverName))
+		}(serverAddress, SplitBackends(backend), serverName)
+	}
+	wg.Wait()
+
+	// routes may have been removed since the previous check
+	h.Lock()
+	for serverAddress := range h.unhealthy {
+		if _, exists := mappings[serverAddress]; !exists {
+			delete(h.unhealthy, serverAddress)
+		}
+	}
+	h.Unlock()
+}
+
+// anyResponds reports if any of the route's backends, such as when load balanced, responds to a status ping
+func (h *HealthChecker) anyResponds(ctx context.Context, serverAddress string, backends []string, serverName string) bool {
+	for _, backend := range backends {
+		_, err := FetchBackendStatus(ctx, h.dial, backend, serverName, healthCheckProtocolVersion)
+		if err == nil {
+			return true
+		}
+		logrus.
+			WithError(err).
+			WithField("serverAddress", serverAddress).
+			WithField("backend", backend).
+			Debug("Backend failed health check")
+	}
+	return false
+}
+
+// record notes the outcome of a route's check and logs transitions between healthy and unhealthy
+func (h *HealthChecker) record(serverAddress string, healthy bool) {
+	h.Lock()
+	defer h.Unlock()
+
+	_, wasUnhealthy := h.unhealthy[serverAddress]
+	if healthy {
+		h.healthy.With("server_address", serverAddress).Set(1)
+		if wasUnhealthy {
+			delete(h.unhealthy, serverAddress)
+			logrus.WithField("serverAddress", serverAddress).Info("Backend of route is healthy again")
+		}
+	} else {
+		h.healthy.With("server_address", serverAddress).Set(0)
+		if !wasUnhealthy {
+			h.unhealthy[serverAddress] = struct{}{}
+			logrus.WithField("serverAddress", serverAddress).Warn("Backend of route is unhealthy")
+		}
+	}
+}
+
+// forget drops any health recorded for a route that is no longer checked
+func (h *HealthChecker) forget(serverAddress string) {
+	h.Lock()
+	defer h.Unlock()
+	delete(h.unhealthy, serverAddress)
+}
+
+// UseHealthChecker serves clients of routes whose backend is unhealthy according to the health checker as if
+// the backend couldn't be reached, such as with the route's MOTD, rather than dialing it
+func (c *Connector) UseHealthChecker(healthChecker *HealthChecker) {
+	c.healthChecker = healthChecker
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/go-kit/kit/metrics/discard"
+	"github.com/itzg/mc-router/mcproto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthChecker_check(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	//goland:noinspection GoUnhandledErrorResult
+	defer listener.Close()
+	serveTestStatus(t, listener, &mcproto.StatusResponse{Description: mcproto.TextComponent{Text: "Hello"}})
+
+	// nothing is listening on the reserved port
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	require.NoError(t, closed.Close())
+
+	routes := NewRoutes()
+	routes.CreateMapping("up.example.com", listener.Addr().String(), nil, RouteOptions{})
+	routes.CreateMapping("down.example.com", closed.Addr().String(), nil, RouteOptions{})
+	routes.CreateMapping("asleep.example.com", closed.Addr().String(), nil, RouteOptions{Scalable: true})
+
+	healthChecker := NewHealthChecker(dialTest, discard.NewGauge())
+	healthChecker.check(context.Background(), routes)
+
+	assert.True(t, healthChecker.IsHealthy("up.example.com"))
+	assert.False(t, healthChecker.IsHealthy("down.example.com"))
+	assert.True(t, healthChecker.IsHealthy("asleep.example.com"))
+
+	// a removed route is forgotten, where the test backend only serves a single status
+	routes.DeleteMapping("up.example.com")
+	routes.DeleteMapping("down.example.com")
+	healthChecker.check(context.Background(), routes)
+	assert.True(t, healthChecker.IsHealthy("down.example.com"))
+}
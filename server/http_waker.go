@@ -0,0 +1,146 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// how long, by default, and how often a backend woken by an HTTPWaker is checked until reachable
+var (
+	httpWakerDefaultTimeout = 2 * time.Minute
+	httpWakerPollInterval   = 500 * time.Millisecond
+)
+
+// HTTPWaker wakes a backend that isn't reachable by posting to a URL, such as of a script that starts the server,
+// and then waits until the backend is reachable
+type HTTPWaker struct {
+	URL     string
+	Backend string
+	// Timeout is how long the request and the backend becoming reachable may take in total, which defaults to
+	// two minutes
+	Timeout time.Duration
+	// Statuses are the expected status codes of the response, where any 2xx status is expected when empty
+	Statuses []int
+	Client   *http.Client
+}
+
+// Wake requests the URL unless the backend is already reachable and then waits until the backend is reachable
+func (h *HTTPWaker) Wake(ctx context.Context) error {
+	if isReachable(ctx, h.Backend, httpWakerPollInterval) {
+		return nil
+	}
+
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = httpWakerDefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to create wake request")
+	}
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return errors.Wrapf(err, "failed to request wake URL %s", h.URL)
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 4096))
+	_ = response.Body.Close()
+	if !h.expectsStatus(response.StatusCode) {
+		return errors.Errorf("wake URL %s responded with unexpected status %d", h.URL, response.StatusCode)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"backend": h.Backend,
+		"wakeUrl": h.URL,
+		"status":  response.StatusCode,
+	}).Info("Requested wake of backend")
+	return waitUntilReachable(ctx, h.Backend, timeout, httpWakerPollInterval)
+}
+
+func (h *HTTPWaker) expectsStatus(status int) bool {
+	if len(h.Statuses) == 0 {
+		return status >= 200 && status < 300
+	}
+	for _, expected := range h.Statuses {
+		if status == expected {
+			return true
+		}
+	}
+	return false
+}
+
+// isReachable determines if the endpoint can be dialed within the timeout
+func isReachable(ctx context.Context, endpoint string, timeout time.Duration) bool {
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", endpoint)
+	if err != nil {
+		return false
+	}
+	_ = conn.Close()
+	return true
+}
+
+// validateWakeURL checks that the wake URL is an absolute http or https URL
+func validateWakeURL(wakeURL string) error {
+	parsed, err := url.Parse(wakeURL)
+	if err != nil {
+		return errors.Wrapf(err, "invalid wake URL %q", wakeURL)
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return errors.Errorf("wake URL %q must be an http or https URL", wakeURL)
+	}
+	return nil
+}
+
+// parseWakeStatuses parses the expected status codes of a wake request separated by |, such as "200|202", since
+// commas delimit the mappings of -mapping
+func parseWakeStatuses(value string) ([]int, error) {
+	var statuses []int
+	for _, status := range strings.Split(value, "|") {
+		status = strings.TrimSpace(status)
+		if status == "" {
+			continue
+		}
+		code, err := strconv.Atoi(status)
+		if err != nil || code < 100 || code > 599 {
+			return nil, errors.Errorf("invalid wake status %q", status)
+		}
+		statuses = append(statuses, code)
+	}
+	return statuses, nil
+}
+
+// routeWaker returns the waker of a mapping's backend, which requests the wake URL, or runs the wake command, of the
+// options, or nil when the route declares neither. For load balanced backends, the first one is waited on.
+func routeWaker(backend string, options RouteOptions) func(ctx context.Context) error {
+	if options.WakeCommand != "" {
+		return commandScaler(backend, options).Wake
+	}
+	if options.WakeURL == "" {
+		return nil
+	}
+	// the statuses were validated when parsing the options
+	statuses, _ := parseWakeStatuses(options.WakeStatuses)
+	waker := &HTTPWaker{
+		URL:      options.WakeURL,
+		Backend:  strings.TrimSpace(strings.Split(backend, BackendsDelimiter)[0]),
+		Timeout:  options.WakeTimeout,
+		Statuses: statuses,
+	}
+	return waker.Wake
+}
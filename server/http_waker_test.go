@@ -0,0 +1,62 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPWaker_Wake(t *testing.T) {
+	t.Run("starts backend", func(t *testing.T) {
+		// reserves an address that is only listened on once woken
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		backend := listener.Addr().String()
+		require.NoError(t, listener.Close())
+
+		var requests atomic.Int32
+		started := make(chan net.Listener, 1)
+		starter := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, http.MethodPost, r.Method)
+			if requests.Add(1) == 1 {
+				if listener, err := net.Listen("tcp", backend); err == nil {
+					started <- listener
+				}
+			}
+			w.WriteHeader(http.StatusAccepted)
+		}))
+		t.Cleanup(starter.Close)
+
+		waker := &HTTPWaker{URL: starter.URL, Backend: backend, Timeout: 5 * time.Second}
+		require.NoError(t, waker.Wake(context.Background()))
+		assert.Equal(t, int32(1), requests.Load())
+
+		// already reachable
+		require.NoError(t, waker.Wake(context.Background()))
+		assert.Equal(t, int32(1), requests.Load())
+
+		select {
+		case listener := <-started:
+			_ = listener.Close()
+		default:
+		}
+	})
+
+	t.Run("unexpected status", func(t *testing.T) {
+		starter := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		t.Cleanup(starter.Close)
+
+		waker := &HTTPWaker{URL: starter.URL, Backend: "127.0.0.1:1", Timeout: time.Second,
+			Statuses: []int{http.StatusAccepted}}
+		assert.ErrorContains(t, waker.Wake(context.Background()), "unexpected status 200")
+	})
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxIdleCheckInterval bounds how often idleness is checked for long idle timeouts
+const maxIdleCheckInterval = 10 * time.Second
+
+// lastActivity is the last time a connection was opened or closed
+func (c *Connector) lastActivity() time.Time {
+	return time.Unix(0, c.lastActivityNanos.Load())
+}
+
+// WatchIdleShutdown returns a channel that is closed once there have been no connections, and when requireNoRoutes
+// also no route mappings, for the idleTimeout, such as to let mc-router exit when not in use and be started again by
+// an orchestrator. The channel is never closed if the ctx is done first.
+func (c *Connector) WatchIdleShutdown(ctx context.Context, idleTimeout time.Duration, requireNoRoutes bool, routes IRoutes) <-chan struct{} {
+	idle := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(min(idleTimeout/10, maxIdleCheckInterval))
+		defer ticker.Stop()
+
+		idleSince := time.Now()
+		for {
+			select {
+			case now := <-ticker.C:
+				if atomic.LoadInt32(&c.activeConnections) > 0 ||
+					(requireNoRoutes && len(routes.GetMappings()) > 0) {
+					idleSince = now
+					continue
+				}
+				// connections may have opened and closed since the last check
+				if lastActivity := c.lastActivity(); lastActivity.After(idleSince) {
+					idleSince = lastActivity
+				}
+				if now.Sub(idleSince) >= idleTimeout {
+					logrus.
+						WithField("idleTimeout", idleTimeout).
+						WithField("requireNoRoutes", requireNoRoutes).
+						Info("Shutting down since idle")
+					close(idle)
+					return
+				}
+
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return idle
+}
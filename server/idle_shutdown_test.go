@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnector_WatchIdleShutdown(t *testing.T) {
+	t.Run("idle", func(t *testing.T) {
+		c := newTestConnector(t)
+		idle := c.WatchIdleShutdown(context.Background(), 50*time.Millisecond, false, NewRoutes())
+
+		select {
+		case <-idle:
+		case <-time.After(5 * time.Second):
+			t.Fatal("did not shut down while idle")
+		}
+	})
+
+	t.Run("active connection", func(t *testing.T) {
+		c := newTestConnector(t)
+		c.setActiveConnections(1)
+		idle := c.WatchIdleShutdown(context.Background(), 50*time.Millisecond, false, NewRoutes())
+
+		select {
+		case <-idle:
+			t.Fatal("shut down with an active connection")
+		case <-time.After(300 * time.Millisecond):
+		}
+
+		c.setActiveConnections(0)
+		assert.Eventually(t, func() bool {
+			select {
+			case <-idle:
+				return true
+			default:
+				return false
+			}
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("routes", func(t *testing.T) {
+		c := newTestConnector(t)
+		routes := NewRoutes()
+		routes.CreateMapping("mc.example.com", "backend:25565", nil, RouteOptions{})
+		idle := c.WatchIdleShutdown(context.Background(), 50*time.Millisecond, true, routes)
+
+		select {
+		case <-idle:
+			t.Fatal("shut down with a mapped route")
+		case <-time.After(300 * time.Millisecond):
+		}
+	})
+}
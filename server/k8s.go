@@ -7,28 +7,57 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	apps "k8s.io/api/apps/v1"
 	autoscaling "k8s.io/api/autoscaling/v1"
 	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/retry"
 )
 
 const (
 	AnnotationExternalServerName = "mc-router.itzg.me/externalServerName"
 	AnnotationDefaultServer      = "mc-router.itzg.me/defaultServer"
+	// AnnotationAutoScaleIdleReplicas declares the replicas, at or below which, the StatefulSet is considered asleep
+	AnnotationAutoScaleIdleReplicas = "mc-router.itzg.me/autoScaleIdleReplicas"
+	// AnnotationAutoScaleWakeReplicas declares the replicas the StatefulSet is scaled to when woken
+	AnnotationAutoScaleWakeReplicas = "mc-router.itzg.me/autoScaleWakeReplicas"
+)
+
+const (
+	defaultAutoScaleIdleReplicas = 0
+	defaultAutoScaleWakeReplicas = 1
 )
 
 type IK8sWatcher interface {
 	StartWithConfig(kubeConfigFile string, autoScaleUp bool) error
 	StartInCluster(autoScaleUp bool) error
+	// UseRouteConflictPolicy decides which of the services declaring the same host is routed, such as
+	// RouteConflictFirstWins, and is to be called before starting
+	UseRouteConflictPolicy(policy string)
+	// UseScaleRetry sets the attempts and initial backoff of StatefulSet scale updates that conflict
+	UseScaleRetry(attempts int, backoff time.Duration)
+	// UseEndpoints routes services only while their EndpointSlices have a ready endpoint, and is to be called
+	// before starting
+	UseEndpoints(useEndpoints bool)
+	// UseLabelSelector only watches the services matching the label selector, such as mc-router=true, and is to be
+	// called before starting
+	UseLabelSelector(selector string) error
+	// UseLeaderElection only scales StatefulSets while this replica holds the lease, and is to be called before
+	// starting
+	UseLeaderElection(leaseName string, leaseNamespace string)
 	Stop()
 }
 
@@ -39,8 +68,55 @@ type k8sWatcherImpl struct {
 	// The key in mappings is a Service, and the value the StatefulSet name
 	mappings map[string]string
 
-	clientset *kubernetes.Clientset
+	clientset kubernetes.Interface
 	stop      chan struct{}
+	// autoScaleUp is set when the StatefulSets of services are scaled up by their routes' wakers
+	autoScaleUp bool
+	// scaleRetry, when it has steps, is the backoff of scale updates that conflict
+	scaleRetry wait.Backoff
+
+	conflictPolicy string
+	// labelSelector, when set, restricts the watched services
+	labelSelector labels.Selector
+	// claims holds the routable services declaring each host, or the default route when empty, keyed by
+	// the namespace and name of their Service
+	claims map[string]map[string]*routableService
+
+	useEndpoints bool
+	// readySlices holds whether each EndpointSlice has a ready endpoint, by the slice name, keyed by the namespace
+	// and name of their Service
+	readySlices map[string]map[string]bool
+
+	// leaderElection is set when only the replica holding the lease scales StatefulSets, which is when leading
+	leaderElection bool
+	leaseName      string
+	leaseNamespace string
+	leading        atomic.Bool
+}
+
+func (w *k8sWatcherImpl) UseRouteConflictPolicy(policy string) {
+	w.conflictPolicy = policy
+}
+
+func (w *k8sWatcherImpl) UseLabelSelector(selector string) error {
+	if selector == "" {
+		w.labelSelector = nil
+		return nil
+	}
+	parsed, err := labels.Parse(selector)
+	if err != nil {
+		return errors.Wrapf(err, "invalid label selector %q", selector)
+	}
+	w.labelSelector = parsed
+	return nil
+}
+
+// filterServices applies the label selector, if any, to listing and watching services
+func (w *k8sWatcherImpl) filterServices(options *meta.ListOptions) {
+	options.FieldSelector = fields.Everything().String()
+	if w.labelSelector != nil {
+		options.LabelSelector = w.labelSelector.String()
+	}
 }
 
 func (w *k8sWatcherImpl) StartInCluster(autoScaleUp bool) error {
@@ -63,6 +139,7 @@ func (w *k8sWatcherImpl) StartWithConfig(kubeConfigFile string, autoScaleUp bool
 
 func (w *k8sWatcherImpl) startWithLoadedConfig(config *rest.Config, autoScaleUp bool) error {
 	w.stop = make(chan struct{}, 1)
+	w.autoScaleUp = autoScaleUp
 
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
@@ -70,12 +147,16 @@ func (w *k8sWatcherImpl) startWithLoadedConfig(config *rest.Config, autoScaleUp
 	}
 	w.clientset = clientset
 
+	if w.useEndpoints {
+		w.watchEndpointSlices(clientset)
+	}
+
 	_, serviceController := cache.NewInformer(
-		cache.NewListWatchFromClient(
+		cache.NewFilteredListWatchFromClient(
 			clientset.CoreV1().RESTClient(),
 			string(core.ResourceServices),
 			core.NamespaceAll,
-			fields.Everything(),
+			w.filterServices,
 		),
 		&core.Service{},
 		0,
@@ -89,6 +170,11 @@ func (w *k8sWatcherImpl) startWithLoadedConfig(config *rest.Config, autoScaleUp
 
 	w.mappings = make(map[string]string)
 	if autoScaleUp {
+		if w.leaderElection {
+			if err := w.startLeaderElection(clientset); err != nil {
+				return err
+			}
+		}
 		_, statefulSetController := cache.NewInformer(
 			cache.NewListWatchFromClient(
 				clientset.AppsV1().RESTClient(),
@@ -142,59 +228,114 @@ func (w *k8sWatcherImpl) startWithLoadedConfig(config *rest.Config, autoScaleUp
 
 // oldObj and newObj are expected to be *v1.Service
 func (w *k8sWatcherImpl) handleUpdate(oldObj interface{}, newObj interface{}) {
+	w.Lock()
+	defer w.Unlock()
+
+	hosts := make(map[string]struct{})
 	for _, oldRoutableService := range w.extractRoutableServices(oldObj) {
 		logrus.WithFields(logrus.Fields{
 			"old": oldRoutableService,
 		}).Debug("UPDATE")
-		if oldRoutableService.externalServiceName != "" {
-			Routes.DeleteMapping(oldRoutableService.externalServiceName)
-		}
+		w.releaseRoute(oldRoutableService)
+		hosts[strings.ToLower(oldRoutableService.externalServiceName)] = struct{}{}
 	}
 
 	for _, newRoutableService := range w.extractRoutableServices(newObj) {
 		logrus.WithFields(logrus.Fields{
 			"new": newRoutableService,
 		}).Debug("UPDATE")
-		if newRoutableService.externalServiceName != "" {
-			Routes.CreateMapping(newRoutableService.externalServiceName, newRoutableService.containerEndpoint, newRoutableService.autoScaleUp)
-		} else {
-			Routes.SetDefaultRoute(newRoutableService.containerEndpoint)
-		}
+		w.claimRoute(newRoutableService)
+		hosts[strings.ToLower(newRoutableService.externalServiceName)] = struct{}{}
+	}
+
+	for host := range hosts {
+		w.applyRoute(host)
 	}
 }
 
 // obj is expected to be a *v1.Service
 func (w *k8sWatcherImpl) handleDelete(obj interface{}) {
+	w.Lock()
+	defer w.Unlock()
+
 	routableServices := w.extractRoutableServices(obj)
 	for _, routableService := range routableServices {
 		if routableService != nil {
 			logrus.WithField("routableService", routableService).Debug("DELETE")
 
-			if routableService.externalServiceName != "" {
-				Routes.DeleteMapping(routableService.externalServiceName)
-			} else {
-				Routes.SetDefaultRoute("")
-			}
+			w.releaseRoute(routableService)
+			w.applyRoute(strings.ToLower(routableService.externalServiceName))
 		}
 	}
 }
 
 // obj is expected to be a *v1.Service
 func (w *k8sWatcherImpl) handleAdd(obj interface{}) {
+	w.Lock()
+	defer w.Unlock()
+
 	routableServices := w.extractRoutableServices(obj)
 	for _, routableService := range routableServices {
 		if routableService != nil {
 			logrus.WithField("routableService", routableService).Debug("ADD")
 
-			if routableService.externalServiceName != "" {
-				Routes.CreateMapping(routableService.externalServiceName, routableService.containerEndpoint, routableService.autoScaleUp)
-			} else {
-				Routes.SetDefaultRoute(routableService.containerEndpoint)
-			}
+			w.claimRoute(routableService)
+			w.applyRoute(strings.ToLower(routableService.externalServiceName))
 		}
 	}
 }
 
+// claimRoute records that the service declares its host and must be called while holding the lock
+func (w *k8sWatcherImpl) claimRoute(rs *routableService) {
+	if w.claims == nil {
+		w.claims = make(map[string]map[string]*routableService)
+	}
+	host := strings.ToLower(rs.externalServiceName)
+	if w.claims[host] == nil {
+		w.claims[host] = make(map[string]*routableService)
+	}
+	w.claims[host][rs.owner] = rs
+}
+
+// releaseRoute forgets that the service declares its host and must be called while holding the lock
+func (w *k8sWatcherImpl) releaseRoute(rs *routableService) {
+	host := strings.ToLower(rs.externalServiceName)
+	delete(w.claims[host], rs.owner)
+	if len(w.claims[host]) == 0 {
+		delete(w.claims, host)
+	}
+}
+
+// applyRoute routes the host to the service declaring it, where the services in conflict are resolved by the
+// conflict policy, and must be called while holding the lock
+func (w *k8sWatcherImpl) applyRoute(host string) {
+	var services []*routableService
+	var claims []routeClaim
+	for _, rs := range w.claims[host] {
+		services = append(services, rs)
+		claims = append(claims, routeClaim{host: host, owner: rs.owner, created: rs.created})
+	}
+
+	for i, routed := range resolveRouteConflicts(w.conflictPolicy, RouteSourceK8s, claims) {
+		if !routed {
+			continue
+		}
+		if host != "" {
+			Routes.CreateMapping(host, w.routedEndpoint(services[i]), services[i].autoScaleUp, RouteOptions{Source: RouteSourceK8s, Scalable: w.autoScaleUp})
+		} else {
+			Routes.SetDefaultRoute(w.routedEndpoint(services[i]))
+		}
+		return
+	}
+
+	// no service declares the host anymore, or those that do are in conflict
+	if host != "" {
+		Routes.DeleteMapping(host)
+	} else {
+		Routes.SetDefaultRoute("")
+	}
+}
+
 func (w *k8sWatcherImpl) Stop() {
 	if w.stop != nil {
 		close(w.stop)
@@ -205,6 +346,14 @@ type routableService struct {
 	externalServiceName string
 	containerEndpoint   string
 	autoScaleUp         func(ctx context.Context) error
+	// autoScaleDown, when set, is the sleeper of the Docker Swarm service's routes, which are scaled down after
+	// being idle for autoScaleDownAfter, or the default when zero
+	autoScaleDown      func(ctx context.Context) error
+	autoScaleDownAfter time.Duration
+	// owner identifies the Kubernetes Service by its namespace and name
+	owner string
+	// created orders the Kubernetes Services that declare the same host
+	created time.Time
 }
 
 // obj is expected to be a *v1.Service
@@ -239,45 +388,145 @@ func (w *k8sWatcherImpl) buildDetails(service *core.Service, externalServiceName
 	rs := &routableService{
 		externalServiceName: externalServiceName,
 		containerEndpoint:   net.JoinHostPort(clusterIp, port),
-		autoScaleUp:         w.buildScaleUpFunction(service),
+		owner:               service.Namespace + "/" + service.Name,
+		created:             service.CreationTimestamp.Time,
+	}
+	// without auto scale up, the route has no waker, so that its backend is not treated as one that may be starting
+	if w.autoScaleUp {
+		rs.autoScaleUp = w.buildScaleUpFunction(service)
 	}
 	return rs
 }
 
+// autoScaleReplicas holds the replicas of an asleep StatefulSet and those it is scaled to when woken
+type autoScaleReplicas struct {
+	idle int32
+	wake int32
+}
+
+// getAutoScaleReplicas reads the replica counts from the service's annotations, defaulting to scaling from 0 to 1
+func getAutoScaleReplicas(service *core.Service) autoScaleReplicas {
+	replicas := autoScaleReplicas{
+		idle: readReplicasAnnotation(service, AnnotationAutoScaleIdleReplicas, defaultAutoScaleIdleReplicas),
+		wake: readReplicasAnnotation(service, AnnotationAutoScaleWakeReplicas, defaultAutoScaleWakeReplicas),
+	}
+	if replicas.wake <= replicas.idle {
+		logrus.WithFields(logrus.Fields{
+			"service": service.Name,
+			"idle":    replicas.idle,
+			"wake":    replicas.wake,
+		}).Warn("Wake replicas must be greater than idle replicas, so using defaults")
+		return autoScaleReplicas{idle: defaultAutoScaleIdleReplicas, wake: defaultAutoScaleWakeReplicas}
+	}
+	return replicas
+}
+
+func readReplicasAnnotation(service *core.Service, annotation string, defaultValue int32) int32 {
+	value, exists := service.Annotations[annotation]
+	if !exists {
+		return defaultValue
+	}
+	replicas, err := strconv.ParseInt(strings.TrimSpace(value), 10, 32)
+	if err != nil || replicas < 0 {
+		logrus.WithFields(logrus.Fields{
+			"service":    service.Name,
+			"annotation": annotation,
+			"value":      value,
+		}).Warn("Invalid replicas annotation, so using default")
+		return defaultValue
+	}
+	return int32(replicas)
+}
+
 func (w *k8sWatcherImpl) buildScaleUpFunction(service *core.Service) func(ctx context.Context) error {
 	return func(ctx context.Context) error {
 		serviceName := service.Name
-		if statefulSetName, exists := w.mappings[serviceName]; exists {
-			if scale, err := w.clientset.AppsV1().StatefulSets(service.Namespace).GetScale(ctx, statefulSetName, meta.GetOptions{}); err == nil {
-				replicas := scale.Status.Replicas
-				logrus.WithFields(logrus.Fields{
+		w.RLock()
+		statefulSetName, exists := w.mappings[serviceName]
+		w.RUnlock()
+		if !exists {
+			return nil
+		}
+		if !w.isLeader() {
+			logrus.WithFields(logrus.Fields{
+				"service":     serviceName,
+				"statefulSet": statefulSetName,
+			}).Debug("Not scaling up StatefulSet since another replica is the leader")
+		} else if err := w.scaleUpStatefulSet(ctx, service.Namespace, statefulSetName, serviceName, getAutoScaleReplicas(service)); err != nil {
+			return err
+		}
+		if w.useEndpoints {
+			return w.waitUntilReady(ctx, service.Namespace+"/"+serviceName, k8sWakeReadyTimeout, k8sWakeReadyPollInterval)
+		}
+		return nil
+	}
+}
+
+// scaleUpStatefulSet wakes the StatefulSet by scaling it to the wake replicas when it is at or below the idle replicas.
+// An update that conflicts with another change to the StatefulSet is retried, with backoff, using its latest scale.
+func (w *k8sWatcherImpl) scaleUpStatefulSet(ctx context.Context, namespace string, statefulSetName string, serviceName string,
+	autoScale autoScaleReplicas) error {
+	attempt := 0
+	return retry.RetryOnConflict(w.scaleBackoff(), func() error {
+		attempt++
+		scale, err := w.clientset.AppsV1().StatefulSets(namespace).GetScale(ctx, statefulSetName, meta.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("GetScale failed for StatefulSet %s: %w", statefulSetName, err)
+		}
+
+		replicas := scale.Status.Replicas
+		logrus.WithFields(logrus.Fields{
+			"service":     serviceName,
+			"statefulSet": statefulSetName,
+			"replicas":    replicas,
+		}).Debug("StatefulSet of Service Replicas")
+		if replicas > autoScale.idle {
+			return nil
+		}
+
+		if _, err := w.clientset.AppsV1().StatefulSets(namespace).UpdateScale(ctx, statefulSetName, &autoscaling.Scale{
+			ObjectMeta: meta.ObjectMeta{
+				Name:            scale.Name,
+				Namespace:       scale.Namespace,
+				UID:             scale.UID,
+				ResourceVersion: scale.ResourceVersion,
+			},
+			Spec: autoscaling.ScaleSpec{Replicas: autoScale.wake}}, meta.UpdateOptions{},
+		); err != nil {
+			if apierrors.IsConflict(err) {
+				logrus.WithError(err).WithFields(logrus.Fields{
 					"service":     serviceName,
 					"statefulSet": statefulSetName,
-					"replicas":    replicas,
-				}).Debug("StatefulSet of Service Replicas")
-				if replicas == 0 {
-					if _, err := w.clientset.AppsV1().StatefulSets(service.Namespace).UpdateScale(ctx, statefulSetName, &autoscaling.Scale{
-						ObjectMeta: meta.ObjectMeta{
-							Name:            scale.Name,
-							Namespace:       scale.Namespace,
-							UID:             scale.UID,
-							ResourceVersion: scale.ResourceVersion,
-						},
-						Spec: autoscaling.ScaleSpec{Replicas: 1}}, meta.UpdateOptions{},
-					); err == nil {
-						logrus.WithFields(logrus.Fields{
-							"service":     serviceName,
-							"statefulSet": statefulSetName,
-							"replicas":    replicas,
-						}).Info("StatefulSet Replicas Autoscaled from 0 to 1 (wake up)")
-					} else {
-						return errors.Wrap(err, "UpdateScale for Replicas=1 failed for StatefulSet: "+statefulSetName)
-					}
-				}
-			} else {
-				return fmt.Errorf("GetScale failed for StatefulSet %s: %w", statefulSetName, err)
+					"attempt":     attempt,
+				}).Debug("Conflict updating StatefulSet scale")
 			}
+			return errors.Wrapf(err, "UpdateScale for Replicas=%d failed for StatefulSet: %s", autoScale.wake, statefulSetName)
 		}
+
+		logrus.WithFields(logrus.Fields{
+			"service":     serviceName,
+			"statefulSet": statefulSetName,
+			"replicas":    replicas,
+		}).Infof("StatefulSet Replicas Autoscaled from %d to %d (wake up)", replicas, autoScale.wake)
 		return nil
+	})
+}
+
+// UseScaleRetry sets how many times, in total, the scale of a StatefulSet is updated when the update conflicts with
+// another change to it, where the wait between attempts starts at the backoff and doubles
+func (w *k8sWatcherImpl) UseScaleRetry(attempts int, backoff time.Duration) {
+	w.scaleRetry = wait.Backoff{
+		Steps:    attempts,
+		Duration: backoff,
+		Factor:   2,
+		Jitter:   0.1,
+	}
+}
+
+// scaleBackoff is the configured backoff for conflicting scale updates, or else the one recommended by client-go
+func (w *k8sWatcherImpl) scaleBackoff() wait.Backoff {
+	if w.scaleRetry.Steps > 0 {
+		return w.scaleRetry
 	}
+	return retry.DefaultRetry
 }
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	core "k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// how long, and how often, a woken service is checked for ready endpoints
+var (
+	k8sWakeReadyTimeout      = 2 * time.Minute
+	k8sWakeReadyPollInterval = 500 * time.Millisecond
+)
+
+// UseEndpoints routes services only while their EndpointSlices have a ready endpoint, and otherwise treats them as
+// asleep, and is to be called before starting
+func (w *k8sWatcherImpl) UseEndpoints(useEndpoints bool) {
+	w.useEndpoints = useEndpoints
+}
+
+// watchEndpointSlices tracks the readiness of the endpoints of services and re-applies their routes as it changes
+func (w *k8sWatcherImpl) watchEndpointSlices(clientset kubernetes.Interface) {
+	_, endpointSliceController := cache.NewInformer(
+		cache.NewListWatchFromClient(
+			clientset.DiscoveryV1().RESTClient(),
+			"endpointslices",
+			core.NamespaceAll,
+			fields.Everything(),
+		),
+		&discovery.EndpointSlice{},
+		0,
+		cache.ResourceEventHandlerFuncs{
+			AddFunc: w.handleEndpointSlice,
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				w.handleEndpointSlice(newObj)
+			},
+			DeleteFunc: w.handleEndpointSliceDelete,
+		},
+	)
+	go endpointSliceController.Run(w.stop)
+}
+
+// obj is expected to be a *discovery.EndpointSlice
+func (w *k8sWatcherImpl) handleEndpointSlice(obj interface{}) {
+	slice, ok := obj.(*discovery.EndpointSlice)
+	if !ok {
+		return
+	}
+	owner, ok := endpointSliceOwner(slice)
+	if !ok {
+		return
+	}
+
+	w.Lock()
+	defer w.Unlock()
+	if w.readySlices == nil {
+		w.readySlices = make(map[string]map[string]bool)
+	}
+	wasReady := w.serviceReady(owner)
+	if w.readySlices[owner] == nil {
+		w.readySlices[owner] = make(map[string]bool)
+	}
+	w.readySlices[owner][slice.Name] = hasReadyEndpoint(slice)
+	w.applyReadinessChange(owner, wasReady)
+}
+
+// obj is expected to be a *discovery.EndpointSlice
+func (w *k8sWatcherImpl) handleEndpointSliceDelete(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	slice, ok := obj.(*discovery.EndpointSlice)
+	if !ok {
+		return
+	}
+	owner, ok := endpointSliceOwner(slice)
+	if !ok {
+		return
+	}
+
+	w.Lock()
+	defer w.Unlock()
+	wasReady := w.serviceReady(owner)
+	delete(w.readySlices[owner], slice.Name)
+	if len(w.readySlices[owner]) == 0 {
+		delete(w.readySlices, owner)
+	}
+	w.applyReadinessChange(owner, wasReady)
+}
+
+// applyReadinessChange re-applies the routes of the service when its readiness changed and must be called while
+// holding the lock
+func (w *k8sWatcherImpl) applyReadinessChange(owner string, wasReady bool) {
+	ready := w.serviceReady(owner)
+	if ready == wasReady {
+		return
+	}
+	logrus.WithField("service", owner).WithField("ready", ready).Debug("Endpoints of service changed readiness")
+	for host, claims := range w.claims {
+		if _, exists := claims[owner]; exists {
+			w.applyRoute(host)
+		}
+	}
+}
+
+// serviceReady reports if any EndpointSlice of the service, identified by its namespace and name, has a ready
+// endpoint and must be called while holding the lock
+func (w *k8sWatcherImpl) serviceReady(owner string) bool {
+	for _, ready := range w.readySlices[owner] {
+		if ready {
+			return true
+		}
+	}
+	return false
+}
+
+// routedEndpoint is the backend of the service's route, which is empty, as for an asleep backend, while using
+// endpoints and none are ready. With auto scale up, the endpoint is retained so that the waker can wake it and
+// the client is then connected once ready.
+func (w *k8sWatcherImpl) routedEndpoint(rs *routableService) string {
+	if w.useEndpoints && !w.autoScaleUp && !w.serviceReady(rs.owner) {
+		return ""
+	}
+	return rs.containerEndpoint
+}
+
+// waitUntilReady polls until the service, identified by its namespace and name, has a ready endpoint
+func (w *k8sWatcherImpl) waitUntilReady(ctx context.Context, owner string, timeout time.Duration,
+	pollInterval time.Duration) error {
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		w.RLock()
+		ready := w.serviceReady(owner)
+		w.RUnlock()
+		if ready {
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return errors.Wrapf(ctx.Err(), "endpoints of service %s did not become ready", owner)
+		}
+	}
+}
+
+// endpointSliceOwner returns the namespace and name of the service that the EndpointSlice belongs to
+func endpointSliceOwner(slice *discovery.EndpointSlice) (string, bool) {
+	serviceName, exists := slice.Labels[discovery.LabelServiceName]
+	if !exists {
+		return "", false
+	}
+	return slice.Namespace + "/" + serviceName, true
+}
+
+func hasReadyEndpoint(slice *discovery.EndpointSlice) bool {
+	for _, endpoint := range slice.Endpoints {
+		// an unknown readiness is to be interpreted as ready
+		if endpoint.Conditions.Ready == nil || *endpoint.Conditions.Ready {
+			return true
+		}
+	}
+	return false
+}
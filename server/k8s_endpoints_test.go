@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func testEndpointSlice(name string, ready ...bool) *discovery.EndpointSlice {
+	slice := &discovery.EndpointSlice{
+		ObjectMeta: meta.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Labels:    map[string]string{discovery.LabelServiceName: "mc"},
+		},
+	}
+	for _, r := range ready {
+		r := r
+		slice.Endpoints = append(slice.Endpoints, discovery.Endpoint{
+			Addresses:  []string{"10.0.0.1"},
+			Conditions: discovery.EndpointConditions{Ready: &r},
+		})
+	}
+	return slice
+}
+
+func TestK8sWatcherImpl_useEndpoints(t *testing.T) {
+	Routes.Reset()
+	t.Cleanup(Routes.Reset)
+
+	watcher := &k8sWatcherImpl{}
+	watcher.UseEndpoints(true)
+	service := &v1.Service{
+		ObjectMeta: meta.ObjectMeta{
+			Name:        "mc",
+			Namespace:   "default",
+			Annotations: map[string]string{AnnotationExternalServerName: "mc.example.com"},
+		},
+		Spec: v1.ServiceSpec{ClusterIP: "1.1.1.1"},
+	}
+	backend := func() string {
+		return Routes.GetMappings()["mc.example.com"]
+	}
+
+	watcher.handleAdd(service)
+	assert.Contains(t, Routes.GetMappings(), "mc.example.com", "routed as asleep")
+	assert.Equal(t, "", backend())
+
+	watcher.handleEndpointSlice(testEndpointSlice("mc-a", false))
+	assert.Equal(t, "", backend())
+
+	watcher.handleEndpointSlice(testEndpointSlice("mc-b", false, true))
+	assert.Equal(t, "1.1.1.1:25565", backend())
+
+	watcher.handleEndpointSliceDelete(testEndpointSlice("mc-b"))
+	assert.Equal(t, "", backend())
+
+	// a slice of another service
+	other := testEndpointSlice("other-a", true)
+	other.Labels[discovery.LabelServiceName] = "other"
+	watcher.handleEndpointSlice(other)
+	assert.Equal(t, "", backend())
+
+	// an unknown readiness is ready
+	unknown := testEndpointSlice("mc-a")
+	unknown.Endpoints = []discovery.Endpoint{{Addresses: []string{"10.0.0.1"}}}
+	watcher.handleEndpointSlice(unknown)
+	assert.Equal(t, "1.1.1.1:25565", backend())
+}
+
+func TestK8sWatcherImpl_waitUntilReady(t *testing.T) {
+	watcher := &k8sWatcherImpl{}
+	watcher.UseEndpoints(true)
+
+	t.Run("not ready", func(t *testing.T) {
+		err := watcher.waitUntilReady(context.Background(), "default/mc", 50*time.Millisecond, 10*time.Millisecond)
+		assert.Error(t, err)
+	})
+
+	t.Run("becomes ready", func(t *testing.T) {
+		go func() {
+			time.Sleep(30 * time.Millisecond)
+			watcher.handleEndpointSlice(testEndpointSlice("mc-a", true))
+		}()
+		require.NoError(t, watcher.waitUntilReady(context.Background(), "default/mc", 5*time.Second, 10*time.Millisecond))
+	})
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// serviceAccountNamespaceFile holds the namespace of the pod when running in a cluster
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// the timing of the lease recommended by client-go
+var (
+	k8sLeaseDuration = 15 * time.Second
+	k8sRenewDeadline = 10 * time.Second
+	k8sRetryPeriod   = 2 * time.Second
+)
+
+// UseLeaderElection only scales StatefulSets while this replica holds the lease of the given name and namespace,
+// where the namespace defaults to that of the pod, and is to be called before starting
+func (w *k8sWatcherImpl) UseLeaderElection(leaseName string, leaseNamespace string) {
+	w.leaderElection = true
+	w.leaseName = leaseName
+	w.leaseNamespace = leaseNamespace
+}
+
+// isLeader reports whether this replica may scale StatefulSets, which is always when not electing a leader
+func (w *k8sWatcherImpl) isLeader() bool {
+	return !w.leaderElection || w.leading.Load()
+}
+
+// startLeaderElection campaigns for the lease until the watcher is stopped
+func (w *k8sWatcherImpl) startLeaderElection(clientset kubernetes.Interface) error {
+	identity, err := os.Hostname()
+	if err != nil {
+		return errors.Wrap(err, "unable to determine the identity for leader election")
+	}
+	namespace := w.leaseNamespace
+	if namespace == "" {
+		namespace = podNamespace()
+	}
+	elector, err := w.newLeaderElector(clientset, namespace, identity)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-w.stop
+		cancel()
+	}()
+	go func() {
+		// campaigns again after losing the lease, until stopped
+		for ctx.Err() == nil {
+			elector.Run(ctx)
+		}
+	}()
+
+	logrus.WithFields(logrus.Fields{
+		"lease":     w.leaseName,
+		"namespace": namespace,
+		"identity":  identity,
+	}).Info("Electing the leader that scales StatefulSets")
+	return nil
+}
+
+func (w *k8sWatcherImpl) newLeaderElector(clientset kubernetes.Interface, namespace string, identity string) (*leaderelection.LeaderElector, error) {
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  meta.ObjectMeta{Name: w.leaseName, Namespace: namespace},
+		Client:     clientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+	}
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   k8sLeaseDuration,
+		RenewDeadline:   k8sRenewDeadline,
+		RetryPeriod:     k8sRetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            w.leaseName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				logrus.WithField("identity", identity).Info("Became the leader that scales StatefulSets")
+				w.leading.Store(true)
+			},
+			OnStoppedLeading: func() {
+				logrus.WithField("identity", identity).Info("No longer the leader that scales StatefulSets")
+				w.leading.Store(false)
+			},
+			OnNewLeader: func(leader string) {
+				logrus.WithField("leader", leader).Debug("Observed the leader that scales StatefulSets")
+			},
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid leader election config")
+	}
+	return elector, nil
+}
+
+// podNamespace is the namespace of the pod, when running in a cluster, or else the default namespace
+func podNamespace() string {
+	if content, err := os.ReadFile(serviceAccountNamespaceFile); err == nil {
+		if namespace := strings.TrimSpace(string(content)); namespace != "" {
+			return namespace
+		}
+	}
+	return meta.NamespaceDefault
+}
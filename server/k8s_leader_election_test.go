@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	autoscaling "k8s.io/api/autoscaling/v1"
+	v1 "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestK8sWatcherImpl_leaderElection(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	leader := &k8sWatcherImpl{}
+	leader.UseLeaderElection("mc-router", "default")
+	follower := &k8sWatcherImpl{}
+	follower.UseLeaderElection("mc-router", "default")
+	assert.False(t, leader.isLeader())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	leaderElector, err := leader.newLeaderElector(clientset, "default", "leader")
+	require.NoError(t, err)
+	go leaderElector.Run(ctx)
+	require.Eventually(t, leader.isLeader, 5*time.Second, 10*time.Millisecond)
+
+	followerElector, err := follower.newLeaderElector(clientset, "default", "follower")
+	require.NoError(t, err)
+	go followerElector.Run(ctx)
+	require.Eventually(t, func() bool {
+		return followerElector.GetLeader() == "leader"
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.False(t, follower.isLeader(), "the lease is held by the leader")
+}
+
+func TestK8sWatcherImpl_buildScaleUpFunction_follower(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("get", "statefulsets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, &autoscaling.Scale{ObjectMeta: meta.ObjectMeta{Name: "mc", Namespace: "default"}}, nil
+	})
+	updated := false
+	clientset.PrependReactor("update", "statefulsets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		updated = true
+		return true, action.(k8stesting.UpdateAction).GetObject(), nil
+	})
+
+	watcher := &k8sWatcherImpl{
+		clientset: clientset,
+		mappings:  map[string]string{"mc-svc": "mc"},
+	}
+	watcher.UseLeaderElection("mc-router", "default")
+	service := &v1.Service{ObjectMeta: meta.ObjectMeta{Name: "mc-svc", Namespace: "default"}}
+
+	require.NoError(t, watcher.buildScaleUpFunction(service)(context.Background()))
+	assert.False(t, updated, "followers don't scale")
+
+	watcher.leading.Store(true)
+	require.NoError(t, watcher.buildScaleUpFunction(service)(context.Background()))
+	assert.True(t, updated, "the leader scales")
+}
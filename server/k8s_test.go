@@ -3,11 +3,20 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	autoscaling "k8s.io/api/autoscaling/v1"
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 func TestK8sWatcherImpl_handleAddThenUpdate(t *testing.T) {
@@ -170,3 +179,204 @@ func TestK8sWatcherImpl_handleAddThenDelete(t *testing.T) {
 		})
 	}
 }
+
+func TestK8sWatcherImpl_routeConflicts(t *testing.T) {
+	older := ` {"metadata": {"name": "older", "creationTimestamp": "2024-01-01T00:00:00Z", "annotations": {"mc-router.itzg.me/externalServerName": "shared.com"}}, "spec":{"clusterIP": "1.1.1.1"}}`
+	newer := ` {"metadata": {"name": "newer", "creationTimestamp": "2024-02-01T00:00:00Z", "annotations": {"mc-router.itzg.me/externalServerName": "shared.com"}}, "spec":{"clusterIP": "2.2.2.2"}}`
+
+	tests := []struct {
+		policy string
+		// expect is the backend while both services declare the host
+		expect string
+	}{
+		{policy: RouteConflictLastWins, expect: "2.2.2.2:25565"},
+		{policy: RouteConflictFirstWins, expect: "1.1.1.1:25565"},
+		{policy: RouteConflictError, expect: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			Routes.Reset()
+
+			watcher := &k8sWatcherImpl{}
+			watcher.UseRouteConflictPolicy(tt.policy)
+			olderSvc, newerSvc := v1.Service{}, v1.Service{}
+			require.NoError(t, json.Unmarshal([]byte(older), &olderSvc))
+			require.NoError(t, json.Unmarshal([]byte(newer), &newerSvc))
+
+			// the informer may deliver the services in any order
+			watcher.handleAdd(&newerSvc)
+			watcher.handleAdd(&olderSvc)
+			backend, _, _ := Routes.FindBackendForServerAddress(context.Background(), "shared.com")
+			assert.Equal(t, tt.expect, backend)
+
+			// resolving the conflict routes the remaining service
+			watcher.handleDelete(&newerSvc)
+			backend, _, _ = Routes.FindBackendForServerAddress(context.Background(), "shared.com")
+			assert.Equal(t, "1.1.1.1:25565", backend)
+
+			watcher.handleDelete(&olderSvc)
+			backend, _, _ = Routes.FindBackendForServerAddress(context.Background(), "shared.com")
+			assert.Equal(t, "", backend)
+		})
+	}
+}
+
+func TestK8sWatcherImpl_buildScaleUpFunction(t *testing.T) {
+	tests := []struct {
+		name          string
+		annotations   map[string]string
+		replicas      int32
+		expectUpdated bool
+		expectScaled  int32
+	}{
+		{
+			name:          "defaults from 0 to 1",
+			replicas:      0,
+			expectUpdated: true,
+			expectScaled:  1,
+		},
+		{
+			name:     "defaults already awake",
+			replicas: 1,
+		},
+		{
+			name:          "idle at 0 wake to 3",
+			annotations:   map[string]string{AnnotationAutoScaleWakeReplicas: "3"},
+			replicas:      0,
+			expectUpdated: true,
+			expectScaled:  3,
+		},
+		{
+			name: "idle at 1 wake to 2",
+			annotations: map[string]string{
+				AnnotationAutoScaleIdleReplicas: "1",
+				AnnotationAutoScaleWakeReplicas: "2",
+			},
+			replicas:      1,
+			expectUpdated: true,
+			expectScaled:  2,
+		},
+		{
+			name: "idle at 1 already awake",
+			annotations: map[string]string{
+				AnnotationAutoScaleIdleReplicas: "1",
+				AnnotationAutoScaleWakeReplicas: "2",
+			},
+			replicas: 2,
+		},
+		{
+			name: "wake not above idle uses defaults",
+			annotations: map[string]string{
+				AnnotationAutoScaleIdleReplicas: "2",
+				AnnotationAutoScaleWakeReplicas: "2",
+			},
+			replicas:      0,
+			expectUpdated: true,
+			expectScaled:  1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset()
+			clientset.PrependReactor("get", "statefulsets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				return true, &autoscaling.Scale{
+					ObjectMeta: meta.ObjectMeta{Name: "mc", Namespace: "default"},
+					Status:     autoscaling.ScaleStatus{Replicas: test.replicas},
+				}, nil
+			})
+			var scaledTo *int32
+			clientset.PrependReactor("update", "statefulsets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				scale := action.(k8stesting.UpdateAction).GetObject().(*autoscaling.Scale)
+				scaledTo = &scale.Spec.Replicas
+				return true, scale, nil
+			})
+
+			watcher := &k8sWatcherImpl{
+				clientset: clientset,
+				mappings:  map[string]string{"mc-svc": "mc"},
+			}
+			service := &v1.Service{
+				ObjectMeta: meta.ObjectMeta{Name: "mc-svc", Namespace: "default", Annotations: test.annotations},
+			}
+
+			err := watcher.buildScaleUpFunction(service)(context.Background())
+			require.NoError(t, err)
+			if test.expectUpdated {
+				require.NotNil(t, scaledTo)
+				assert.Equal(t, test.expectScaled, *scaledTo)
+			} else {
+				assert.Nil(t, scaledTo)
+			}
+		})
+	}
+}
+
+func TestK8sWatcherImpl_scaleUpRetriesConflict(t *testing.T) {
+	tests := []struct {
+		name          string
+		conflicts     int
+		expectErr     bool
+		expectUpdates int
+	}{
+		{name: "conflict then success", conflicts: 1, expectUpdates: 2},
+		{name: "conflicts exhaust attempts", conflicts: 3, expectErr: true, expectUpdates: 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset()
+			var gets int
+			clientset.PrependReactor("get", "statefulsets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				gets++
+				return true, &autoscaling.Scale{
+					ObjectMeta: meta.ObjectMeta{Name: "mc", Namespace: "default", ResourceVersion: strconv.Itoa(gets)},
+				}, nil
+			})
+			var updates int
+			var resourceVersions []string
+			clientset.PrependReactor("update", "statefulsets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				updates++
+				scale := action.(k8stesting.UpdateAction).GetObject().(*autoscaling.Scale)
+				resourceVersions = append(resourceVersions, scale.ResourceVersion)
+				if updates <= test.conflicts {
+					return true, nil, apierrors.NewConflict(autoscaling.Resource("statefulsets"), "mc",
+						errors.New("the object has been modified"))
+				}
+				return true, scale, nil
+			})
+
+			watcher := &k8sWatcherImpl{
+				clientset: clientset,
+				mappings:  map[string]string{"mc-svc": "mc"},
+			}
+			watcher.UseScaleRetry(3, time.Millisecond)
+			service := &v1.Service{
+				ObjectMeta: meta.ObjectMeta{Name: "mc-svc", Namespace: "default"},
+			}
+
+			err := watcher.buildScaleUpFunction(service)(context.Background())
+			if test.expectErr {
+				assert.True(t, apierrors.IsConflict(err), "expected conflict, got %v", err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, test.expectUpdates, updates)
+			// each attempt updates from the latest scale
+			for i, resourceVersion := range resourceVersions {
+				assert.Equal(t, strconv.Itoa(i+1), resourceVersion)
+			}
+		})
+	}
+}
+
+func TestK8sWatcherImpl_UseLabelSelector(t *testing.T) {
+	watcher := &k8sWatcherImpl{}
+	assert.Error(t, watcher.UseLabelSelector("mc-router in ("))
+
+	var options meta.ListOptions
+	watcher.filterServices(&options)
+	assert.Equal(t, "", options.LabelSelector, "all services without a selector")
+
+	require.NoError(t, watcher.UseLabelSelector("mc-router=true,tier!=test"))
+	watcher.filterServices(&options)
+	assert.Equal(t, "mc-router=true,tier!=test", options.LabelSelector)
+}
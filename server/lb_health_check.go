@@ -0,0 +1,78 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/pires/go-proxyproto"
+	"github.com/pkg/errors"
+)
+
+// lbHealthChecks identifies the connections of load balancers that health check the listener by connecting and
+// closing, which are closed quietly rather than handled as clients
+type lbHealthChecks struct {
+	// sources are the addresses of the load balancers whose connections are all health checks
+	sources *addrMatcher
+	// closeDelay is how long connections from the sources are held open before being closed
+	closeDelay time.Duration
+	// empty treats connections that close without sending any data as health checks
+	empty bool
+}
+
+// UseLoadBalancerHealthChecks treats connections from the sources, given as IP addresses or CIDRs, as health checks of
+// load balancers in front of the listeners. They are closed, after the closeDelay if any, without being read, logged,
+// or counted. With empty set, connections that close without sending any data are also treated as health checks rather
+// than logged and counted as client aborts.
+func (c *Connector) UseLoadBalancerHealthChecks(sources []string, closeDelay time.Duration, empty bool) error {
+	matcher, err := newAddrMatcher(sources)
+	if err != nil {
+		return errors.Wrap(err, "invalid health check source")
+	}
+	c.lbHealthChecks = &lbHealthChecks{
+		sources:    matcher,
+		closeDelay: closeDelay,
+		empty:      empty,
+	}
+	return nil
+}
+
+// fromSource determines if the connection is from one of the sources, which are matched against the immediate peer
+// rather than the client given by a PROXY header, since health checks don't send one
+func (h *lbHealthChecks) fromSource(frontendConn net.Conn) bool {
+	if h == nil || h.sources.Empty() {
+		return false
+	}
+	remoteAddr := frontendConn.RemoteAddr()
+	if proxyConn, ok := frontendConn.(*proxyproto.Conn); ok {
+		// avoids reading a PROXY header
+		remoteAddr = proxyConn.Raw().RemoteAddr()
+	}
+	tcpAddr, ok := remoteAddr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	addr, ok := netip.AddrFromSlice(tcpAddr.IP)
+	return ok && h.sources.Match(addr.Unmap())
+}
+
+// serve closes the health check connection after the close delay or once the ctx is done
+func (h *lbHealthChecks) serve(ctx context.Context, frontendConn net.Conn) {
+	if h.closeDelay > 0 {
+		timer := time.NewTimer(h.closeDelay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+	}
+	_ = frontendConn.Close()
+}
+
+// isEmpty determines if the connection is a health check that closed without sending any data, where err is from
+// reading the first packet and inspectionBuffer is what was read
+func (h *lbHealthChecks) isEmpty(err error, inspectionBuffer *bytes.Buffer) bool {
+	return h != nil && h.empty && inspectionBuffer.Len() == 0 && isClientAbort(err)
+}
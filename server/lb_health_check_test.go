@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newLbHealthCheckTestConnector returns a connector whose frontend connections and errors are counted
+func newLbHealthCheckTestConnector(t *testing.T) (*Connector, *errorTypeCounter, *errorTypeCounter) {
+	clientFilter, err := NewClientFilter(nil, nil)
+	require.NoError(t, err)
+	frontendCounter := newErrorTypeCounter()
+	errorCounter := newErrorTypeCounter()
+	connectorMetrics := newTestConnectorMetrics()
+	connectorMetrics.ConnectionsFrontend = frontendCounter
+	connectorMetrics.Errors = errorCounter
+	return NewConnector(connectorMetrics, false, false, nil, clientFilter), frontendCounter, errorCounter
+}
+
+// handleTCPConnection handles a TCP connection from the returned client connection
+func handleTCPConnection(t *testing.T, c *Connector) (net.Conn, <-chan struct{}) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	//goland:noinspection GoUnhandledErrorResult
+	defer listener.Close()
+
+	clientConn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = clientConn.Close() })
+	routerConn, err := listener.Accept()
+	require.NoError(t, err)
+
+	handled := make(chan struct{})
+	go func() {
+		c.HandleConnection(context.Background(), routerConn)
+		close(handled)
+	}()
+	return clientConn, handled
+}
+
+func TestConnector_LoadBalancerHealthCheckSources(t *testing.T) {
+	c, frontendCounter, errorCounter := newLbHealthCheckTestConnector(t)
+	require.NoError(t, c.UseLoadBalancerHealthChecks([]string{"127.0.0.0/8"}, 50*time.Millisecond, false))
+
+	start := time.Now()
+	clientConn, handled := handleTCPConnection(t, c)
+	require.NoError(t, clientConn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err := clientConn.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond, "closed after the delay")
+	<-handled
+
+	assert.Equal(t, float64(0), frontendCounter.count(""))
+	assert.Empty(t, errorCounter.counts)
+}
+
+func TestConnector_LoadBalancerHealthCheckOtherSources(t *testing.T) {
+	c, frontendCounter, _ := newLbHealthCheckTestConnector(t)
+	require.NoError(t, c.UseLoadBalancerHealthChecks([]string{"10.0.0.0/8"}, 0, false))
+
+	clientConn, handled := handleTCPConnection(t, c)
+	require.NoError(t, clientConn.Close())
+	<-handled
+
+	assert.Equal(t, float64(1), frontendCounter.count(""))
+}
+
+func TestConnector_LoadBalancerHealthCheckEmpty(t *testing.T) {
+	for _, empty := range []bool{false, true} {
+		c, _, errorCounter := newLbHealthCheckTestConnector(t)
+		if empty {
+			require.NoError(t, c.UseLoadBalancerHealthChecks(nil, 0, true))
+		}
+
+		clientConn, handled := handleTCPConnection(t, c)
+		require.NoError(t, clientConn.Close())
+		<-handled
+
+		if empty {
+			assert.Empty(t, errorCounter.counts)
+		} else {
+			assert.Equal(t, float64(1), errorCounter.count("client_abort"))
+		}
+	}
+}
+
+func TestConnector_UseLoadBalancerHealthChecksInvalid(t *testing.T) {
+	c := newTestConnector(t)
+	assert.Error(t, c.UseLoadBalancerHealthChecks([]string{"10.0.0.0/33"}, 0, false))
+}
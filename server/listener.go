@@ -0,0 +1,73 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	listenerOptionReceiveProxyProto = "receive-proxy-protocol"
+	listenerOptionDefault           = "default"
+)
+
+// ListenerConfig declares an additional address to accept Minecraft client connections
+type ListenerConfig struct {
+	Address string
+	// ReceiveProxyProto overrides, when not nil, the connector-wide setting for receiving PROXY protocol
+	ReceiveProxyProto *bool
+	// DefaultBackend, when set, is the host:port of the backend for clients of this listener whose server address
+	// isn't mapped, which takes precedence over the client and global default routes
+	DefaultBackend string
+}
+
+// ParseListenerConfig parses a listener declared as [host]:port, optionally followed by semicolon delimited options.
+// The receive-proxy-protocol option may be given as a bare flag or with a boolean value, such as
+// ":25566;receive-proxy-protocol" or ":25565;receive-proxy-protocol=false". The default option sets the
+// listener's default backend, such as ":25566;default=lobby:25565".
+func ParseListenerConfig(value string) (ListenerConfig, error) {
+	parts := strings.Split(value, ";")
+	listenerConfig := ListenerConfig{
+		Address: strings.TrimSpace(parts[0]),
+	}
+	if listenerConfig.Address == "" {
+		return ListenerConfig{}, errors.Errorf("listener is missing an address: %s", value)
+	}
+
+	for _, option := range parts[1:] {
+		key, optionValue, hasValue := strings.Cut(strings.TrimSpace(option), "=")
+		switch key {
+		case listenerOptionReceiveProxyProto:
+			enabled := true
+			if hasValue {
+				var err error
+				enabled, err = strconv.ParseBool(optionValue)
+				if err != nil {
+					return ListenerConfig{}, errors.Wrapf(err, "invalid value for %s in listener %s", key, value)
+				}
+			}
+			listenerConfig.ReceiveProxyProto = &enabled
+		case listenerOptionDefault:
+			if optionValue == "" {
+				return ListenerConfig{}, errors.Errorf("missing value for %s in listener %s", key, value)
+			}
+			if err := ValidateBackend(optionValue); err != nil {
+				return ListenerConfig{}, errors.Wrapf(err, "invalid value for %s in listener %s", key, value)
+			}
+			listenerConfig.DefaultBackend = optionValue
+		default:
+			return ListenerConfig{}, errors.Errorf("unknown listener option %s in %s", key, value)
+		}
+	}
+
+	return listenerConfig, nil
+}
+
+// receivesProxyProto determines if the given listener expects PROXY protocol from its clients
+func (c *Connector) receivesProxyProto(listenerConfig ListenerConfig) bool {
+	if listenerConfig.ReceiveProxyProto != nil {
+		return *listenerConfig.ReceiveProxyProto
+	}
+	return c.receiveProxyProto
+}
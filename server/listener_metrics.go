@@ -0,0 +1,35 @@
+package server
+
+import (
+	"context"
+
+	"github.com/go-kit/kit/metrics"
+)
+
+// DefaultListenerName labels the metrics of the connections accepted by the listener of StartAcceptingConnections,
+// other than ngrok's, and of those handled by HandleConnection. Additional listeners are labelled by their address.
+const DefaultListenerName = "default"
+
+// NgrokListenerName labels the metrics of the connections accepted through the ngrok tunnel
+const NgrokListenerName = "ngrok"
+
+type listenerNameKey struct{}
+
+// withListenerName returns a context of the connections accepted by the named listener
+func withListenerName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, listenerNameKey{}, name)
+}
+
+// listenerName returns the name of the listener that accepted the connection of the ctx
+func listenerName(ctx context.Context) string {
+	if name, ok := ctx.Value(listenerNameKey{}).(string); ok {
+		return name
+	}
+	return DefaultListenerName
+}
+
+// connectionErrors returns the counter of the errors of the given type, labelled by the listener of the
+// connection of the ctx
+func (c *Connector) connectionErrors(ctx context.Context, errorType string) metrics.Counter {
+	return c.metrics.Errors.With("type", errorType, "listener", listenerName(ctx))
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// labelledCounter records the counts by the label values they were added with
+type labelledCounter struct {
+	mu     *sync.Mutex
+	counts map[string]float64
+	labels []string
+}
+
+func newLabelledCounter() *labelledCounter {
+	return &labelledCounter{mu: &sync.Mutex{}, counts: make(map[string]float64)}
+}
+
+func (c *labelledCounter) With(labelValues ...string) metrics.Counter {
+	return &labelledCounter{mu: c.mu, counts: c.counts, labels: append(append([]string{}, c.labels...), labelValues...)}
+}
+
+func (c *labelledCounter) Add(delta float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[strings.Join(c.labels, ",")] += delta
+}
+
+func (c *labelledCounter) count(labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts[strings.Join(labelValues, ",")]
+}
+
+func TestConnector_metricsLabelledByListener(t *testing.T) {
+	connections := newLabelledCounter()
+	errorCounter := newLabelledCounter()
+	c := newTestConnector(t)
+	c.metrics.ConnectionsFrontend = connections
+	c.metrics.Errors = errorCounter
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	//goland:noinspection GoUnhandledErrorResult
+	defer listener.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.acceptConnections(withListenerName(ctx, "lan"), listener, 5, "")
+
+	clientConn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	// a handshake that isn't one
+	_, err = clientConn.Write([]byte{0x03, 0xff, 0xff, 0xff})
+	require.NoError(t, err)
+	require.NoError(t, clientConn.Close())
+
+	assert.Eventually(t, func() bool {
+		return connections.count("listener", "lan") == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		errorCounter.mu.Lock()
+		defer errorCounter.mu.Unlock()
+		for labels := range errorCounter.counts {
+			if strings.HasSuffix(labels, ",listener,lan") {
+				return true
+			}
+		}
+		return false
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestListenerName(t *testing.T) {
+	assert.Equal(t, DefaultListenerName, listenerName(context.Background()))
+	assert.Equal(t, "0.0.0.0:25566", listenerName(withListenerName(context.Background(), "0.0.0.0:25566")))
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pires/go-proxyproto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseListenerConfig(t *testing.T) {
+	enabled, disabled := true, false
+
+	tests := []struct {
+		value    string
+		expected ListenerConfig
+	}{
+		{
+			value:    ":25566",
+			expected: ListenerConfig{Address: ":25566"},
+		},
+		{
+			value:    ":25566;receive-proxy-protocol",
+			expected: ListenerConfig{Address: ":25566", ReceiveProxyProto: &enabled},
+		},
+		{
+			value:    "127.0.0.1:25565; receive-proxy-protocol=false",
+			expected: ListenerConfig{Address: "127.0.0.1:25565", ReceiveProxyProto: &disabled},
+		},
+		{
+			value:    ":25566;default=lobby:25565;receive-proxy-protocol",
+			expected: ListenerConfig{Address: ":25566", ReceiveProxyProto: &enabled, DefaultBackend: "lobby:25565"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			listenerConfig, err := ParseListenerConfig(test.value)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, listenerConfig)
+		})
+	}
+}
+
+func TestParseListenerConfig_Invalid(t *testing.T) {
+	for _, value := range []string{"", ";receive-proxy-protocol", ":25566;receive-proxy-protocol=maybe", ":25566;unknown", ":25566;default", ":25566;default=lobby"} {
+		_, err := ParseListenerConfig(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestConnector_receivesProxyProto(t *testing.T) {
+	enabled, disabled := true, false
+
+	tests := []struct {
+		name     string
+		global   bool
+		override *bool
+		expected bool
+	}{
+		{name: "inherit disabled", global: false, override: nil, expected: false},
+		{name: "inherit enabled", global: true, override: nil, expected: true},
+		{name: "enabled on listener only", global: false, override: &enabled, expected: true},
+		{name: "disabled on exposed listener", global: true, override: &disabled, expected: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &Connector{receiveProxyProto: test.global}
+			assert.Equal(t, test.expected, c.receivesProxyProto(ListenerConfig{Address: ":0", ReceiveProxyProto: test.override}))
+		})
+	}
+}
+
+func TestConnector_createListener_PerListenerProxyProto(t *testing.T) {
+	c := &Connector{}
+	proxySource := &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 40000}
+
+	tests := []struct {
+		name              string
+		receiveProxyProto bool
+		expectProxiedAddr bool
+	}{
+		{name: "proxy listener", receiveProxyProto: true, expectProxiedAddr: true},
+		{name: "exposed listener", receiveProxyProto: false, expectProxiedAddr: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ln, err := c.createListener("127.0.0.1:0", test.receiveProxyProto)
+			require.NoError(t, err)
+			//goland:noinspection GoUnhandledErrorResult
+			defer ln.Close()
+
+			clientConn, err := net.Dial("tcp", ln.Addr().String())
+			require.NoError(t, err)
+			//goland:noinspection GoUnhandledErrorResult
+			defer clientConn.Close()
+
+			header := proxyproto.HeaderProxyFromAddrs(1, proxySource, ln.Addr())
+			_, err = header.WriteTo(clientConn)
+			require.NoError(t, err)
+
+			serverConn, err := ln.Accept()
+			require.NoError(t, err)
+			//goland:noinspection GoUnhandledErrorResult
+			defer serverConn.Close()
+			require.NoError(t, serverConn.SetDeadline(time.Now().Add(5*time.Second)))
+
+			if test.expectProxiedAddr {
+				assert.Equal(t, proxySource.String(), serverConn.RemoteAddr().String())
+			} else {
+				assert.Equal(t, clientConn.LocalAddr().String(), serverConn.RemoteAddr().String())
+			}
+		})
+	}
+}
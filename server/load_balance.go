@@ -0,0 +1,95 @@
+package server
+
+import (
+	"math/rand"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// BackendsDelimiter separates the backends of a route that is load balanced across more than one backend, such as
+// "lobby1:25565|lobby2:25565"
+const BackendsDelimiter = "|"
+
+// Values of the load balancing strategy given to UseLoadBalancing
+const (
+	LoadBalanceRoundRobin = "round-robin"
+	LoadBalanceLeastConn  = "least-conn"
+	LoadBalanceRandom     = "random"
+)
+
+// SplitBackends returns the backends of a route's backend, which is more than one when separated by BackendsDelimiter
+func SplitBackends(backend string) []string {
+	var backends []string
+	for _, b := range strings.Split(backend, BackendsDelimiter) {
+		if b = strings.TrimSpace(b); b != "" {
+			backends = append(backends, b)
+		}
+	}
+	return backends
+}
+
+// ValidateBackends checks each of the backends separated by BackendsDelimiter with ValidateBackend
+func ValidateBackends(backend string) error {
+	for _, b := range strings.Split(backend, BackendsDelimiter) {
+		if err := ValidateBackend(strings.TrimSpace(b)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseLoadBalancing sets how one of a route's backends is selected for each connection, which is one of
+// LoadBalanceRoundRobin, the default, LoadBalanceLeastConn, or LoadBalanceRandom. The connectionCount
+// provides the active connections of a backend for LoadBalanceLeastConn.
+func (r *routesImpl) UseLoadBalancing(strategy string, connectionCount func(backend string) int) error {
+	switch strategy {
+	case "", LoadBalanceRoundRobin, LoadBalanceRandom:
+	case LoadBalanceLeastConn:
+		if connectionCount == nil {
+			return errors.New("least-conn load balancing requires the connection counts of backends")
+		}
+	default:
+		return errors.Errorf("unsupported load balancing strategy %q", strategy)
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	r.loadBalance = strategy
+	r.connectionCount = connectionCount
+	return nil
+}
+
+// selectBackend returns one of the mapping's backends according to the load balancing strategy.
+// The caller must hold the read lock.
+func (r *routesImpl) selectBackend(m mapping) string {
+	if len(m.backends) <= 1 {
+		return m.backend
+	}
+
+	switch r.loadBalance {
+	case LoadBalanceRandom:
+		return m.backends[rand.Intn(len(m.backends))]
+
+	case LoadBalanceLeastConn:
+		// start from the next in rotation so that ties, such as during a burst of connections, are spread out
+		start := m.rotate()
+		selected := m.backends[start]
+		least := r.connectionCount(selected)
+		for i := 1; i < len(m.backends); i++ {
+			backend := m.backends[(start+i)%len(m.backends)]
+			if count := r.connectionCount(backend); count < least {
+				selected, least = backend, count
+			}
+		}
+		return selected
+
+	default:
+		return m.backends[m.rotate()]
+	}
+}
+
+// rotate returns the next round-robin position of the mapping's backends
+func (m mapping) rotate() int {
+	return int((m.next.Add(1) - 1) % uint64(len(m.backends)))
+}
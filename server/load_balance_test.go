@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitBackends(t *testing.T) {
+	assert.Equal(t, []string{"lobby1:25565", "lobby2:25565"}, SplitBackends("lobby1:25565| lobby2:25565"))
+	assert.Equal(t, []string{"lobby:25565"}, SplitBackends("lobby:25565"))
+	assert.Empty(t, SplitBackends(""))
+
+	assert.NoError(t, ValidateBackends("lobby1:25565|lobby2:25565"))
+	assert.Error(t, ValidateBackends("lobby1:25565|lobby2"))
+}
+
+func TestRoutes_LoadBalancing(t *testing.T) {
+	counts := map[string]int{"lobby1:25565": 3, "lobby2:25565": 1, "lobby3:25565": 2}
+
+	tests := []struct {
+		strategy string
+		want     []string
+	}{
+		{
+			strategy: LoadBalanceRoundRobin,
+			want:     []string{"lobby1:25565", "lobby2:25565", "lobby3:25565", "lobby1:25565"},
+		},
+		{
+			strategy: LoadBalanceLeastConn,
+			want:     []string{"lobby2:25565", "lobby2:25565", "lobby2:25565", "lobby2:25565"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			routes := NewRoutes()
+			require.NoError(t, routes.UseLoadBalancing(tt.strategy, func(backend string) int {
+				return counts[backend]
+			}))
+			routes.CreateMapping("lobby.example.com", "lobby1:25565|lobby2:25565|lobby3:25565", nil, RouteOptions{})
+
+			var selected []string
+			for range tt.want {
+				backend, _, _ := routes.FindBackendForServerAddress(context.Background(), "lobby.example.com")
+				selected = append(selected, backend)
+			}
+			assert.Equal(t, tt.want, selected)
+		})
+	}
+}
+
+func TestRoutes_LoadBalancingRandom(t *testing.T) {
+	routes := NewRoutes()
+	require.NoError(t, routes.UseLoadBalancing(LoadBalanceRandom, nil))
+	routes.CreateMapping("lobby.example.com", "lobby1:25565|lobby2:25565", nil, RouteOptions{})
+
+	for i := 0; i < 10; i++ {
+		backend, _, _ := routes.FindBackendForServerAddress(context.Background(), "lobby.example.com")
+		assert.Contains(t, []string{"lobby1:25565", "lobby2:25565"}, backend)
+	}
+	// the route is still described by all of its backends
+	assert.Equal(t, "lobby1:25565|lobby2:25565", routes.GetMappings()["lobby.example.com"])
+}
+
+func TestRoutes_UseLoadBalancing(t *testing.T) {
+	routes := NewRoutes()
+	assert.Error(t, routes.UseLoadBalancing("weighted", nil))
+	assert.Error(t, routes.UseLoadBalancing(LoadBalanceLeastConn, nil))
+	assert.NoError(t, routes.UseLoadBalancing("", nil))
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const maintenanceFilePollInterval = 2 * time.Second
+
+// WatchMaintenanceFile places the connector into maintenance mode while the given file exists.
+// While in maintenance mode, all clients are served the given message instead of being connected to a backend.
+func (c *Connector) WatchMaintenanceFile(ctx context.Context, filePath string, message string) {
+	c.maintenanceMessage = message
+	c.checkMaintenanceFile(filePath)
+
+	go func() {
+		ticker := time.NewTicker(maintenanceFilePollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				c.checkMaintenanceFile(filePath)
+
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (c *Connector) checkMaintenanceFile(filePath string) {
+	_, err := os.Stat(filePath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		logrus.WithError(err).WithField("file", filePath).Warn("Unable to check maintenance file")
+		return
+	}
+
+	active := err == nil
+	if c.maintenance.Swap(active) != active {
+		if active {
+			logrus.WithField("file", filePath).Info("Maintenance mode enabled")
+		} else {
+			logrus.WithField("file", filePath).Info("Maintenance mode disabled")
+		}
+	}
+}
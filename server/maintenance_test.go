@@ -0,0 +1,60 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/itzg/mc-router/mcproto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnector_checkMaintenanceFile(t *testing.T) {
+	c := NewConnector(newTestConnectorMetrics(), false, false, nil, nil)
+	filePath := filepath.Join(t.TempDir(), "maintenance")
+
+	c.checkMaintenanceFile(filePath)
+	assert.False(t, c.maintenance.Load())
+
+	require.NoError(t, os.WriteFile(filePath, nil, 0644))
+	c.checkMaintenanceFile(filePath)
+	assert.True(t, c.maintenance.Load())
+
+	require.NoError(t, os.Remove(filePath))
+	c.checkMaintenanceFile(filePath)
+	assert.False(t, c.maintenance.Load())
+}
+
+func TestConnector_MaintenanceLoginDisconnect(t *testing.T) {
+	c := newTestConnector(t)
+	c.maintenance.Store(true)
+	c.maintenanceMessage = "down for upgrades"
+
+	clientConn, routerConn := net.Pipe()
+	//goland:noinspection GoUnhandledErrorResult
+	defer clientConn.Close()
+	go c.HandleConnection(context.Background(), routerConn)
+
+	require.NoError(t, clientConn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	require.NoError(t, mcproto.WriteHandshake(clientConn, &mcproto.Handshake{
+		ProtocolVersion: 767,
+		ServerAddress:   "mc.example.com",
+		ServerPort:      25565,
+		NextState:       int(mcproto.StateLogin),
+	}))
+	require.NoError(t, mcproto.WriteLoginStart(clientConn, 767, &mcproto.LoginStart{Name: "itzg"}))
+
+	packet, err := mcproto.ReadPacket(clientConn, clientConn.LocalAddr(), mcproto.StateLogin)
+	require.NoError(t, err)
+	assert.Equal(t, mcproto.PacketIdLoginDisconnect, packet.PacketID)
+
+	message, err := mcproto.ReadString(bytes.NewReader(packet.Data.([]byte)))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"text":"down for upgrades"}`, message)
+}
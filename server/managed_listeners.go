@@ -0,0 +1,252 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrListenerExists is returned by AddListener when a listener was already added on the port
+var ErrListenerExists = errors.New("a listener was already added on the port")
+
+// ErrListenerNotFound is returned by RemoveListener when no listener was added on the port
+var ErrListenerNotFound = errors.New("no listener was added on the port")
+
+// ManagedListener describes a listener that was added at runtime, such as via the REST API
+type ManagedListener struct {
+	Address string `json:"address"`
+	Port    int    `json:"port"`
+	// ReceiveProxyProtocol is whether the listener expects PROXY protocol from its clients
+	ReceiveProxyProtocol bool   `json:"receiveProxyProtocol"`
+	DefaultBackend       string `json:"defaultBackend,omitempty"`
+}
+
+type managedListener struct {
+	info ManagedListener
+	ln   net.Listener
+	// done is closed once the listener stops accepting connections
+	done chan struct{}
+}
+
+// managedListeners are the listeners added at runtime, keyed by their port
+type managedListeners struct {
+	sync.Mutex
+	// ctx is given to the connections accepted by the listeners and closes the listeners when done
+	ctx           context.Context
+	connRateLimit int
+	listeners     map[int]*managedListener
+}
+
+// UseManagedListeners allows listeners to be added and removed at runtime with AddListener and RemoveListener, which
+// includes the /listeners endpoints of the REST API. The listeners accept up to connRateLimit connections per second
+// and are closed when the ctx is done.
+func (c *Connector) UseManagedListeners(ctx context.Context, connRateLimit int) {
+	m := &managedListeners{
+		ctx:           ctx,
+		connRateLimit: connRateLimit,
+		listeners:     make(map[int]*managedListener),
+	}
+	c.managedListeners = m
+
+	context.AfterFunc(ctx, func() {
+		m.Lock()
+		defer m.Unlock()
+		for port, listener := range m.listeners {
+			//goland:noinspection GoUnhandledErrorResult
+			listener.ln.Close()
+			delete(m.listeners, port)
+		}
+	})
+}
+
+// AddListener starts accepting Minecraft client connections on the listener's address, which fails when the
+// address can't be bound, such as when its port is in use
+func (c *Connector) AddListener(listenerConfig ListenerConfig) (ManagedListener, error) {
+	m := c.managedListeners
+	if m == nil {
+		return ManagedListener{}, errors.New("managed listeners are not enabled")
+	}
+	if m.ctx.Err() != nil {
+		return ManagedListener{}, errors.Wrap(m.ctx.Err(), "unable to add listener")
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	_, portValue, err := net.SplitHostPort(listenerConfig.Address)
+	if err != nil {
+		return ManagedListener{}, errors.Wrapf(err, "invalid listener address %s", listenerConfig.Address)
+	}
+	port, err := strconv.Atoi(portValue)
+	if err != nil {
+		return ManagedListener{}, errors.Wrapf(err, "invalid port of listener address %s", listenerConfig.Address)
+	}
+	if _, exists := m.listeners[port]; exists {
+		return ManagedListener{}, ErrListenerExists
+	}
+
+	receiveProxyProto := c.receivesProxyProto(listenerConfig)
+	ln, err := c.createListener(listenerConfig.Address, receiveProxyProto)
+	if err != nil {
+		return ManagedListener{}, err
+	}
+	// such as when the address declared port 0
+	if tcpAddr, ok := ln.Addr().(*net.TCPAddr); ok {
+		port = tcpAddr.Port
+	}
+
+	listener := &managedListener{
+		info: ManagedListener{
+			Address:              ln.Addr().String(),
+			Port:                 port,
+			ReceiveProxyProtocol: receiveProxyProto,
+			DefaultBackend:       listenerConfig.DefaultBackend,
+		},
+		ln:   ln,
+		done: make(chan struct{}),
+	}
+	m.listeners[port] = listener
+
+	go func() {
+		defer close(listener.done)
+		c.acceptConnections(withListenerName(m.ctx, listenerConfig.Address), ln, m.connRateLimit,
+			listenerConfig.DefaultBackend)
+	}()
+
+	return listener.info, nil
+}
+
+// RemoveListener stops accepting connections on the port of a listener that was added with AddListener. The
+// connections that were already accepted are left to complete.
+func (c *Connector) RemoveListener(port int) error {
+	m := c.managedListeners
+	if m == nil {
+		return ErrListenerNotFound
+	}
+
+	m.Lock()
+	listener, exists := m.listeners[port]
+	delete(m.listeners, port)
+	m.Unlock()
+	if !exists {
+		return ErrListenerNotFound
+	}
+
+	if err := listener.ln.Close(); err != nil {
+		return errors.Wrapf(err, "failed to close listener on port %d", port)
+	}
+	<-listener.done
+	logrus.WithField("listenAddress", listener.info.Address).Info("Stopped listening for Minecraft client connections")
+	return nil
+}
+
+// ManagedListeners returns the listeners that were added with AddListener, ordered by port
+func (c *Connector) ManagedListeners() []ManagedListener {
+	m := c.managedListeners
+	if m == nil {
+		return nil
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	result := make([]ManagedListener, 0, len(m.listeners))
+	for _, listener := range m.listeners {
+		result = append(result, listener.info)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Port < result[j].Port
+	})
+	return result
+}
+
+func (c *Connector) registerListenerApiRoutes() {
+	apiRoutes.Path("/listeners").Methods("GET").HandlerFunc(c.listenersListHandler)
+	apiRoutes.Path("/listeners").Methods("POST").HandlerFunc(c.listenersCreateHandler)
+	apiRoutes.Path("/listeners/{port}").Methods("DELETE").HandlerFunc(c.listenersDeleteHandler)
+}
+
+func (c *Connector) listenersListHandler(writer http.ResponseWriter, _ *http.Request) {
+	writeListenerJson(writer, http.StatusOK, c.ManagedListeners())
+}
+
+func (c *Connector) listenersCreateHandler(writer http.ResponseWriter, request *http.Request) {
+	var definition = struct {
+		Address string
+		// ReceiveProxyProtocol overrides, when set, the connector-wide setting for receiving PROXY protocol
+		ReceiveProxyProtocol *bool
+		DefaultBackend       string
+	}{}
+
+	//goland:noinspection GoUnhandledErrorResult
+	defer request.Body.Close()
+
+	decoder := json.NewDecoder(request.Body)
+	if err := decoder.Decode(&definition); err != nil {
+		logrus.WithError(err).Error("Unable to get request body")
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if definition.Address == "" {
+		http.Error(writer, "missing address", http.StatusBadRequest)
+		return
+	}
+	if err := ValidateBackend(definition.DefaultBackend); err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	listener, err := c.AddListener(ListenerConfig{
+		Address:           definition.Address,
+		ReceiveProxyProto: definition.ReceiveProxyProtocol,
+		DefaultBackend:    definition.DefaultBackend,
+	})
+	if err != nil {
+		logrus.WithError(err).WithField("address", definition.Address).Error("Unable to add listener")
+		// the port is most likely in use, whether by a listener of this or another process
+		http.Error(writer, err.Error(), http.StatusConflict)
+		return
+	}
+	writeListenerJson(writer, http.StatusCreated, listener)
+}
+
+func (c *Connector) listenersDeleteHandler(writer http.ResponseWriter, request *http.Request) {
+	port, err := strconv.Atoi(mux.Vars(request)["port"])
+	if err != nil {
+		http.Error(writer, "invalid port", http.StatusBadRequest)
+		return
+	}
+
+	if err := c.RemoveListener(port); err != nil {
+		if errors.Is(err, ErrListenerNotFound) {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		logrus.WithError(err).WithField("port", port).Error("Unable to remove listener")
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writer.WriteHeader(http.StatusOK)
+}
+
+func writeListenerJson(writer http.ResponseWriter, status int, value any) {
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to marshal listeners")
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	_, err = writer.Write(bytes)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to write response")
+	}
+}
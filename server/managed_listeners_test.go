@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnector_AddRemoveListener(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := newTestConnector(t)
+	c.UseManagedListeners(ctx, 10)
+
+	listener, err := c.AddListener(ListenerConfig{Address: "127.0.0.1:0", DefaultBackend: "lobby:25565"})
+	require.NoError(t, err)
+	assert.NotZero(t, listener.Port)
+	assert.Equal(t, "lobby:25565", listener.DefaultBackend)
+	assert.Equal(t, []ManagedListener{listener}, c.ManagedListeners())
+
+	conn, err := net.Dial("tcp", listener.Address)
+	require.NoError(t, err)
+	_ = conn.Close()
+
+	_, err = c.AddListener(ListenerConfig{Address: listener.Address})
+	assert.ErrorIs(t, err, ErrListenerExists)
+
+	// a port already in use, such as by another process, is rejected
+	inUse, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer inUse.Close()
+	_, err = c.AddListener(ListenerConfig{Address: inUse.Addr().String()})
+	assert.Error(t, err)
+
+	require.NoError(t, c.RemoveListener(listener.Port))
+	assert.Empty(t, c.ManagedListeners())
+	_, err = net.Dial("tcp", listener.Address)
+	assert.Error(t, err)
+
+	assert.ErrorIs(t, c.RemoveListener(listener.Port), ErrListenerNotFound)
+}
+
+func TestConnector_listenersApi(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := newTestConnector(t)
+	c.UseManagedListeners(ctx, 10)
+	router := mux.NewRouter()
+	router.Path("/listeners").Methods("POST").HandlerFunc(c.listenersCreateHandler)
+	router.Path("/listeners/{port}").Methods("DELETE").HandlerFunc(c.listenersDeleteHandler)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/listeners",
+		strings.NewReader(`{"address": "127.0.0.1:0", "defaultBackend": "not-a-backend"}`)))
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/listeners",
+		strings.NewReader(`{"address": "127.0.0.1:0", "receiveProxyProtocol": true}`)))
+	require.Equal(t, http.StatusCreated, recorder.Code)
+	listeners := c.ManagedListeners()
+	require.Len(t, listeners, 1)
+	assert.True(t, listeners[0].ReceiveProxyProtocol)
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodDelete, "/listeners/"+strconv.Itoa(listeners[0].Port), nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodDelete, "/listeners/"+strconv.Itoa(listeners[0].Port), nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
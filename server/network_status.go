@@ -0,0 +1,77 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/itzg/mc-router/mcproto"
+	"github.com/pkg/errors"
+)
+
+// networkStatusSampleLimit bounds the players combined into the sample of the network status, which is about as many
+// as a client shows when hovering over the player count
+const networkStatusSampleLimit = 12
+
+// NetworkStatus declares the status served to status requests for server addresses that aren't mapped, such as the
+// network's own address, in place of relaying to the default backend
+type NetworkStatus struct {
+	MOTD string
+	// Favicon can be a data URL, base64 encoded PNG content, or the path to a PNG file
+	Favicon string
+	// Routes are the server addresses of the routes whose cached players are combined, where all the routes are
+	// combined when empty
+	Routes []string
+	// MaxPlayers, when positive, is served as the maximum players rather than the sum of those of the routes
+	MaxPlayers int
+	// HideSample omits the combined sample of online players
+	HideSample bool
+}
+
+// UseNetworkStatus serves the given status, along with the players combined across the routes from the status cache,
+// to status requests for server addresses that aren't mapped
+func (c *Connector) UseNetworkStatus(status NetworkStatus) error {
+	favicon, err := loadFavicon(status.Favicon)
+	if err != nil {
+		return errors.Wrap(err, "invalid network status favicon")
+	}
+	status.Favicon = favicon
+	routes := make([]string, len(status.Routes))
+	for i, serverAddress := range status.Routes {
+		routes[i] = strings.ToLower(strings.TrimSpace(serverAddress))
+	}
+	status.Routes = routes
+	c.networkStatus = &status
+	return nil
+}
+
+// buildNetworkStatus combines the players cached for the routes in scope of the network status
+func (c *Connector) buildNetworkStatus() *mcproto.StatusResponse {
+	status := &mcproto.StatusResponse{
+		Description: mcproto.TextComponent{Text: c.networkStatus.MOTD},
+		Favicon:     c.networkStatus.Favicon,
+	}
+
+	serverAddresses := c.networkStatus.Routes
+	if len(serverAddresses) == 0 {
+		for serverAddress := range Routes.GetMappings() {
+			serverAddresses = append(serverAddresses, serverAddress)
+		}
+	}
+	if c.statusCache != nil {
+		for _, serverAddress := range serverAddresses {
+			players, ok := c.statusCache.GetPoolPlayers(serverAddress)
+			if !ok {
+				continue
+			}
+			status.Players.Online += players.Online
+			status.Players.Max += players.Max
+			if !c.networkStatus.HideSample {
+				remaining := networkStatusSampleLimit - len(status.Players.Sample)
+				status.Players.Sample = append(status.Players.Sample, players.Sample[:min(remaining, len(players.Sample))]...)
+			}
+		}
+	}
+	if c.networkStatus.MaxPlayers > 0 {
+		status.Players.Max = c.networkStatus.MaxPlayers
+	}
+	return status
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/itzg/mc-router/mcproto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnector_buildNetworkStatus(t *testing.T) {
+	Routes.Reset()
+	for _, serverAddress := range []string{"lobby.example.com", "survival.example.com", "creative.example.com"} {
+		Routes.CreateMapping(serverAddress, "backend:25565", nil, RouteOptions{})
+		defer Routes.DeleteMapping(serverAddress)
+	}
+
+	cache := NewStatusCache(dialTest, time.Minute)
+	cache.put("lobby.example.com", &mcproto.StatusResponse{Players: mcproto.StatusPlayers{
+		Max: 20, Online: 1, Sample: []mcproto.StatusPlayerSample{{Name: "alice"}},
+	}}, time.Now())
+	cache.put("survival.example.com", &mcproto.StatusResponse{Players: mcproto.StatusPlayers{
+		Max: 50, Online: 2, Sample: []mcproto.StatusPlayerSample{{Name: "bob"}, {Name: "carol"}},
+	}}, time.Now())
+	cache.put("creative.example.com", &mcproto.StatusResponse{Players: mcproto.StatusPlayers{Max: 10, Online: 4}}, time.Now())
+
+	tests := []struct {
+		name   string
+		status NetworkStatus
+		expect mcproto.StatusPlayers
+	}{
+		{
+			name:   "all routes",
+			status: NetworkStatus{MOTD: "Network"},
+			expect: mcproto.StatusPlayers{Max: 80, Online: 7,
+				Sample: []mcproto.StatusPlayerSample{{Name: "alice"}, {Name: "bob"}, {Name: "carol"}}},
+		},
+		{
+			name:   "scoped",
+			status: NetworkStatus{MOTD: "Network", Routes: []string{"Lobby.example.com", "creative.example.com"}},
+			expect: mcproto.StatusPlayers{Max: 30, Online: 5, Sample: []mcproto.StatusPlayerSample{{Name: "alice"}}},
+		},
+		{
+			name:   "max players without sample",
+			status: NetworkStatus{MOTD: "Network", MaxPlayers: 500, HideSample: true},
+			expect: mcproto.StatusPlayers{Max: 500, Online: 7},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestConnector(t)
+			c.UseStatusCache(cache)
+			require.NoError(t, c.UseNetworkStatus(tt.status))
+
+			status := c.buildNetworkStatus()
+			assert.Equal(t, "Network", status.Description.Text)
+			assert.Equal(t, tt.expect.Max, status.Players.Max)
+			assert.Equal(t, tt.expect.Online, status.Players.Online)
+			assert.ElementsMatch(t, tt.expect.Sample, status.Players.Sample)
+		})
+	}
+}
+
+func TestConnector_NetworkStatusForUnmappedAddress(t *testing.T) {
+	Routes.Reset()
+	Routes.SetDefaultRoute("lobby:25565")
+	defer Routes.SetDefaultRoute("")
+
+	c := newTestConnector(t)
+	require.NoError(t, c.UseNetworkStatus(NetworkStatus{MOTD: "Example Network"}))
+
+	content := new(bytes.Buffer)
+	require.NoError(t, mcproto.WriteHandshake(content, &mcproto.Handshake{
+		ProtocolVersion: 767,
+		ServerAddress:   "example.com",
+		ServerPort:      25565,
+		NextState:       int(mcproto.StateStatus),
+	}))
+	require.NoError(t, mcproto.WriteStatusRequest(content))
+
+	clientConn, routerConn := net.Pipe()
+	//goland:noinspection GoUnhandledErrorResult
+	defer clientConn.Close()
+	go c.HandleConnection(context.Background(), routerConn)
+	_, err := clientConn.Write(content.Bytes())
+	require.NoError(t, err)
+
+	require.NoError(t, clientConn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	packet, err := mcproto.ReadPacket(clientConn, clientConn.RemoteAddr(), mcproto.StateStatus)
+	require.NoError(t, err)
+	status, err := mcproto.ReadStatusResponse(packet.Data)
+	require.NoError(t, err)
+	assert.Equal(t, "Example Network", status.Description.Text)
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/itzg/mc-router/mcproto"
+	"github.com/pkg/errors"
+)
+
+// defaultAcceptedNextStates are the next states of handshakes that are accepted unless configured otherwise
+var defaultAcceptedNextStates = []mcproto.State{mcproto.StateStatus, mcproto.StateLogin, mcproto.StateTransfer}
+
+// nextStateNames are the names of the next states that may be given to ParseNextStates
+var nextStateNames = map[string]mcproto.State{
+	"status":   mcproto.StateStatus,
+	"login":    mcproto.StateLogin,
+	"transfer": mcproto.StateTransfer,
+}
+
+// UseAcceptedNextStates disconnects clients whose handshake has a next state other than the given ones, such as from
+// malformed handshakes, where none accepts status, login, and transfer
+func (c *Connector) UseAcceptedNextStates(states []mcproto.State) {
+	c.acceptedNextStates = states
+}
+
+// ParseNextStates parses the next states given by name, which is one of status, login, or transfer, or by number,
+// such as for states of future protocol versions
+func ParseNextStates(values []string) ([]mcproto.State, error) {
+	var states []mcproto.State
+	for _, value := range values {
+		value = strings.ToLower(strings.TrimSpace(value))
+		if value == "" {
+			continue
+		}
+		if state, ok := nextStateNames[value]; ok {
+			states = append(states, state)
+			continue
+		}
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, errors.Errorf("invalid next state %q, expected status, login, transfer, or a number", value)
+		}
+		if mcproto.State(parsed) <= mcproto.StateHandshaking {
+			return nil, errors.Errorf("invalid next state %d, which must be positive", parsed)
+		}
+		states = append(states, mcproto.State(parsed))
+	}
+	return states, nil
+}
+
+// acceptsNextState determines if clients whose handshake has the nextState are routed
+func (c *Connector) acceptsNextState(nextState int) bool {
+	accepted := c.acceptedNextStates
+	if len(accepted) == 0 {
+		accepted = defaultAcceptedNextStates
+	}
+	return slices.Contains(accepted, mcproto.State(nextState))
+}
+
+// isLoginIntent determines if the client proceeds to log in after the handshake with the nextState, which
+// includes clients that were transferred
+func isLoginIntent(nextState int) bool {
+	state := mcproto.State(nextState)
+	return state == mcproto.StateLogin || state == mcproto.StateTransfer
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/itzg/mc-router/mcproto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNextStates(t *testing.T) {
+	states, err := ParseNextStates([]string{"status", " Login", "transfer", "4", ""})
+	require.NoError(t, err)
+	assert.Equal(t, []mcproto.State{mcproto.StateStatus, mcproto.StateLogin, mcproto.StateTransfer, 4}, states)
+
+	_, err = ParseNextStates([]string{"play"})
+	assert.Error(t, err)
+	_, err = ParseNextStates([]string{"0"})
+	assert.Error(t, err)
+}
+
+func TestConnector_NextStates(t *testing.T) {
+	tests := []struct {
+		name     string
+		accepted []mcproto.State
+		rejected map[mcproto.State]bool
+	}{
+		{
+			name:     "default",
+			rejected: map[mcproto.State]bool{mcproto.StateHandshaking: true, 4: true, 127: true},
+		},
+		{
+			name:     "configured",
+			accepted: []mcproto.State{mcproto.StateStatus, mcproto.StateLogin, 4},
+			rejected: map[mcproto.State]bool{mcproto.StateHandshaking: true, mcproto.StateTransfer: true, 127: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, state := range []mcproto.State{
+				mcproto.StateHandshaking, mcproto.StateStatus, mcproto.StateLogin, mcproto.StateTransfer, 4, 127,
+			} {
+				errorCounter := newErrorTypeCounter()
+				connectorMetrics := newTestConnectorMetrics()
+				connectorMetrics.Errors = errorCounter
+				c := NewConnector(connectorMetrics, false, false, nil, nil)
+				c.UseAcceptedNextStates(tt.accepted)
+
+				assert.Equal(t, !tt.rejected[state], c.acceptsNextState(int(state)), "state %d", state)
+
+				clientConn, routerConn := net.Pipe()
+				handled := make(chan struct{})
+				go func() {
+					c.HandleConnection(context.Background(), routerConn)
+					close(handled)
+				}()
+				require.NoError(t, mcproto.WriteHandshake(clientConn, &mcproto.Handshake{
+					ProtocolVersion: 767,
+					ServerAddress:   "unmapped.example.com",
+					ServerPort:      25565,
+					NextState:       int(state),
+				}))
+				// ends the handling of accepted states, which otherwise continue reading from the client
+				_ = clientConn.Close()
+
+				select {
+				case <-handled:
+				case <-time.After(5 * time.Second):
+					t.Fatalf("connection with state %d was not handled", state)
+				}
+				if tt.rejected[state] {
+					assert.Equal(t, float64(1), errorCounter.count("next_state"), "state %d", state)
+				} else {
+					assert.Equal(t, float64(0), errorCounter.count("next_state"), "state %d", state)
+				}
+			}
+		})
+	}
+}
+
+func TestIsLoginIntent(t *testing.T) {
+	assert.False(t, isLoginIntent(int(mcproto.StateHandshaking)))
+	assert.False(t, isLoginIntent(int(mcproto.StateStatus)))
+	assert.True(t, isLoginIntent(int(mcproto.StateLogin)))
+	assert.True(t, isLoginIntent(int(mcproto.StateTransfer)))
+	assert.False(t, isLoginIntent(4))
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+const (
+	ConnectionEventConnect                 = "connect"
+	ConnectionEventDisconnect              = "disconnect"
+	ConnectionEventMissingBackend          = "missing-backend"
+	ConnectionEventFailedBackendConnection = "failed-backend-connection"
+)
+
+// EventRouteDefault is the route of connection events for clients that were routed to a default backend
+const EventRouteDefault = "default"
+
+// Route events are delivered, when enabled, along with connection events but have no client
+const (
+	RouteEventAdded   = "route-added"
+	RouteEventRemoved = "route-removed"
+)
+
+// ConnectionEvent describes a client connection event, or a route event, delivered to a ConnectionNotifier
+type ConnectionEvent struct {
+	Event     string    `json:"event"`
+	Timestamp time.Time `json:"timestamp"`
+	Client    string    `json:"client,omitempty"`
+	Server    string    `json:"server"`
+	// Route is the server address of the matched route, which may differ from the requested Server after
+	// normalization, or EventRouteDefault
+	Route           string      `json:"route,omitempty"`
+	Player          *PlayerInfo `json:"player,omitempty"`
+	BackendHostPort string      `json:"backend,omitempty"`
+	Error           string      `json:"error,omitempty"`
+	// Source is what registered the route of a route event, such as docker or k8s
+	Source string `json:"source,omitempty"`
+}
+
+// ConnectionNotifier is given the opportunity to react to client connection events,
+// such as informing an external system that a player connected to a backend.
+type ConnectionNotifier interface {
+	Notify(ctx context.Context, event *ConnectionEvent) error
+}
+
+func newConnectionEvent(event string, clientAddr net.Addr, serverAddress string, route string, playerInfo *PlayerInfo,
+	backendHostPort string, err error) *ConnectionEvent {
+	connectionEvent := &ConnectionEvent{
+		Event:           event,
+		Timestamp:       time.Now(),
+		Client:          clientAddr.String(),
+		Server:          serverAddress,
+		Route:           route,
+		Player:          playerInfo,
+		BackendHostPort: backendHostPort,
+	}
+	if err != nil {
+		connectionEvent.Error = err.Error()
+	}
+	return connectionEvent
+}
+
+func newRouteEvent(event string, serverAddress string, backendHostPort string, source string) *ConnectionEvent {
+	return &ConnectionEvent{
+		Event:           event,
+		Timestamp:       time.Now(),
+		Server:          serverAddress,
+		BackendHostPort: backendHostPort,
+		Source:          source,
+	}
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"io"
+	"net"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/itzg/mc-router/mcproto"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultLoginStartTimeout is used when the connector hasn't been configured with a login start timeout
+const defaultLoginStartTimeout = 2 * time.Second
+
+// PlayerInfo identifies the player from the login start packet
+type PlayerInfo struct {
+	Name string    `json:"name"`
+	UUID uuid.UUID `json:"uuid"`
+}
+
+func (p *PlayerInfo) String() string {
+	if p == nil {
+		return ""
+	}
+	return p.Name + "/" + p.UUID.String()
+}
+
+// UseLoginStartHandling configures how long to wait for the login start packet that follows a login handshake
+// and if clients should be rejected when the player info can't be read from it.
+func (c *Connector) UseLoginStartHandling(timeout time.Duration, requirePlayerInfo bool) {
+	c.loginStartTimeout = timeout
+	c.requirePlayerInfo = requirePlayerInfo
+}
+
+// readPlayerInfo reads the login start packet that follows a login handshake in order to identify the player.
+// The packet is parsed on a best-effort basis, so the UUID may be unset for layouts that don't match the protocol
+// version. An error is returned when the packet could not be read in time or was truncated, such as some clients
+// trigger under packet loss.
+func (c *Connector) readPlayerInfo(frontendConn net.Conn, clientAddr net.Addr, reader io.Reader, protocolVersion int) (*PlayerInfo, error) {
+	timeout := c.loginStartTimeout
+	if timeout <= 0 {
+		timeout = defaultLoginStartTimeout
+	}
+	if err := frontendConn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, errors.Wrap(err, "failed to set login start read deadline")
+	}
+
+	packet, err := mcproto.ReadPacket(reader, clientAddr, mcproto.StateLogin)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read login start packet")
+	}
+	c.observeFrameLength(packet)
+	if packet.PacketID != mcproto.PacketIdLogin {
+		return nil, errors.Errorf("expected login start packet, got packetID %d", packet.PacketID)
+	}
+
+	loginStart, err := mcproto.ReadLoginStartBestEffort(packet.Data, protocolVersion)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse login start packet")
+	}
+
+	playerInfo := &PlayerInfo{
+		Name: loginStart.Name,
+		UUID: loginStart.PlayerUUID,
+	}
+	logrus.
+		WithField("client", clientAddr).
+		WithField("player", playerInfo).
+		Debug("Got player info")
+	return playerInfo, nil
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/itzg/mc-router/mcproto"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Values accepted for the response to connections that are clearly not Minecraft clients, such as port scanners,
+// browsers, and TLS probes
+const (
+	// ProbeResponseNone reads probes like any other connection, which logs the resulting parse error
+	ProbeResponseNone = "none"
+	// ProbeResponseClose closes probes with only a debug log
+	ProbeResponseClose = "close"
+	// ProbeResponseBanner responds to HTTP requests with a 400 status and the banner, and closes other probes
+	ProbeResponseBanner = "banner"
+)
+
+// probeSniffLength is the number of leading bytes inspected, where any Minecraft handshake is longer
+const probeSniffLength = 4
+
+// httpProbePrefixes are the leading bytes of HTTP/1.x requests and the HTTP/2 connection preface
+var httpProbePrefixes = [][]byte{
+	[]byte("GET "),
+	[]byte("HEAD"),
+	[]byte("POST"),
+	[]byte("PUT "),
+	[]byte("DELE"),
+	[]byte("OPTI"),
+	[]byte("PATC"),
+	[]byte("CONN"),
+	[]byte("TRAC"),
+	[]byte("PRI "),
+}
+
+type probeKind string
+
+const (
+	probeKindHTTP probeKind = "http"
+	probeKindTLS  probeKind = "tls"
+)
+
+// sniffProbe identifies the kind of non-Minecraft protocol from the leading bytes, if any
+func sniffProbe(leading []byte) (probeKind, bool) {
+	for _, prefix := range httpProbePrefixes {
+		if bytes.HasPrefix(leading, prefix) {
+			return probeKindHTTP, true
+		}
+	}
+	// a TLS handshake record followed by the major version 3, which a Minecraft frame of the same
+	// length can't start with since its packet ID would not be the handshake
+	if len(leading) >= 2 && leading[0] == 0x16 && leading[1] == 0x03 {
+		return probeKindTLS, true
+	}
+	return "", false
+}
+
+// UseProbeResponse configures how to respond to connections that are clearly not Minecraft clients, where mode
+// is one of ProbeResponseNone, ProbeResponseClose, or ProbeResponseBanner. The banner is written to such
+// connections with ProbeResponseBanner.
+func (c *Connector) UseProbeResponse(mode string, banner string) error {
+	switch mode {
+	case "", ProbeResponseNone:
+		c.probeResponse = ""
+	case ProbeResponseClose, ProbeResponseBanner:
+		c.probeResponse = mode
+	default:
+		return errors.Errorf("unsupported probe response: %s", mode)
+	}
+	c.probeBanner = banner
+	return nil
+}
+
+// handleProbe peeks at the leading bytes of the connection and responds to it when it is clearly not a
+// Minecraft client. Returns true when the connection was a probe and should be closed.
+func (c *Connector) handleProbe(ctx context.Context, frontendConn net.Conn, clientAddr net.Addr,
+	reader *bufio.Reader) bool {
+	if c.probeResponse == "" {
+		return false
+	}
+
+	// errors, such as a client sending fewer bytes, are left to the packet reading that follows
+	first, err := reader.Peek(1)
+	if err != nil || first[0] == mcproto.PacketIdLegacyServerListPing {
+		// very old clients only send the one byte of the legacy server list ping
+		return false
+	}
+	leading, _ := reader.Peek(probeSniffLength)
+	kind, ok := sniffProbe(leading)
+	if !ok {
+		return false
+	}
+
+	logrus.
+		WithField("client", clientAddr).
+		WithField("kind", kind).
+		Debug("Closing connection that is not a Minecraft client")
+	c.connectionErrors(ctx, "probe").Add(1)
+
+	if c.probeResponse == ProbeResponseBanner && kind == probeKindHTTP {
+		if _, err := frontendConn.Write(httpProbeResponse(c.probeBanner)); err != nil {
+			logrus.
+				WithError(err).
+				WithField("client", clientAddr).
+				Debug("Failed to write probe banner")
+		}
+	}
+	return true
+}
+
+func httpProbeResponse(banner string) []byte {
+	body := banner + "\n"
+	return []byte(fmt.Sprintf("HTTP/1.1 400 Bad Request\r\n"+
+		"Content-Type: text/plain; charset=utf-8\r\n"+
+		"Content-Length: %d\r\n"+
+		"Connection: close\r\n"+
+		"\r\n"+
+		"%s", len(body), body))
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/itzg/mc-router/mcproto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSniffProbe(t *testing.T) {
+	var handshake bytes.Buffer
+	require.NoError(t, mcproto.WriteHandshake(&handshake, &mcproto.Handshake{
+		ProtocolVersion: 767,
+		ServerAddress:   "GET.example.com",
+		ServerPort:      25565,
+		NextState:       int(mcproto.StateStatus),
+	}))
+
+	tests := []struct {
+		name     string
+		leading  []byte
+		wantKind probeKind
+		wantOk   bool
+	}{
+		{name: "http get", leading: []byte("GET / HTTP/1.1\r\n"), wantKind: probeKindHTTP, wantOk: true},
+		{name: "http2 preface", leading: []byte("PRI * HTTP/2.0\r\n"), wantKind: probeKindHTTP, wantOk: true},
+		{name: "tls client hello", leading: []byte{0x16, 0x03, 0x01, 0x02}, wantKind: probeKindTLS, wantOk: true},
+		{name: "handshake", leading: handshake.Bytes()[:probeSniffLength]},
+		{name: "legacy ping", leading: []byte{0xFE, 0x01, 0xFA, 0x00}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kind, ok := sniffProbe(tt.leading)
+			assert.Equal(t, tt.wantOk, ok)
+			assert.Equal(t, tt.wantKind, kind)
+		})
+	}
+}
+
+func TestConnector_UseProbeResponse(t *testing.T) {
+	c := newTestConnector(t)
+	assert.NoError(t, c.UseProbeResponse(ProbeResponseNone, ""))
+	assert.Empty(t, c.probeResponse)
+	assert.NoError(t, c.UseProbeResponse(ProbeResponseBanner, "hello"))
+	assert.Equal(t, ProbeResponseBanner, c.probeResponse)
+	assert.Error(t, c.UseProbeResponse("reply", ""))
+}
+
+func TestConnector_ProbeBanner(t *testing.T) {
+	errorCounter := newErrorTypeCounter()
+	connectorMetrics := newTestConnectorMetrics()
+	connectorMetrics.Errors = errorCounter
+	clientFilter, err := NewClientFilter(nil, nil)
+	require.NoError(t, err)
+	c := NewConnector(connectorMetrics, false, false, nil, clientFilter)
+	require.NoError(t, c.UseProbeResponse(ProbeResponseBanner, "This is a Minecraft server port"))
+
+	clientConn, routerConn := net.Pipe()
+	handled := make(chan struct{})
+	go func() {
+		c.HandleConnection(context.Background(), routerConn)
+		close(handled)
+	}()
+
+	go func() {
+		_, _ = clientConn.Write([]byte("GET / HTTP/1.1\r\nHost: mc.example.com\r\n\r\n"))
+	}()
+	require.NoError(t, clientConn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	response, err := io.ReadAll(clientConn)
+	require.NoError(t, err)
+
+	select {
+	case <-handled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection was not handled")
+	}
+	assert.True(t, strings.HasPrefix(string(response), "HTTP/1.1 400 Bad Request\r\n"))
+	assert.True(t, strings.HasSuffix(string(response), "\r\n\r\nThis is a Minecraft server port\n"))
+	assert.Equal(t, float64(1), errorCounter.count("probe"))
+	assert.Equal(t, float64(0), errorCounter.count("read"))
+}
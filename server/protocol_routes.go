@@ -0,0 +1,107 @@
+package server
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/itzg/mc-router/mcproto"
+	"github.com/pkg/errors"
+)
+
+// ProtocolRoute is the backend for clients with a protocol version in a range whose server address isn't mapped
+type ProtocolRoute struct {
+	// Min and Max are the inclusive range of protocol versions, where Max is negative when the range is open-ended
+	Min     int
+	Max     int
+	Backend string
+}
+
+// Contains determines if the protocol version is in the route's range
+func (r ProtocolRoute) Contains(protocolVersion int) bool {
+	return protocolVersion >= r.Min && (r.Max < 0 || protocolVersion <= r.Max)
+}
+
+// ParseProtocolRoutes parses the backends keyed by a range of protocol versions, such as "47-340" = "pvp:25565".
+// A range may be open-ended, such as "770-" or "-340", or a single protocol version. Since at most one backend
+// can be selected for a protocol version, overlapping ranges are an error. The routes are ordered by their ranges.
+func ParseProtocolRoutes(mappings map[string]string) ([]ProtocolRoute, error) {
+	routes := make([]ProtocolRoute, 0, len(mappings))
+	for protocols, backend := range mappings {
+		minVersion, maxVersion, err := parseProtocolRange(protocols)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid protocol versions of route %s", protocols)
+		}
+		if backend == "" {
+			return nil, errors.Errorf("missing backend of protocol route for %s", protocols)
+		}
+		if err := ValidateBackends(backend); err != nil {
+			return nil, errors.Wrapf(err, "invalid backend of protocol route for %s", protocols)
+		}
+		routes = append(routes, ProtocolRoute{Min: minVersion, Max: maxVersion, Backend: backend})
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].Min < routes[j].Min
+	})
+	for i := 1; i < len(routes); i++ {
+		previous := routes[i-1]
+		if previous.Max < 0 || previous.Max >= routes[i].Min {
+			return nil, errors.Errorf("protocol routes for %s and %s overlap", previous.Backend, routes[i].Backend)
+		}
+	}
+	return routes, nil
+}
+
+// parseProtocolRange parses a range of protocol versions, where an omitted start is 0 and an omitted end is
+// returned as -1 to indicate the range is open-ended
+func parseProtocolRange(value string) (int, int, error) {
+	value = strings.TrimSpace(value)
+	start, end, isRange := strings.Cut(value, "-")
+	if !isRange {
+		end = start
+	}
+	start, end = strings.TrimSpace(start), strings.TrimSpace(end)
+	if start == "" && end == "" {
+		return 0, 0, errors.Errorf("%q is not a protocol version or range", value)
+	}
+
+	minVersion := 0
+	if start != "" {
+		var err error
+		if minVersion, err = strconv.Atoi(start); err != nil || minVersion < 0 {
+			return 0, 0, errors.Errorf("%q is not a protocol version", start)
+		}
+	}
+	maxVersion := -1
+	if end != "" {
+		var err error
+		if maxVersion, err = strconv.Atoi(end); err != nil || maxVersion < 0 {
+			return 0, 0, errors.Errorf("%q is not a protocol version", end)
+		}
+		if maxVersion < minVersion {
+			return 0, 0, errors.Errorf("protocol range %q ends before it starts", value)
+		}
+	}
+	return minVersion, maxVersion, nil
+}
+
+// UseProtocolRoutes selects the backend by the client's protocol version for server addresses that aren't mapped,
+// which takes precedence over the listener, client, and global default routes
+func (c *Connector) UseProtocolRoutes(routes []ProtocolRoute) {
+	c.protocolRoutes = routes
+}
+
+// selectProtocolBackend returns the backend of the protocol route containing the client's protocol version, if any.
+// Legacy server list pings have no handshake, so are not routed by protocol version.
+func (c *Connector) selectProtocolBackend(handshake *mcproto.Handshake) string {
+	if handshake == nil {
+		return ""
+	}
+	for _, route := range c.protocolRoutes {
+		if route.Contains(handshake.ProtocolVersion) {
+			return route.Backend
+		}
+	}
+	return ""
+}
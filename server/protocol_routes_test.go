@@ -0,0 +1,57 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/itzg/mc-router/mcproto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseProtocolRoutes(t *testing.T) {
+	routes, err := ParseProtocolRoutes(map[string]string{
+		"770-":   "survival:25565",
+		"47-340": "pvp:25565",
+		"-46":    "legacy:25565",
+		"767":    "exact:25565",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []ProtocolRoute{
+		{Min: 0, Max: 46, Backend: "legacy:25565"},
+		{Min: 47, Max: 340, Backend: "pvp:25565"},
+		{Min: 767, Max: 767, Backend: "exact:25565"},
+		{Min: 770, Max: -1, Backend: "survival:25565"},
+	}, routes)
+
+	for _, invalid := range []map[string]string{
+		{"-": "backend:25565"},
+		{"1.8": "backend:25565"},
+		{"340-47": "backend:25565"},
+		{"47-340": ""},
+		{"47-340": "backend"},
+		{"47-340": "a:25565", "340-400": "b:25565"},
+		{"770-": "a:25565", "800": "b:25565"},
+	} {
+		_, err := ParseProtocolRoutes(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestConnector_selectProtocolBackend(t *testing.T) {
+	c := newTestConnector(t)
+	routes, err := ParseProtocolRoutes(map[string]string{
+		"47-340": "pvp:25565",
+		"770-":   "survival:25565",
+	})
+	require.NoError(t, err)
+	c.UseProtocolRoutes(routes)
+
+	handshakeOf := func(protocolVersion int) *mcproto.Handshake {
+		return &mcproto.Handshake{ProtocolVersion: protocolVersion}
+	}
+	assert.Equal(t, "pvp:25565", c.selectProtocolBackend(handshakeOf(47)))
+	assert.Equal(t, "pvp:25565", c.selectProtocolBackend(handshakeOf(340)))
+	assert.Equal(t, "survival:25565", c.selectProtocolBackend(handshakeOf(773)))
+	assert.Empty(t, c.selectProtocolBackend(handshakeOf(767)))
+	assert.Empty(t, c.selectProtocolBackend(nil))
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+
+	"github.com/pires/go-proxyproto"
+	"github.com/sirupsen/logrus"
+)
+
+// UseProxyHeaderFallback handles the connections whose received PROXY header is malformed or partial as direct
+// connections from the immediate peer, continuing with the data that followed the header, rather than closing them.
+// It is to be called before listening.
+func (c *Connector) UseProxyHeaderFallback(fallback bool) {
+	c.proxyHeaderFallback = fallback
+}
+
+// checkProxyHeader reads the PROXY header, if any, of the frontend connection. When the header can't be read, the
+// connection is to be closed unless, with the fallback, it is returned as a direct connection.
+func (c *Connector) checkProxyHeader(ctx context.Context, frontendConn net.Conn) (net.Conn, bool) {
+	proxyConn, ok := frontendConn.(*proxyproto.Conn)
+	if !ok {
+		return frontendConn, true
+	}
+	recording, _ := proxyConn.Raw().(*recordingConn)
+
+	// reading nothing only reads the header, where its error is retained rather than only closing the connection
+	if _, err := proxyConn.Read(nil); err != nil {
+		logger := logrus.
+			WithError(err).
+			WithField("upstream", proxyConn.Raw().RemoteAddr())
+		c.connectionErrors(ctx, "proxy_header").Add(1)
+		if recording == nil {
+			logger.Warn("Closing connection whose PROXY header could not be read")
+			return nil, false
+		}
+		logger.Warn("Handling connection whose PROXY header could not be read as a direct connection")
+		return recording.direct(), true
+	}
+
+	if recording != nil {
+		recording.stopRecording()
+	}
+	return frontendConn, true
+}
+
+// recordingListener records what is read from its connections until their PROXY header has been read, so that a
+// connection can fall back to being handled as a direct connection
+type recordingListener struct {
+	net.Listener
+}
+
+func (l *recordingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &recordingConn{Conn: conn, recording: true}, nil
+}
+
+// recordingConn records what is read, which is bounded by the length of a PROXY header and the read-ahead of its
+// parsing, until recording is stopped
+type recordingConn struct {
+	net.Conn
+	recording bool
+	recorded  []byte
+}
+
+func (r *recordingConn) Read(b []byte) (int, error) {
+	n, err := r.Conn.Read(b)
+	if r.recording {
+		r.recorded = append(r.recorded, b[:n]...)
+	}
+	return n, err
+}
+
+// WriteTo uses the copying of the underlying connection, such as splicing, once no longer recording
+func (r *recordingConn) WriteTo(w io.Writer) (int64, error) {
+	if r.recording {
+		return io.Copy(w, struct{ io.Reader }{r})
+	}
+	return io.Copy(w, r.Conn)
+}
+
+func (r *recordingConn) stopRecording() {
+	r.recording = false
+	r.recorded = nil
+}
+
+// direct returns the connection without its PROXY header, which reads what followed the header that failed to
+// be read and then the rest of the connection
+func (r *recordingConn) direct() net.Conn {
+	recorded := r.recorded
+	r.stopRecording()
+
+	reader := bufio.NewReader(bytes.NewReader(recorded))
+	// reading the recorded header again consumes it the same way, so only what followed it remains
+	_, _ = proxyproto.Read(reader)
+	return &directConn{Conn: r.Conn, reader: io.MultiReader(reader, r.Conn)}
+}
+
+// directConn reads from the reader rather than the connection
+type directConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (d *directConn) Read(b []byte) (int, error) {
+	return d.reader.Read(b)
+}
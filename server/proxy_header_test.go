@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnector_checkProxyHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		fallback bool
+		sent     string
+		// expectedAddr is the IP of the client, where empty expects the connection to be closed
+		expectedAddr string
+		// expectedData is what follows the header
+		expectedData string
+		expectedErr  bool
+	}{
+		{
+			name:         "valid",
+			sent:         "PROXY TCP4 192.0.2.1 192.0.2.2 51234 25565\r\nhello",
+			expectedAddr: "192.0.2.1",
+			expectedData: "hello",
+		},
+		{
+			name:        "truncated",
+			sent:        "PROXY TCP4 192.0.2.1 192.0",
+			expectedErr: true,
+		},
+		{
+			name:         "truncated with fallback",
+			fallback:     true,
+			sent:         "PROXY TCP4 192.0.2.1 192.0",
+			expectedAddr: "127.0.0.1",
+			expectedErr:  true,
+		},
+		{
+			name:         "malformed with fallback",
+			fallback:     true,
+			sent:         "PROXY TCP4 192.0.2.1 192.0.2.2 51234 99999\r\nhello",
+			expectedAddr: "127.0.0.1",
+			expectedData: "hello",
+			expectedErr:  true,
+		},
+		{
+			name:         "absent with fallback",
+			fallback:     true,
+			sent:         "hello",
+			expectedAddr: "127.0.0.1",
+			expectedData: "hello",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestConnector(t)
+			errorCounter := newErrorTypeCounter()
+			c.metrics.Errors = errorCounter
+			c.UseProxyHeaderFallback(tt.fallback)
+
+			listener, err := c.createListener("127.0.0.1:0", true)
+			require.NoError(t, err)
+			//goland:noinspection GoUnhandledErrorResult
+			defer listener.Close()
+
+			clientConn, err := net.Dial("tcp", listener.Addr().String())
+			require.NoError(t, err)
+			//goland:noinspection GoUnhandledErrorResult
+			defer clientConn.Close()
+			_, err = clientConn.Write([]byte(tt.sent))
+			require.NoError(t, err)
+
+			frontendConn, err := listener.Accept()
+			require.NoError(t, err)
+			//goland:noinspection GoUnhandledErrorResult
+			defer frontendConn.Close()
+			require.NoError(t, frontendConn.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+			conn, ok := c.checkProxyHeader(context.Background(), frontendConn)
+			if tt.expectedErr {
+				assert.Equal(t, float64(1), errorCounter.count("proxy_header"))
+			} else {
+				assert.Empty(t, errorCounter.counts)
+			}
+			if tt.expectedAddr == "" {
+				assert.False(t, ok)
+				return
+			}
+			require.True(t, ok)
+			assert.Equal(t, tt.expectedAddr, conn.RemoteAddr().(*net.TCPAddr).IP.String())
+
+			// the client continues after the header
+			_, err = clientConn.Write([]byte(" there"))
+			require.NoError(t, err)
+			expected := tt.expectedData + " there"
+			data := make([]byte, len(expected))
+			_, err = io.ReadFull(conn, data)
+			require.NoError(t, err)
+			assert.Equal(t, expected, string(data))
+		})
+	}
+}
@@ -0,0 +1,24 @@
+package server
+
+import "github.com/pkg/errors"
+
+// DefaultProxyProtocolVersion is the binary version of the PROXY headers sent to backends
+const DefaultProxyProtocolVersion = 2
+
+// UseProxyProtocolVersion sets the version of the PROXY headers sent to backends, which is 2, the binary form, or 1,
+// the text form understood by older backends. Version 1 headers carry no TLVs.
+func (c *Connector) UseProxyProtocolVersion(version int) error {
+	if version != 1 && version != 2 {
+		return errors.Errorf("unsupported PROXY protocol version %d, expected 1 or 2", version)
+	}
+	c.proxyProtoVersion = byte(version)
+	return nil
+}
+
+// sentProxyProtoVersion is the version of the PROXY headers sent to backends
+func (c *Connector) sentProxyProtoVersion() byte {
+	if c.proxyProtoVersion == 0 {
+		return DefaultProxyProtocolVersion
+	}
+	return c.proxyProtoVersion
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/itzg/mc-router/mcproto"
+	"github.com/pires/go-proxyproto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnector_UseProxyProtocolVersion(t *testing.T) {
+	backendListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	//goland:noinspection GoUnhandledErrorResult
+	defer backendListener.Close()
+
+	Routes.Reset()
+	defer Routes.DeleteMapping("mc.example.com")
+	Routes.CreateMapping("mc.example.com", backendListener.Addr().String(), nil, RouteOptions{})
+
+	for _, version := range []int{1, 2} {
+		t.Run(strconv.Itoa(version), func(t *testing.T) {
+			clientFilter, err := NewClientFilter(nil, nil)
+			require.NoError(t, err)
+			c := NewConnector(newTestConnectorMetrics(), true, false, nil, clientFilter)
+			require.NoError(t, c.UseProxyProtocolVersion(version))
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			clientConn, routerConn := tcpPair(t)
+			go c.HandleConnection(ctx, routerConn)
+			go func() {
+				_ = mcproto.WriteHandshake(clientConn, &mcproto.Handshake{
+					ProtocolVersion: 767,
+					ServerAddress:   "mc.example.com",
+					ServerPort:      25565,
+					NextState:       int(mcproto.StateStatus),
+				})
+			}()
+
+			require.NoError(t, backendListener.(*net.TCPListener).SetDeadline(time.Now().Add(5*time.Second)))
+			backendConn, err := backendListener.Accept()
+			require.NoError(t, err)
+			//goland:noinspection GoUnhandledErrorResult
+			defer backendConn.Close()
+			require.NoError(t, backendConn.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+			reader := bufio.NewReader(backendConn)
+			if version == 1 {
+				line, err := reader.ReadString('\n')
+				require.NoError(t, err)
+				assert.True(t, strings.HasPrefix(line, "PROXY TCP4 127.0.0.1 127.0.0.1 "), line)
+				return
+			}
+			header, err := proxyproto.Read(reader)
+			require.NoError(t, err)
+			assert.Equal(t, byte(2), header.Version)
+			assert.Equal(t, clientConn.LocalAddr().String(), header.SourceAddr.String())
+		})
+	}
+
+	assert.Error(t, newTestConnector(t).UseProxyProtocolVersion(3))
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/pires/go-proxyproto"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// UseProxyProtoTLVForwarding copies the TLVs of the given types from the PROXY header received from the client,
+// if any, into the PROXY header sent to the backend
+func (c *Connector) UseProxyProtoTLVForwarding(types []proxyproto.PP2Type) {
+	c.forwardTLVTypes = types
+}
+
+// UseProxyHeaderForwarding sends the PROXY header received from the client, if any, to the backend, including all
+// its TLVs except the checksum, rather than a header of the client's address and only the forwarded TLVs
+func (c *Connector) UseProxyHeaderForwarding(forward bool) {
+	c.forwardProxyHeader = forward
+}
+
+// ParseTLVTypes parses PROXY protocol v2 TLV types given in decimal or, with a 0x prefix, hexadecimal
+func ParseTLVTypes(values []string) ([]proxyproto.PP2Type, error) {
+	var types []proxyproto.PP2Type
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.ParseUint(value, 0, 8)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid TLV type %q", value)
+		}
+		tlvType := proxyproto.PP2Type(parsed)
+		// the checksum covers the received header, so would be wrong for the header sent to the backend
+		if tlvType == proxyproto.PP2_TYPE_CRC32C {
+			return nil, errors.New("the CRC32C TLV can't be forwarded")
+		}
+		types = append(types, tlvType)
+	}
+	return types, nil
+}
+
+// forwardedTLVs returns the TLVs of the forwarded types from the PROXY header received on the frontend connection
+func (c *Connector) forwardedTLVs(frontendConn net.Conn) ([]proxyproto.TLV, error) {
+	proxyConn, ok := frontendConn.(*proxyproto.Conn)
+	if !ok || proxyConn.ProxyHeader() == nil {
+		return nil, nil
+	}
+	received, err := proxyConn.ProxyHeader().TLVs()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read received TLVs")
+	}
+	return filterTLVs(received, c.forwardTLVTypes), nil
+}
+
+func filterTLVs(tlvs []proxyproto.TLV, types []proxyproto.PP2Type) []proxyproto.TLV {
+	var result []proxyproto.TLV
+	for _, tlv := range tlvs {
+		for _, tlvType := range types {
+			if tlv.Type == tlvType {
+				result = append(result, tlv)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// addForwardedTLVs sets the forwarded TLVs, if any, on the header sent to the backend
+func (c *Connector) addForwardedTLVs(frontendConn net.Conn, header *proxyproto.Header) {
+	if len(c.forwardTLVTypes) == 0 {
+		return
+	}
+	tlvs, err := c.forwardedTLVs(frontendConn)
+	if err == nil && len(tlvs) > 0 {
+		err = header.SetTLVs(tlvs)
+	}
+	if err != nil {
+		// the header is still useful without them
+		logrus.
+			WithError(err).
+			WithField("client", frontendConn.RemoteAddr()).
+			Warn("Unable to forward PROXY header TLVs")
+	}
+}
+
+// receivedProxyHeader returns a copy of the PROXY header received on the frontend connection, when forwarding
+// received headers, without the checksum, which covers the received header. The copy is of the version sent to
+// backends, where version 1 carries no TLVs. Headers of the LOCAL command, such as the health checks of a proxy,
+// aren't forwarded since they don't convey the client.
+func (c *Connector) receivedProxyHeader(frontendConn net.Conn) *proxyproto.Header {
+	if !c.forwardProxyHeader {
+		return nil
+	}
+	proxyConn, ok := frontendConn.(*proxyproto.Conn)
+	if !ok || proxyConn.ProxyHeader() == nil || !proxyConn.ProxyHeader().Command.IsProxy() {
+		return nil
+	}
+	received := proxyConn.ProxyHeader()
+	header := &proxyproto.Header{
+		Version:           c.sentProxyProtoVersion(),
+		Command:           received.Command,
+		TransportProtocol: received.TransportProtocol,
+		SourceAddr:        received.SourceAddr,
+		DestinationAddr:   received.DestinationAddr,
+	}
+	if header.Version != 2 || received.Version != 2 {
+		return header
+	}
+
+	tlvs, err := received.TLVs()
+	if err == nil {
+		var withoutChecksum []proxyproto.TLV
+		for _, tlv := range tlvs {
+			if tlv.Type != proxyproto.PP2_TYPE_CRC32C {
+				withoutChecksum = append(withoutChecksum, tlv)
+			}
+		}
+		err = header.SetTLVs(withoutChecksum)
+	}
+	if err != nil {
+		// the header is still useful without them
+		logrus.
+			WithError(err).
+			WithField("client", frontendConn.RemoteAddr()).
+			Warn("Unable to forward received PROXY header TLVs")
+	}
+	return header
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/pires/go-proxyproto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// awsTLVType carries the VPC endpoint ID of connections through an AWS PrivateLink
+const awsTLVType = proxyproto.PP2Type(0xEA)
+
+func TestParseTLVTypes(t *testing.T) {
+	types, err := ParseTLVTypes([]string{"1", "0xEA", " 0x05 "})
+	require.NoError(t, err)
+	assert.Equal(t, []proxyproto.PP2Type{proxyproto.PP2_TYPE_ALPN, awsTLVType, proxyproto.PP2_TYPE_UNIQUE_ID}, types)
+
+	_, err = ParseTLVTypes([]string{"256"})
+	assert.Error(t, err)
+
+	_, err = ParseTLVTypes([]string{"0x03"})
+	assert.Error(t, err, "CRC32C can't be forwarded")
+}
+
+func TestConnector_addForwardedTLVs(t *testing.T) {
+	clientConn, routerConn := net.Pipe()
+	//goland:noinspection GoUnhandledErrorResult
+	defer clientConn.Close()
+
+	received := &proxyproto.Header{
+		Version:           2,
+		Command:           proxyproto.PROXY,
+		TransportProtocol: proxyproto.TCPv4,
+		SourceAddr:        &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 51000},
+		DestinationAddr:   &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 25565},
+	}
+	require.NoError(t, received.SetTLVs([]proxyproto.TLV{
+		{Type: proxyproto.PP2_TYPE_ALPN, Value: []byte("mc")},
+		{Type: awsTLVType, Value: []byte{0x01, 'v', 'p', 'c', 'e'}},
+	}))
+	go func() {
+		_, _ = received.WriteTo(clientConn)
+	}()
+
+	c := newTestConnector(t)
+	c.UseProxyProtoTLVForwarding([]proxyproto.PP2Type{awsTLVType})
+
+	sent := &proxyproto.Header{
+		Version:           2,
+		Command:           proxyproto.PROXY,
+		TransportProtocol: proxyproto.TCPv4,
+		SourceAddr:        received.SourceAddr,
+		DestinationAddr:   &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 25565},
+	}
+	c.addForwardedTLVs(proxyproto.NewConn(routerConn), sent)
+
+	tlvs, err := sent.TLVs()
+	require.NoError(t, err)
+	assert.Equal(t, []proxyproto.TLV{{Type: awsTLVType, Value: []byte{0x01, 'v', 'p', 'c', 'e'}}}, tlvs)
+}
+
+func TestConnector_receivedProxyHeader(t *testing.T) {
+	clientConn, routerConn := net.Pipe()
+	//goland:noinspection GoUnhandledErrorResult
+	defer clientConn.Close()
+
+	received := &proxyproto.Header{
+		Version:           2,
+		Command:           proxyproto.PROXY,
+		TransportProtocol: proxyproto.TCPv4,
+		SourceAddr:        &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 51000},
+		DestinationAddr:   &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 25565},
+	}
+	require.NoError(t, received.SetTLVs([]proxyproto.TLV{
+		{Type: proxyproto.PP2_TYPE_SSL, Value: []byte{0x01, 0, 0, 0, 0}},
+		{Type: proxyproto.PP2_TYPE_CRC32C, Value: []byte{1, 2, 3, 4}},
+		{Type: awsTLVType, Value: []byte{0x01, 'v', 'p', 'c', 'e'}},
+	}))
+	go func() {
+		_, _ = received.WriteTo(clientConn)
+	}()
+
+	c := newTestConnector(t)
+	frontendConn := proxyproto.NewConn(routerConn)
+	assert.Nil(t, c.receivedProxyHeader(frontendConn), "only forwarded when enabled")
+
+	c.UseProxyHeaderForwarding(true)
+	sent := c.receivedProxyHeader(frontendConn)
+	require.NotNil(t, sent)
+	assert.Equal(t, "203.0.113.7:51000", sent.SourceAddr.String())
+	assert.Equal(t, "10.0.0.1:25565", sent.DestinationAddr.String(), "the destination of the first proxy is retained")
+	tlvs, err := sent.TLVs()
+	require.NoError(t, err)
+	assert.Equal(t, []proxyproto.TLV{
+		{Type: proxyproto.PP2_TYPE_SSL, Value: []byte{0x01, 0, 0, 0, 0}},
+		{Type: awsTLVType, Value: []byte{0x01, 'v', 'p', 'c', 'e'}},
+	}, tlvs, "all but the checksum are forwarded")
+
+	direct, _ := net.Pipe()
+	assert.Nil(t, c.receivedProxyHeader(direct), "a header of the client's address is sent instead")
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// reconnectTracker remembers the recent connections of each client IP in order to delay those reconnecting
+// abusively often, such as bots in a reconnect loop
+type reconnectTracker struct {
+	sync.Mutex
+	// threshold is the number of connections within the window that are not delayed
+	threshold int
+	window    time.Duration
+	maxDelay  time.Duration
+	recent    map[netip.Addr][]time.Time
+	lastSweep time.Time
+}
+
+// UseReconnectDelay delays the handshake of clients that connected more than threshold times within the window by
+// a random delay of up to maxDelay, which grows as they keep reconnecting. This slows down abusive reconnect loops
+// without affecting the occasional reconnects of players. It is to be called before listening.
+func (c *Connector) UseReconnectDelay(threshold int, window time.Duration, maxDelay time.Duration) {
+	c.reconnects = &reconnectTracker{
+		threshold: threshold,
+		window:    window,
+		maxDelay:  maxDelay,
+		recent:    make(map[netip.Addr][]time.Time),
+	}
+}
+
+// delayReconnect waits, when the client has been reconnecting too often, and returns false if the ctx was done
+// while waiting
+func (c *Connector) delayReconnect(ctx context.Context, clientAddr net.Addr) bool {
+	tcpAddr, ok := clientAddr.(*net.TCPAddr)
+	if c.reconnects == nil || !ok {
+		return true
+	}
+	delay := c.reconnects.record(tcpAddr.AddrPort().Addr().Unmap(), time.Now())
+	if delay <= 0 {
+		return true
+	}
+
+	logrus.
+		WithField("client", clientAddr).
+		WithField("delay", delay).
+		Debug("Delaying client that is reconnecting frequently")
+	c.connectionErrors(ctx, "reconnect_delayed").Add(1)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// record notes the connection of the client at the given time and returns how long to delay it, which is zero until
+// the threshold is exceeded and then grows with the excess connections up to the maxDelay, with jitter
+func (t *reconnectTracker) record(client netip.Addr, now time.Time) time.Duration {
+	t.Lock()
+	defer t.Unlock()
+
+	t.sweep(now)
+	recent := append(withinWindow(t.recent[client], now.Add(-t.window)), now)
+	t.recent[client] = recent
+
+	excess := len(recent) - t.threshold
+	if excess <= 0 {
+		return 0
+	}
+	delay := t.maxDelay
+	if excess < t.threshold {
+		delay = t.maxDelay * time.Duration(excess) / time.Duration(t.threshold)
+	}
+	// between half and all of the delay, so that the clients of a flood don't reconnect in lockstep
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// sweep forgets the clients that haven't connected within the window, at most once per window, and must be called
+// while holding the lock
+func (t *reconnectTracker) sweep(now time.Time) {
+	if now.Sub(t.lastSweep) < t.window {
+		return
+	}
+	t.lastSweep = now
+	since := now.Add(-t.window)
+	for client, times := range t.recent {
+		if remaining := withinWindow(times, since); len(remaining) > 0 {
+			t.recent[client] = remaining
+		} else {
+			delete(t.recent, client)
+		}
+	}
+}
+
+// withinWindow returns the times, which are in ascending order, that are after since
+func withinWindow(times []time.Time, since time.Time) []time.Time {
+	for i, at := range times {
+		if at.After(since) {
+			return times[i:]
+		}
+	}
+	return nil
+}
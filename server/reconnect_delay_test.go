@@ -0,0 +1,48 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReconnectTracker_record(t *testing.T) {
+	tracker := &reconnectTracker{
+		threshold: 2,
+		window:    10 * time.Second,
+		maxDelay:  time.Second,
+		recent:    make(map[netip.Addr][]time.Time),
+	}
+	client := netip.MustParseAddr("192.0.2.1")
+	other := netip.MustParseAddr("192.0.2.2")
+	now := time.Now()
+
+	assert.Zero(t, tracker.record(client, now))
+	assert.Zero(t, tracker.record(client, now.Add(time.Second)))
+	delay := tracker.record(client, now.Add(2*time.Second))
+	assert.GreaterOrEqual(t, delay, 250*time.Millisecond, "half of the delay for one excess connection")
+	assert.LessOrEqual(t, delay, 500*time.Millisecond)
+	delay = tracker.record(client, now.Add(3*time.Second))
+	assert.GreaterOrEqual(t, delay, 500*time.Millisecond, "capped at the max delay")
+	assert.LessOrEqual(t, delay, time.Second)
+
+	assert.Zero(t, tracker.record(other, now.Add(3*time.Second)), "other clients are counted separately")
+	assert.Zero(t, tracker.record(client, now.Add(20*time.Second)), "connections outside of the window are forgotten")
+	assert.Len(t, tracker.recent, 1, "clients idle for a window are swept")
+}
+
+func TestConnector_delayReconnect(t *testing.T) {
+	c := newTestConnector(t)
+	c.UseReconnectDelay(1, time.Minute, time.Hour)
+	clientAddr := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 12345}
+
+	assert.True(t, c.delayReconnect(context.Background(), clientAddr))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.False(t, c.delayReconnect(ctx, clientAddr), "the delay is aborted by the ctx")
+}
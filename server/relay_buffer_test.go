@@ -0,0 +1,37 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnector_UseRelayBufferSize(t *testing.T) {
+	c := newTestConnector(t)
+	assert.Error(t, c.UseRelayBufferSize(-1))
+	require.NoError(t, c.UseRelayBufferSize(16))
+
+	clientConn, frontendConn := tcpPair(t)
+	backendConn, serverConn := tcpPair(t)
+	relayed := make(chan struct{})
+	go func() {
+		c.pumpConnections(context.Background(), frontendConn, backendConn)
+		close(relayed)
+	}()
+
+	// spans many of the small buffers
+	sent := bytes.Repeat([]byte("0123456789"), 100)
+	_, err := clientConn.Write(sent)
+	require.NoError(t, err)
+	received := make([]byte, len(sent))
+	_, err = io.ReadFull(serverConn, received)
+	require.NoError(t, err)
+	assert.Equal(t, sent, received)
+
+	_ = clientConn.Close()
+	<-relayed
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// BackendOptionsDelimiter separates a mapping's backend from its options, such as
+// "mc-internal:25565;rewriteHost=internal.example.com"
+const BackendOptionsDelimiter = ";"
+
+// BackendOptionRewriteHost sets RouteOptions.BackendServerName from a mapping's backend options
+const BackendOptionRewriteHost = "rewriteHost"
+
+// Backend options of waking the backend with an HTTPWaker, which set RouteOptions.WakeURL, WakeTimeout, and
+// WakeStatuses
+const (
+	BackendOptionWakeURL     = "wakeUrl"
+	BackendOptionWakeTimeout = "wakeTimeout"
+	BackendOptionWakeStatus  = "wakeStatus"
+)
+
+// ParseBackendOptions splits the options, given as name=value after BackendOptionsDelimiter, from the backend of a
+// mapping, such as from the routes config file, and applies them to the given options
+func ParseBackendOptions(value string, options RouteOptions) (string, RouteOptions, error) {
+	parts := strings.Split(value, BackendOptionsDelimiter)
+	backend := strings.TrimSpace(parts[0])
+	for _, option := range parts[1:] {
+		option = strings.TrimSpace(option)
+		if option == "" {
+			continue
+		}
+		name, optionValue, found := strings.Cut(option, "=")
+		if !found {
+			return "", options, errors.Errorf("backend option %q is not name=value", option)
+		}
+		switch strings.TrimSpace(name) {
+		case BackendOptionRewriteHost:
+			optionValue = strings.TrimSpace(optionValue)
+			if optionValue == "" {
+				return "", options, errors.Errorf("backend option %s is missing the host", BackendOptionRewriteHost)
+			}
+			options.BackendServerName = optionValue
+		case BackendOptionWakeURL:
+			optionValue = strings.TrimSpace(optionValue)
+			if err := validateWakeURL(optionValue); err != nil {
+				return "", options, err
+			}
+			options.WakeURL = optionValue
+			// the backend is asleep until woken, so isn't health checked
+			options.Scalable = true
+		case BackendOptionWakeTimeout:
+			timeout, err := time.ParseDuration(strings.TrimSpace(optionValue))
+			if err != nil || timeout <= 0 {
+				return "", options, errors.Errorf("backend option %s must be a positive duration", BackendOptionWakeTimeout)
+			}
+			options.WakeTimeout = timeout
+		case BackendOptionWakeStatus:
+			optionValue = strings.TrimSpace(optionValue)
+			if _, err := parseWakeStatuses(optionValue); err != nil {
+				return "", options, err
+			}
+			options.WakeStatuses = optionValue
+		default:
+			return "", options, errors.Errorf("unknown backend option %q", name)
+		}
+	}
+	if options.WakeURL != "" && options.WakeCommand != "" {
+		return "", options, errors.Errorf("backend option %s can't be combined with a wake command",
+			BackendOptionWakeURL)
+	}
+	if options.WakeURL == "" && options.WakeCommand == "" && options.WakeTimeout != 0 {
+		return "", options, errors.Errorf("backend option %s requires %s or a wake command",
+			BackendOptionWakeTimeout, BackendOptionWakeURL)
+	}
+	if options.WakeURL == "" && options.WakeStatuses != "" {
+		return "", options, errors.Errorf("backend option %s requires %s",
+			BackendOptionWakeStatus, BackendOptionWakeURL)
+	}
+	return backend, options, nil
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBackendOptions(t *testing.T) {
+	tests := []struct {
+		name        string
+		value       string
+		wantBackend string
+		wantOptions RouteOptions
+		wantErr     bool
+	}{
+		{name: "backend only", value: "mc:25565", wantBackend: "mc:25565"},
+		{name: "rewrite host", value: "mc:25565;rewriteHost=internal.example.com",
+			wantBackend: "mc:25565", wantOptions: RouteOptions{BackendServerName: "internal.example.com"}},
+		{name: "load balanced with spaces", value: "mc1:25565|mc2:25565 ; rewriteHost = internal.example.com ;",
+			wantBackend: "mc1:25565|mc2:25565", wantOptions: RouteOptions{BackendServerName: "internal.example.com"}},
+		{name: "missing host", value: "mc:25565;rewriteHost=", wantErr: true},
+		{name: "not name=value", value: "mc:25565;rewriteHost", wantErr: true},
+		{name: "unknown option", value: "mc:25565;weight=2", wantErr: true},
+		{name: "wake URL", value: "mc:25565;wakeUrl=http://starter:8080/start?server=mc;wakeTimeout=5m;wakeStatus=200|202",
+			wantBackend: "mc:25565", wantOptions: RouteOptions{
+				WakeURL:      "http://starter:8080/start?server=mc",
+				WakeTimeout:  5 * time.Minute,
+				WakeStatuses: "200|202",
+				Scalable:     true,
+			}},
+		{name: "wake URL not http", value: "mc:25565;wakeUrl=starter:8080", wantErr: true},
+		{name: "invalid wake status", value: "mc:25565;wakeUrl=http://starter;wakeStatus=ok", wantErr: true},
+		{name: "wake timeout without URL", value: "mc:25565;wakeTimeout=5m", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend, options, err := ParseBackendOptions(tt.value, RouteOptions{})
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantBackend, backend)
+			assert.Equal(t, tt.wantOptions, options)
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Route conflict policies decide which of the containers or services that declare the same host is routed
+const (
+	// RouteConflictLastWins routes the most recently created container or service
+	RouteConflictLastWins = "last-wins"
+	// RouteConflictFirstWins routes the earliest created container or service
+	RouteConflictFirstWins = "first-wins"
+	// RouteConflictError routes none of them until the conflict is resolved
+	RouteConflictError = "error"
+)
+
+// ValidateRouteConflictPolicy returns an error if the policy is not one of the route conflict policies
+func ValidateRouteConflictPolicy(policy string) error {
+	switch policy {
+	case RouteConflictLastWins, RouteConflictFirstWins, RouteConflictError:
+		return nil
+	default:
+		return errors.Errorf("unknown route conflict policy %q, expected %s, %s, or %s",
+			policy, RouteConflictLastWins, RouteConflictFirstWins, RouteConflictError)
+	}
+}
+
+// routeClaim is a host, or the default route when empty, declared by a container or service
+type routeClaim struct {
+	host string
+	// owner identifies the container or service, such as by its name
+	owner   string
+	created time.Time
+}
+
+// resolveRouteConflicts returns, parallel to the claims, whether each claim is routed. Claims of the same host
+// conflict, which is logged, and are ordered by their creation to be resolved by the policy, where an empty policy
+// is RouteConflictLastWins.
+func resolveRouteConflicts(policy string, source string, claims []routeClaim) []bool {
+	byHost := make(map[string][]int)
+	for i, claim := range claims {
+		host := strings.ToLower(claim.host)
+		byHost[host] = append(byHost[host], i)
+	}
+
+	routed := make([]bool, len(claims))
+	for host, indexes := range byHost {
+		if len(indexes) == 1 {
+			routed[indexes[0]] = true
+			continue
+		}
+
+		sort.SliceStable(indexes, func(i, j int) bool {
+			a, b := claims[indexes[i]], claims[indexes[j]]
+			if !a.created.Equal(b.created) {
+				return a.created.Before(b.created)
+			}
+			return a.owner < b.owner
+		})
+		owners := make([]string, len(indexes))
+		for i, index := range indexes {
+			owners[i] = claims[index].owner
+		}
+		logger := logrus.WithFields(logrus.Fields{
+			"serverAddress": host,
+			"source":        source,
+			"owners":        owners,
+			"policy":        policy,
+		})
+
+		switch policy {
+		case RouteConflictFirstWins:
+			routed[indexes[0]] = true
+			logger.WithField("routed", owners[0]).Warn("Conflicting routes declare the same host, so routing the first created")
+		case RouteConflictError:
+			logger.Error("Conflicting routes declare the same host, so routing none of them")
+		default:
+			routed[indexes[len(indexes)-1]] = true
+			logger.WithField("routed", owners[len(owners)-1]).Warn("Conflicting routes declare the same host, so routing the last created")
+		}
+	}
+	return routed
+}
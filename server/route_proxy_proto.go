@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/pires/go-proxyproto"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// OptionalBool is a per-route override of a connector-wide setting, which remains comparable within RouteOptions
+type OptionalBool int8
+
+const (
+	// OptionalBoolUnset uses the connector-wide setting
+	OptionalBoolUnset OptionalBool = iota
+	OptionalBoolTrue
+	OptionalBoolFalse
+)
+
+// NewOptionalBool returns the override given by the value, if any
+func NewOptionalBool(value *bool) OptionalBool {
+	switch {
+	case value == nil:
+		return OptionalBoolUnset
+	case *value:
+		return OptionalBoolTrue
+	default:
+		return OptionalBoolFalse
+	}
+}
+
+// ParseOptionalBool parses the value as with strconv.ParseBool, where an empty value is unset
+func ParseOptionalBool(value string) (OptionalBool, error) {
+	if strings.TrimSpace(value) == "" {
+		return OptionalBoolUnset, nil
+	}
+	parsed, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		return OptionalBoolUnset, err
+	}
+	return NewOptionalBool(&parsed), nil
+}
+
+// Or returns the override, if set, or otherwise the given value
+func (b OptionalBool) Or(value bool) bool {
+	switch b {
+	case OptionalBoolTrue:
+		return true
+	case OptionalBoolFalse:
+		return false
+	default:
+		return value
+	}
+}
+
+// shouldSendProxyProto determines if a PROXY header is sent to the route's backend
+func (c *Connector) shouldSendProxyProto(options RouteOptions) bool {
+	return options.SendProxyProto.Or(c.sendProxyProto)
+}
+
+// ParseTrustedProxies parses the comma separated IPs or CIDRs of RouteOptions.TrustedProxies
+func ParseTrustedProxies(trustedProxies string) ([]*net.IPNet, error) {
+	var result []*net.IPNet
+	for _, entry := range strings.Split(trustedProxies, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, errors.Errorf("invalid trusted proxy IP %q", entry)
+			}
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			result = append(result, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid trusted proxy CIDR %q", entry)
+		}
+		result = append(result, ipNet)
+	}
+	return result, nil
+}
+
+// receivedProxyHeader returns the address of the upstream proxy that sent the PROXY header which was used
+// for the frontend connection. False is returned when no header was used.
+func receivedProxyHeader(frontendConn net.Conn) (net.Addr, bool) {
+	proxyConn, ok := frontendConn.(*proxyproto.Conn)
+	if !ok || proxyConn.ProxyHeader() == nil {
+		return nil, false
+	}
+	return proxyConn.Raw().RemoteAddr(), true
+}
+
+// checkRouteProxyProto verifies the inbound PROXY protocol expectations of the route, where upstream is the
+// address of the proxy that sent a used PROXY header, if hasHeader
+func checkRouteProxyProto(options RouteOptions, upstream net.Addr, hasHeader bool) error {
+	if !hasHeader {
+		if options.RequireProxyProto || options.TrustedProxies != "" {
+			return errors.New("route requires a PROXY header")
+		}
+		return nil
+	}
+
+	if options.TrustedProxies == "" {
+		return nil
+	}
+	trustedNets, err := ParseTrustedProxies(options.TrustedProxies)
+	if err != nil {
+		return err
+	}
+	tcpAddr, ok := upstream.(*net.TCPAddr)
+	if !ok {
+		return errors.Errorf("unable to determine the IP of upstream %s", upstream)
+	}
+	for _, ipNet := range trustedNets {
+		if ipNet.Contains(tcpAddr.IP) {
+			return nil
+		}
+	}
+	return errors.Errorf("upstream %s is not a trusted proxy of the route", upstream)
+}
+
+// acceptsRouteProxyProto applies checkRouteProxyProto to the frontend connection, logging when rejected
+func (c *Connector) acceptsRouteProxyProto(ctx context.Context, frontendConn net.Conn, serverAddress string,
+	options RouteOptions) bool {
+	upstream, hasHeader := receivedProxyHeader(frontendConn)
+	if err := checkRouteProxyProto(options, upstream, hasHeader); err != nil {
+		logrus.
+			WithError(err).
+			WithField("client", frontendConn.RemoteAddr()).
+			WithField("serverAddress", serverAddress).
+			Warn("Rejecting client due to the route's PROXY protocol settings")
+		c.connectionErrors(ctx, "proxy_rejected").Add(1)
+		return false
+	}
+	return true
+}
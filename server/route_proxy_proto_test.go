@@ -0,0 +1,79 @@
+package server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnector_shouldSendProxyProto(t *testing.T) {
+	tests := []struct {
+		name      string
+		connector bool
+		route     OptionalBool
+		expected  bool
+	}{
+		{name: "inherit disabled", connector: false, route: OptionalBoolUnset, expected: false},
+		{name: "inherit enabled", connector: true, route: OptionalBoolUnset, expected: true},
+		{name: "route enables", connector: false, route: OptionalBoolTrue, expected: true},
+		{name: "route disables", connector: true, route: OptionalBoolFalse, expected: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := NewConnector(newTestConnectorMetrics(), test.connector, false, nil, nil)
+			assert.Equal(t, test.expected, c.shouldSendProxyProto(RouteOptions{SendProxyProto: test.route}))
+		})
+	}
+}
+
+func TestParseOptionalBool(t *testing.T) {
+	value, err := ParseOptionalBool("")
+	require.NoError(t, err)
+	assert.Equal(t, OptionalBoolUnset, value)
+
+	value, err = ParseOptionalBool("true")
+	require.NoError(t, err)
+	assert.Equal(t, OptionalBoolTrue, value)
+
+	value, err = ParseOptionalBool("0")
+	require.NoError(t, err)
+	assert.Equal(t, OptionalBoolFalse, value)
+
+	_, err = ParseOptionalBool("maybe")
+	assert.Error(t, err)
+}
+
+func TestCheckRouteProxyProto(t *testing.T) {
+	lb := &net.TCPAddr{IP: net.ParseIP("10.0.0.5"), Port: 40000}
+	other := &net.TCPAddr{IP: net.ParseIP("192.168.1.5"), Port: 40000}
+
+	tests := []struct {
+		name      string
+		options   RouteOptions
+		upstream  net.Addr
+		hasHeader bool
+		accepted  bool
+	}{
+		{name: "no expectations without header", accepted: true},
+		{name: "no expectations with header", upstream: other, hasHeader: true, accepted: true},
+		{name: "required without header", options: RouteOptions{RequireProxyProto: true}},
+		{name: "required with header", options: RouteOptions{RequireProxyProto: true}, upstream: other, hasHeader: true, accepted: true},
+		{name: "trusted without header", options: RouteOptions{TrustedProxies: "10.0.0.0/24"}},
+		{name: "trusted CIDR", options: RouteOptions{TrustedProxies: "10.0.0.0/24"}, upstream: lb, hasHeader: true, accepted: true},
+		{name: "trusted IP", options: RouteOptions{TrustedProxies: "172.16.0.1, 10.0.0.5"}, upstream: lb, hasHeader: true, accepted: true},
+		{name: "untrusted", options: RouteOptions{TrustedProxies: "10.0.0.0/24"}, upstream: other, hasHeader: true},
+		{name: "invalid trusted", options: RouteOptions{TrustedProxies: "10.0.0.0/99"}, upstream: lb, hasHeader: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkRouteProxyProto(test.options, test.upstream, test.hasHeader)
+			if test.accepted {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
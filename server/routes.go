@@ -3,12 +3,19 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"net"
 	"net/http"
+	"net/netip"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/itzg/mc-router/mcproto"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
@@ -25,6 +32,7 @@ func init() {
 		Headers("Content-Type", "application/json").
 		HandlerFunc(routesSetDefault)
 	apiRoutes.Path("/routes/{serverAddress}").Methods("DELETE").HandlerFunc(routesDeleteHandler)
+	apiRoutes.Path("/resolve").Methods("GET").Queries("address", "{address}").HandlerFunc(routesResolveHandler)
 }
 
 func routesListHandler(writer http.ResponseWriter, _ *http.Request) {
@@ -41,6 +49,21 @@ func routesListHandler(writer http.ResponseWriter, _ *http.Request) {
 	}
 }
 
+func routesResolveHandler(writer http.ResponseWriter, request *http.Request) {
+	resolution := Routes.ResolveServerAddress(request.URL.Query().Get("address"))
+	bytes, err := json.Marshal(resolution)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to marshal resolution")
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	_, err = writer.Write(bytes)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to write response")
+	}
+}
+
 func routesDeleteHandler(writer http.ResponseWriter, request *http.Request) {
 	serverAddress := mux.Vars(request)["serverAddress"]
 	RoutesConfig.DeleteMapping(serverAddress)
@@ -55,8 +78,16 @@ func routesDeleteHandler(writer http.ResponseWriter, request *http.Request) {
 
 func routesCreateHandler(writer http.ResponseWriter, request *http.Request) {
 	var definition = struct {
-		ServerAddress string
-		Backend       string
+		ServerAddress     string
+		Backend           string
+		BackendServerName string
+		DialConcurrency   int
+		ShadowBackend     string
+		ShadowSampleRate  float64
+		SendProxyProtocol *bool
+		// RequireProxyProtocol and TrustedProxies declare the expected inbound PROXY protocol
+		RequireProxyProtocol bool
+		TrustedProxies       string
 	}{}
 
 	//goland:noinspection GoUnhandledErrorResult
@@ -69,8 +100,28 @@ func routesCreateHandler(writer http.ResponseWriter, request *http.Request) {
 		writer.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	if err := ValidateBackends(definition.Backend); err != nil {
+		logrus.WithError(err).WithField("serverAddress", definition.ServerAddress).Error("Invalid backend in route")
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := ParseTrustedProxies(definition.TrustedProxies); err != nil {
+		logrus.WithError(err).WithField("serverAddress", definition.ServerAddress).Error("Invalid trusted proxies in route")
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	Routes.CreateMapping(definition.ServerAddress, definition.Backend, func(ctx context.Context) error { return nil })
+	Routes.CreateMapping(definition.ServerAddress, definition.Backend, nil,
+		RouteOptions{
+			BackendServerName: definition.BackendServerName,
+			DialConcurrency:   definition.DialConcurrency,
+			ShadowBackend:     definition.ShadowBackend,
+			ShadowSampleRate:  definition.ShadowSampleRate,
+			SendProxyProto:    NewOptionalBool(definition.SendProxyProtocol),
+			RequireProxyProto: definition.RequireProxyProtocol,
+			TrustedProxies:    definition.TrustedProxies,
+			Source:            RouteSourceAPI,
+		})
 	RoutesConfig.AddMapping(definition.ServerAddress, definition.Backend)
 	writer.WriteHeader(http.StatusCreated)
 }
@@ -90,6 +141,11 @@ func routesSetDefault(writer http.ResponseWriter, request *http.Request) {
 		writer.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	if err := ValidateBackend(body.Backend); err != nil {
+		logrus.WithError(err).Error("Invalid backend for default route")
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	Routes.SetDefaultRoute(body.Backend)
 	RoutesConfig.SetDefaultRoute(body.Backend)
@@ -103,11 +159,40 @@ type IRoutes interface {
 	// Otherwise, an empty string is returned. Also returns the normalized version of the given serverAddress.
 	// The 3rd value returned is an (optional) "waker" function which a caller must invoke to wake up serverAddress.
 	FindBackendForServerAddress(ctx context.Context, serverAddress string) (string, string, func(ctx context.Context) error)
+	// ResolveServerAddress performs the same normalization and lookup as FindBackendForServerAddress, but
+	// describes the outcome without waking the backend
+	ResolveServerAddress(serverAddress string) RouteResolution
 	GetMappings() map[string]string
+	// GetRouteOptions returns the options of the route registered for the normalized serverAddress, if any
+	GetRouteOptions(serverAddress string) (RouteOptions, bool)
+	// GetRouteDetail describes the route registered for the serverAddress, if any
+	GetRouteDetail(serverAddress string) (RouteDetail, bool)
+	// RecordConnection notes the time a client was connected to the backend of the route registered for the
+	// normalized serverAddress, if any
+	RecordConnection(serverAddress string, at time.Time)
 	DeleteMapping(serverAddress string) bool
-	CreateMapping(serverAddress string, backend string, waker func(ctx context.Context) error)
+	// CreateMapping registers the route, unless the backend is invalid according to ValidateBackends or the route
+	// declares a command without AllowExecWakers
+	CreateMapping(serverAddress string, backend string, waker func(ctx context.Context) error, options RouteOptions)
 	SetDefaultRoute(backend string)
 	SimplifySRV(srvEnabled bool)
+	// UseSRVLabels limits the leading labels stripped by SimplifySRV to the given service and protocol labels,
+	// such as _minecraft and _tcp. By default, all underscore-prefixed labels are stripped.
+	UseSRVLabels(labels []string)
+	// ObserveRouteEvents calls the observer with a RouteEventAdded or RouteEventRemoved event whenever a route is
+	// registered with a new backend or deleted
+	ObserveRouteEvents(observer func(event *ConnectionEvent))
+	// UseLoadBalancing sets how one of a route's backends, separated by BackendsDelimiter, is selected for each
+	// connection, which is one of LoadBalanceRoundRobin, LoadBalanceLeastConn, or LoadBalanceRandom
+	UseLoadBalancing(strategy string, connectionCount func(backend string) int) error
+	// AllowExecWakers permits routes that declare a wake or sleep command, which are otherwise ignored since they
+	// execute arbitrary commands
+	AllowExecWakers(allow bool)
+	// SetSleeper sets the function that puts the backend of the route registered for the serverAddress to sleep
+	// once it is idle, such as by scaling down a Docker Swarm service. It is cleared when the route is created again.
+	SetSleeper(serverAddress string, sleeper func(ctx context.Context) error)
+	// GetSleeper returns the sleeper of the route registered for the normalized serverAddress, if any
+	GetSleeper(serverAddress string) func(ctx context.Context) error
 }
 
 var Routes = NewRoutes()
@@ -122,13 +207,159 @@ func NewRoutes() IRoutes {
 
 func (r *routesImpl) RegisterAll(mappings map[string]string) {
 	for k, v := range mappings {
-		r.CreateMapping(k, v, func(ctx context.Context) error { return nil })
+		backend, options, err := ParseBackendOptions(v, RouteOptions{})
+		if err != nil {
+			logrus.WithError(err).WithField("serverAddress", k).Error("Ignoring route with invalid backend options")
+			continue
+		}
+		r.CreateMapping(k, backend, routeWaker(backend, options), options)
+	}
+}
+
+// normalizeIPLiteral converts an IPv6 literal server address, such as from a client connecting directly to an IPv6
+// address, to its canonical form without brackets or a zone, so that "[::1]" and "::1%eth0" both match a route of
+// "::1". Other server addresses are returned as is.
+func normalizeIPLiteral(serverAddress string) string {
+	literal := serverAddress
+	if strings.HasPrefix(literal, "[") {
+		end := strings.Index(literal, "]")
+		if end < 0 {
+			return serverAddress
+		}
+		literal = literal[1:end]
+	}
+	if !strings.Contains(literal, ":") {
+		return serverAddress
+	}
+	addr, err := netip.ParseAddr(literal)
+	if err != nil {
+		return serverAddress
 	}
+	return addr.WithZone("").String()
+}
+
+// ValidateBackend checks that the backend is in the host:port form, where the port is numeric.
+// An empty backend is valid, such as for a route that serves a status in place of a stopped backend.
+func ValidateBackend(backend string) error {
+	if backend == "" {
+		return nil
+	}
+	host, port, err := net.SplitHostPort(backend)
+	if err != nil {
+		return errors.Wrapf(err, "backend %q is not host:port", backend)
+	}
+	if host == "" {
+		return errors.Errorf("backend %q is missing the host", backend)
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return errors.Errorf("backend %q has an invalid port", backend)
+	}
+	return nil
+}
+
+// RouteOptions are optional, per-route settings
+type RouteOptions struct {
+	// MOTD is served for status requests when the backend is unavailable
+	MOTD string
+	// Favicon is served along with MOTD and is in the data URL form required by status responses
+	Favicon string
+	// PlayersSource, when set, is where the players of a status served in place of the backend come from, such as
+	// PlayersSourceCached, which overrides the connector's default
+	PlayersSource string
+	// MaxPlayers, when greater than zero, caps the maximum players of a status served in place of the backend
+	MaxPlayers int
+	// BackendServerName, when set, replaces the server address in the handshake relayed to the backend
+	BackendServerName string
+	// DialConcurrency, when greater than zero, overrides the connector's limit of concurrent dials to the backend
+	DialConcurrency int
+	// DialTimeout and FirstByteTimeout, when positive, override the connector's timeouts of dialing the backend and
+	// of the backend's first response, such as to give a slow starting backend more time
+	DialTimeout      time.Duration
+	FirstByteTimeout time.Duration
+	// ShadowBackend, when set, is the host:port of a backend that is sent a copy of the client to backend stream,
+	// such as for testing a new server version. Its responses are discarded.
+	ShadowBackend string
+	// ShadowSampleRate is the fraction, from 0 to 1, of connections that are mirrored to the ShadowBackend
+	ShadowSampleRate float64
+	// SendProxyProto, when set, overrides whether a PROXY header is sent to the backend
+	SendProxyProto OptionalBool
+	// RequireProxyProto rejects clients that did not arrive with a PROXY header, such as from a load balancer
+	RequireProxyProto bool
+	// TrustedProxies, when set, are the comma separated IPs or CIDRs of the only upstream proxies whose PROXY header
+	// is accepted for the route, which also requires a PROXY header
+	TrustedProxies string
+	// WakeURL, when set, is posted to by the route's HTTPWaker to wake the backend, where WakeTimeout and
+	// WakeStatuses, given as status codes separated by |, override the waker's defaults
+	WakeURL      string
+	WakeTimeout  time.Duration
+	WakeStatuses string
+	// WakeCommand and SleepCommand, when set, are run by the route's CommandScaler, split on whitespace, to wake the
+	// backend and to put it to sleep once it has had no connections for SleepAfter. These require AllowExecWakers.
+	WakeCommand  string
+	SleepCommand string
+	SleepAfter   time.Duration
+	// Scalable is set when the backend may be intentionally scaled to zero and woken by the route's waker, such as by
+	// the Kubernetes auto scale up
+	Scalable bool
+	// Source identifies what registered the route, such as RouteSourceDocker, and is empty for static mappings
+	Source string
+}
+
+// Values of RouteOptions.Source
+const (
+	RouteSourceAPI         = "api"
+	RouteSourceConfig      = "config"
+	RouteSourceDocker      = "docker"
+	RouteSourceDockerSwarm = "docker-swarm"
+	RouteSourceK8s         = "k8s"
+)
+
+func (o RouteOptions) withSource(source string) RouteOptions {
+	o.Source = source
+	return o
+}
+
+// Values of RouteResolution.Match
+const (
+	RouteMatchExact   = "exact"
+	RouteMatchDefault = "default"
+	RouteMatchNone    = "none"
+)
+
+// RouteResolution describes how a requested server address was resolved to a backend
+type RouteResolution struct {
+	ServerAddress     string `json:"serverAddress"`
+	NormalizedAddress string `json:"normalizedAddress"`
+	// Match is one of RouteMatchExact, RouteMatchDefault, or RouteMatchNone
+	Match   string `json:"match"`
+	Backend string `json:"backend,omitempty"`
+}
+
+// RouteDetail describes a registered route
+type RouteDetail struct {
+	ServerAddress string `json:"serverAddress"`
+	Backend       string `json:"backend"`
+	// LastConnection is when a client was last connected to the backend, if since the route was registered
+	LastConnection *time.Time `json:"lastConnection,omitempty"`
+	// Wakeable is whether the backend is woken, such as by scaling it up, when a client connects
+	Wakeable bool `json:"wakeable"`
+	// ActiveConnections and Status are only known by the Connector, which fills them in
+	ActiveConnections int                     `json:"activeConnections"`
+	Status            *mcproto.StatusResponse `json:"status,omitempty"`
 }
 
 type mapping struct {
 	backend string
-	waker   func(ctx context.Context) error
+	// backends are those of backend separated by BackendsDelimiter
+	backends []string
+	// next is the round-robin position, which is shared by the copies of the mapping
+	next  *atomic.Uint64
+	waker func(ctx context.Context) error
+	// sleeper, when set, puts the backend to sleep once it is idle
+	sleeper func(ctx context.Context) error
+	options RouteOptions
+	// lastConnection is zero until a client is connected to the backend
+	lastConnection time.Time
 }
 
 type routesImpl struct {
@@ -136,9 +367,22 @@ type routesImpl struct {
 	mappings     map[string]mapping
 	defaultRoute string
 	simplifySRV  bool
+	// srvLabels, when not empty, are the only labels stripped by simplifySRV
+	srvLabels map[string]struct{}
+	// routeObserver, when set, is called with route added and removed events
+	routeObserver func(event *ConnectionEvent)
+	// execWakers permits routes with wake or sleep commands
+	execWakers bool
+	// loadBalance is the strategy for selecting one of a route's backends
+	loadBalance string
+	// connectionCount provides the active connections of a backend for LoadBalanceLeastConn
+	connectionCount func(backend string) int
 }
 
 func (r *routesImpl) Reset() {
+	r.Lock()
+	defer r.Unlock()
+
 	r.mappings = make(map[string]mapping)
 }
 
@@ -150,14 +394,73 @@ func (r *routesImpl) SetDefaultRoute(backend string) {
 	}).Info("Using default route")
 }
 
+func (r *routesImpl) AllowExecWakers(allow bool) {
+	r.Lock()
+	defer r.Unlock()
+	r.execWakers = allow
+}
+
 func (r *routesImpl) SimplifySRV(srvEnabled bool) {
 	r.simplifySRV = srvEnabled
 }
 
+func (r *routesImpl) UseSRVLabels(labels []string) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.srvLabels = make(map[string]struct{}, len(labels))
+	for _, label := range labels {
+		label = strings.ToLower(strings.TrimSpace(label))
+		if label != "" {
+			r.srvLabels[label] = struct{}{}
+		}
+	}
+}
+
+// stripSRVLabels removes the leading service and protocol labels, such as the _minecraft._tcp of an SRV name
+func (r *routesImpl) stripSRVLabels(serverAddress string) string {
+	parts := strings.Split(serverAddress, ".")
+	stripped := 0
+	for stripped < len(parts)-1 && r.isSRVLabel(parts[stripped]) {
+		stripped++
+	}
+	return strings.Join(parts[stripped:], ".")
+}
+
+func (r *routesImpl) isSRVLabel(label string) bool {
+	if !strings.HasPrefix(label, "_") {
+		return false
+	}
+	if len(r.srvLabels) == 0 {
+		return true
+	}
+	_, recognized := r.srvLabels[label]
+	return recognized
+}
+
 func (r *routesImpl) FindBackendForServerAddress(_ context.Context, serverAddress string) (string, string, func(ctx context.Context) error) {
 	r.RLock()
 	defer r.RUnlock()
 
+	resolution, waker := r.resolve(serverAddress)
+	if resolution.Match == RouteMatchExact {
+		return r.selectBackend(r.mappings[resolution.NormalizedAddress]), resolution.NormalizedAddress, waker
+	}
+	return resolution.Backend, resolution.NormalizedAddress, waker
+}
+
+func (r *routesImpl) ResolveServerAddress(serverAddress string) RouteResolution {
+	r.RLock()
+	defer r.RUnlock()
+
+	resolution, _ := r.resolve(serverAddress)
+	return resolution
+}
+
+// resolve normalizes the serverAddress and looks up its route. The caller must hold the read lock.
+func (r *routesImpl) resolve(serverAddress string) (RouteResolution, func(ctx context.Context) error) {
+	resolution := RouteResolution{ServerAddress: serverAddress}
+
 	// Trim off Forge null-delimited address parts like \x00FML3\x00
 	serverAddress = strings.Split(serverAddress, "\x00")[0]
 
@@ -165,35 +468,36 @@ func (r *routesImpl) FindBackendForServerAddress(_ context.Context, serverAddres
 		// trim the root zone indicator, see https://en.wikipedia.org/wiki/Fully_qualified_domain_name
 		strings.TrimSuffix(serverAddress, "."))
 
+	serverAddress = normalizeIPLiteral(serverAddress)
+
 	logrus.WithFields(logrus.Fields{
 		"serverAddress": serverAddress,
 	}).Debug("Finding backend for server address")
 
 	if r.simplifySRV {
-		parts := strings.Split(serverAddress, ".")
-		tcpIndex := -1
-		for i, part := range parts {
-			if part == "_tcp" {
-				tcpIndex = i
-				break
-			}
-		}
-		if tcpIndex != -1 {
-			parts = parts[tcpIndex+1:]
-		}
-
-		serverAddress = strings.Join(parts, ".")
+		serverAddress = r.stripSRVLabels(serverAddress)
 	}
 
 	// Strip suffix of TCP Shield
 	serverAddress = tcpShieldPattern.ReplaceAllString(serverAddress, "")
 
+	resolution.NormalizedAddress = serverAddress
+
 	if r.mappings != nil {
 		if mapping, exists := r.mappings[serverAddress]; exists {
-			return mapping.backend, serverAddress, mapping.waker
+			resolution.Match = RouteMatchExact
+			resolution.Backend = mapping.backend
+			return resolution, mapping.waker
 		}
 	}
-	return r.defaultRoute, serverAddress, nil
+
+	resolution.Backend = r.defaultRoute
+	if r.defaultRoute != "" {
+		resolution.Match = RouteMatchDefault
+	} else {
+		resolution.Match = RouteMatchNone
+	}
+	return resolution, nil
 }
 
 func (r *routesImpl) GetMappings() map[string]string {
@@ -207,28 +511,119 @@ func (r *routesImpl) GetMappings() map[string]string {
 	return result
 }
 
+func (r *routesImpl) GetRouteOptions(serverAddress string) (RouteOptions, bool) {
+	r.RLock()
+	defer r.RUnlock()
+
+	mapping, exists := r.mappings[serverAddress]
+	return mapping.options, exists
+}
+
+func (r *routesImpl) GetRouteDetail(serverAddress string) (RouteDetail, bool) {
+	r.RLock()
+	defer r.RUnlock()
+
+	serverAddress = normalizeIPLiteral(strings.ToLower(serverAddress))
+	mapping, exists := r.mappings[serverAddress]
+	if !exists {
+		return RouteDetail{}, false
+	}
+	detail := RouteDetail{ServerAddress: serverAddress, Backend: mapping.backend, Wakeable: mapping.waker != nil}
+	if !mapping.lastConnection.IsZero() {
+		lastConnection := mapping.lastConnection
+		detail.LastConnection = &lastConnection
+	}
+	return detail, true
+}
+
+func (r *routesImpl) SetSleeper(serverAddress string, sleeper func(ctx context.Context) error) {
+	r.Lock()
+	defer r.Unlock()
+
+	serverAddress = normalizeIPLiteral(strings.ToLower(serverAddress))
+	if mapping, exists := r.mappings[serverAddress]; exists {
+		mapping.sleeper = sleeper
+		r.mappings[serverAddress] = mapping
+	}
+}
+
+func (r *routesImpl) GetSleeper(serverAddress string) func(ctx context.Context) error {
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.mappings[serverAddress].sleeper
+}
+
+func (r *routesImpl) RecordConnection(serverAddress string, at time.Time) {
+	r.Lock()
+	defer r.Unlock()
+
+	if mapping, exists := r.mappings[serverAddress]; exists {
+		mapping.lastConnection = at
+		r.mappings[serverAddress] = mapping
+	}
+}
+
+func (r *routesImpl) ObserveRouteEvents(observer func(event *ConnectionEvent)) {
+	r.Lock()
+	defer r.Unlock()
+	r.routeObserver = observer
+}
+
 func (r *routesImpl) DeleteMapping(serverAddress string) bool {
 	r.Lock()
 	defer r.Unlock()
+	serverAddress = normalizeIPLiteral(serverAddress)
 	logrus.WithField("serverAddress", serverAddress).Info("Deleting route")
 
-	if _, ok := r.mappings[serverAddress]; ok {
+	if existing, ok := r.mappings[serverAddress]; ok {
 		delete(r.mappings, serverAddress)
+		r.observeRouteEvent(RouteEventRemoved, serverAddress, existing)
 		return true
 	} else {
 		return false
 	}
 }
 
-func (r *routesImpl) CreateMapping(serverAddress string, backend string, waker func(ctx context.Context) error) {
+func (r *routesImpl) CreateMapping(serverAddress string, backend string, waker func(ctx context.Context) error, options RouteOptions) {
 	r.Lock()
 	defer r.Unlock()
 
-	serverAddress = strings.ToLower(serverAddress)
+	serverAddress = normalizeIPLiteral(strings.ToLower(serverAddress))
+
+	if err := ValidateBackends(backend); err != nil {
+		logrus.WithError(err).WithField("serverAddress", serverAddress).Error("Ignoring route with invalid backend")
+		return
+	}
+	if (options.WakeCommand != "" || options.SleepCommand != "") && !r.execWakers {
+		logrus.WithField("serverAddress", serverAddress).
+			Error("Ignoring route with a wake or sleep command since -allow-exec-wakers is not set")
+		return
+	}
 
 	logrus.WithFields(logrus.Fields{
 		"serverAddress": serverAddress,
 		"backend":       backend,
 	}).Info("Created route mapping")
-	r.mappings[serverAddress] = mapping{backend: backend, waker: waker}
+	// retain the activity of a route that is being updated
+	existing, existed := r.mappings[serverAddress]
+	created := mapping{
+		backend:        backend,
+		backends:       SplitBackends(backend),
+		next:           new(atomic.Uint64),
+		waker:          waker,
+		options:        options,
+		lastConnection: existing.lastConnection,
+	}
+	r.mappings[serverAddress] = created
+	if !existed || existing.backend != backend {
+		r.observeRouteEvent(RouteEventAdded, serverAddress, created)
+	}
+}
+
+// observeRouteEvent informs the route observer, if any, and must be called while holding the lock
+func (r *routesImpl) observeRouteEvent(event string, serverAddress string, m mapping) {
+	if r.routeObserver != nil {
+		r.routeObserver(newRouteEvent(event, serverAddress, m.backend, m.options.Source))
+	}
 }
@@ -1,14 +1,53 @@
 package server
 
 import (
+	"bytes"
 	"encoding/json"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"github.com/tailscale/hujson"
 	"io/fs"
+	"net/http"
 	"os"
+	"sort"
 	"sync"
+	"time"
 )
 
+func init() {
+	apiRoutes.Path("/reload").Methods("POST").HandlerFunc(reloadHandler)
+}
+
+type reloadResponse struct {
+	// RoutesConfig is nil when a routes config file is not in use
+	RoutesConfig *RoutesConfigChanges `json:"routesConfig"`
+}
+
+func reloadHandler(writer http.ResponseWriter, _ *http.Request) {
+	var response reloadResponse
+	if RoutesConfig.isRoutesConfigEnabled() {
+		changes, err := RoutesConfig.Reload()
+		if err != nil {
+			logrus.WithError(err).Error("Failed to reload routes config")
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		response.RoutesConfig = changes
+	}
+
+	bytes, err := json.Marshal(response)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to marshal reload response")
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	_, err = writer.Write(bytes)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to write response")
+	}
+}
+
 type IRoutesConfig interface {
 	ReadRoutesConfig(routesConfig string)
 	AddMapping(serverAddress string, backend string)
@@ -21,11 +60,68 @@ var RoutesConfig = &routesConfigImpl{}
 type routesConfigImpl struct {
 	sync.RWMutex
 	fileName string
+	// loaded tracks the most recently read or written content to identify changes on reload
+	loaded routesConfigStructure
+	// favicons avoids re-reading favicon files of unchanged mappings on reload
+	favicons faviconCache
+}
+
+// RoutesConfigChanges summarizes the changes applied by reloading the routes config file
+type RoutesConfigChanges struct {
+	Added                []string `json:"added"`
+	Removed              []string `json:"removed"`
+	Changed              []string `json:"changed"`
+	DefaultServerChanged bool     `json:"defaultServerChanged"`
 }
 
 type routesConfigStructure struct {
-	DefaultServer string            `json:"default-server"`
-	Mappings      map[string]string `json:"mappings"`
+	DefaultServer string                         `json:"default-server"`
+	Mappings      map[string]routesConfigMapping `json:"mappings"`
+}
+
+// routesConfigMapping is the value of a mapping in the routes config file, which is either the backend as a string
+// or an object that also declares the status served when the backend is unavailable, such as while it is asleep
+type routesConfigMapping struct {
+	Backend    string `json:"backend"`
+	AsleepMOTD string `json:"asleepMotd,omitempty"`
+	// Favicon can be a data URL, base64 encoded PNG content, or the path to a PNG file
+	Favicon    string `json:"favicon,omitempty"`
+	MaxPlayers int    `json:"maxPlayers,omitempty"`
+	// DialTimeout and FirstByteTimeout are durations, such as "30s", that override the connector's timeouts
+	DialTimeout      string `json:"dialTimeout,omitempty"`
+	FirstByteTimeout string `json:"firstByteTimeout,omitempty"`
+	// WakeCmd and SleepCmd are commands, split on whitespace and run without a shell, that wake the backend and put
+	// it to sleep once it has had no connections for SleepAfter, such as "10m"
+	WakeCmd    string `json:"wakeCmd,omitempty"`
+	SleepCmd   string `json:"sleepCmd,omitempty"`
+	SleepAfter string `json:"sleepAfter,omitempty"`
+}
+
+func (m *routesConfigMapping) UnmarshalJSON(data []byte) error {
+	var backend string
+	if err := json.Unmarshal(data, &backend); err == nil {
+		*m = routesConfigMapping{Backend: backend}
+		return nil
+	}
+
+	// the alias avoids recursing into this method
+	type mappingObject routesConfigMapping
+	var object mappingObject
+	if err := json.Unmarshal(data, &object); err != nil {
+		return errors.Wrap(err, "mapping must be a backend string or an object with a backend")
+	}
+	*m = routesConfigMapping(object)
+	return nil
+}
+
+// MarshalJSON writes the mappings that only declare a backend in the string form, which keeps the file as it was
+// for those not using the object form
+func (m routesConfigMapping) MarshalJSON() ([]byte, error) {
+	if m == (routesConfigMapping{Backend: m.Backend}) {
+		return json.Marshal(m.Backend)
+	}
+	type mappingObject routesConfigMapping
+	return json.Marshal(mappingObject(m))
 }
 
 func (r *routesConfigImpl) ReadRoutesConfig(routesConfig string) error {
@@ -43,12 +139,132 @@ func (r *routesConfigImpl) ReadRoutesConfig(routesConfig string) error {
 		}
 		return errors.Wrap(readErr, "Could not load the routes config file")
 	}
+	config = expandRoutesConfig(config)
 
-	Routes.RegisterAll(config.Mappings)
+	for serverAddress, mapping := range config.Mappings {
+		r.createConfiguredMapping(serverAddress, mapping)
+	}
 	Routes.SetDefaultRoute(config.DefaultServer)
+	r.setLoaded(config)
 	return nil
 }
 
+// Reload reads the routes config file again and applies only the routes that were added, removed, or changed
+// since it was last read or written. The routes are left unchanged when the file can't be read.
+func (r *routesConfigImpl) Reload() (*RoutesConfigChanges, error) {
+	logrus.WithField("routesConfig", r.fileName).Info("Reloading routes config file")
+
+	// A missing file, such as one temporarily moved during a deployment, is an error rather than
+	// an empty config in order to avoid removing all the routes
+	config, readErr := r.readRoutesConfigFile()
+	if readErr != nil {
+		return nil, errors.Wrap(readErr, "Could not reload the routes config file")
+	}
+	config = expandRoutesConfig(config)
+
+	r.RLock()
+	previous := r.loaded
+	r.RUnlock()
+
+	changes := &RoutesConfigChanges{
+		Added:   []string{},
+		Removed: []string{},
+		Changed: []string{},
+	}
+	for serverAddress := range previous.Mappings {
+		if _, exists := config.Mappings[serverAddress]; !exists {
+			Routes.DeleteMapping(serverAddress)
+			changes.Removed = append(changes.Removed, serverAddress)
+		}
+	}
+	for serverAddress, mapping := range config.Mappings {
+		previousMapping, existed := previous.Mappings[serverAddress]
+		if existed && previousMapping == mapping {
+			continue
+		}
+		r.createConfiguredMapping(serverAddress, mapping)
+		if existed {
+			changes.Changed = append(changes.Changed, serverAddress)
+		} else {
+			changes.Added = append(changes.Added, serverAddress)
+		}
+	}
+	if previous.DefaultServer != config.DefaultServer {
+		Routes.SetDefaultRoute(config.DefaultServer)
+		changes.DefaultServerChanged = true
+	}
+
+	sort.Strings(changes.Added)
+	sort.Strings(changes.Removed)
+	sort.Strings(changes.Changed)
+
+	r.setLoaded(config)
+
+	logrus.WithFields(logrus.Fields{
+		"added":                changes.Added,
+		"removed":              changes.Removed,
+		"changed":              changes.Changed,
+		"defaultServerChanged": changes.DefaultServerChanged,
+	}).Info("Reloaded routes config file")
+	return changes, nil
+}
+
+// createConfiguredMapping registers the mapping of the routes config file, whose backend may declare options
+// such as "host:port;rewriteHost=internal.example.com"
+func (r *routesConfigImpl) createConfiguredMapping(serverAddress string, mapping routesConfigMapping) {
+	logger := logrus.WithField("serverAddress", serverAddress)
+	backend, options, err := ParseBackendOptions(mapping.Backend, RouteOptions{
+		Source:       RouteSourceConfig,
+		MOTD:         mapping.AsleepMOTD,
+		MaxPlayers:   mapping.MaxPlayers,
+		WakeCommand:  mapping.WakeCmd,
+		SleepCommand: mapping.SleepCmd,
+		// the backend is asleep until woken, so isn't health checked
+		Scalable: mapping.WakeCmd != "",
+	})
+	if err != nil {
+		logger.WithError(err).Error("Ignoring route with invalid backend options")
+		return
+	}
+	if options.DialTimeout, err = parseRouteTimeout(mapping.DialTimeout); err != nil {
+		logger.WithError(err).Error("Ignoring route with invalid dial timeout")
+		return
+	}
+	if options.FirstByteTimeout, err = parseRouteTimeout(mapping.FirstByteTimeout); err != nil {
+		logger.WithError(err).Error("Ignoring route with invalid first byte timeout")
+		return
+	}
+	if options.SleepAfter, err = parseRouteTimeout(mapping.SleepAfter); err != nil {
+		logger.WithError(err).Error("Ignoring route with invalid sleep after")
+		return
+	}
+	if mapping.Favicon != "" {
+		options.Favicon = r.favicons.get(mapping.Favicon, logger)
+	}
+	Routes.CreateMapping(serverAddress, backend, routeWaker(backend, options), options)
+}
+
+// parseRouteTimeout parses the duration of a route's timeout, where an empty value uses the connector's timeout
+func parseRouteTimeout(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if timeout < 0 {
+		return 0, errors.Errorf("timeout %s is negative", value)
+	}
+	return timeout, nil
+}
+
+func (r *routesConfigImpl) setLoaded(config routesConfigStructure) {
+	r.Lock()
+	defer r.Unlock()
+	r.loaded = config
+}
+
 func (r *routesConfigImpl) AddMapping(serverAddress string, backend string) {
 	if !r.isRoutesConfigEnabled() {
 		return
@@ -60,10 +276,13 @@ func (r *routesConfigImpl) AddMapping(serverAddress string, backend string) {
 		return
 	}
 	if config.Mappings == nil {
-		config.Mappings = make(map[string]string)
+		config.Mappings = make(map[string]routesConfigMapping)
 	}
 
-	config.Mappings[serverAddress] = backend
+	// the status declared by an object mapping is kept when only its backend is replaced
+	mapping := config.Mappings[serverAddress]
+	mapping.Backend = backend
+	config.Mappings[serverAddress] = mapping
 
 	writeErr := r.writeRoutesConfigFile(config)
 	if writeErr != nil {
@@ -139,7 +358,7 @@ func (r *routesConfigImpl) readRoutesConfigFile() (routesConfigStructure, error)
 
 	config := routesConfigStructure{
 		"",
-		make(map[string]string),
+		make(map[string]routesConfigMapping),
 	}
 
 	file, fileErr := os.ReadFile(r.fileName)
@@ -147,7 +366,11 @@ func (r *routesConfigImpl) readRoutesConfigFile() (routesConfigStructure, error)
 		return config, errors.Wrap(fileErr, "Could not load the routes config file")
 	}
 
-	parseErr := json.Unmarshal(file, &config)
+	// Comments and trailing commas are tolerated since the file is often edited by hand
+	standardized, parseErr := hujson.Standardize(blankHashComments(file))
+	if parseErr == nil {
+		parseErr = json.Unmarshal(standardized, &config)
+	}
 	if parseErr != nil {
 		return config, errors.Wrap(parseErr, "Could not parse the json routes config file")
 	}
@@ -155,6 +378,18 @@ func (r *routesConfigImpl) readRoutesConfigFile() (routesConfigStructure, error)
 	return config, nil
 }
 
+// blankHashComments blanks the lines that start with #, other than leading whitespace, which HuJSON doesn't accept.
+// Such a line can't be within a JSON string, and its newline is retained so that parse errors refer to the same line.
+func blankHashComments(file []byte) []byte {
+	lines := bytes.SplitAfter(file, []byte("\n"))
+	for i, line := range lines {
+		if bytes.HasPrefix(bytes.TrimLeft(line, " \t"), []byte("#")) {
+			lines[i] = line[len(bytes.TrimRight(line, "\r\n")):]
+		}
+	}
+	return bytes.Join(lines, nil)
+}
+
 func (r *routesConfigImpl) writeRoutesConfigFile(config routesConfigStructure) error {
 	r.Lock()
 	defer r.Unlock()
@@ -168,6 +403,38 @@ func (r *routesConfigImpl) writeRoutesConfigFile(config routesConfigStructure) e
 	if fileErr != nil {
 		return errors.Wrap(fileErr, "Could not write to the routes config file")
 	}
+	r.loaded = expandRoutesConfig(config)
 
 	return nil
 }
+
+// expandRoutesConfig returns a copy of the config where ${VAR} or $VAR references to environment variables in the
+// backends of the mappings are expanded. A literal $ can be given as $$. The file itself is left with the references
+// so that routes added or removed via the API don't persist the expanded values.
+func expandRoutesConfig(config routesConfigStructure) routesConfigStructure {
+	expanded := routesConfigStructure{
+		DefaultServer: expandEnv(config.DefaultServer),
+		Mappings:      make(map[string]routesConfigMapping, len(config.Mappings)),
+	}
+	for serverAddress, mapping := range config.Mappings {
+		mapping.Backend = expandEnv(mapping.Backend)
+		expanded.Mappings[serverAddress] = mapping
+	}
+	return expanded
+}
+
+func expandEnv(value string) string {
+	return os.Expand(value, func(name string) string {
+		if name == "$" {
+			return "$"
+		}
+		envValue, exists := os.LookupEnv(name)
+		if !exists {
+			logrus.
+				WithField("variable", name).
+				WithField("value", value).
+				Warn("Routes config references an unset environment variable")
+		}
+		return envValue
+	})
+}
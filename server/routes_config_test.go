@@ -0,0 +1,282 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoutesConfig_Reload(t *testing.T) {
+	Routes.Reset()
+	Routes.SetDefaultRoute("")
+
+	configFile := filepath.Join(t.TempDir(), "routes.json")
+	require.NoError(t, os.WriteFile(configFile, []byte(`{
+  "default-server": "default:25565",
+  "mappings": {
+    "same.example.com": "same:25565",
+    "changed.example.com": "old:25565",
+    "removed.example.com": "removed:25565"
+  }
+}`), 0644))
+
+	routesConfig := &routesConfigImpl{}
+	require.NoError(t, routesConfig.ReadRoutesConfig(configFile))
+
+	require.NoError(t, os.WriteFile(configFile, []byte(`{
+  "default-server": "default:25565",
+  "mappings": {
+    "same.example.com": "same:25565",
+    "changed.example.com": "new:25565",
+    "added.example.com": "added:25565"
+  }
+}`), 0644))
+
+	changes, err := routesConfig.Reload()
+	require.NoError(t, err)
+	assert.Equal(t, &RoutesConfigChanges{
+		Added:   []string{"added.example.com"},
+		Removed: []string{"removed.example.com"},
+		Changed: []string{"changed.example.com"},
+	}, changes)
+
+	assert.Equal(t, map[string]string{
+		"same.example.com":    "same:25565",
+		"changed.example.com": "new:25565",
+		"added.example.com":   "added:25565",
+	}, Routes.GetMappings())
+
+	backend, _, _ := Routes.FindBackendForServerAddress(context.Background(), "removed.example.com")
+	assert.Equal(t, "default:25565", backend, "removed route falls back to default")
+}
+
+func TestRoutesConfig_rewriteHost(t *testing.T) {
+	Routes.Reset()
+	defer Routes.DeleteMapping("mc.example.com")
+
+	configFile := filepath.Join(t.TempDir(), "routes.json")
+	require.NoError(t, os.WriteFile(configFile, []byte(`{
+  "mappings": {
+    "mc.example.com": "mc-internal:25565;rewriteHost=internal.example.com",
+    "invalid.example.com": "invalid:25565;unknown=value"
+  }
+}`), 0644))
+
+	routesConfig := &routesConfigImpl{}
+	require.NoError(t, routesConfig.ReadRoutesConfig(configFile))
+
+	assert.Equal(t, map[string]string{"mc.example.com": "mc-internal:25565"}, Routes.GetMappings())
+	options, exists := Routes.GetRouteOptions("mc.example.com")
+	require.True(t, exists)
+	assert.Equal(t, "internal.example.com", options.BackendServerName)
+	assert.Equal(t, RouteSourceConfig, options.Source)
+}
+
+func TestRoutesConfig_statusOfObjectMappings(t *testing.T) {
+	Routes.Reset()
+	defer Routes.DeleteMapping("plain.example.com")
+	defer Routes.DeleteMapping("lobby.example.com")
+
+	dir := t.TempDir()
+	faviconFile := filepath.Join(dir, "lobby.png")
+	require.NoError(t, os.WriteFile(faviconFile, pngSignature, 0644))
+
+	configFile := filepath.Join(dir, "routes.json")
+	require.NoError(t, os.WriteFile(configFile, []byte(`{
+  "mappings": {
+    "plain.example.com": "plain:25565",
+    "lobby.example.com": {
+      "backend": "lobby:25565",
+      "asleepMotd": "The lobby is asleep",
+      "favicon": "`+faviconFile+`",
+      "maxPlayers": 50
+    }
+  }
+}`), 0644))
+
+	routesConfig := &routesConfigImpl{}
+	require.NoError(t, routesConfig.ReadRoutesConfig(configFile))
+
+	assert.Equal(t, map[string]string{
+		"plain.example.com": "plain:25565",
+		"lobby.example.com": "lobby:25565",
+	}, Routes.GetMappings())
+	options, exists := Routes.GetRouteOptions("lobby.example.com")
+	require.True(t, exists)
+	assert.Equal(t, "The lobby is asleep", options.MOTD)
+	assert.Equal(t, 50, options.MaxPlayers)
+	assert.Equal(t, faviconDataUrlPrefix+base64.StdEncoding.EncodeToString(pngSignature), options.Favicon)
+
+	// replacing the backend via the API keeps the object form, and plain mappings stay strings
+	routesConfig.AddMapping("lobby.example.com", "lobby2:25565")
+	routesConfig.AddMapping("added.example.com", "added:25565")
+	content, err := os.ReadFile(configFile)
+	require.NoError(t, err)
+	var written struct {
+		Mappings map[string]any `json:"mappings"`
+	}
+	require.NoError(t, json.Unmarshal(content, &written))
+	assert.Equal(t, "plain:25565", written.Mappings["plain.example.com"])
+	assert.Equal(t, "added:25565", written.Mappings["added.example.com"])
+	assert.Equal(t, map[string]any{
+		"backend":    "lobby2:25565",
+		"asleepMotd": "The lobby is asleep",
+		"favicon":    faviconFile,
+		"maxPlayers": float64(50),
+	}, written.Mappings["lobby.example.com"])
+}
+
+func TestRoutesConfig_timeouts(t *testing.T) {
+	Routes.Reset()
+	defer Routes.DeleteMapping("modded.example.com")
+
+	configFile := filepath.Join(t.TempDir(), "routes.json")
+	require.NoError(t, os.WriteFile(configFile, []byte(`{
+  "mappings": {
+    "modded.example.com": {"backend": "modded:25565", "dialTimeout": "30s", "firstByteTimeout": "2m"},
+    "invalid.example.com": {"backend": "invalid:25565", "dialTimeout": "soon"}
+  }
+}`), 0644))
+
+	routesConfig := &routesConfigImpl{}
+	require.NoError(t, routesConfig.ReadRoutesConfig(configFile))
+
+	assert.Equal(t, map[string]string{"modded.example.com": "modded:25565"}, Routes.GetMappings())
+	options, exists := Routes.GetRouteOptions("modded.example.com")
+	require.True(t, exists)
+	assert.Equal(t, 30*time.Second, options.DialTimeout)
+	assert.Equal(t, 2*time.Minute, options.FirstByteTimeout)
+}
+
+func TestRoutesConfig_commands(t *testing.T) {
+	Routes.Reset()
+	defer Routes.DeleteMapping("mc.example.com")
+	Routes.AllowExecWakers(true)
+	defer Routes.AllowExecWakers(false)
+
+	configFile := filepath.Join(t.TempDir(), "routes.json")
+	require.NoError(t, os.WriteFile(configFile, []byte(`{
+  "mappings": {
+    "mc.example.com": {
+      "backend": "mc:25565;wakeTimeout=5m",
+      "wakeCmd": "docker compose up -d mc",
+      "sleepCmd": "docker compose stop mc",
+      "sleepAfter": "30m"
+    },
+    "conflicting.example.com": {"backend": "mc:25565;wakeUrl=http://starter", "wakeCmd": "docker compose up -d mc"}
+  }
+}`), 0644))
+
+	routesConfig := &routesConfigImpl{}
+	require.NoError(t, routesConfig.ReadRoutesConfig(configFile))
+
+	assert.Equal(t, map[string]string{"mc.example.com": "mc:25565"}, Routes.GetMappings())
+	options, exists := Routes.GetRouteOptions("mc.example.com")
+	require.True(t, exists)
+	assert.Equal(t, "docker compose up -d mc", options.WakeCommand)
+	assert.Equal(t, "docker compose stop mc", options.SleepCommand)
+	assert.Equal(t, 30*time.Minute, options.SleepAfter)
+	assert.Equal(t, 5*time.Minute, options.WakeTimeout)
+	assert.True(t, options.Scalable)
+}
+
+func TestExpandRoutesConfig(t *testing.T) {
+	t.Setenv("MC_ROUTER_TEST_BACKEND_HOST", "vanilla.internal")
+	t.Setenv("MC_ROUTER_TEST_DEFAULT", "default.internal:25565")
+
+	expanded := expandRoutesConfig(routesConfigStructure{
+		DefaultServer: "${MC_ROUTER_TEST_DEFAULT}",
+		Mappings: map[string]routesConfigMapping{
+			"vanilla.example.com": {Backend: "${MC_ROUTER_TEST_BACKEND_HOST}:25565"},
+			"literal.example.com": {Backend: "$$literal:25565"},
+			"missing.example.com": {Backend: "${MC_ROUTER_TEST_MISSING}:25565", AsleepMOTD: "$HOME is kept"},
+		},
+	})
+
+	assert.Equal(t, routesConfigStructure{
+		DefaultServer: "default.internal:25565",
+		Mappings: map[string]routesConfigMapping{
+			"vanilla.example.com": {Backend: "vanilla.internal:25565"},
+			"literal.example.com": {Backend: "$literal:25565"},
+			"missing.example.com": {Backend: ":25565", AsleepMOTD: "$HOME is kept"},
+		},
+	}, expanded)
+}
+
+func TestRoutesConfig_KeepsReferencesWhenWriting(t *testing.T) {
+	Routes.Reset()
+	t.Setenv("MC_ROUTER_TEST_BACKEND_HOST", "vanilla.internal")
+
+	configFile := filepath.Join(t.TempDir(), "routes.json")
+	require.NoError(t, os.WriteFile(configFile, []byte(`{
+  "mappings": {
+    "vanilla.example.com": "${MC_ROUTER_TEST_BACKEND_HOST}:25565"
+  }
+}`), 0644))
+
+	routesConfig := &routesConfigImpl{}
+	require.NoError(t, routesConfig.ReadRoutesConfig(configFile))
+	assert.Equal(t, "vanilla.internal:25565", Routes.GetMappings()["vanilla.example.com"])
+
+	routesConfig.AddMapping("added.example.com", "added:25565")
+
+	content, err := os.ReadFile(configFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "${MC_ROUTER_TEST_BACKEND_HOST}:25565")
+}
+
+func TestRoutesConfig_ReloadMissingFile(t *testing.T) {
+	Routes.Reset()
+
+	configFile := filepath.Join(t.TempDir(), "routes.json")
+	require.NoError(t, os.WriteFile(configFile, []byte(`{"mappings": {"vanilla.example.com": "vanilla:25565"}}`), 0644))
+
+	routesConfig := &routesConfigImpl{}
+	require.NoError(t, routesConfig.ReadRoutesConfig(configFile))
+
+	require.NoError(t, os.Remove(configFile))
+	_, err := routesConfig.Reload()
+	assert.Error(t, err)
+	assert.Equal(t, map[string]string{"vanilla.example.com": "vanilla:25565"}, Routes.GetMappings())
+}
+
+func TestRoutesConfig_ReadWithCommentsAndTrailingCommas(t *testing.T) {
+	Routes.Reset()
+	Routes.SetDefaultRoute("")
+
+	configFile := filepath.Join(t.TempDir(), "routes.json")
+	require.NoError(t, os.WriteFile(configFile, []byte(`{
+  // used when no mapping matches
+  "default-server": "default:25565",
+  # mappings by server address
+  "mappings": {
+    /* the main server */
+    "vanilla.example.com": "vanilla:25565",
+	# "retired.example.com": "retired:25565",
+    "modded.example.com": "modded:25565", // added for the event
+  },
+}`), 0644))
+
+	routesConfig := &routesConfigImpl{}
+	require.NoError(t, routesConfig.ReadRoutesConfig(configFile))
+	assert.Equal(t, map[string]string{
+		"vanilla.example.com": "vanilla:25565",
+		"modded.example.com":  "modded:25565",
+	}, Routes.GetMappings())
+
+	// written back as strict JSON
+	routesConfig.AddMapping("added.example.com", "added:25565")
+	content, err := os.ReadFile(configFile)
+	require.NoError(t, err)
+	var written routesConfigStructure
+	require.NoError(t, json.Unmarshal(content, &written))
+	assert.Equal(t, "default:25565", written.DefaultServer)
+	assert.Len(t, written.Mappings, 3)
+}
@@ -3,8 +3,10 @@ package server
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func Test_routesImpl_FindBackendForServerAddress(t *testing.T) {
@@ -61,12 +63,42 @@ func Test_routesImpl_FindBackendForServerAddress(t *testing.T) {
 			},
 			want: "backend:25566",
 		},
+		{
+			name: "bracketed ipv6",
+			mapping: mapping{
+				serverAddress: "::1", backend: "backend:25566",
+			},
+			args: args{
+				serverAddress: "[::1]",
+			},
+			want: "backend:25566",
+		},
+		{
+			name: "ipv6 with zone",
+			mapping: mapping{
+				serverAddress: "fe80::1", backend: "backend:25566",
+			},
+			args: args{
+				serverAddress: "FE80:0:0::1%eth0",
+			},
+			want: "backend:25566",
+		},
+		{
+			name: "non-canonical ipv6",
+			mapping: mapping{
+				serverAddress: "2001:db8::10", backend: "backend:25566",
+			},
+			args: args{
+				serverAddress: "[2001:DB8:0::10]",
+			},
+			want: "backend:25566",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			r := NewRoutes()
 
-			r.CreateMapping(tt.mapping.serverAddress, tt.mapping.backend, func(ctx context.Context) error { return nil })
+			r.CreateMapping(tt.mapping.serverAddress, tt.mapping.backend, func(ctx context.Context) error { return nil }, RouteOptions{})
 
 			if got, server, _ := r.FindBackendForServerAddress(context.Background(), tt.args.serverAddress); got != tt.want {
 				t.Errorf("routesImpl.FindBackendForServerAddress() = %v, want %v", got, tt.want)
@@ -76,3 +108,180 @@ func Test_routesImpl_FindBackendForServerAddress(t *testing.T) {
 		})
 	}
 }
+
+func Test_routesImpl_ResolveServerAddress(t *testing.T) {
+	r := NewRoutes()
+	r.CreateMapping("typical.my.domain", "backend:25565", func(ctx context.Context) error { return nil }, RouteOptions{})
+
+	resolution := r.ResolveServerAddress("Typical.My.Domain.\x00FML3\x00")
+	assert.Equal(t, RouteResolution{
+		ServerAddress:     "Typical.My.Domain.\x00FML3\x00",
+		NormalizedAddress: "typical.my.domain",
+		Match:             RouteMatchExact,
+		Backend:           "backend:25565",
+	}, resolution)
+
+	resolution = r.ResolveServerAddress("other.my.domain")
+	assert.Equal(t, RouteMatchNone, resolution.Match)
+	assert.Empty(t, resolution.Backend)
+
+	r.SetDefaultRoute("default:25565")
+	resolution = r.ResolveServerAddress("other.my.domain")
+	assert.Equal(t, RouteMatchDefault, resolution.Match)
+	assert.Equal(t, "default:25565", resolution.Backend)
+}
+
+func Test_routesImpl_RecordConnection(t *testing.T) {
+	r := NewRoutes()
+	r.CreateMapping("typical.my.domain", "backend:25565", func(ctx context.Context) error { return nil }, RouteOptions{})
+
+	detail, exists := r.GetRouteDetail("Typical.My.Domain")
+	require.True(t, exists)
+	assert.Equal(t, RouteDetail{ServerAddress: "typical.my.domain", Backend: "backend:25565", Wakeable: true}, detail)
+
+	connectedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	r.RecordConnection("typical.my.domain", connectedAt)
+	// unregistered, such as the default route, are ignored
+	r.RecordConnection("other.my.domain", connectedAt)
+
+	// retained when the route is updated
+	r.CreateMapping("typical.my.domain", "new-backend:25565", func(ctx context.Context) error { return nil }, RouteOptions{})
+	detail, exists = r.GetRouteDetail("typical.my.domain")
+	require.True(t, exists)
+	if assert.NotNil(t, detail.LastConnection) {
+		assert.Equal(t, connectedAt, *detail.LastConnection)
+	}
+	assert.Equal(t, "new-backend:25565", detail.Backend)
+
+	_, exists = r.GetRouteDetail("other.my.domain")
+	assert.False(t, exists)
+}
+
+func Test_routesImpl_GetRouteDetail_wakeable(t *testing.T) {
+	r := NewRoutes()
+	r.RegisterAll(map[string]string{
+		"static.my.domain": "backend:25565",
+		"woken.my.domain":  "backend:25565;wakeUrl=http://waker.my.domain/start",
+	})
+
+	detail, exists := r.GetRouteDetail("static.my.domain")
+	require.True(t, exists)
+	assert.False(t, detail.Wakeable, "a static route without a wake URL or command can't be woken")
+
+	detail, exists = r.GetRouteDetail("woken.my.domain")
+	require.True(t, exists)
+	assert.True(t, detail.Wakeable)
+}
+
+func Test_normalizeIPLiteral(t *testing.T) {
+	assert.Equal(t, "::1", normalizeIPLiteral("[::1]"))
+	assert.Equal(t, "::1", normalizeIPLiteral("[::1]:25565"))
+	assert.Equal(t, "fe80::1", normalizeIPLiteral("[fe80::1%25eth0]"))
+	assert.Equal(t, "fe80::1", normalizeIPLiteral("fe80::1%eth0"))
+	assert.Equal(t, "10.0.0.1", normalizeIPLiteral("10.0.0.1"))
+	assert.Equal(t, "mc.example.com", normalizeIPLiteral("mc.example.com"))
+	assert.Equal(t, "[mc.example.com", normalizeIPLiteral("[mc.example.com"))
+
+	// routes are registered in the same form
+	r := NewRoutes()
+	r.CreateMapping("[2001:DB8::10]", "backend:25565", nil, RouteOptions{})
+	assert.Contains(t, r.GetMappings(), "2001:db8::10")
+}
+
+func TestValidateBackend(t *testing.T) {
+	tests := []struct {
+		backend string
+		valid   bool
+	}{
+		{backend: "", valid: true},
+		{backend: "10.0.0.1:25565", valid: true},
+		{backend: "mc.example.com:25565", valid: true},
+		{backend: "[::1]:25565", valid: true},
+		{backend: "10.0.0.1;25565"},
+		{backend: "10.0.0.1"},
+		{backend: ":25565"},
+		{backend: "10.0.0.1:"},
+		{backend: "10.0.0.1:minecraft"},
+		{backend: "10.0.0.1:70000"},
+		{backend: "::1:25565"},
+	}
+	for _, test := range tests {
+		t.Run(test.backend, func(t *testing.T) {
+			err := ValidateBackend(test.backend)
+			if test.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func Test_routesImpl_CreateMappingIgnoresInvalidBackend(t *testing.T) {
+	r := NewRoutes()
+	r.CreateMapping("typical.my.domain", "10.0.0.1;25565", func(ctx context.Context) error { return nil }, RouteOptions{})
+
+	assert.Empty(t, r.GetMappings())
+}
+
+func Test_routesImpl_ObserveRouteEvents(t *testing.T) {
+	r := NewRoutes()
+	var events []*ConnectionEvent
+	r.ObserveRouteEvents(func(event *ConnectionEvent) {
+		events = append(events, event)
+	})
+	noopWaker := func(ctx context.Context) error { return nil }
+
+	r.CreateMapping("typical.my.domain", "backend:25565", noopWaker, RouteOptions{Source: RouteSourceDocker})
+	// re-registering the same backend isn't a change
+	r.CreateMapping("typical.my.domain", "backend:25565", noopWaker, RouteOptions{Source: RouteSourceDocker})
+	r.CreateMapping("typical.my.domain", "new-backend:25565", noopWaker, RouteOptions{Source: RouteSourceDocker})
+	r.CreateMapping("invalid.my.domain", "10.0.0.1;25565", noopWaker, RouteOptions{Source: RouteSourceDocker})
+	assert.True(t, r.DeleteMapping("typical.my.domain"))
+	assert.False(t, r.DeleteMapping("typical.my.domain"))
+
+	require.Len(t, events, 3)
+	assert.Equal(t, RouteEventAdded, events[0].Event)
+	assert.Equal(t, "backend:25565", events[0].BackendHostPort)
+	assert.Equal(t, RouteEventAdded, events[1].Event)
+	assert.Equal(t, "new-backend:25565", events[1].BackendHostPort)
+	assert.Equal(t, RouteEventRemoved, events[2].Event)
+	assert.Equal(t, "new-backend:25565", events[2].BackendHostPort)
+	for _, event := range events {
+		assert.Equal(t, "typical.my.domain", event.Server)
+		assert.Equal(t, RouteSourceDocker, event.Source)
+		assert.Empty(t, event.Client)
+	}
+}
+
+func Test_routesImpl_SimplifySRV(t *testing.T) {
+	tests := []struct {
+		name          string
+		labels        []string
+		serverAddress string
+		want          string
+	}{
+		{name: "minecraft tcp", serverAddress: "_minecraft._tcp.play.my.domain", want: "play.my.domain"},
+		{name: "nested", serverAddress: "_extra._minecraft._tcp.play.my.domain", want: "play.my.domain"},
+		{name: "not srv", serverAddress: "play.my.domain", want: "play.my.domain"},
+		{name: "only leading", serverAddress: "_minecraft._tcp.play._sub.my.domain", want: "play._sub.my.domain"},
+		{
+			name: "recognized labels", labels: []string{"_minecraft", "_TCP"},
+			serverAddress: "_minecraft._tcp.play.my.domain", want: "play.my.domain",
+		},
+		{
+			name: "unrecognized label", labels: []string{"_minecraft", "_tcp"},
+			serverAddress: "_other._minecraft._tcp.play.my.domain", want: "_other._minecraft._tcp.play.my.domain",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRoutes()
+			r.SimplifySRV(true)
+			r.UseSRVLabels(tt.labels)
+
+			resolution := r.ResolveServerAddress(tt.serverAddress)
+			assert.Equal(t, tt.want, resolution.NormalizedAddress)
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// shadowBufferChunks is the number of writes that can be queued for the shadow backend before
+// mirroring of the connection is abandoned
+const shadowBufferChunks = 64
+
+// shadowedConn is a backend connection where the writes, which carry the client to backend stream, are also
+// mirrored to a shadow backend. The mirroring never blocks nor fails writes to the primary backend. Once the
+// shadow backend falls behind or fails, mirroring of the connection stops since the partial stream is no longer
+// meaningful to the shadow backend.
+type shadowedConn struct {
+	net.Conn
+	chunks    chan []byte
+	closeOnce sync.Once
+	mu        sync.Mutex
+	stopped   bool
+}
+
+// shouldShadow determines if a connection should be mirrored given the route's shadow sample rate,
+// where a rate of 1 or more mirrors all connections
+func shouldShadow(options RouteOptions) bool {
+	if options.ShadowBackend == "" || options.ShadowSampleRate <= 0 {
+		return false
+	}
+	return options.ShadowSampleRate >= 1 || rand.Float64() < options.ShadowSampleRate
+}
+
+// newShadowedConn wraps the backendConn and dials the shadow backend in the background, queueing the mirrored
+// writes in the meantime.
+func newShadowedConn(ctx context.Context, backendConn net.Conn, shadowHostPort string, clientAddr net.Addr,
+	dial func(ctx context.Context, address string) (net.Conn, error)) *shadowedConn {
+
+	s := &shadowedConn{
+		Conn:   backendConn,
+		chunks: make(chan []byte, shadowBufferChunks),
+	}
+	go s.mirror(ctx, shadowHostPort, clientAddr, dial)
+	return s
+}
+
+func (s *shadowedConn) Write(p []byte) (int, error) {
+	s.queue(p)
+	return s.Conn.Write(p)
+}
+
+func (s *shadowedConn) queue(p []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return
+	}
+
+	chunk := make([]byte, len(p))
+	copy(chunk, p)
+	select {
+	case s.chunks <- chunk:
+	default:
+		logrus.Debug("Shadow backend fell behind, stopping mirroring of connection")
+		s.stopLocked()
+	}
+}
+
+func (s *shadowedConn) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopLocked()
+}
+
+func (s *shadowedConn) stopLocked() {
+	s.closeOnce.Do(func() {
+		s.stopped = true
+		close(s.chunks)
+	})
+}
+
+func (s *shadowedConn) Close() error {
+	s.stop()
+	return s.Conn.Close()
+}
+
+func (s *shadowedConn) mirror(ctx context.Context, shadowHostPort string, clientAddr net.Addr,
+	dial func(ctx context.Context, address string) (net.Conn, error)) {
+
+	shadowConn, err := dial(ctx, shadowHostPort)
+	if err != nil {
+		logrus.
+			WithError(err).
+			WithField("client", clientAddr).
+			WithField("shadowBackend", shadowHostPort).
+			Warn("Unable to connect to shadow backend")
+		s.stop()
+		// drain anything queued prior to stopping
+		for range s.chunks {
+		}
+		return
+	}
+	//goland:noinspection GoUnhandledErrorResult
+	defer shadowConn.Close()
+
+	// responses from the shadow backend are never relayed to the client
+	go func() {
+		_, _ = io.Copy(io.Discard, shadowConn)
+	}()
+
+	for chunk := range s.chunks {
+		if _, err := shadowConn.Write(chunk); err != nil {
+			logrus.
+				WithError(err).
+				WithField("client", clientAddr).
+				WithField("shadowBackend", shadowHostPort).
+				Debug("Failed to write to shadow backend")
+			s.stop()
+			for range s.chunks {
+			}
+			return
+		}
+	}
+}
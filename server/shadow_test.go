@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func dialTest(ctx context.Context, address string) (net.Conn, error) {
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "tcp", address)
+}
+
+func TestShadowedConn_Mirrors(t *testing.T) {
+	shadowListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	//goland:noinspection GoUnhandledErrorResult
+	defer shadowListener.Close()
+
+	primaryConn, primaryPeer := net.Pipe()
+	s := newShadowedConn(context.Background(), primaryConn, shadowListener.Addr().String(),
+		primaryConn.LocalAddr(), dialTest)
+
+	go func() {
+		_, _ = s.Write([]byte("hello"))
+		_, _ = s.Write([]byte(" world"))
+		_ = s.Close()
+	}()
+
+	primaryReceived, err := io.ReadAll(primaryPeer)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(primaryReceived))
+
+	shadowConn, err := shadowListener.Accept()
+	require.NoError(t, err)
+	//goland:noinspection GoUnhandledErrorResult
+	defer shadowConn.Close()
+	require.NoError(t, shadowConn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	shadowReceived, err := io.ReadAll(shadowConn)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(shadowReceived))
+}
+
+func TestShadowedConn_NeverBlocksPrimary(t *testing.T) {
+	// a shadow backend that never completes connecting
+	blockedDial := func(ctx context.Context, address string) (net.Conn, error) {
+		<-ctx.Done()
+		return nil, errors.New("cancelled")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	primaryConn, primaryPeer := net.Pipe()
+	go func() {
+		_, _ = io.Copy(io.Discard, primaryPeer)
+	}()
+	s := newShadowedConn(ctx, primaryConn, "shadow:25565", primaryConn.LocalAddr(), blockedDial)
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < shadowBufferChunks*2; i++ {
+			_, err := s.Write([]byte("data"))
+			assert.NoError(t, err)
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("writes to the primary were blocked by the shadow")
+	}
+	s.mu.Lock()
+	assert.True(t, s.stopped, "mirroring should stop once the shadow falls behind")
+	s.mu.Unlock()
+	require.NoError(t, s.Close())
+}
+
+func TestShouldShadow(t *testing.T) {
+	assert.False(t, shouldShadow(RouteOptions{}))
+	assert.False(t, shouldShadow(RouteOptions{ShadowBackend: "shadow:25565"}))
+	assert.True(t, shouldShadow(RouteOptions{ShadowBackend: "shadow:25565", ShadowSampleRate: 1}))
+	assert.False(t, shouldShadow(RouteOptions{ShadowSampleRate: 1}))
+}
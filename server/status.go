@@ -0,0 +1,201 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+
+	"github.com/itzg/mc-router/mcproto"
+	"github.com/sirupsen/logrus"
+)
+
+// serveUnavailable responds in place of a backend by serving a status with the given message and optional favicon
+// to status requests and disconnecting login attempts with the same message.
+// Legacy server list pings are just closed.
+func (c *Connector) serveUnavailable(ctx context.Context, frontendConn net.Conn, clientAddr net.Addr,
+	frontendReader io.Reader, handshake *mcproto.Handshake, message string, favicon string) {
+
+	if handshake == nil {
+		return
+	}
+
+	if mcproto.State(handshake.NextState) == mcproto.StateStatus {
+		c.serveStatus(ctx, frontendConn, clientAddr, frontendReader, handshake, message, favicon)
+	} else if isLoginIntent(handshake.NextState) {
+		c.serveLoginDisconnect(ctx, frontendConn, clientAddr, message)
+	}
+}
+
+// serveStatus completes the status exchange with the client using a status built from the given MOTD and
+// optional favicon
+func (c *Connector) serveStatus(ctx context.Context, frontendConn net.Conn, clientAddr net.Addr,
+	frontendReader io.Reader, handshake *mcproto.Handshake, motd string, favicon string) {
+
+	c.serveStatusResponse(ctx, frontendConn, clientAddr, frontendReader, handshake, &mcproto.StatusResponse{
+		Description: mcproto.TextComponent{Text: motd},
+		Favicon:     favicon,
+	})
+}
+
+// serveStatusResponse completes the status exchange with the client using the given status, where the version
+// is replaced to match the client's
+func (c *Connector) serveStatusResponse(ctx context.Context, frontendConn net.Conn, clientAddr net.Addr,
+	frontendReader io.Reader, handshake *mcproto.Handshake, status *mcproto.StatusResponse) {
+
+	served := *status
+	served.Version = c.getVersionInfo(handshake.ProtocolVersion)
+	c.completeStatusExchange(ctx, frontendConn, clientAddr, frontendReader, &served)
+}
+
+// completeStatusExchange reads the client's status request, responds with the status as given, and then echoes
+// the client's ping
+func (c *Connector) completeStatusExchange(ctx context.Context, frontendConn net.Conn, clientAddr net.Addr,
+	frontendReader io.Reader, status *mcproto.StatusResponse) {
+
+	c.completeStatusExchangeWith(ctx, frontendConn, clientAddr, frontendReader, func(writer io.Writer) error {
+		return mcproto.WriteStatusResponse(writer, status)
+	})
+}
+
+// completeStatusExchangeWith reads the client's status request, responds using writeStatus, and then echoes the
+// client's ping
+func (c *Connector) completeStatusExchangeWith(ctx context.Context, frontendConn net.Conn, clientAddr net.Addr,
+	frontendReader io.Reader, writeStatus func(writer io.Writer) error) {
+
+	packet, err := mcproto.ReadPacket(frontendReader, clientAddr, mcproto.StateStatus)
+	if err != nil {
+		logrus.WithError(err).WithField("client", clientAddr).Debug("Failed to read status request")
+		return
+	}
+	c.observeFrameLength(packet)
+	if packet.PacketID != mcproto.PacketIdStatusRequest {
+		logrus.
+			WithField("client", clientAddr).
+			WithField("packetID", packet.PacketID).
+			Debug("Unexpected packetID, expected status request")
+		return
+	}
+
+	if err := writeStatus(frontendConn); err != nil {
+		logrus.WithError(err).WithField("client", clientAddr).Error("Failed to write status response")
+		c.connectionErrors(ctx, "status_write").Add(1)
+		return
+	}
+
+	// The client follows up with a ping to measure latency, which is echoed back as the pong
+	packet, err = mcproto.ReadPacket(frontendReader, clientAddr, mcproto.StateStatus)
+	if err != nil {
+		logrus.WithError(err).WithField("client", clientAddr).Debug("Client did not ping after status")
+		return
+	}
+	c.observeFrameLength(packet)
+	if packet.PacketID != mcproto.PacketIdPing {
+		return
+	}
+	payload, _ := packet.Data.([]byte)
+	if err := mcproto.WritePacket(frontendConn, mcproto.PacketIdPong, payload); err != nil {
+		logrus.WithError(err).WithField("client", clientAddr).Debug("Failed to write pong")
+	}
+}
+
+// serveRouteStatus serves the cached status of the route's backend, the route's configured MOTD, or the unavailable
+// status message to status requests, when available, and disconnects login attempts with the no backend message, when configured.
+// Otherwise, the client is left to be disconnected.
+func (c *Connector) serveRouteStatus(ctx context.Context, frontendConn net.Conn, clientAddr net.Addr,
+	frontendReader io.Reader, handshake *mcproto.Handshake, resolvedHost string, options RouteOptions) {
+
+	if handshake == nil {
+		return
+	}
+	if isLoginIntent(handshake.NextState) {
+		if c.noBackendMessage != "" {
+			c.serveLoginDisconnect(ctx, frontendConn, clientAddr, c.noBackendMessage)
+		}
+		return
+	}
+	if mcproto.State(handshake.NextState) != mcproto.StateStatus {
+		return
+	}
+
+	if status, ok := c.cachedStatus(resolvedHost); ok {
+		logrus.
+			WithField("client", clientAddr).
+			WithField("serverAddress", resolvedHost).
+			Debug("Serving cached status in place of backend")
+		c.serveStatusResponse(ctx, frontendConn, clientAddr, frontendReader, handshake,
+			c.withRoutePlayers(status, resolvedHost, options))
+		return
+	}
+
+	motd := options.MOTD
+	if motd == "" {
+		// so that the server still appears in the client's list
+		motd = c.unavailableStatusMessage
+	}
+	if motd == "" {
+		return
+	}
+
+	logrus.
+		WithField("client", clientAddr).
+		WithField("serverAddress", resolvedHost).
+		Debug("Serving route's status in place of backend")
+	c.serveStatusResponse(ctx, frontendConn, clientAddr, frontendReader, handshake,
+		c.withRoutePlayers(&mcproto.StatusResponse{
+			Description: mcproto.TextComponent{Text: motd},
+			Favicon:     c.routeFavicon(resolvedHost, options),
+		}, resolvedHost, options))
+}
+
+// UseUnavailableStatusMessage serves a status with the given message to status requests when their backend is
+// missing or can't be reached and the route has no MOTD, rather than just closing the connection
+func (c *Connector) UseUnavailableStatusMessage(message string) {
+	c.unavailableStatusMessage = message
+}
+
+// UseNoBackendMessage disconnects login attempts with the given message when their backend is missing or
+// can't be reached, rather than just closing the connection
+func (c *Connector) UseNoBackendMessage(message string) {
+	c.noBackendMessage = message
+}
+
+// serveLoginDisconnect disconnects a client in the login state with the given message
+func (c *Connector) serveLoginDisconnect(ctx context.Context, frontendConn net.Conn, clientAddr net.Addr,
+	message string) {
+	messageJson, err := json.Marshal(mcproto.TextComponent{Text: message})
+	if err != nil {
+		logrus.WithError(err).Error("Failed to marshal disconnect message")
+		return
+	}
+
+	if err := mcproto.WriteLoginDisconnect(frontendConn, string(messageJson)); err != nil {
+		logrus.WithError(err).WithField("client", clientAddr).Error("Failed to write login disconnect")
+		c.connectionErrors(ctx, "disconnect_write").Add(1)
+	}
+}
+
+// defaultUnknownVersionName is served for protocol versions that aren't known by mcproto.ProtocolName
+const defaultUnknownVersionName = "1.7+"
+
+// UseUnknownVersionName sets the version name served along with statuses to clients with a protocol version
+// that isn't known, where an empty name restores the default
+func (c *Connector) UseUnknownVersionName(name string) {
+	if name == "" {
+		name = defaultUnknownVersionName
+	}
+	c.unknownVersionName = name
+}
+
+// getVersionInfo echoes back the client's protocol version, along with its version name, so that the served status
+// is not presented as incompatible
+func (c *Connector) getVersionInfo(clientProtocol int) mcproto.StatusVersion {
+	name := mcproto.ProtocolName(clientProtocol)
+	if name == "" {
+		name = c.unknownVersionName
+	}
+	return mcproto.StatusVersion{
+		Name:     name,
+		Protocol: clientProtocol,
+	}
+}
@@ -0,0 +1,217 @@
+package server
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/itzg/mc-router/mcproto"
+	"github.com/sirupsen/logrus"
+)
+
+// statusCacheProtocolVersion is presented to backends during the status pings, where any recent version is
+// acceptable since servers respond to status requests regardless of the client's version
+const statusCacheProtocolVersion = 767
+
+type statusCacheEntry struct {
+	status *mcproto.StatusResponse
+	// poolPlayers sums the players of each of the route's backends that responded, such as when load balanced
+	poolPlayers mcproto.StatusPlayers
+	fetchedAt   time.Time
+}
+
+// StatusCache retains the last status fetched from the backend of each route, so that a backend that is
+// asleep or can't be reached can still be presented with its own description, favicon, and players
+type StatusCache struct {
+	sync.RWMutex
+	entries map[string]statusCacheEntry
+	ttl     time.Duration
+	dial    func(ctx context.Context, address string) (net.Conn, error)
+}
+
+// NewStatusCache creates a StatusCache that fetches statuses using the given dial function, such as one from
+// NewBackendDialFunc, and serves each status for up to the ttl after it was fetched
+func NewStatusCache(dial func(ctx context.Context, address string) (net.Conn, error), ttl time.Duration) *StatusCache {
+	return &StatusCache{
+		entries: make(map[string]statusCacheEntry),
+		ttl:     ttl,
+		dial:    dial,
+	}
+}
+
+// Get returns the status last fetched for the route of the serverAddress, unless it is older than the ttl
+func (s *StatusCache) Get(serverAddress string) (*mcproto.StatusResponse, bool) {
+	s.RLock()
+	defer s.RUnlock()
+
+	entry, exists := s.entries[serverAddress]
+	if !exists || time.Since(entry.fetchedAt) > s.ttl {
+		return nil, false
+	}
+	return entry.status, true
+}
+
+// GetPoolPlayers returns the players summed across the backends of the route of the serverAddress, as last fetched,
+// unless older than the ttl. For a route with a single backend, these are the players of its status.
+func (s *StatusCache) GetPoolPlayers(serverAddress string) (mcproto.StatusPlayers, bool) {
+	s.RLock()
+	defer s.RUnlock()
+
+	entry, exists := s.entries[serverAddress]
+	if !exists || time.Since(entry.fetchedAt) > s.ttl {
+		return mcproto.StatusPlayers{}, false
+	}
+	return entry.poolPlayers, true
+}
+
+// GetFavicon returns the favicon of the status last fetched for the route of the serverAddress, regardless of the ttl
+// since a favicon rarely changes, such as to present a sleeping backend with its own favicon
+func (s *StatusCache) GetFavicon(serverAddress string) (string, bool) {
+	s.RLock()
+	defer s.RUnlock()
+
+	entry, exists := s.entries[serverAddress]
+	if !exists || entry.status.Favicon == "" {
+		return "", false
+	}
+	return entry.status.Favicon, true
+}
+
+// Size returns the number of routes with a cached status, including any that are older than the ttl
+func (s *StatusCache) Size() int {
+	s.RLock()
+	defer s.RUnlock()
+	return len(s.entries)
+}
+
+// Delete drops the status of the route of the serverAddress, such as when the route is removed
+func (s *StatusCache) Delete(serverAddress string) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.entries, serverAddress)
+}
+
+func (s *StatusCache) put(serverAddress string, status *mcproto.StatusResponse, fetchedAt time.Time) {
+	s.putPool(serverAddress, status, status.Players, fetchedAt)
+}
+
+func (s *StatusCache) putPool(serverAddress string, status *mcproto.StatusResponse, poolPlayers mcproto.StatusPlayers,
+	fetchedAt time.Time) {
+	s.Lock()
+	defer s.Unlock()
+	s.entries[serverAddress] = statusCacheEntry{status: status, poolPlayers: poolPlayers, fetchedAt: fetchedAt}
+}
+
+// StartUpdater fetches the status of every route with a backend now and then every interval until the ctx is done.
+// A failed fetch, such as of a sleeping backend, retains the previously fetched status.
+func (s *StatusCache) StartUpdater(ctx context.Context, interval time.Duration, routes IRoutes) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			s.update(ctx, routes)
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// update concurrently fetches the status of every route with a backend
+func (s *StatusCache) update(ctx context.Context, routes IRoutes) {
+	var wg sync.WaitGroup
+	for serverAddress, backend := range routes.GetMappings() {
+		// such as a stopped container that is only routed to serve its MOTD
+		if backend == "" {
+			continue
+		}
+
+		serverName := serverAddress
+		if options, exists := routes.GetRouteOptions(serverAddress); exists && options.BackendServerName != "" {
+			serverName = options.BackendServerName
+		}
+
+		wg.Add(1)
+		go func(serverAddress string, backends []string, serverName string) {
+			defer wg.Done()
+			s.updateRoute(ctx, routes, serverAddress, backends, serverName)
+		}(serverAddress, SplitBackends(backend), serverName)
+	}
+	wg.Wait()
+}
+
+// updateRoute fetches the status of each of the route's backends, where the status of a load balanced route is that
+// of its first backend that responds and the pool players are summed across those that respond
+func (s *StatusCache) updateRoute(ctx context.Context, routes IRoutes, serverAddress string, backends []string,
+	serverName string) {
+
+	statuses := make([]*mcproto.StatusResponse, len(backends))
+	var wg sync.WaitGroup
+	for i, backend := range backends {
+		wg.Add(1)
+		go func(i int, backend string) {
+			defer wg.Done()
+
+			backendStatus, err := FetchBackendStatus(ctx, s.dial, backend, serverName, statusCacheProtocolVersion)
+			if err != nil {
+				logrus.
+					WithError(err).
+					WithField("serverAddress", serverAddress).
+					WithField("backend", backend).
+					Debug("Unable to fetch status for the status cache")
+				return
+			}
+			statuses[i] = backendStatus.Status
+		}(i, backend)
+	}
+	wg.Wait()
+
+	var status *mcproto.StatusResponse
+	var poolPlayers mcproto.StatusPlayers
+	for _, backendStatus := range statuses {
+		if backendStatus == nil {
+			continue
+		}
+		if status == nil {
+			status = backendStatus
+		}
+		poolPlayers.Online += backendStatus.Players.Online
+		poolPlayers.Max += backendStatus.Players.Max
+		poolPlayers.Sample = append(poolPlayers.Sample, backendStatus.Players.Sample...)
+	}
+	// the previous status is retained when no backend responded
+	if status == nil {
+		return
+	}
+	// the route may have been removed during the fetch
+	if _, exists := routes.GetRouteOptions(serverAddress); exists {
+		s.putPool(serverAddress, status, poolPlayers, time.Now())
+	}
+}
+
+// UseStatusCache serves the cached status of a route's backend, when available, to status requests while the
+// backend is missing or can't be reached, in place of the route's MOTD. Entries of removed routes are dropped when
+// given to NotifyRouteEvent.
+func (c *Connector) UseStatusCache(cache *StatusCache) {
+	c.statusCache = cache
+}
+
+// UseCachedStatusForReachableBackends also answers the status requests of routes with a backend from the status
+// cache, when fetched within its ttl, rather than relaying each server list ping to the backend. Since the backend
+// closes a status connection after a single ping, its periodically fetched status stands in for a pooled connection.
+func (c *Connector) UseCachedStatusForReachableBackends() {
+	c.cachedStatusForReachable = true
+}
+
+// cachedStatus returns the cached status for the route of the resolvedHost, if a status cache is used
+func (c *Connector) cachedStatus(resolvedHost string) (*mcproto.StatusResponse, bool) {
+	if c.statusCache == nil {
+		return nil, false
+	}
+	return c.statusCache.Get(resolvedHost)
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/itzg/mc-router/mcproto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusCache_update(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	//goland:noinspection GoUnhandledErrorResult
+	defer listener.Close()
+
+	handshakes := serveTestStatus(t, listener, &mcproto.StatusResponse{
+		Version:     mcproto.StatusVersion{Name: "1.21", Protocol: 767},
+		Players:     mcproto.StatusPlayers{Max: 20, Online: 1, Sample: []mcproto.StatusPlayerSample{{Name: "steve"}}},
+		Description: mcproto.TextComponent{Text: "Hello"},
+	})
+
+	routes := NewRoutes()
+	routes.CreateMapping("mc.example.com", listener.Addr().String(), nil, RouteOptions{BackendServerName: "internal.example.com"})
+	// a route without a backend isn't fetched
+	routes.CreateMapping("stopped.example.com", "", nil, RouteOptions{})
+
+	cache := NewStatusCache(dialTest, time.Minute)
+	cache.update(context.Background(), routes)
+
+	assert.Equal(t, "internal.example.com", (<-handshakes).ServerAddress)
+	status, ok := cache.Get("mc.example.com")
+	require.True(t, ok)
+	assert.Equal(t, "Hello", status.Description.Text)
+	assert.Equal(t, []mcproto.StatusPlayerSample{{Name: "steve"}}, status.Players.Sample)
+
+	// the previous status is retained when the backend can't be reached
+	_ = listener.Close()
+	cache.update(context.Background(), routes)
+	_, ok = cache.Get("mc.example.com")
+	assert.True(t, ok)
+
+	_, ok = cache.Get("stopped.example.com")
+	assert.False(t, ok)
+
+	cache.Delete("mc.example.com")
+	_, ok = cache.Get("mc.example.com")
+	assert.False(t, ok)
+}
+
+func TestStatusCache_GetExpired(t *testing.T) {
+	cache := NewStatusCache(dialTest, time.Minute)
+	cache.put("mc.example.com", &mcproto.StatusResponse{}, time.Now().Add(-2*time.Minute))
+
+	_, ok := cache.Get("mc.example.com")
+	assert.False(t, ok)
+}
+
+func TestConnector_CachedStatusForReachableBackends(t *testing.T) {
+	Routes.Reset()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	//goland:noinspection GoUnhandledErrorResult
+	defer listener.Close()
+	Routes.CreateMapping("mc.example.com", listener.Addr().String(), nil, RouteOptions{})
+	defer Routes.DeleteMapping("mc.example.com")
+
+	cache := NewStatusCache(dialTest, time.Minute)
+	cache.put("mc.example.com", &mcproto.StatusResponse{Description: mcproto.TextComponent{Text: "Cached"}}, time.Now())
+	c := newTestConnector(t)
+	c.UseStatusCache(cache)
+	c.UseCachedStatusForReachableBackends()
+
+	content := new(bytes.Buffer)
+	require.NoError(t, mcproto.WriteHandshake(content, &mcproto.Handshake{
+		ProtocolVersion: 767,
+		ServerAddress:   "mc.example.com",
+		ServerPort:      25565,
+		NextState:       int(mcproto.StateStatus),
+	}))
+	require.NoError(t, mcproto.WriteStatusRequest(content))
+
+	clientConn, routerConn := net.Pipe()
+	//goland:noinspection GoUnhandledErrorResult
+	defer clientConn.Close()
+	go c.HandleConnection(context.Background(), routerConn)
+	_, err = clientConn.Write(content.Bytes())
+	require.NoError(t, err)
+
+	require.NoError(t, clientConn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	packet, err := mcproto.ReadPacket(clientConn, clientConn.RemoteAddr(), mcproto.StateStatus)
+	require.NoError(t, err)
+	status, err := mcproto.ReadStatusResponse(packet.Data)
+	require.NoError(t, err)
+	assert.Equal(t, "Cached", status.Description.Text)
+
+	// the reachable backend was not connected to
+	require.NoError(t, listener.(*net.TCPListener).SetDeadline(time.Now().Add(100*time.Millisecond)))
+	_, err = listener.Accept()
+	assert.Error(t, err)
+}
+
+func TestStatusCache_updatePool(t *testing.T) {
+	var backends []string
+	for _, online := range []int{2, 3} {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		//goland:noinspection GoUnhandledErrorResult
+		defer listener.Close()
+		serveTestStatus(t, listener, &mcproto.StatusResponse{
+			Players:     mcproto.StatusPlayers{Max: 20, Online: online},
+			Description: mcproto.TextComponent{Text: "Lobby"},
+		})
+		backends = append(backends, listener.Addr().String())
+	}
+	// a backend that can't be reached doesn't count towards the pool
+	unreachable, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	require.NoError(t, unreachable.Close())
+	backends = append(backends, unreachable.Addr().String())
+
+	routes := NewRoutes()
+	routes.CreateMapping("lobby.example.com", strings.Join(backends, BackendsDelimiter), nil, RouteOptions{})
+
+	cache := NewStatusCache(dialTest, time.Minute)
+	cache.update(context.Background(), routes)
+
+	status, ok := cache.Get("lobby.example.com")
+	require.True(t, ok)
+	assert.Equal(t, "Lobby", status.Description.Text)
+	assert.Equal(t, 2, status.Players.Online)
+	players, ok := cache.GetPoolPlayers("lobby.example.com")
+	require.True(t, ok)
+	assert.Equal(t, mcproto.StatusPlayers{Max: 40, Online: 5}, players)
+}
+
+func TestConnector_CachedStatusRetainsBackendFields(t *testing.T) {
+	Routes.Reset()
+	Routes.CreateMapping("mc.example.com", unreachableAddress(t), nil, RouteOptions{})
+	t.Cleanup(Routes.Reset)
+
+	statusJson := new(bytes.Buffer)
+	require.NoError(t, mcproto.WriteString(statusJson, `{"version":{"name":"Forge 1.20.1","protocol":763},`+
+		`"players":{"max":20,"online":3},"description":{"text":"","extra":[{"text":"Modded","color":"green"}]},`+
+		`"forgeData":{"fmlNetworkVersion":3}}`))
+	cached, err := mcproto.ReadStatusResponse(statusJson.Bytes())
+	require.NoError(t, err)
+	cache := NewStatusCache(dialTest, time.Minute)
+	cache.put("mc.example.com", cached, time.Now())
+	c := newTestConnector(t)
+	c.UseStatusCache(cache)
+
+	content := new(bytes.Buffer)
+	require.NoError(t, mcproto.WriteHandshake(content, &mcproto.Handshake{
+		ProtocolVersion: 767,
+		ServerAddress:   "mc.example.com",
+		ServerPort:      25565,
+		NextState:       int(mcproto.StateStatus),
+	}))
+	require.NoError(t, mcproto.WriteStatusRequest(content))
+
+	clientConn, routerConn := net.Pipe()
+	//goland:noinspection GoUnhandledErrorResult
+	defer clientConn.Close()
+	go c.HandleConnection(context.Background(), routerConn)
+	_, err = clientConn.Write(content.Bytes())
+	require.NoError(t, err)
+
+	require.NoError(t, clientConn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	packet, err := mcproto.ReadPacket(clientConn, clientConn.RemoteAddr(), mcproto.StateStatus)
+	require.NoError(t, err)
+	served, err := mcproto.ReadString(bytes.NewReader(packet.Data.([]byte)))
+	require.NoError(t, err)
+	// only the version is replaced to match the client's
+	assert.JSONEq(t, `{"version":{"name":"1.21-1.21.1","protocol":767},"players":{"max":20,"online":3},`+
+		`"description":{"text":"","extra":[{"text":"Modded","color":"green"}]},"forgeData":{"fmlNetworkVersion":3}}`,
+		served)
+}
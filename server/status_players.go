@@ -0,0 +1,74 @@
+package server
+
+import (
+	"github.com/itzg/mc-router/mcproto"
+	"github.com/pkg/errors"
+)
+
+// Values of RouteOptions.PlayersSource, which is where the players of a status served in place of the backend
+// come from
+const (
+	// PlayersSourceStatic serves no online players and, if set, the route's MaxPlayers as the maximum
+	PlayersSourceStatic = "static"
+	// PlayersSourceCached serves the players last fetched by the status cache from the route's backend
+	PlayersSourceCached = "cached"
+	// PlayersSourcePool serves the players last fetched by the status cache, summed across the route's backends
+	PlayersSourcePool = "pool"
+)
+
+// ValidatePlayersSource checks that the source is empty or one of the PlayersSource values
+func ValidatePlayersSource(source string) error {
+	switch source {
+	case "", PlayersSourceStatic, PlayersSourceCached, PlayersSourcePool:
+		return nil
+	default:
+		return errors.Errorf("unsupported players source %q", source)
+	}
+}
+
+// UseStatusPlayers sets the defaults for routes that don't declare their own PlayersSource and MaxPlayers.
+// An empty source leaves the players of each served status as is, which are those of the backend for a cached
+// status and none otherwise.
+func (c *Connector) UseStatusPlayers(source string, maxPlayers int) error {
+	if err := ValidatePlayersSource(source); err != nil {
+		return err
+	}
+	c.playersSource = source
+	c.maxPlayers = maxPlayers
+	return nil
+}
+
+// withRoutePlayers returns a copy of the status served in place of the route's backend with the players from the
+// route's source and the maximum capped at the route's MaxPlayers
+func (c *Connector) withRoutePlayers(status *mcproto.StatusResponse, resolvedHost string,
+	options RouteOptions) *mcproto.StatusResponse {
+
+	source := options.PlayersSource
+	if source == "" {
+		source = c.playersSource
+	}
+	maxPlayers := options.MaxPlayers
+	if maxPlayers <= 0 {
+		maxPlayers = c.maxPlayers
+	}
+
+	served := *status
+	switch source {
+	case PlayersSourceStatic:
+		served.Players = mcproto.StatusPlayers{Max: maxPlayers}
+	case PlayersSourceCached:
+		if cached, ok := c.cachedStatus(resolvedHost); ok {
+			served.Players = cached.Players
+		}
+	case PlayersSourcePool:
+		if c.statusCache != nil {
+			if players, ok := c.statusCache.GetPoolPlayers(resolvedHost); ok {
+				served.Players = players
+			}
+		}
+	}
+	if maxPlayers > 0 && served.Players.Max > maxPlayers {
+		served.Players.Max = maxPlayers
+	}
+	return &served
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/itzg/mc-router/mcproto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnector_withRoutePlayers(t *testing.T) {
+	cache := NewStatusCache(dialTest, time.Minute)
+	cache.putPool("lobby.example.com",
+		&mcproto.StatusResponse{Players: mcproto.StatusPlayers{Max: 20, Online: 2}},
+		mcproto.StatusPlayers{Max: 60, Online: 7},
+		time.Now())
+
+	motd := &mcproto.StatusResponse{Description: mcproto.TextComponent{Text: "Sleeping"}}
+
+	tests := []struct {
+		name          string
+		defaultSource string
+		defaultMax    int
+		options       RouteOptions
+		want          mcproto.StatusPlayers
+	}{
+		{name: "as is", want: mcproto.StatusPlayers{}},
+		{name: "static", options: RouteOptions{PlayersSource: PlayersSourceStatic, MaxPlayers: 10},
+			want: mcproto.StatusPlayers{Max: 10}},
+		{name: "cached", options: RouteOptions{PlayersSource: PlayersSourceCached},
+			want: mcproto.StatusPlayers{Max: 20, Online: 2}},
+		{name: "pool", options: RouteOptions{PlayersSource: PlayersSourcePool},
+			want: mcproto.StatusPlayers{Max: 60, Online: 7}},
+		{name: "pool capped", options: RouteOptions{PlayersSource: PlayersSourcePool, MaxPlayers: 50},
+			want: mcproto.StatusPlayers{Max: 50, Online: 7}},
+		{name: "defaults", defaultSource: PlayersSourcePool, defaultMax: 40,
+			want: mcproto.StatusPlayers{Max: 40, Online: 7}},
+		{name: "route overrides defaults", defaultSource: PlayersSourcePool, defaultMax: 40,
+			options: RouteOptions{PlayersSource: PlayersSourceCached, MaxPlayers: 100},
+			want:    mcproto.StatusPlayers{Max: 20, Online: 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestConnector(t)
+			c.UseStatusCache(cache)
+			require.NoError(t, c.UseStatusPlayers(tt.defaultSource, tt.defaultMax))
+
+			served := c.withRoutePlayers(motd, "lobby.example.com", tt.options)
+			assert.Equal(t, tt.want, served.Players)
+			assert.Equal(t, "Sleeping", served.Description.Text)
+		})
+	}
+	// the given status is left as is
+	assert.Equal(t, mcproto.StatusPlayers{}, motd.Players)
+
+	assert.Error(t, newTestConnector(t).UseStatusPlayers("summed", 0))
+}
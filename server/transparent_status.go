@@ -0,0 +1,56 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"time"
+
+	"github.com/itzg/mc-router/mcproto"
+	"github.com/sirupsen/logrus"
+)
+
+// UseTransparentStatus answers status requests by performing the status exchange with the backend and serving the
+// status packet it responds with unchanged, rather than relaying the client's server list ping. That way a backend
+// that accepts the connection but doesn't respond is presented with the route's status.
+func (c *Connector) UseTransparentStatus() {
+	c.transparentStatus = true
+}
+
+// serveTransparentStatus fetches the status from the connected backend, presenting the given serverName in the
+// handshake, and relays it to the client as the backend sent it. When the backend doesn't respond, the route's status
+// is served in its place.
+func (c *Connector) serveTransparentStatus(ctx context.Context, frontendConn net.Conn, clientAddr net.Addr,
+	frontendReader io.Reader, backendConn net.Conn, handshake *mcproto.Handshake, serverName string,
+	resolvedHost string, options RouteOptions) {
+
+	if err := backendConn.SetDeadline(time.Now().Add(defaultBackendStatusTimeout)); err != nil {
+		logrus.WithError(err).WithField("client", clientAddr).Error("Failed to set backend deadline")
+		return
+	}
+
+	backendHandshake := *handshake
+	if serverName != "" {
+		backendHandshake.ServerAddress = rewriteServerAddress(handshake.ServerAddress, serverName)
+	}
+	backendStatus, err := exchangeStatus(backendConn, &backendHandshake)
+	if err != nil {
+		logrus.
+			WithError(err).
+			WithField("client", clientAddr).
+			WithField("serverAddress", resolvedHost).
+			Warn("Unable to fetch status from backend")
+		c.connectionErrors(ctx, "backend_failed").Add(1)
+		c.serveRouteStatus(ctx, frontendConn, clientAddr, frontendReader, handshake, resolvedHost, options)
+		return
+	}
+
+	logrus.
+		WithField("client", clientAddr).
+		WithField("serverAddress", resolvedHost).
+		WithField("latency", backendStatus.Latency).
+		Debug("Serving status fetched from backend")
+	c.completeStatusExchangeWith(ctx, frontendConn, clientAddr, frontendReader, func(writer io.Writer) error {
+		return mcproto.WritePacket(writer, mcproto.PacketIdStatusResponse, backendStatus.Data)
+	})
+}
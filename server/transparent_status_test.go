@@ -0,0 +1,69 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/itzg/mc-router/mcproto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnector_TransparentStatus(t *testing.T) {
+	Routes.Reset()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	//goland:noinspection GoUnhandledErrorResult
+	defer listener.Close()
+	backendStatus := &mcproto.StatusResponse{
+		Version:     mcproto.StatusVersion{Name: "Paper 1.21.1", Protocol: 767},
+		Players:     mcproto.StatusPlayers{Max: 100, Online: 5},
+		Description: mcproto.TextComponent{Text: "Live"},
+	}
+	handshakes := serveTestStatus(t, listener, backendStatus)
+	Routes.CreateMapping("mc.example.com", listener.Addr().String(), nil,
+		RouteOptions{BackendServerName: "internal.example.com", MaxPlayers: 20})
+	defer Routes.DeleteMapping("mc.example.com")
+
+	c := newTestConnector(t)
+	c.UseTransparentStatus()
+
+	content := new(bytes.Buffer)
+	require.NoError(t, mcproto.WriteHandshake(content, &mcproto.Handshake{
+		ProtocolVersion: 766,
+		ServerAddress:   "mc.example.com",
+		ServerPort:      25565,
+		NextState:       int(mcproto.StateStatus),
+	}))
+	require.NoError(t, mcproto.WriteStatusRequest(content))
+
+	clientConn, routerConn := net.Pipe()
+	//goland:noinspection GoUnhandledErrorResult
+	defer clientConn.Close()
+	go c.HandleConnection(context.Background(), routerConn)
+	_, err = clientConn.Write(content.Bytes())
+	require.NoError(t, err)
+
+	require.NoError(t, clientConn.SetDeadline(time.Now().Add(5*time.Second)))
+	packet, err := mcproto.ReadPacket(clientConn, clientConn.RemoteAddr(), mcproto.StateStatus)
+	require.NoError(t, err)
+	// the backend's status response is relayed unchanged, including its version and players
+	expected := new(bytes.Buffer)
+	require.NoError(t, mcproto.WriteStatusResponse(expected, backendStatus))
+	expectedPacket, err := mcproto.ReadPacket(expected, nil, mcproto.StateStatus)
+	require.NoError(t, err)
+	assert.Equal(t, expectedPacket.Data, packet.Data)
+
+	handshake := <-handshakes
+	assert.Equal(t, "internal.example.com", handshake.ServerAddress)
+	assert.Equal(t, 766, handshake.ProtocolVersion)
+
+	// the client's ping is answered by mc-router
+	require.NoError(t, mcproto.WritePing(clientConn, 1234))
+	packet, err = mcproto.ReadPacket(clientConn, clientConn.RemoteAddr(), mcproto.StateStatus)
+	require.NoError(t, err)
+	assert.Equal(t, mcproto.PacketIdPong, packet.PacketID)
+}
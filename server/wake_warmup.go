@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// wakeWarmup tracks the backends being woken in the background, so that clients are told the backend is
+// starting rather than waiting on it
+type wakeWarmup struct {
+	message string
+
+	mu     sync.Mutex
+	waking map[string]struct{}
+}
+
+// UseWakeWarmup serves the given message to clients of a backend that could not be reached, as a status or
+// login disconnect, while the backend is woken in the background rather than blocking the client on the wake.
+// Only one wake of each backend is in progress at a time.
+func (c *Connector) UseWakeWarmup(message string) {
+	c.wakeWarmup = &wakeWarmup{
+		message: message,
+		waking:  make(map[string]struct{}),
+	}
+}
+
+// start invokes the waker in the background unless a wake of the resolvedHost is already in progress,
+// in which case false is returned
+func (w *wakeWarmup) start(ctx context.Context, resolvedHost string, waker func(ctx context.Context) error,
+	done func(err error)) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, exists := w.waking[resolvedHost]; exists {
+		return false
+	}
+	w.waking[resolvedHost] = struct{}{}
+
+	go func() {
+		err := waker(ctx)
+		w.mu.Lock()
+		delete(w.waking, resolvedHost)
+		w.mu.Unlock()
+		done(err)
+	}()
+	return true
+}
+
+// warmUpBackend wakes the backend of the resolvedHost in the background
+func (c *Connector) warmUpBackend(ctx context.Context, clientAddr net.Addr, resolvedHost string, waker func(ctx context.Context) error) {
+	started := c.wakeWarmup.start(ctx, resolvedHost, waker, func(err error) {
+		if err != nil {
+			logrus.WithError(err).WithField("serverAddress", resolvedHost).Error("failed to wake up backend")
+			c.connectionErrors(ctx, "wakeup_failed").Add(1)
+		}
+	})
+	logrus.
+		WithField("client", clientAddr).
+		WithField("serverAddress", resolvedHost).
+		WithField("started", started).
+		Info("Waking backend in the background")
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/itzg/mc-router/mcproto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnector_WakeWarmup(t *testing.T) {
+	// an address that refuses connections, like a sleeping backend
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	backend := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	var wakes atomic.Int32
+	release := make(chan struct{})
+	waker := func(ctx context.Context) error {
+		wakes.Add(1)
+		<-release
+		return nil
+	}
+
+	Routes.Reset()
+	Routes.CreateMapping("mc.example.com", backend, waker, RouteOptions{})
+	t.Cleanup(Routes.Reset)
+
+	c := newTestConnector(t)
+	c.UseWakeWarmup("starting, please retry")
+
+	login := func() string {
+		clientConn, routerConn := net.Pipe()
+		//goland:noinspection GoUnhandledErrorResult
+		defer clientConn.Close()
+		go c.HandleConnection(context.Background(), routerConn)
+
+		require.NoError(t, clientConn.SetDeadline(time.Now().Add(5*time.Second)))
+		require.NoError(t, mcproto.WriteHandshake(clientConn, &mcproto.Handshake{
+			ProtocolVersion: 767,
+			ServerAddress:   "mc.example.com",
+			ServerPort:      25565,
+			NextState:       int(mcproto.StateLogin),
+		}))
+		require.NoError(t, mcproto.WriteLoginStart(clientConn, 767, &mcproto.LoginStart{Name: "itzg"}))
+
+		packet, err := mcproto.ReadPacket(clientConn, clientConn.LocalAddr(), mcproto.StateLogin)
+		require.NoError(t, err)
+		require.Equal(t, mcproto.PacketIdLoginDisconnect, packet.PacketID)
+		message, err := mcproto.ReadString(bytes.NewReader(packet.Data.([]byte)))
+		require.NoError(t, err)
+		return message
+	}
+
+	assert.JSONEq(t, `{"text":"starting, please retry"}`, login())
+	// the second client is not blocked on the wake in progress and doesn't start another
+	assert.JSONEq(t, `{"text":"starting, please retry"}`, login())
+	assert.Equal(t, int32(1), wakes.Load())
+
+	close(release)
+	assert.Eventually(t, func() bool {
+		c.wakeWarmup.mu.Lock()
+		defer c.wakeWarmup.mu.Unlock()
+		return len(c.wakeWarmup.waking) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestConnector_WakeWarmup_staticRoute(t *testing.T) {
+	// a static route can't be woken, so its unreachable backend is handled as failed rather than warmed up
+	backend := unreachableAddress(t)
+	Routes.Reset()
+	Routes.RegisterAll(map[string]string{"mc.example.com": backend})
+	t.Cleanup(Routes.Reset)
+
+	errorCounter := newErrorTypeCounter()
+	connectorMetrics := newTestConnectorMetrics()
+	connectorMetrics.Errors = errorCounter
+	clientFilter, err := NewClientFilter(nil, nil)
+	require.NoError(t, err)
+	c := NewConnector(connectorMetrics, false, false, nil, clientFilter)
+	c.UseWakeWarmup("starting, please retry")
+	c.UseNoBackendMessage("backend is down")
+
+	clientConn, routerConn := net.Pipe()
+	//goland:noinspection GoUnhandledErrorResult
+	defer clientConn.Close()
+	handled := make(chan struct{})
+	go func() {
+		c.HandleConnection(context.Background(), routerConn)
+		close(handled)
+	}()
+
+	require.NoError(t, clientConn.SetDeadline(time.Now().Add(5*time.Second)))
+	require.NoError(t, mcproto.WriteHandshake(clientConn, &mcproto.Handshake{
+		ProtocolVersion: 767,
+		ServerAddress:   "mc.example.com",
+		ServerPort:      25565,
+		NextState:       int(mcproto.StateLogin),
+	}))
+	require.NoError(t, mcproto.WriteLoginStart(clientConn, 767, &mcproto.LoginStart{Name: "itzg"}))
+
+	packet, err := mcproto.ReadPacket(clientConn, clientConn.LocalAddr(), mcproto.StateLogin)
+	require.NoError(t, err)
+	require.Equal(t, mcproto.PacketIdLoginDisconnect, packet.PacketID)
+	message, err := mcproto.ReadString(bytes.NewReader(packet.Data.([]byte)))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"text":"backend is down"}`, message)
+
+	<-handled
+	assert.Equal(t, float64(1), errorCounter.count("backend_failed"))
+	assert.Empty(t, c.wakeWarmup.waking, "no wake was started")
+}
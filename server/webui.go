@@ -0,0 +1,24 @@
+package server
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed webui
+var webUiContent embed.FS
+
+// registerWebUi serves the embedded web UI at the root of the API server. The UI only consumes the
+// existing JSON API endpoints.
+func registerWebUi() {
+	content, err := fs.Sub(webUiContent, "webui")
+	if err != nil {
+		// only possible if the embed directive above is broken
+		panic(err)
+	}
+	fileServer := http.FileServer(http.FS(content))
+
+	apiRoutes.Path("/").Methods("GET").Handler(fileServer)
+	apiRoutes.Path("/index.html").Methods("GET").Handler(fileServer)
+}